@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/glutechnologies/glubng/pkg/core"
+	"github.com/glutechnologies/glubng/pkg/kea"
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+const defaultConfigFile = "/etc/glubng.toml"
+
+type validation struct {
+	File  string `json:"file"`
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+func runConfig(c *cli, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "show":
+		config, err := c.api.Config()
+		if err != nil {
+			return err
+		}
+		// Configuration is nested, table output is also indented JSON
+		var v interface{}
+		if err = json.Unmarshal(config, &v); err != nil {
+			return err
+		}
+		c.format = formatJSON
+		return c.print(v, nil)
+	case "validate":
+		return validateConfig(c, args[1:])
+	case "kea":
+		return keaConfig(c, args[1:])
+	}
+
+	return errUsage
+}
+
+func validateConfig(c *cli, args []string) error {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	configFile := fs.String("config", defaultConfigFile, "Config source path")
+	ifacesFile := fs.String("interfaces", defaultIfacesFile, "Config interfaces source path")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	results := []validation{{File: *configFile}, {File: *ifacesFile}}
+
+	config, err := core.ReadConfig(*configFile)
+	if err == nil {
+		err = config.Validate()
+	}
+	results[0].setError(err)
+
+	ifaces, err := vpp.ReadIfacesConfig(*ifacesFile)
+	if err == nil {
+		err = vpp.ValidateIfaces(ifaces)
+	}
+	results[1].setError(err)
+
+	err = c.print(results, func(w io.Writer) {
+		fmt.Fprintln(w, "FILE\tVALID\tERROR")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%t\t%s\n", r.File, r.Valid, r.Error)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		if !r.Valid {
+			return fmt.Errorf("%s is not valid", r.File)
+		}
+	}
+
+	return nil
+}
+
+// keaConfig prints kea-dhcp4 configuration generated from configuration
+// files, or its differences with an existing one
+func keaConfig(c *cli, args []string) error {
+	fs := flag.NewFlagSet("config kea", flag.ContinueOnError)
+	configFile := fs.String("config", defaultConfigFile, "Config source path")
+	ifacesFile := fs.String("interfaces", defaultIfacesFile, "Config interfaces source path")
+	diffFile := fs.String("diff", "", "Compare with an existing kea-dhcp4 configuration file")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	config, err := core.ReadConfig(*configFile)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		return fmt.Errorf("%s is not valid, %w", *configFile, err)
+	}
+
+	ifaces, err := vpp.ReadIfacesConfig(*ifacesFile)
+	if err == nil {
+		err = vpp.ValidateIfaces(ifaces)
+	}
+	if err != nil {
+		return fmt.Errorf("%s is not valid, %w", *ifacesFile, err)
+	}
+
+	generated, err := kea.GenerateDHCP4Config(&kea.GenerateParams{
+		Socket: config.Misc.SrcKeaSocket,
+		Kea:    &config.Kea,
+		Vpp:    &config.Vpp,
+		Ifaces: ifaces,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *diffFile == "" {
+		_, err = c.out.Write(generated)
+		return err
+	}
+
+	existing, err := os.ReadFile(*diffFile)
+	if err != nil {
+		return err
+	}
+	diff, err := kea.DiffConfig(*diffFile, existing, "generated", generated)
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		fmt.Fprintf(c.out, "%s matches generated configuration\n", *diffFile)
+		return nil
+	}
+
+	fmt.Fprint(c.out, diff)
+	return fmt.Errorf("%s differs from generated configuration", *diffFile)
+}
+
+func (v *validation) setError(err error) {
+	v.Valid = err == nil
+	if err != nil {
+		v.Error = err.Error()
+	}
+}
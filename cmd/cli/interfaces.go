@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/glutechnologies/glubng/pkg/rest"
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+const defaultIfacesFile = "/etc/interfaces.toml"
+
+func runInterfaces(c *cli, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		ifaces, err := c.api.ListInterfaces()
+		if err != nil {
+			return err
+		}
+		return c.print(ifaces, func(w io.Writer) { interfacesTable(w, ifaces) })
+	case "add":
+		return addInterface(c, args[1:])
+	case "remove":
+		return removeInterface(c, args[1:])
+	}
+
+	return errUsage
+}
+
+func addInterface(c *cli, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	name := args[0]
+
+	fs := flag.NewFlagSet("interfaces add", flag.ContinueOnError)
+	file := fs.String("interfaces", defaultIfacesFile, "Config interfaces source path")
+	src := fs.Int("src-iface", 0, "VPP SwIf of the parent interface")
+	outer := fs.Int("outer-vlan", 0, "Outer VLAN, creates a sub-interface when set")
+	inner := fs.Int("inner-vlan", 0, "Inner VLAN, creates a QinQ sub-interface when set")
+	mtu := fs.Uint("mtu", 1500, "Interface MTU")
+	flexId := fs.String("flex-id", name, "Flex-id sent to Kea")
+	profile := fs.String("profile", "", "Service profile, leases may set another one")
+	circuitID := fs.String("circuit-id", "", "Relay agent circuit-id of subscribers, for table mapping")
+	remoteID := fs.String("remote-id", "", "Relay agent remote-id of subscribers, for table mapping")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	// Comments and order of interfaces in the file are kept
+	err := vpp.AddIfaceFile(*file, name, vpp.Iface{
+		VPPSrcIface: *src,
+		IsSubIf:     *outer != 0,
+		HasQinQ:     *inner != 0,
+		OuterVLAN:   *outer,
+		InnerVLAN:   *inner,
+		MTU:         uint32(*mtu),
+		FlexId:      *flexId,
+		Profile:     *profile,
+		CircuitID:   *circuitID,
+		RemoteID:    *remoteID,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Interface %s added to %s\n", name, *file)
+	return nil
+}
+
+func removeInterface(c *cli, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	name := args[0]
+
+	fs := flag.NewFlagSet("interfaces remove", flag.ContinueOnError)
+	file := fs.String("interfaces", defaultIfacesFile, "Config interfaces source path")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	if err := vpp.RemoveIfaceFile(*file, name); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Interface %s removed from %s\n", name, *file)
+	return nil
+}
+
+func interfacesTable(w io.Writer, ifaces []rest.Interface) {
+	fmt.Fprintln(w, "NAME\tSWIF\tSRC\tOUTER\tINNER\tMTU\tFLEX-ID\tPROFILE\tSTATUS\tREASON")
+	for _, v := range ifaces {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", v.Name, v.SwIf, v.VPPSrcIface,
+			v.OuterVLAN, v.InnerVLAN, v.MTU, v.FlexId, orDash(v.Profile), v.Status, v.Reason)
+	}
+}
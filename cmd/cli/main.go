@@ -1,7 +1,95 @@
 package main
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/glutechnologies/glubng/pkg/rest"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+type cli struct {
+	api    *rest.Client
+	format string
+	out    io.Writer
+}
+
+type command struct {
+	run   func(c *cli, args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"sessions":   {runSessions, "sessions list|show <key>|clear <key>...|clear -all"},
+	"interfaces": {runInterfaces, "interfaces list|add <name> [flags]|remove <name>"},
+	"config":     {runConfig, "config show|validate|kea [-config file] [-interfaces file] [-diff file]"},
+	"status":     {runStatus, "status"},
+}
+
+var errUsage = errors.New("invalid usage")
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: glubng [flags] <command>\n\nCommands:\n")
+	for _, name := range []string{"sessions", "interfaces", "config", "status"} {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+	flag.PrintDefaults()
+}
 
 func main() {
-	fmt.Println("Hello world!")
+	api := flag.String("api", "127.0.0.1:8080", "glubngd REST API address")
+	format := flag.String("o", formatTable, "Output format, table or json")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *format != formatTable && *format != formatJSON {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q\n", *format)
+		os.Exit(2)
+	}
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	c := &cli{api: rest.NewClient(*api), format: *format, out: os.Stdout}
+
+	if err := cmd.run(c, flag.Args()[1:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "Usage: glubng %s\n", cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// print writes v as JSON or calls table to render it
+func (c *cli) print(v interface{}, table func(w io.Writer)) error {
+	if c.format == formatJSON {
+		e := json.NewEncoder(c.out)
+		e.SetIndent("", "  ")
+		return e.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/rest"
+)
+
+type fakeBackend struct {
+	sessions []rest.Session
+	deleted  []string
+}
+
+func (b *fakeBackend) ListSessions() []rest.Session {
+	return b.sessions
+}
+
+func (b *fakeBackend) GetSession(key string) (rest.Session, error) {
+	for _, ses := range b.sessions {
+		if ses.Key == key {
+			return ses, nil
+		}
+	}
+	return rest.Session{}, rest.ErrNotFound
+}
+
+func (b *fakeBackend) DeleteSession(key string) error {
+	if key == "100.64.0.99" {
+		return errors.New("VPP is not connected")
+	}
+	b.deleted = append(b.deleted, key)
+	return nil
+}
+
+func (b *fakeBackend) ListInterfaces() []rest.Interface {
+	return []rest.Interface{{Name: "cpe1", SwIf: 3, VPPSrcIface: 1, OuterVLAN: 101, MTU: 1500, FlexId: "cpe1", Status: "ok"}}
+}
+
+func (b *fakeBackend) Config() interface{} {
+	return map[string]string{"SrcKeaSocket": "/run/kea/glubng.sock"}
+}
+
+func (b *fakeBackend) Health() rest.Health {
+	h := rest.Health{Vpp: rest.VppHealth{Connected: true}, Kea: rest.KeaHealth{Socket: "/run/kea/glubng.sock", Listening: true}}
+	h.Status = rest.HealthStatus(&h)
+	return h
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{sessions: []rest.Session{
+		{Key: "100.64.0.10", Iface: 3, IPv4: "100.64.0.10", State: "active", Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Key: "100.64.0.99", Iface: 3, IPv4: "100.64.0.99", State: "active"},
+	}}
+}
+
+// run runs a command against an API served by b
+func run(t *testing.T, b rest.Backend, format string, args ...string) (string, error) {
+	t.Helper()
+
+	srv := httptest.NewServer(rest.NewHandler(b))
+	t.Cleanup(srv.Close)
+
+	out := new(bytes.Buffer)
+	c := &cli{api: rest.NewClient(srv.URL), format: format, out: out}
+	err := commands[args[0]].run(c, args[1:])
+	return out.String(), err
+}
+
+func TestSessionsTable(t *testing.T) {
+	out, err := run(t, newFakeBackend(), formatTable, "sessions", "show", "100.64.0.10")
+	if err != nil {
+		t.Fatalf("sessions show error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output has %d lines, want header and session, %q", len(lines), out)
+	}
+	if got := strings.Fields(lines[0]); !reflect.DeepEqual(got, []string{"IPV4", "IPV6", "PREFIX", "SWIF", "STATE", "EXPIRES"}) {
+		t.Errorf("header = %v", got)
+	}
+	fields := strings.Fields(lines[1])
+	if len(fields) != 6 || fields[0] != "100.64.0.10" || fields[1] != "-" || fields[3] != "3" || fields[4] != "active" {
+		t.Errorf("session row = %v", fields)
+	}
+	if expires, err := time.Parse(time.RFC3339, fields[5]); err != nil || !expires.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expires = %q, want 2030-01-01 in RFC 3339", fields[5])
+	}
+}
+
+func TestSessionsJSON(t *testing.T) {
+	b := newFakeBackend()
+	out, err := run(t, b, formatJSON, "sessions", "list")
+	if err != nil {
+		t.Fatalf("sessions list error = %v", err)
+	}
+
+	var sessions []rest.Session
+	if err := json.Unmarshal([]byte(out), &sessions); err != nil {
+		t.Fatalf("decoding %q, %v", out, err)
+	}
+	if len(sessions) != 2 || sessions[0].Key != "100.64.0.10" || !sessions[0].Expires.Equal(b.sessions[0].Expires) {
+		t.Errorf("sessions = %+v, want %+v", sessions, b.sessions)
+	}
+}
+
+func TestSessionsErrors(t *testing.T) {
+	_, err := run(t, newFakeBackend(), formatTable, "sessions", "show", "100.64.0.20")
+	if err == nil || !strings.Contains(err.Error(), rest.ErrNotFound.Error()) {
+		t.Errorf("show of unknown session error = %v, want %q", err, rest.ErrNotFound.Error())
+	}
+
+	b := newFakeBackend()
+	out, err := run(t, b, formatTable, "sessions", "clear", "-all")
+	if err == nil || !strings.Contains(err.Error(), "clearing session 100.64.0.99") || !strings.Contains(err.Error(), "VPP is not connected") {
+		t.Errorf("clear error = %v, want failure of 100.64.0.99", err)
+	}
+	if out != "Session 100.64.0.10 cleared\n" || !reflect.DeepEqual(b.deleted, []string{"100.64.0.10"}) {
+		t.Errorf("output %q and deleted %v, want 100.64.0.10 cleared", out, b.deleted)
+	}
+
+	for _, args := range [][]string{{"sessions"}, {"sessions", "show"}, {"sessions", "clear"}, {"sessions", "flush"}, {"status", "all"}} {
+		if _, err := run(t, b, formatTable, args...); err != errUsage {
+			t.Errorf("%v error = %v, want %v", args, err, errUsage)
+		}
+	}
+}
+
+func TestUnreachableAPI(t *testing.T) {
+	srv := httptest.NewServer(rest.NewHandler(newFakeBackend()))
+	srv.Close()
+
+	c := &cli{api: rest.NewClient(srv.URL), format: formatTable, out: new(bytes.Buffer)}
+	if err := runStatus(c, nil); err == nil {
+		t.Error("status of unreachable API succeeded, want error")
+	}
+}
+
+func TestStatusAndInterfaces(t *testing.T) {
+	out, err := run(t, newFakeBackend(), formatTable, "status")
+	if err != nil {
+		t.Fatalf("status error = %v", err)
+	}
+	if !strings.Contains(out, "Status:") || !strings.Contains(out, "VPP connected:      true") {
+		t.Errorf("status = %q", out)
+	}
+
+	out, err = run(t, newFakeBackend(), formatTable, "interfaces", "list")
+	if err != nil {
+		t.Fatalf("interfaces list error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !reflect.DeepEqual(strings.Fields(lines[1]), []string{"cpe1", "3", "1", "101", "0", "1500", "cpe1", "-", "ok"}) {
+		t.Errorf("interfaces = %q", out)
+	}
+
+	out, err = run(t, newFakeBackend(), formatTable, "config", "show")
+	if err != nil {
+		t.Fatalf("config show error = %v", err)
+	}
+	var config map[string]string
+	if err := json.Unmarshal([]byte(out), &config); err != nil || config["SrcKeaSocket"] != "/run/kea/glubng.sock" {
+		t.Errorf("config = %q, want JSON with SrcKeaSocket", out)
+	}
+}
+
+const ifacesFile = `# CPE interfaces
+
+# Building A
+[cpe1]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 101
+MTU = 1500
+FlexId = "cpe1"
+
+# Building B
+[cpe2]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 102
+MTU = 1500
+FlexId = "cpe2"
+`
+
+func TestInterfacesEditKeepsFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "interfaces.toml")
+	if err := os.WriteFile(filename, []byte(ifacesFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := newFakeBackend()
+
+	out, err := run(t, b, formatTable, "interfaces", "add", "cpe0", "-interfaces", filename, "-src-iface", "1", "-outer-vlan", "100")
+	if err != nil {
+		t.Fatalf("interfaces add error = %v", err)
+	}
+	if out != "Interface cpe0 added to "+filename+"\n" {
+		t.Errorf("output = %q", out)
+	}
+
+	if _, err = run(t, b, formatTable, "interfaces", "remove", "cpe1", "-interfaces", filename); err != nil {
+		t.Fatalf("interfaces remove error = %v", err)
+	}
+
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Comments and order of remaining interfaces are kept, added one is last
+	want := "# CPE interfaces\n\n# Building B\n[cpe2]\n"
+	if !strings.HasPrefix(string(body), want) {
+		t.Errorf("file = %q, want it to start with %q", body, want)
+	}
+	if i, j := strings.Index(string(body), "[cpe2]"), strings.Index(string(body), "[cpe0]"); i < 0 || j < i {
+		t.Errorf("file = %q, want [cpe0] after [cpe2]", body)
+	}
+	if strings.Contains(string(body), "cpe1") || strings.Contains(string(body), "Building A") {
+		t.Errorf("file = %q, want cpe1 and its comment removed", body)
+	}
+}
+
+func TestInterfacesEditErrors(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "interfaces.toml")
+	if err := os.WriteFile(filename, []byte(ifacesFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := newFakeBackend()
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "existing", args: []string{"interfaces", "add", "cpe1", "-interfaces", filename}, want: "already exists"},
+		{name: "duplicated vlan", args: []string{"interfaces", "add", "cpe3", "-interfaces", filename, "-src-iface", "1", "-outer-vlan", "101"}, want: "same iface and VLANs"},
+		{name: "unknown", args: []string{"interfaces", "remove", "cpe9", "-interfaces", filename}, want: "not exists"},
+		{name: "missing file", args: []string{"interfaces", "remove", "cpe1", "-interfaces", filename + ".missing"}, want: "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := run(t, b, formatTable, tt.args...); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	if body, _ := os.ReadFile(filename); string(body) != ifacesFile {
+		t.Errorf("file changed by failed edits, %q", body)
+	}
+	if _, err := run(t, b, formatTable, "interfaces", "add", "cpe3", "-mtu", "x"); err != errUsage {
+		t.Errorf("add with malformed flag error = %v, want %v", err, errUsage)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/rest"
+)
+
+func runSessions(c *cli, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		sessions, err := c.api.ListSessions()
+		if err != nil {
+			return err
+		}
+		return c.print(sessions, func(w io.Writer) { sessionsTable(w, sessions...) })
+	case "show":
+		if len(args) != 2 {
+			return errUsage
+		}
+		ses, err := c.api.GetSession(args[1])
+		if err != nil {
+			return err
+		}
+		return c.print(ses, func(w io.Writer) { sessionsTable(w, ses) })
+	case "clear":
+		return clearSessions(c, args[1:])
+	}
+
+	return errUsage
+}
+
+func clearSessions(c *cli, args []string) error {
+	fs := flag.NewFlagSet("sessions clear", flag.ContinueOnError)
+	all := fs.Bool("all", false, "Clear every session")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	keys := fs.Args()
+	if *all {
+		sessions, err := c.api.ListSessions()
+		if err != nil {
+			return err
+		}
+		keys = keys[:0]
+		for _, ses := range sessions {
+			keys = append(keys, ses.Key)
+		}
+	} else if len(keys) == 0 {
+		return errUsage
+	}
+
+	for _, key := range keys {
+		if err := c.api.DeleteSession(key); err != nil {
+			return fmt.Errorf("clearing session %s, %w", key, err)
+		}
+		fmt.Fprintf(c.out, "Session %s cleared\n", key)
+	}
+
+	return nil
+}
+
+func sessionsTable(w io.Writer, sessions ...rest.Session) {
+	fmt.Fprintln(w, "IPV4\tIPV6\tPREFIX\tSWIF\tSTATE\tEXPIRES")
+	for _, ses := range sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", orDash(ses.IPv4), orDash(ses.IPv6), orDash(ses.IPv6Prefix),
+			ses.Iface, ses.State, formatTime(ses.Expires))
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+func runStatus(c *cli, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	h, err := c.api.Health()
+	if err != nil {
+		return err
+	}
+
+	return c.print(h, func(w io.Writer) {
+		fmt.Fprintf(w, "Status:\t%s\n", h.Status)
+		fmt.Fprintf(w, "VPP connected:\t%t\n", h.Vpp.Connected)
+		fmt.Fprintf(w, "Failed interfaces:\t%d\n", h.Vpp.FailedIfaces)
+		fmt.Fprintf(w, "Kea socket:\t%s\n", h.Kea.Socket)
+		fmt.Fprintf(w, "Kea listening:\t%t\n", h.Kea.Listening)
+	})
+}
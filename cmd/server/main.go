@@ -1,10 +1,14 @@
 package main
 
 import (
+	"log"
+
 	"github.com/glutechnologies/glubng/pkg/core"
 )
 
 func main() {
 	c := &core.Core{}
-	c.Init()
+	if err := c.Init(); err != nil {
+		log.Fatalf("Error running GluBNGd, %s", err.Error())
+	}
 }
@@ -0,0 +1,118 @@
+package vpptest
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/glutechnologies/glubng/pkg/vpp"
+	"go.fd.io/govpp/adapter"
+)
+
+// Stats segment entries read by vpp.Client
+const (
+	statsIfaceRx = "/if/rx"
+	statsIfaceTx = "/if/tx"
+	statsRouteTo = "/net/route/to"
+)
+
+var errStats = errors.New("not supported by fake stats segment")
+
+// statsSegment is the stats segment of VPP, only dumps of counters read by
+// vpp.Client are supported
+type statsSegment struct {
+	m *VPP
+}
+
+func (m *VPP) resetStats() {
+	m.routeStats = make(map[string]uint32)
+	m.nextStatsIndex = 0
+	m.routeCounters = make(map[uint32]vpp.Counter)
+	m.ifaceCounters = make(map[uint32]vpp.IfaceCounters)
+}
+
+// SetIfaceCounters sets counters of an interface in the stats segment
+func (m *VPP) SetIfaceCounters(swIf uint32, c vpp.IfaceCounters) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ifaceCounters[swIf] = c
+}
+
+// SetRouteCounter sets counter of a route in the stats segment, it reports
+// if the route exists
+func (m *VPP) SetRouteCounter(prefix string, c vpp.Counter) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index, ok := m.routeStats[prefix]
+	if ok {
+		m.routeCounters[index] = c
+	}
+
+	return ok
+}
+
+func combinedStat(n uint32, get func(i uint32) vpp.Counter) adapter.CombinedCounterStat {
+	thread := make([]adapter.CombinedCounter, n)
+	for i := range thread {
+		c := get(uint32(i))
+		thread[i] = adapter.CombinedCounter{c.Packets, c.Bytes}
+	}
+
+	return adapter.CombinedCounterStat{thread}
+}
+
+func (s *statsSegment) Connect() error {
+	return nil
+}
+
+func (s *statsSegment) Disconnect() error {
+	return nil
+}
+
+func (s *statsSegment) DumpStats(patterns ...string) ([]adapter.StatEntry, error) {
+	m := s.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters := map[string]adapter.CombinedCounterStat{
+		statsIfaceRx: combinedStat(m.nextSwIf, func(i uint32) vpp.Counter { return m.ifaceCounters[i].Rx }),
+		statsIfaceTx: combinedStat(m.nextSwIf, func(i uint32) vpp.Counter { return m.ifaceCounters[i].Tx }),
+		statsRouteTo: combinedStat(m.nextStatsIndex, func(i uint32) vpp.Counter { return m.routeCounters[i] }),
+	}
+
+	var entries []adapter.StatEntry
+	for name, stat := range counters {
+		matched := len(patterns) == 0
+		for _, p := range patterns {
+			if ok, _ := regexp.MatchString(p, name); ok {
+				matched = true
+			}
+		}
+		if matched {
+			entries = append(entries, adapter.StatEntry{
+				StatIdentifier: adapter.StatIdentifier{Name: []byte(name)},
+				Type:           adapter.CombinedCounterVector,
+				Data:           stat,
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *statsSegment) ListStats(patterns ...string) ([]adapter.StatIdentifier, error) {
+	return nil, errStats
+}
+
+func (s *statsSegment) PrepareDir(patterns ...string) (*adapter.StatDir, error) {
+	return nil, errStats
+}
+
+func (s *statsSegment) PrepareDirOnIndex(indexes ...uint32) (*adapter.StatDir, error) {
+	return nil, errStats
+}
+
+func (s *statsSegment) UpdateDir(dir *adapter.StatDir) error {
+	return errStats
+}
@@ -0,0 +1,918 @@
+// Package vpptest provides an in-memory VPP to test vpp.Client and its
+// users without a running VPP.
+package vpptest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/vpp"
+	"go.fd.io/govpp/adapter/mock"
+	"go.fd.io/govpp/api"
+	"go.fd.io/govpp/binapi/arp"
+	"go.fd.io/govpp/binapi/dhcp"
+	"go.fd.io/govpp/binapi/fib_types"
+	interfaces "go.fd.io/govpp/binapi/interface"
+	"go.fd.io/govpp/binapi/interface_types"
+	"go.fd.io/govpp/binapi/ip"
+	"go.fd.io/govpp/binapi/ip6_nd"
+	"go.fd.io/govpp/binapi/ip_types"
+	"go.fd.io/govpp/binapi/memclnt"
+	"go.fd.io/govpp/binapi/policer"
+	"go.fd.io/govpp/binapi/tapv2"
+	"go.fd.io/govpp/codec"
+	"go.fd.io/govpp/core"
+)
+
+// Message IDs hardcoded by govpp mock adapter
+const (
+	controlPingID     = 100
+	swInterfaceDumpID = 200
+)
+
+// Iface is an interface created in VPP
+type Iface struct {
+	SwIfIndex  uint32
+	Name       string
+	Up         bool
+	MTU        uint32
+	Addresses  []string
+	Unnumbered bool
+	// SwIf lending its addresses when Unnumbered
+	UnnumberedTo uint32
+	ProxyARP     bool
+	Parent       uint32
+	SubID        uint32
+	OuterVLAN    uint16
+	InnerVLAN    uint16
+	Tag          string
+	IP6          bool
+	// Policer bound to input
+	Policer string
+	// IPv4 table
+	Table uint32
+	// Router advertisements, nil until configured
+	RA *RA
+}
+
+type RA struct {
+	Managed     bool
+	Other       bool
+	MaxInterval uint32
+	MinInterval uint32
+	Lifetime    uint32
+	Prefixes    []RAPrefix
+}
+
+type RAPrefix struct {
+	Prefix       string
+	Autoconfig   bool
+	ValidLft     uint32
+	PreferredLft uint32
+}
+
+type RoutePath struct {
+	SwIf    uint32
+	NextHop string
+	Weight  uint8
+}
+
+type Policer struct {
+	Rate  uint32
+	Burst uint64
+}
+
+type ProxyArpRange struct {
+	Low string
+	Hi  string
+}
+
+type DHCPProxy struct {
+	Server string
+	Src    string
+}
+
+// VPP is an in-memory VPP built on govpp mock adapter. It answers binary
+// API requests sent by a vpp.Client and keeps the resulting state so it can
+// be inspected.
+type VPP struct {
+	adapter  *serialAdapter
+	mu       sync.Mutex
+	ifaces   map[uint32]*Iface
+	nextSwIf uint32
+	routes   map[string][]RoutePath
+	// Table of routes by prefix, and IPv4 tables created besides table 0
+	routeTables map[string]uint32
+	tables      map[uint32]bool
+	proxyArp    []ProxyArpRange
+	dhcpProxies []DHCPProxy
+	policers    map[string]Policer
+	// Stats index of routes and counters of stats segment
+	routeStats     map[string]uint32
+	nextStatsIndex uint32
+	routeCounters  map[uint32]vpp.Counter
+	ifaceCounters  map[uint32]vpp.IfaceCounters
+	requests       []string
+	failures       map[string]api.VPPApiError
+	// Hardware interfaces survive restarts
+	hwIfaces map[uint32]string
+	// Connection events of the client, mock adapter only replies to the
+	// last connection
+	events chan core.ConnectionEvent
+}
+
+// serialAdapter handles one request at a time. Details of dumps are queued
+// in the mock adapter until the control ping following the dump, so
+// requests of other channels wait for it.
+type serialAdapter struct {
+	*mock.VppAdapter
+	mu   sync.Mutex
+	cond *sync.Cond
+	// Context of the dump waiting for its control ping, zero if none
+	multipart uint32
+	// Clients connected to the adapter
+	connected atomic.Int32
+}
+
+// Multipart requests are flagged in their context by govpp, the dump and
+// its control ping are sent with the same context
+const multipartContext = 1 << 16
+
+func (a *serialAdapter) Connect() error {
+	a.connected.Add(1)
+	return a.VppAdapter.Connect()
+}
+
+func (a *serialAdapter) Disconnect() error {
+	a.connected.Add(-1)
+	return a.VppAdapter.Disconnect()
+}
+
+func (a *serialAdapter) SendMsg(context uint32, data []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for a.multipart != 0 && a.multipart != context {
+		a.cond.Wait()
+	}
+	switch {
+	case a.multipart == context:
+		a.multipart = 0
+		a.cond.Broadcast()
+	case context&multipartContext != 0:
+		a.multipart = context
+	}
+
+	return a.VppAdapter.SendMsg(context, data)
+}
+
+// Requests handled by VPP with their reply, dumps have no reply
+var requestTypes = map[string][2]api.Message{}
+
+func init() {
+	for _, msgs := range [][2]api.Message{
+		{&memclnt.ControlPing{}, &memclnt.ControlPingReply{}},
+		{&interfaces.SwInterfaceSetFlags{}, &interfaces.SwInterfaceSetFlagsReply{}},
+		{&interfaces.SwInterfaceSetMtu{}, &interfaces.SwInterfaceSetMtuReply{}},
+		{&interfaces.SwInterfaceAddDelAddress{}, &interfaces.SwInterfaceAddDelAddressReply{}},
+		{&interfaces.SwInterfaceSetUnnumbered{}, &interfaces.SwInterfaceSetUnnumberedReply{}},
+		{&interfaces.SwInterfaceTagAddDel{}, &interfaces.SwInterfaceTagAddDelReply{}},
+		{&interfaces.SwInterfaceSetTable{}, &interfaces.SwInterfaceSetTableReply{}},
+		{&interfaces.CreateLoopback{}, &interfaces.CreateLoopbackReply{}},
+		{&interfaces.CreateSubif{}, &interfaces.CreateSubifReply{}},
+		{&interfaces.DeleteSubif{}, &interfaces.DeleteSubifReply{}},
+		{&interfaces.DeleteLoopback{}, &interfaces.DeleteLoopbackReply{}},
+		{&tapv2.TapDeleteV2{}, &tapv2.TapDeleteV2Reply{}},
+		{&tapv2.TapCreateV2{}, &tapv2.TapCreateV2Reply{}},
+		{&arp.ProxyArpIntfcEnableDisable{}, &arp.ProxyArpIntfcEnableDisableReply{}},
+		{&arp.ProxyArpAddDel{}, &arp.ProxyArpAddDelReply{}},
+		{&dhcp.DHCPProxyConfig{}, &dhcp.DHCPProxyConfigReply{}},
+		{&ip.IPRouteAddDel{}, &ip.IPRouteAddDelReply{}},
+		{&policer.PolicerAddDel{}, &policer.PolicerAddDelReply{}},
+		{&policer.PolicerInput{}, &policer.PolicerInputReply{}},
+		{&ip.SwInterfaceIP6EnableDisable{}, &ip.SwInterfaceIP6EnableDisableReply{}},
+		{&ip6_nd.SwInterfaceIP6ndRaConfig{}, &ip6_nd.SwInterfaceIP6ndRaConfigReply{}},
+		{&ip6_nd.SwInterfaceIP6ndRaPrefix{}, &ip6_nd.SwInterfaceIP6ndRaPrefixReply{}},
+		{&ip.IPRouteDump{}, nil},
+		{&interfaces.SwInterfaceDump{}, nil},
+	} {
+		requestTypes[msgs[0].GetMessageName()] = msgs
+	}
+}
+
+func newMessage(proto api.Message) api.Message {
+	return reflect.New(reflect.TypeOf(proto).Elem()).Interface().(api.Message)
+}
+
+func NewVPP() *VPP {
+	m := &VPP{
+		adapter:     &serialAdapter{VppAdapter: mock.NewVppAdapter()},
+		ifaces:      make(map[uint32]*Iface),
+		routes:      make(map[string][]RoutePath),
+		tables:      make(map[uint32]bool),
+		routeTables: make(map[string]uint32),
+		failures:    make(map[string]api.VPPApiError),
+		hwIfaces:    make(map[uint32]string),
+		policers:    make(map[string]Policer),
+	}
+	m.resetStats()
+	// local0 always exists in VPP
+	m.ifaces[0] = &Iface{SwIfIndex: 0, Name: "local0"}
+	m.nextSwIf = 1
+	m.adapter.cond = sync.NewCond(&m.adapter.mu)
+	m.adapter.MockReplyHandler(m.handleRequest)
+
+	return m
+}
+
+// AddHwInterface adds a hardware interface, as VPP would do at boot for
+// configured NICs. Returns its SwIf.
+func (m *VPP) AddHwInterface(name string) uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	swIf := m.newIface(&Iface{Name: name})
+	m.hwIfaces[swIf] = name
+
+	return swIf
+}
+
+// AddAddress configures an address on an interface as an operator would,
+// prefix is like 192.0.2.2/24
+func (m *VPP) AddAddress(swIf uint32, prefix string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ifaces[swIf].Addresses = append(m.ifaces[swIf].Addresses, prefix)
+}
+
+// AddTable creates an IPv4 table as an operator would, it's kept when VPP is
+// restarted
+func (m *VPP) AddTable(table uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tables[table] = true
+}
+
+// RouteTable returns the table of the route of a prefix
+func (m *VPP) RouteTable(prefix string) (uint32, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	table, ok := m.routeTables[prefix]
+	return table, ok
+}
+
+// Restart simulates a VPP restart. Everything configured through the API is
+// lost, hardware interfaces come back with their SwIf, tables are created
+// again and clients see their connection going down and being established
+// again.
+func (m *VPP) Restart() {
+	m.mu.Lock()
+	m.ifaces = map[uint32]*Iface{0: {SwIfIndex: 0, Name: "local0"}}
+	m.nextSwIf = 1
+	for swIf, name := range m.hwIfaces {
+		m.ifaces[swIf] = &Iface{SwIfIndex: swIf, Name: name}
+		if swIf >= m.nextSwIf {
+			m.nextSwIf = swIf + 1
+		}
+	}
+	m.routes = make(map[string][]RoutePath)
+	m.routeTables = make(map[string]uint32)
+	m.proxyArp = nil
+	m.dhcpProxies = nil
+	m.policers = make(map[string]Policer)
+	m.resetStats()
+	events := m.events
+	m.mu.Unlock()
+
+	if events != nil {
+		events <- core.ConnectionEvent{Timestamp: time.Now(), State: core.Disconnected}
+		events <- core.ConnectionEvent{Timestamp: time.Now(), State: core.Connected}
+	}
+}
+
+// Channels of clients, so requests of concurrent callers are interleaved
+const clientChannels = 4
+
+// NewClient connects a vpp.Client and provisions it from config and CPE
+// interfaces of ifacesFile, like Init does against a real VPP. As in Init,
+// client is returned with provisioning errors so degraded state can be
+// inspected. Only one client can be connected at a time, a previous one
+// must be closed.
+func (m *VPP) NewClient(config *vpp.VPPConfig, ifacesFile string) (*vpp.Client, error) {
+	conn, err := core.Connect(m.adapter)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan core.ConnectionEvent, 1)
+	c := &vpp.Client{}
+	c.SetChannels(clientChannels)
+	err = c.InitConn(config, ifacesFile, &vpp.Conn{API: conn, Events: events, Stats: &statsSegment{m: m}})
+	var provisionErrs vpp.ProvisionErrors
+	if err != nil && !errors.As(err, &provisionErrs) {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.events = events
+	m.mu.Unlock()
+
+	return c, err
+}
+
+// Connected returns number of clients connected to VPP
+func (m *VPP) Connected() int {
+	return int(m.adapter.connected.Load())
+}
+
+// WriteIfaces writes CPE interfaces to a file in a temporary directory of
+// the test, it returns its name
+func WriteIfaces(t testing.TB, ifaces map[string]vpp.Iface) string {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "interfaces.toml")
+	if err := vpp.WriteIfacesFile(name, ifaces); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+// FailNext makes next request with msgName fail with retval
+func (m *VPP) FailNext(msgName string, retval api.VPPApiError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures[msgName] = retval
+}
+
+// Requests returns names of requests received, in order
+func (m *VPP) Requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string{}, m.requests...)
+}
+
+// Ifaces returns a copy of every interface sorted by SwIf
+func (m *VPP) Ifaces() []Iface {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Iface, 0, len(m.ifaces))
+	for _, v := range m.ifaces {
+		list = append(list, v.copy())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SwIfIndex < list[j].SwIfIndex })
+
+	return list
+}
+
+// Iface returns a copy of an interface
+func (m *VPP) Iface(swIf uint32) (Iface, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.ifaces[swIf]
+	if !ok {
+		return Iface{}, false
+	}
+	return v.copy(), true
+}
+
+func (v *Iface) copy() Iface {
+	cp := *v
+	cp.Addresses = append([]string{}, v.Addresses...)
+	if v.RA != nil {
+		ra := *v.RA
+		ra.Prefixes = append([]RAPrefix{}, v.RA.Prefixes...)
+		cp.RA = &ra
+	}
+
+	return cp
+}
+
+// Routes returns paths SwIfs indexed by prefix
+func (m *VPP) Routes() map[string][]uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	routes := make(map[string][]uint32, len(m.routes))
+	for k, v := range m.routes {
+		for _, p := range v {
+			routes[k] = append(routes[k], p.SwIf)
+		}
+	}
+
+	return routes
+}
+
+// RoutePaths returns paths of a route with their next-hop and weight
+func (m *VPP) RoutePaths(prefix string) []RoutePath {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]RoutePath{}, m.routes[prefix]...)
+}
+
+// Policers returns a copy of policers indexed by name
+func (m *VPP) Policers() map[string]Policer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	policers := make(map[string]Policer, len(m.policers))
+	for k, v := range m.policers {
+		policers[k] = v
+	}
+
+	return policers
+}
+
+func (m *VPP) ProxyArpRanges() []ProxyArpRange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]ProxyArpRange{}, m.proxyArp...)
+}
+
+func (m *VPP) DHCPProxies() []DHCPProxy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]DHCPProxy{}, m.dhcpProxies...)
+}
+
+func (m *VPP) newIface(v *Iface) uint32 {
+	v.SwIfIndex = m.nextSwIf
+	m.nextSwIf++
+	m.ifaces[v.SwIfIndex] = v
+
+	return v.SwIfIndex
+}
+
+func (m *VPP) handleRequest(req mock.MessageDTO) ([]byte, uint16, bool) {
+	name := req.MsgName
+	switch req.MsgID {
+	case controlPingID:
+		name = "control_ping"
+	case swInterfaceDumpID:
+		name = "sw_interface_dump"
+	}
+
+	protos, ok := requestTypes[name]
+	if !ok {
+		// Unknown messages get mock adapter default reply
+		return nil, 0, false
+	}
+
+	msg := newMessage(protos[0])
+	if err := codec.DefaultCodec.DecodeMsg(req.Data, msg); err != nil {
+		return nil, 0, false
+	}
+
+	var reply api.Message
+	var details []api.Message
+
+	m.mu.Lock()
+	m.requests = append(m.requests, name)
+	if retval, ok := m.failures[name]; ok && protos[1] != nil {
+		// Failed requests do not change state
+		delete(m.failures, name)
+		reply = newMessage(protos[1])
+		reflect.ValueOf(reply).Elem().FieldByName("Retval").SetInt(int64(retval))
+	} else {
+		reply, details = m.process(msg)
+	}
+	m.mu.Unlock()
+
+	if reply == nil {
+		// Multipart replies are queued in the adapter, control ping
+		// following the dump ends them
+		m.adapter.MockReply(details...)
+		return nil, 0, false
+	}
+
+	msgID, err := m.adapter.GetMsgID(reply.GetMessageName(), reply.GetCrcString())
+	if err != nil {
+		return nil, 0, false
+	}
+	data, err := codec.DefaultCodec.EncodeMsg(reply, msgID)
+	if err != nil {
+		return nil, 0, false
+	}
+	binary.BigEndian.PutUint32(data[2:6], req.ClientID)
+
+	return data, msgID, true
+}
+
+// process applies a request to the state, it returns a reply or details of
+// a dump
+func (m *VPP) process(msg api.Message) (api.Message, []api.Message) {
+	switch req := msg.(type) {
+	case *memclnt.ControlPing:
+		return &memclnt.ControlPingReply{}, nil
+	case *interfaces.SwInterfaceSetFlags:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil {
+			v.Up = req.Flags&interface_types.IF_STATUS_API_FLAG_ADMIN_UP != 0
+		}
+		return &interfaces.SwInterfaceSetFlagsReply{Retval: retval}, nil
+	case *interfaces.SwInterfaceSetMtu:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil && len(req.Mtu) > 0 {
+			v.MTU = req.Mtu[0]
+		}
+		return &interfaces.SwInterfaceSetMtuReply{Retval: retval}, nil
+	case *interfaces.SwInterfaceAddDelAddress:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil {
+			retval = m.addDelAddress(v, req.Prefix.String(), req.IsAdd, req.DelAll)
+		}
+		return &interfaces.SwInterfaceAddDelAddressReply{Retval: retval}, nil
+	case *interfaces.SwInterfaceSetUnnumbered:
+		_, retval := m.lookup(req.SwIfIndex)
+		v, retvalUnnum := m.lookup(req.UnnumberedSwIfIndex)
+		if retval == 0 && v != nil {
+			v.Unnumbered = req.IsAdd
+			v.UnnumberedTo = uint32(req.SwIfIndex)
+		} else if retval == 0 {
+			retval = retvalUnnum
+		}
+		return &interfaces.SwInterfaceSetUnnumberedReply{Retval: retval}, nil
+	case *interfaces.SwInterfaceTagAddDel:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil && req.IsAdd {
+			v.Tag = req.Tag
+		} else if v != nil {
+			v.Tag = ""
+		}
+		return &interfaces.SwInterfaceTagAddDelReply{Retval: retval}, nil
+	case *interfaces.SwInterfaceSetTable:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil && req.VrfID != 0 && !m.tables[req.VrfID] {
+			retval = int32(api.NO_SUCH_FIB)
+		} else if v != nil && !req.IsIPv6 {
+			v.Table = req.VrfID
+		}
+		return &interfaces.SwInterfaceSetTableReply{Retval: retval}, nil
+	case *interfaces.CreateLoopback:
+		swIf := m.newIface(&Iface{Name: fmt.Sprintf("loop%d", m.countIfaces("loop"))})
+		return &interfaces.CreateLoopbackReply{SwIfIndex: interface_types.InterfaceIndex(swIf)}, nil
+	case *interfaces.CreateSubif:
+		return m.createSubif(req), nil
+	case *interfaces.DeleteSubif:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil && v.Parent == 0 {
+			retval = int32(api.INVALID_SW_IF_INDEX)
+		} else if v != nil {
+			delete(m.ifaces, v.SwIfIndex)
+		}
+		return &interfaces.DeleteSubifReply{Retval: retval}, nil
+	case *interfaces.DeleteLoopback:
+		return &interfaces.DeleteLoopbackReply{Retval: m.deleteIface(req.SwIfIndex, "loop")}, nil
+	case *tapv2.TapDeleteV2:
+		return &tapv2.TapDeleteV2Reply{Retval: m.deleteIface(req.SwIfIndex, "tap")}, nil
+	case *tapv2.TapCreateV2:
+		swIf := m.newIface(&Iface{Name: fmt.Sprintf("tap%d", m.countIfaces("tap"))})
+		return &tapv2.TapCreateV2Reply{SwIfIndex: interface_types.InterfaceIndex(swIf)}, nil
+	case *arp.ProxyArpIntfcEnableDisable:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil {
+			v.ProxyARP = req.Enable
+		}
+		return &arp.ProxyArpIntfcEnableDisableReply{Retval: retval}, nil
+	case *arp.ProxyArpAddDel:
+		r := ProxyArpRange{Low: req.Proxy.Low.String(), Hi: req.Proxy.Hi.String()}
+		return &arp.ProxyArpAddDelReply{Retval: m.addDelProxyArp(r, req.IsAdd)}, nil
+	case *dhcp.DHCPProxyConfig:
+		p := DHCPProxy{Server: req.DHCPServer.String(), Src: req.DHCPSrcAddress.String()}
+		return &dhcp.DHCPProxyConfigReply{Retval: m.addDelDHCPProxy(p, req.IsAdd)}, nil
+	case *ip.IPRouteAddDel:
+		retval := m.addDelRoute(&req.Route, req.IsAdd)
+		return &ip.IPRouteAddDelReply{Retval: retval, StatsIndex: m.routeStats[req.Route.Prefix.String()]}, nil
+	case *policer.PolicerAddDel:
+		return &policer.PolicerAddDelReply{Retval: m.addDelPolicer(req)}, nil
+	case *policer.PolicerInput:
+		v, retval := m.lookup(req.SwIfIndex)
+		if _, ok := m.policers[req.Name]; v != nil && !ok {
+			retval = int32(api.NO_SUCH_ENTRY)
+		} else if v != nil && req.Apply {
+			v.Policer = req.Name
+		} else if v != nil && v.Policer == req.Name {
+			v.Policer = ""
+		} else if v != nil {
+			retval = int32(api.NO_SUCH_ENTRY)
+		}
+		return &policer.PolicerInputReply{Retval: retval}, nil
+	case *ip.SwInterfaceIP6EnableDisable:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil && req.Enable && v.IP6 {
+			retval = int32(api.VALUE_EXIST)
+		} else if v != nil {
+			v.IP6 = req.Enable
+			if !v.IP6 {
+				v.RA = nil
+			}
+		}
+		return &ip.SwInterfaceIP6EnableDisableReply{Retval: retval}, nil
+	case *ip6_nd.SwInterfaceIP6ndRaConfig:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil && !v.IP6 {
+			retval = int32(api.IP6_NOT_ENABLED)
+		} else if v != nil {
+			ra := m.ra(v)
+			ra.Managed, ra.Other = req.Managed != 0, req.Other != 0
+			ra.MaxInterval, ra.MinInterval, ra.Lifetime = req.MaxInterval, req.MinInterval, req.Lifetime
+		}
+		return &ip6_nd.SwInterfaceIP6ndRaConfigReply{Retval: retval}, nil
+	case *ip6_nd.SwInterfaceIP6ndRaPrefix:
+		v, retval := m.lookup(req.SwIfIndex)
+		if v != nil && !v.IP6 {
+			retval = int32(api.IP6_NOT_ENABLED)
+		} else if v != nil {
+			m.raPrefix(m.ra(v), req)
+		}
+		return &ip6_nd.SwInterfaceIP6ndRaPrefixReply{Retval: retval}, nil
+	case *ip.IPRouteDump:
+		return nil, m.dumpRoutes(req.Table)
+	case *interfaces.SwInterfaceDump:
+		return nil, m.dumpIfaces(req.SwIfIndex)
+	}
+
+	return nil, nil
+}
+
+func (m *VPP) addDelPolicer(req *policer.PolicerAddDel) int32 {
+	_, ok := m.policers[req.Name]
+	switch {
+	case req.IsAdd && ok:
+		return int32(api.VALUE_EXIST)
+	case !req.IsAdd && !ok:
+		return int32(api.NO_SUCH_ENTRY)
+	case req.IsAdd:
+		m.policers[req.Name] = Policer{Rate: req.Cir, Burst: req.Cb}
+	default:
+		delete(m.policers, req.Name)
+	}
+
+	return 0
+}
+
+func (m *VPP) ra(v *Iface) *RA {
+	if v.RA == nil {
+		v.RA = &RA{}
+	}
+	return v.RA
+}
+
+// raPrefix adds, updates or, with IsNo, removes an advertised prefix
+func (m *VPP) raPrefix(ra *RA, req *ip6_nd.SwInterfaceIP6ndRaPrefix) {
+	p := RAPrefix{
+		Prefix:       req.Prefix.String(),
+		Autoconfig:   !req.NoAutoconfig,
+		ValidLft:     req.ValLifetime,
+		PreferredLft: req.PrefLifetime,
+	}
+
+	for i := range ra.Prefixes {
+		if ra.Prefixes[i].Prefix != p.Prefix {
+			continue
+		}
+		if req.IsNo {
+			ra.Prefixes = append(ra.Prefixes[:i], ra.Prefixes[i+1:]...)
+		} else {
+			ra.Prefixes[i] = p
+		}
+		return
+	}
+
+	if !req.IsNo {
+		ra.Prefixes = append(ra.Prefixes, p)
+	}
+}
+
+func (m *VPP) lookup(swIf interface_types.InterfaceIndex) (*Iface, int32) {
+	v, ok := m.ifaces[uint32(swIf)]
+	if !ok {
+		return nil, int32(api.INVALID_SW_IF_INDEX)
+	}
+
+	return v, 0
+}
+
+// deleteIface deletes an interface created with a name starting by prefix
+func (m *VPP) deleteIface(swIf interface_types.InterfaceIndex, prefix string) int32 {
+	v, retval := m.lookup(swIf)
+	if v == nil {
+		return retval
+	}
+	if !strings.HasPrefix(v.Name, prefix) {
+		return int32(api.INVALID_SW_IF_INDEX)
+	}
+	delete(m.ifaces, v.SwIfIndex)
+
+	return 0
+}
+
+func (m *VPP) countIfaces(prefix string) int {
+	n := 0
+	for _, v := range m.ifaces {
+		if len(v.Name) > len(prefix) && v.Name[:len(prefix)] == prefix {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (m *VPP) createSubif(req *interfaces.CreateSubif) api.Message {
+	parent, retval := m.lookup(req.SwIfIndex)
+	if parent == nil {
+		return &interfaces.CreateSubifReply{Retval: retval}
+	}
+
+	for _, v := range m.ifaces {
+		if v.Parent == parent.SwIfIndex && v.SubID == req.SubID && v.SwIfIndex != parent.SwIfIndex {
+			return &interfaces.CreateSubifReply{Retval: int32(api.SUBIF_ALREADY_EXISTS)}
+		}
+	}
+
+	swIf := m.newIface(&Iface{
+		Name:      fmt.Sprintf("%s.%d", parent.Name, req.SubID),
+		Parent:    parent.SwIfIndex,
+		SubID:     req.SubID,
+		OuterVLAN: req.OuterVlanID,
+		InnerVLAN: req.InnerVlanID,
+	})
+
+	return &interfaces.CreateSubifReply{SwIfIndex: interface_types.InterfaceIndex(swIf)}
+}
+
+func (m *VPP) addDelAddress(v *Iface, prefix string, isAdd bool, delAll bool) int32 {
+	if delAll {
+		v.Addresses = nil
+		return 0
+	}
+
+	for i, a := range v.Addresses {
+		if a != prefix {
+			continue
+		}
+		if isAdd {
+			return int32(api.ADDRESS_IN_USE)
+		}
+		v.Addresses = append(v.Addresses[:i], v.Addresses[i+1:]...)
+		return 0
+	}
+
+	if !isAdd {
+		return int32(api.ADDRESS_NOT_FOUND_FOR_INTERFACE)
+	}
+	v.Addresses = append(v.Addresses, prefix)
+
+	return 0
+}
+
+func (m *VPP) addDelProxyArp(r ProxyArpRange, isAdd bool) int32 {
+	for i, v := range m.proxyArp {
+		if v != r {
+			continue
+		}
+		if isAdd {
+			return int32(api.VALUE_EXIST)
+		}
+		m.proxyArp = append(m.proxyArp[:i], m.proxyArp[i+1:]...)
+		return 0
+	}
+
+	if !isAdd {
+		return int32(api.NO_SUCH_ENTRY)
+	}
+	m.proxyArp = append(m.proxyArp, r)
+
+	return 0
+}
+
+func (m *VPP) addDelDHCPProxy(p DHCPProxy, isAdd bool) int32 {
+	for i, v := range m.dhcpProxies {
+		if v != p {
+			continue
+		}
+		if !isAdd {
+			m.dhcpProxies = append(m.dhcpProxies[:i], m.dhcpProxies[i+1:]...)
+		}
+		return 0
+	}
+
+	if !isAdd {
+		return int32(api.NO_SUCH_ENTRY)
+	}
+	m.dhcpProxies = append(m.dhcpProxies, p)
+
+	return 0
+}
+
+func (m *VPP) addDelRoute(route *ip.IPRoute, isAdd bool) int32 {
+	prefix := route.Prefix.String()
+
+	if route.TableID != 0 && !m.tables[route.TableID] {
+		return int32(api.NO_SUCH_FIB)
+	}
+	if !isAdd {
+		if _, ok := m.routes[prefix]; !ok || m.routeTables[prefix] != route.TableID {
+			return int32(api.NO_SUCH_ENTRY)
+		}
+		delete(m.routes, prefix)
+		delete(m.routeTables, prefix)
+		delete(m.routeCounters, m.routeStats[prefix])
+		delete(m.routeStats, prefix)
+		return 0
+	}
+
+	paths := make([]RoutePath, 0, len(route.Paths))
+	for _, p := range route.Paths {
+		if _, ok := m.ifaces[p.SwIfIndex]; !ok {
+			return int32(api.INVALID_SW_IF_INDEX)
+		}
+
+		path := RoutePath{SwIf: p.SwIfIndex, Weight: p.Weight}
+		if p.Proto == fib_types.FIB_API_PATH_NH_PROTO_IP4 && p.Nh.Address.GetIP4() != (ip_types.IP4Address{}) {
+			path.NextHop = p.Nh.Address.GetIP4().String()
+		}
+		paths = append(paths, path)
+	}
+	if _, ok := m.routeStats[prefix]; !ok {
+		m.routeStats[prefix] = m.nextStatsIndex
+		m.nextStatsIndex++
+	}
+	m.routes[prefix] = paths
+	m.routeTables[prefix] = route.TableID
+
+	return 0
+}
+
+func (m *VPP) dumpRoutes(table ip.IPTable) []api.Message {
+	var details []api.Message
+
+	for prefix, paths := range m.routes {
+		p, err := ip_types.ParsePrefix(prefix)
+		if err != nil || (p.Address.Af == ip_types.ADDRESS_IP6) != table.IsIP6 || m.routeTables[prefix] != table.TableID {
+			continue
+		}
+
+		route := ip.IPRoute{TableID: table.TableID, Prefix: p, StatsIndex: m.routeStats[prefix]}
+		for _, v := range paths {
+			path := fib_types.FibPath{SwIfIndex: v.SwIf, Weight: v.Weight}
+			if a, err := ip_types.ParseAddress(v.NextHop); err == nil {
+				path.Proto = fib_types.FIB_API_PATH_NH_PROTO_IP4
+				path.Nh.Address = a.Un
+			}
+			route.Paths = append(route.Paths, path)
+		}
+		route.NPaths = uint8(len(route.Paths))
+		details = append(details, &ip.IPRouteDetails{Route: route})
+	}
+
+	return details
+}
+func (m *VPP) dumpIfaces(swIf interface_types.InterfaceIndex) []api.Message {
+	var details []api.Message
+
+	for _, v := range m.ifaces {
+		if swIf != ^interface_types.InterfaceIndex(0) && uint32(swIf) != v.SwIfIndex {
+			continue
+		}
+
+		d := &interfaces.SwInterfaceDetails{
+			SwIfIndex:      interface_types.InterfaceIndex(v.SwIfIndex),
+			SupSwIfIndex:   v.SwIfIndex,
+			InterfaceName:  v.Name,
+			Mtu:            []uint32{v.MTU, 0, 0, 0},
+			SubID:          v.SubID,
+			SubOuterVlanID: v.OuterVLAN,
+			SubInnerVlanID: v.InnerVLAN,
+			Tag:            v.Tag,
+		}
+		if v.Up {
+			d.Flags = interface_types.IF_STATUS_API_FLAG_ADMIN_UP
+		}
+		if v.Parent != 0 {
+			d.SupSwIfIndex = v.Parent
+		}
+		details = append(details, d)
+	}
+
+	return details
+}
@@ -0,0 +1,198 @@
+package circuit
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/glutechnologies/glubng/pkg/utils"
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+// Strategies mapping relay agent ids to CPE interfaces
+const (
+	// Circuit-id is the SwIf of the CPE interface in hex, i.e. 0x00000001,
+	// as inserted by VPP DHCP relay
+	StrategyHex = "hex"
+	// Circuit-id and remote-id match a template which names the CPE
+	// interface
+	StrategyRegex = "regex"
+	// CircuitID and RemoteID of CPE interfaces in interfaces.toml
+	StrategyTable = "table"
+)
+
+var ErrNoInterface = errors.New("no CPE interface for relay agent ids")
+
+// Mapping of circuit-ids, hex is used when no strategies are set
+type Config struct {
+	// Tried in order until one resolves a CPE interface
+	Strategies []string
+	// Used by StrategyRegex, in order
+	Templates []Template
+}
+
+// Template maps relay agent ids to the name of a CPE interface
+type Template struct {
+	// Regular expressions matching whole ids, empty ones match any id
+	CircuitID string
+	RemoteID  string
+	// Name of the CPE interface in interfaces.toml, submatches are expanded
+	// as in regexp.Expand, i.e. "cpe-${port}-${vlan}"
+	Iface string
+}
+
+// Validate checks strategies and templates
+func (c *Config) Validate() error {
+	for _, s := range c.Strategies {
+		switch s {
+		case StrategyHex, StrategyRegex, StrategyTable:
+		default:
+			return fmt.Errorf("circuit.Strategies %q is not %s, %s or %s", s, StrategyHex, StrategyRegex, StrategyTable)
+		}
+	}
+
+	for i := range c.Templates {
+		if _, err := c.Templates[i].compile(); err != nil {
+			return fmt.Errorf("circuit.Templates %d, %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Ifaces resolves provisioned CPE interfaces
+type Ifaces interface {
+	LookupIface(swIf int) (vpp.Iface, bool)
+	LookupIfaceName(name string) (vpp.Iface, bool)
+	LookupRelayIDs(circuitID string, remoteID string) (vpp.Iface, bool)
+}
+
+// template matches circuit-id and remote-id joined by a NUL, so submatches
+// of both are expanded at once
+type template struct {
+	re    *regexp.Regexp
+	iface string
+}
+
+func (t *Template) compile() (*template, error) {
+	if t.Iface == "" {
+		return nil, errors.New("Iface is empty")
+	}
+	if t.CircuitID == "" && t.RemoteID == "" {
+		return nil, errors.New("CircuitID and RemoteID are empty")
+	}
+
+	patterns := []string{t.CircuitID, t.RemoteID}
+	for i, p := range patterns {
+		if p == "" {
+			patterns[i] = `[^\x00]*`
+		} else if _, err := regexp.Compile(p); err != nil {
+			return nil, err
+		}
+	}
+
+	re, err := regexp.Compile(`^(?:` + patterns[0] + `)\x00(?:` + patterns[1] + `)$`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &template{re: re, iface: t.Iface}, nil
+}
+
+// expand returns the name of the interface if both ids match
+func (t *template) expand(circuitID string, remoteID string) (string, bool) {
+	ids := circuitID + "\x00" + remoteID
+	match := t.re.FindStringSubmatchIndex(ids)
+	if match == nil {
+		return "", false
+	}
+
+	return string(t.re.ExpandString(nil, t.iface, ids, match)), true
+}
+
+// strategy returns SwIf of the CPE interface of relay agent ids
+type strategy func(circuitID string, remoteID string) (int, bool)
+
+// Mapper resolves CPE interfaces of DHCP queries from relay agent ids
+type Mapper struct {
+	strategies []strategy
+}
+
+func (m *Mapper) Init(config *Config, ifaces Ifaces) error {
+	names := config.Strategies
+	if len(names) == 0 {
+		names = []string{StrategyHex}
+	}
+
+	m.strategies = nil
+	for _, name := range names {
+		switch name {
+		case StrategyHex:
+			m.strategies = append(m.strategies, hexStrategy(ifaces))
+		case StrategyRegex:
+			var templates []*template
+			for i := range config.Templates {
+				t, err := config.Templates[i].compile()
+				if err != nil {
+					return fmt.Errorf("circuit.Templates %d, %w", i, err)
+				}
+				templates = append(templates, t)
+			}
+			m.strategies = append(m.strategies, regexStrategy(templates, ifaces))
+		case StrategyTable:
+			m.strategies = append(m.strategies, tableStrategy(ifaces))
+		default:
+			return fmt.Errorf("unknown circuit-id strategy %q", name)
+		}
+	}
+
+	return nil
+}
+
+// Resolve returns SwIf of the CPE interface of a query, from its circuit-id,
+// or interface-id in DHCPv6, and remote-id
+func (m *Mapper) Resolve(circuitID string, remoteID string) (int, error) {
+	for _, s := range m.strategies {
+		if swIf, ok := s(circuitID, remoteID); ok {
+			return swIf, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w, circuit-id %q, remote-id %q", ErrNoInterface, circuitID, remoteID)
+}
+
+// hexStrategy resolves circuit-ids with the 0x prefix inserted by VPP relay,
+// only when the SwIf is a CPE interface so other circuit-ids in hex are left
+// to the next strategies
+func hexStrategy(ifaces Ifaces) strategy {
+	return func(circuitID string, remoteID string) (int, bool) {
+		swIf, err := utils.ConvertCIDToInt(circuitID)
+		if err != nil {
+			return 0, false
+		}
+		_, ok := ifaces.LookupIface(swIf)
+		return swIf, ok
+	}
+}
+
+func regexStrategy(templates []*template, ifaces Ifaces) strategy {
+	return func(circuitID string, remoteID string) (int, bool) {
+		for _, t := range templates {
+			name, ok := t.expand(circuitID, remoteID)
+			if !ok {
+				continue
+			}
+			if v, ok := ifaces.LookupIfaceName(name); ok {
+				return v.SwIf, true
+			}
+		}
+		return 0, false
+	}
+}
+
+func tableStrategy(ifaces Ifaces) strategy {
+	return func(circuitID string, remoteID string) (int, bool) {
+		v, ok := ifaces.LookupRelayIDs(circuitID, remoteID)
+		return v.SwIf, ok
+	}
+}
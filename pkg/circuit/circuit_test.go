@@ -0,0 +1,78 @@
+package circuit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+type fakeIfaces map[string]vpp.Iface
+
+func (f fakeIfaces) LookupIface(swIf int) (vpp.Iface, bool) {
+	for _, v := range f {
+		if v.SwIf == swIf {
+			return v, true
+		}
+	}
+	return vpp.Iface{}, false
+}
+
+func (f fakeIfaces) LookupIfaceName(name string) (vpp.Iface, bool) {
+	v, ok := f[name]
+	return v, ok
+}
+
+func (f fakeIfaces) LookupRelayIDs(circuitID string, remoteID string) (vpp.Iface, bool) {
+	for _, v := range f {
+		if v.CircuitID == circuitID && (v.RemoteID == "" || v.RemoteID == remoteID) {
+			return v, true
+		}
+	}
+	return vpp.Iface{}, false
+}
+
+func TestResolve(t *testing.T) {
+	ifaces := fakeIfaces{
+		"cpe-3-100": {SwIf: 3},
+		"cpe2":      {SwIf: 4, CircuitID: "olt1 eth 1/1/7:200"},
+	}
+	config := &Config{
+		Strategies: []string{StrategyHex, StrategyRegex, StrategyTable},
+		Templates: []Template{
+			{CircuitID: `eth0/1/(?P<port>\d+):(?P<vlan>\d+)`, Iface: "cpe-${port}-${vlan}"},
+		},
+	}
+	var m Mapper
+	if err := m.Init(config, ifaces); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	tests := []struct {
+		circuitID string
+		want      int
+		wantErr   bool
+	}{
+		{circuitID: "0x00000003", want: 3},
+		// SwIf which is not a CPE interface
+		{circuitID: "0x00000009", wantErr: true},
+		// Hex without prefix is not inserted by VPP relay
+		{circuitID: "00000003", wantErr: true},
+		{circuitID: "0x-0000003", wantErr: true},
+		{circuitID: "eth0/1/3:100", want: 3},
+		{circuitID: "eth0/1/4:100", wantErr: true},
+		{circuitID: "olt1 eth 1/1/7:200", want: 4},
+	}
+	for _, tt := range tests {
+		swIf, err := m.Resolve(tt.circuitID, "")
+		if tt.wantErr {
+			if !errors.Is(err, ErrNoInterface) {
+				t.Errorf("Resolve(%q) = %d, %v, want %v", tt.circuitID, swIf, err, ErrNoInterface)
+			}
+			continue
+		}
+		if err != nil || swIf != tt.want {
+			t.Errorf("Resolve(%q) = %d, %v, want %d", tt.circuitID, swIf, err, tt.want)
+		}
+	}
+}
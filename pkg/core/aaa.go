@@ -0,0 +1,322 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/kea"
+	"github.com/glutechnologies/glubng/pkg/radius"
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+// Accounting is told when IPv4 sessions start and stop, calls must not block
+type Accounting interface {
+	Start(s *radius.AcctSession)
+	Interim(s *radius.AcctSession)
+	Stop(s *radius.AcctSession, cause radius.TerminateCause)
+}
+
+// SetAccounting enables accounting of sessions
+func (s *Sessions) SetAccounting(acct Accounting) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.acct = acct
+}
+
+func newAcctSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// flexID returns flex-id of a CPE interface, sent as NAS-Port-Id
+func flexID(dp vpp.Dataplane, iface int) string {
+	v, _ := dp.LookupIface(iface)
+	return v.FlexId
+}
+
+func (s *Sessions) acctSession(ses *Session) *radius.AcctSession {
+	return &radius.AcctSession{
+		ID:       ses.AcctSessionID,
+		Username: ses.Username,
+		MAC:      ses.MAC,
+		IPv4:     ses.IPv4,
+		Iface:    ses.Iface,
+		FlexID:   flexID(s.vpp, ses.Iface),
+		Started:  ses.Started,
+		Class:    ses.Class,
+		Input:    radius.Counter{Packets: ses.Counters.InPackets, Bytes: ses.Counters.InBytes},
+		Output:   radius.Counter{Packets: ses.Counters.OutPackets, Bytes: ses.Counters.OutBytes},
+	}
+}
+
+// copyAAA copies attributes given by RADIUS from another session
+func (ses *Session) copyAAA(from *Session) {
+	ses.Username = from.Username
+	ses.VRF = from.VRF
+	ses.Pool = from.Pool
+	ses.Class = from.Class
+	ses.Deadline = from.Deadline
+}
+
+// startAccounting opens an accounting session for a session becoming active
+func (s *Sessions) startAccounting(ses *Session) {
+	ses.Started = time.Now()
+	ses.Counters = SessionCounters{}
+	if s.acct == nil {
+		return
+	}
+
+	ses.AcctSessionID = newAcctSessionID()
+	s.acct.Start(s.acctSession(ses))
+}
+
+// stopAccounting closes accounting session of a session, it must be called
+// before IPv4 route is removed
+func (s *Sessions) stopAccounting(ses *Session, cause radius.TerminateCause) {
+	if s.acct == nil || ses.AcctSessionID == "" || ses.State != SessionActive || ses.IPv4 == nil {
+		return
+	}
+
+	s.acct.Stop(s.acctSession(ses), cause)
+	ses.AcctSessionID = ""
+}
+
+// InterimUpdate sends accounting of every active session
+func (s *Sessions) InterimUpdate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.acct == nil {
+		return
+	}
+
+	for _, ses := range s.sessions {
+		if ses.State == SessionActive && ses.IPv4 != nil && ses.AcctSessionID != "" {
+			s.acct.Interim(s.acctSession(ses))
+		}
+	}
+}
+
+// authTTL is how long an authorization given while answering Kea waits for
+// the lease it allowed
+const authTTL = time.Minute
+
+type pendingAuth struct {
+	auth    *radius.Authorization
+	expires time.Time
+}
+
+// keaAuthorizer authenticates subscribers with RADIUS while Kea waits for
+// the response to their query, so Kea allocates leases from the pool given by
+// RADIUS and never answers rejected subscribers. Authorizations wait for the
+// lease to be selected.
+type keaAuthorizer struct {
+	c       *Core
+	mu      sync.Mutex
+	pending map[string]pendingAuth
+}
+
+func authKey(swIf int, mac string) string {
+	return fmt.Sprintf("%d/%s", swIf, mac)
+}
+
+// Authorize implements kea.Authorizer
+func (a *keaAuthorizer) Authorize(swIf int, q *kea.Query) (*kea.Authorization, error) {
+	if a.c.radius == nil {
+		return nil, nil
+	}
+
+	// Active sessions keep their authorization
+	if cur := a.c.sessions.activeByMAC(swIf, q.HwAddr); cur != nil {
+		return a.c.keaAuthorization(cur.Pool, cur.Profile), nil
+	}
+
+	auth, err := a.c.radius.Authenticate(&radius.Subscriber{
+		CircuitID: q.Option82CID,
+		RemoteID:  q.Option82RID,
+		MAC:       q.HwAddr,
+		Iface:     swIf,
+		FlexID:    flexID(a.c.vpp, swIf),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.c.checkAuthorization(auth); err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.pending == nil {
+		a.pending = make(map[string]pendingAuth)
+	}
+	for k, p := range a.pending {
+		if now.After(p.expires) {
+			delete(a.pending, k)
+		}
+	}
+	a.pending[authKey(swIf, q.HwAddr)] = pendingAuth{auth: auth, expires: now.Add(authTTL)}
+
+	return a.c.keaAuthorization(auth.Pool, auth.Profile), nil
+}
+
+// take returns and forgets the authorization given to a subscriber, nil when
+// there is none
+func (a *keaAuthorizer) take(swIf int, mac string) *radius.Authorization {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := authKey(swIf, mac)
+	p, ok := a.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(a.pending, key)
+	if time.Now().After(p.expires) {
+		return nil
+	}
+
+	return p.auth
+}
+
+// checkAuthorization rejects attributes naming VRFs or profiles which are not
+// configured
+func (c *Core) checkAuthorization(auth *radius.Authorization) error {
+	if auth.VRF != "" {
+		if _, ok := c.config.Vpp.VRFs[auth.VRF]; !ok {
+			return fmt.Errorf("%w %q", vpp.ErrUnknownVRF, auth.VRF)
+		}
+	}
+	if auth.Profile != "" {
+		if _, ok := c.vpp.Profiles()[auth.Profile]; !ok {
+			return fmt.Errorf("%w %q", vpp.ErrUnknownProfile, auth.Profile)
+		}
+	}
+
+	return nil
+}
+
+// keaAuthorization returns what Kea is told about an authorized subscriber,
+// client classes come from DHCP settings of its profile
+func (c *Core) keaAuthorization(pool string, profile string) *kea.Authorization {
+	auth := &kea.Authorization{Pool: pool}
+	if p, ok := c.vpp.Profiles()[profile]; ok && profile != "" {
+		auth.ClientClasses = p.DHCP.ClientClasses
+	}
+
+	return auth
+}
+
+// authorize applies attributes given by RADIUS to the session of a lease.
+// Subscribers are authorized when Kea asks for the response to their query,
+// they are authenticated here when the hook did not ask for it. Renewals of an
+// active session keep its authorization.
+func (c *Core) authorize(msg *kea.KeaResult, ses *Session) error {
+	if c.radius == nil {
+		return nil
+	}
+
+	auth := c.authorizer.take(ses.Iface, msg.Query.HwAddr)
+	if auth == nil && msg.Callout == kea.CALLOUT_LEASE4_RENEW {
+		cur := c.sessions.GetSession(ses.Key())
+		if cur != nil && cur.State == SessionActive && cur.Iface == ses.Iface {
+			ses.copyAAA(cur)
+			ses.Profile = cur.Profile
+			return nil
+		}
+	}
+
+	if auth == nil {
+		var err error
+		auth, err = c.radius.Authenticate(&radius.Subscriber{
+			CircuitID: msg.Query.Option82CID,
+			RemoteID:  msg.Query.Option82RID,
+			MAC:       msg.Query.HwAddr,
+			IPv4:      ses.IPv4,
+			Iface:     ses.Iface,
+			FlexID:    flexID(c.vpp, ses.Iface),
+		})
+		if err != nil {
+			return err
+		}
+		if err := c.checkAuthorization(auth); err != nil {
+			return err
+		}
+	}
+
+	ses.Username = auth.Username
+	ses.VRF = auth.VRF
+	ses.Pool = auth.Pool
+	ses.Class = auth.Class
+	// Filter-Id replaces profile given by the lease
+	if auth.Profile != "" {
+		ses.Profile = auth.Profile
+	}
+	if auth.SessionTimeout > 0 {
+		ses.Deadline = time.Now().Add(auth.SessionTimeout)
+	}
+
+	return nil
+}
+
+// accountSessions sends Interim-Update of active sessions periodically
+func (c *Core) accountSessions(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.sessions.InterimUpdate()
+		}
+	}
+}
+
+// initRadius starts RADIUS client when a server is configured
+func (c *Core) initRadius() error {
+	if !c.config.Radius.IsEnabled() {
+		return nil
+	}
+
+	client := &radius.Client{}
+	if err := client.Init(&c.config.Radius); err != nil {
+		return err
+	}
+	c.radius = client
+	if c.config.Radius.AcctServer != "" {
+		c.sessions.SetAccounting(client)
+	}
+
+	if c.config.Radius.DAEListen != "" {
+		if err := c.dae.Init(&c.config.Radius, &daeBackend{c: c}); err != nil {
+			client.Close()
+			c.radius = nil
+			return fmt.Errorf("listening for CoA requests, %w", err)
+		}
+	}
+	log.Printf("RADIUS enabled, authentication: %q, accounting: %q, CoA: %q",
+		c.config.Radius.AuthServer, c.config.Radius.AcctServer, c.config.Radius.DAEListen)
+
+	return nil
+}
+
+func (c *Core) closeRadius() {
+	if c.radius != nil {
+		c.dae.Close()
+		c.radius.Close()
+	}
+}
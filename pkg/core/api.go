@@ -0,0 +1,140 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/glutechnologies/glubng/pkg/rest"
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+// restBackend exposes core state to REST API
+type restBackend struct {
+	c *Core
+}
+
+func toRestSession(ses *Session) rest.Session {
+	return rest.Session{
+		Key:               ses.Key(),
+		Iface:             ses.Iface,
+		IPv4:              ipString(ses.IPv4),
+		State:             string(ses.State),
+		Expires:           ses.Expires,
+		QuarantineUntil:   ses.QuarantineUntil,
+		IPv6:              ipString(ses.IPv6),
+		IPv6Expires:       ses.IPv6Expires,
+		IPv6Prefix:        ses.IPv6Prefix,
+		IPv6PrefixExpires: ses.IPv6PrefixExpires,
+		Profile:           ses.Profile,
+		MAC:               ses.MAC,
+		Username:          ses.Username,
+		AcctSessionID:     ses.AcctSessionID,
+		VRF:               ses.VRF,
+		Pool:              ses.Pool,
+		Deadline:          ses.Deadline,
+		InPackets:         ses.Counters.InPackets,
+		InBytes:           ses.Counters.InBytes,
+		OutPackets:        ses.Counters.OutPackets,
+		OutBytes:          ses.Counters.OutBytes,
+		CountersUpdated:   ses.Counters.Updated,
+	}
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func (b *restBackend) ListSessions() []rest.Session {
+	sessions := b.c.sessions.ListSessions()
+
+	list := make([]rest.Session, 0, len(sessions))
+	for i := range sessions {
+		list = append(list, toRestSession(&sessions[i]))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+
+	return list
+}
+
+func (b *restBackend) GetSession(key string) (rest.Session, error) {
+	ses := b.c.sessions.GetSession(key)
+	if ses == nil {
+		return rest.Session{}, fmt.Errorf("session %s %w", key, rest.ErrNotFound)
+	}
+
+	return toRestSession(ses), nil
+}
+
+func (b *restBackend) DeleteSession(key string) error {
+	if b.c.sessions.GetSession(key) == nil {
+		return fmt.Errorf("session %s %w", key, rest.ErrNotFound)
+	}
+
+	return b.c.sessions.RemoveSession(key)
+}
+
+func (b *restBackend) ListInterfaces() []rest.Interface {
+	ifaces := b.c.vpp.GetIfaces()
+	status := b.c.vpp.GetIfacesStatus()
+
+	list := make([]rest.Interface, 0, len(ifaces))
+	for name, v := range ifaces {
+		list = append(list, rest.Interface{
+			Name:        name,
+			SwIf:        v.SwIf,
+			VPPSrcIface: v.VPPSrcIface,
+			IsSubIf:     v.IsSubIf,
+			HasQinQ:     v.HasQinQ,
+			OuterVLAN:   v.OuterVLAN,
+			InnerVLAN:   v.InnerVLAN,
+			MTU:         v.MTU,
+			FlexId:      v.FlexId,
+			Profile:     v.Profile,
+			CircuitID:   v.CircuitID,
+			RemoteID:    v.RemoteID,
+			Status:      string(status[name].State),
+			Reason:      status[name].Reason,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// Config returns running configuration, service profiles are the ones
+// reloaded last
+// redacted replaces RADIUS secrets in configuration shown by REST API
+const redacted = "***"
+
+func (b *restBackend) Config() interface{} {
+	config := b.c.config
+	config.Vpp.Profiles = b.c.vpp.Profiles()
+	if config.Radius.Secret != "" {
+		config.Radius.Secret = redacted
+	}
+	if config.Radius.Password != "" {
+		config.Radius.Password = redacted
+	}
+	return config
+}
+
+func (b *restBackend) Health() rest.Health {
+	failed := 0
+	for _, st := range b.c.vpp.GetIfacesStatus() {
+		if st.State == vpp.IfaceFailed {
+			failed++
+		}
+	}
+
+	h := rest.Health{
+		Vpp: rest.VppHealth{Connected: b.c.vpp.IsConnected(), FailedIfaces: failed},
+		Kea: rest.KeaHealth{Socket: b.c.config.Misc.SrcKeaSocket, Listening: b.c.kea.IsListening()},
+	}
+	h.Status = rest.HealthStatus(&h)
+
+	return h
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/glutechnologies/glubng/pkg/radius"
+	"github.com/glutechnologies/glubng/pkg/rest"
+)
+
+func TestRestConfigRedactsSecrets(t *testing.T) {
+	f := newSessionsFixture(t)
+	c := &Core{vpp: f.client}
+	c.config.Vpp = *f.config
+	c.config.Radius = radius.Config{AuthServer: "127.0.0.1:1812", Secret: "s3cr3t-shared", Password: "s3cr3t-password"}
+
+	srv := httptest.NewServer(rest.NewHandler(&restBackend{c: c}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "s3cr3t") {
+		t.Errorf("config = %s, want RADIUS secrets redacted", body)
+	}
+
+	var config CoreConfig
+	if err := json.Unmarshal(body, &config); err != nil {
+		t.Fatalf("decoding %s, %v", body, err)
+	}
+	if config.Radius.Secret != redacted || config.Radius.Password != redacted || config.Radius.AuthServer != "127.0.0.1:1812" {
+		t.Errorf("radius config = %+v, want secrets %q", config.Radius, redacted)
+	}
+	// Loaded configuration is not changed
+	if c.config.Radius.Secret != "s3cr3t-shared" {
+		t.Errorf("loaded secret = %q", c.config.Radius.Secret)
+	}
+}
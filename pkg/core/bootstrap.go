@@ -0,0 +1,93 @@
+package core
+
+import (
+	"log"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/kea"
+)
+
+// bootstrapTimeout bounds how long workers wait for leases of Kea to be
+// applied, events of Kea are queued meanwhile and the hook waits once the
+// queue is full
+const bootstrapTimeout = 30 * time.Second
+
+// bootstrapLeases loads leases of Kea, selected while glubngd was down, and
+// applies them as if they were selected now. Sessions of leases whose
+// lifetime has passed are expired. Leases not applied within
+// bootstrapTimeout are bound when they are renewed.
+func (c *Core) bootstrapLeases(now time.Time) {
+	if c.config.Kea.Bootstrap == "" {
+		return
+	}
+
+	leases, err := c.config.Kea.LoadLeases4()
+	if err != nil {
+		log.Printf("Error loading leases from Kea, %s", err.Error())
+		return
+	}
+
+	c.applyLeases(leases, now, now.Add(bootstrapTimeout))
+}
+
+// refreshSession refreshes the lease timer of a session already active on
+// the interface of a lease, i.e. restored from the session store. It keeps
+// its authorization, so the subscriber is not authenticated again. It reports
+// false when the lease must be selected.
+func (c *Core) refreshSession(msg *kea.KeaResult) (bool, error) {
+	ses, err := sessionFromKea(msg, &c.circuits)
+	if err != nil {
+		return false, err
+	}
+
+	cur := c.sessions.GetSession(ses.Key())
+	if cur == nil || cur.State != SessionActive || cur.Iface != ses.Iface {
+		return false, nil
+	}
+	ses.copyAAA(cur)
+	ses.Profile = cur.Profile
+
+	return true, c.sessions.RenewSession(ses)
+}
+
+func (c *Core) applyLeases(leases []kea.Lease4, now time.Time, deadline time.Time) {
+	var added, expired, skipped int
+
+	for i := range leases {
+		if time.Now().After(deadline) {
+			log.Printf("Bootstrap of Kea leases timed out, %d leases are bound when renewed", len(leases)-i)
+			skipped += len(leases) - i
+			break
+		}
+		l := &leases[i]
+		if !l.IsActive(now) {
+			if ses := c.sessions.GetSession(l.IPAddress); ses != nil && ses.State == SessionActive {
+				if err := c.sessions.ExpireSession(l.IPAddress); err != nil {
+					log.Printf("Error expiring session of Kea lease, %s", err.Error())
+				}
+				expired++
+			}
+			continue
+		}
+
+		msg := l.Result(now)
+		if msg.Query.Option82CID == "" && msg.Query.Option82RID == "" {
+			// Kea only stores relay agent info with store-extended-info
+			log.Printf("Kea lease %s has no relay agent info, it's not bootstrapped", l.IPAddress)
+			skipped++
+			continue
+		}
+		refreshed, err := c.refreshSession(&msg)
+		if !refreshed && err == nil {
+			err = c.processKeaMessage(&msg)
+		}
+		if err != nil {
+			log.Printf("Error bootstrapping Kea lease %s, %s", l.IPAddress, err.Error())
+			skipped++
+			continue
+		}
+		added++
+	}
+
+	log.Printf("Bootstrapped %d leases from Kea, %d sessions expired and %d leases skipped", added, expired, skipped)
+}
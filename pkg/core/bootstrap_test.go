@@ -0,0 +1,100 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/circuit"
+	"github.com/glutechnologies/glubng/pkg/kea"
+	"github.com/glutechnologies/glubng/pkg/radius"
+)
+
+// relayLease returns an active lease relayed by VPP on a CPE interface
+func relayLease(ip string, swIf int, now time.Time) kea.Lease4 {
+	info := fmt.Sprintf(`{"ISC": {"relay-agent-info": "0x0104%08X"}}`, swIf)
+	return kea.Lease4{
+		IPAddress:   ip,
+		HwAddress:   "aa:bb:cc:dd:ee:ff",
+		Cltt:        int(now.Unix()),
+		ValidLft:    3600,
+		SubnetID:    1,
+		UserContext: json.RawMessage(info),
+	}
+}
+
+func TestApplyLeasesKeepsAuthorization(t *testing.T) {
+	f := newSessionsFixture(t)
+	now := time.Now()
+
+	c := &Core{vpp: f.client}
+	c.sessions.Init(f.client, nil)
+	if err := c.circuits.Init(&circuit.Config{}, f.client); err != nil {
+		t.Fatal(err)
+	}
+
+	// Access-Requests fail, nothing listens on the port
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := conn.LocalAddr().String()
+	conn.Close()
+	c.radius = &radius.Client{}
+	if err := c.radius.Init(&radius.Config{AuthServer: server, Secret: "secret", Timeout: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.radius.Close()
+
+	// Session restored from the store
+	restored := &Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe1"), Expires: now.Add(time.Minute), Profile: "premium"}
+	restored.Username = "subscriber-1"
+	if err := c.sessions.AddSession(restored); err != nil {
+		t.Fatal(err)
+	}
+
+	c.applyLeases([]kea.Lease4{
+		relayLease(testIPv4, f.swIf("cpe1"), now),
+		relayLease("100.64.0.11", f.swIf("cpe2"), now),
+	}, now, now.Add(time.Minute))
+
+	ses := c.sessions.GetSession(testIPv4)
+	if ses == nil || ses.State != SessionActive {
+		t.Fatalf("session = %+v, want active", ses)
+	}
+	if ses.Username != "subscriber-1" || ses.Profile != "premium" {
+		t.Errorf("session username %q and profile %q, want authorization kept", ses.Username, ses.Profile)
+	}
+	if !ses.Expires.After(now.Add(time.Minute)) {
+		t.Errorf("session expires %s, want lease timer refreshed", ses.Expires)
+	}
+
+	// New leases are authenticated
+	if ses := c.sessions.GetSession("100.64.0.11"); ses != nil {
+		t.Errorf("session of unauthenticated lease = %+v, want none", ses)
+	}
+}
+
+func TestApplyLeasesTimeout(t *testing.T) {
+	f := newSessionsFixture(t)
+	now := time.Now()
+
+	c := &Core{vpp: f.client}
+	c.sessions.Init(f.client, nil)
+	if err := c.circuits.Init(&circuit.Config{}, f.client); err != nil {
+		t.Fatal(err)
+	}
+
+	// Workers are not held up once the deadline has passed
+	c.applyLeases([]kea.Lease4{relayLease(testIPv4, f.swIf("cpe1"), now)}, now, now.Add(-time.Second))
+	if ses := c.sessions.GetSession(testIPv4); ses != nil {
+		t.Errorf("session applied after the deadline = %+v, want none", ses)
+	}
+
+	c.applyLeases([]kea.Lease4{relayLease(testIPv4, f.swIf("cpe1"), now)}, now, now.Add(time.Minute))
+	if ses := c.sessions.GetSession(testIPv4); ses == nil || ses.State != SessionActive {
+		t.Errorf("session = %+v, want active", ses)
+	}
+}
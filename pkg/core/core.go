@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -10,64 +11,198 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/glutechnologies/glubng/pkg/circuit"
 	"github.com/glutechnologies/glubng/pkg/kea"
-	"github.com/glutechnologies/glubng/pkg/utils"
+	"github.com/glutechnologies/glubng/pkg/radius"
+	"github.com/glutechnologies/glubng/pkg/rest"
 	"github.com/glutechnologies/glubng/pkg/vpp"
 )
 
 // Configuration aggregation
 type CoreConfig struct {
-	Misc MiscConfig    `toml:"misc"`
-	Vpp  vpp.VPPConfig `toml:"vpp"`
+	Misc    MiscConfig      `toml:"misc"`
+	Vpp     vpp.VPPConfig   `toml:"vpp"`
+	Radius  radius.Config   `toml:"radius"`
+	Circuit circuit.Config  `toml:"circuit"`
+	Kea     kea.DHCP4Config `toml:"kea"`
+	Queue   kea.QueueConfig `toml:"queue"`
 }
 
 type MiscConfig struct {
 	SrcKeaSocket string
+	// Sessions are only kept in memory when path is empty
+	SessionStorePath         string
+	SessionStoreCompactEvery int
+	// Timers in seconds, defaults are used when zero
+	DeclineQuarantine int
+	ExpiredRetention  int
+	// REST API listen address, API is disabled when empty
+	RestListen string
+	// StartupFailFast or StartupDegraded, fail-fast when empty
+	StartupPolicy string
+	// ShutdownKeep or ShutdownCleanup, keep when empty
+	ShutdownMode string
 }
 
+const (
+	// Exit when any object fails to be provisioned in VPP
+	StartupFailFast = "fail-fast"
+	// Keep running with healthy interfaces when some of them fail
+	StartupDegraded = "degraded"
+)
+
+const (
+	// Leave VPP configured on exit, next start reconciles it
+	ShutdownKeep = "keep"
+	// Remove everything created in VPP on exit
+	ShutdownCleanup = "cleanup"
+)
+
+const sessionSweepInterval = 30 * time.Second
+
 type Core struct {
 	ifacesFile string
 	configFile string
 	control    chan os.Signal
+	reload     chan os.Signal
+	stop       chan struct{}
+	// Closed when workers processing Kea events have finished
+	keaDone    chan struct{}
 	config     CoreConfig
 	sessions   Sessions
-	vpp        vpp.Client
+	vpp        vpp.Dataplane
 	kea        kea.KeaSocket
+	events     kea.Queue
+	circuits   circuit.Mapper
+	radius     *radius.Client
+	authorizer keaAuthorizer
+	dae        radius.DAEServer
+	rest       rest.Server
 	wg         sync.WaitGroup
 }
 
-func (c *Core) LoadConfig() {
-	body, err := os.ReadFile(c.configFile)
+// ReadConfig decodes a glubng.toml file
+func ReadConfig(filename string) (CoreConfig, error) {
+	var config CoreConfig
 
+	body, err := os.ReadFile(filename)
 	if err != nil {
-		log.Fatalf("Error loading configuration file")
+		return config, err
 	}
 
-	_, err = toml.Decode(string(body), &c.config)
+	_, err = toml.Decode(string(body), &config)
+
+	return config, err
+}
+
+// Validate checks configuration values before using them
+func (c *CoreConfig) Validate() error {
+	if c.Misc.SrcKeaSocket == "" {
+		return errors.New("misc.SrcKeaSocket is empty")
+	}
+	if c.Misc.SessionStoreCompactEvery < 0 {
+		return errors.New("misc.SessionStoreCompactEvery is negative")
+	}
+	if c.Misc.DeclineQuarantine < 0 || c.Misc.ExpiredRetention < 0 {
+		return errors.New("misc session timers are negative")
+	}
+	switch c.Misc.StartupPolicy {
+	case "", StartupFailFast, StartupDegraded:
+	default:
+		return fmt.Errorf("misc.StartupPolicy %q is not %s or %s", c.Misc.StartupPolicy, StartupFailFast, StartupDegraded)
+	}
+	switch c.Misc.ShutdownMode {
+	case "", ShutdownKeep, ShutdownCleanup:
+	default:
+		return fmt.Errorf("misc.ShutdownMode %q is not %s or %s", c.Misc.ShutdownMode, ShutdownKeep, ShutdownCleanup)
+	}
+
+	if err := c.Radius.Validate(); err != nil {
+		return err
+	}
+	// CoA-Requests restore settings of interfaces with it
+	if _, ok := c.Vpp.Profiles[radius.InterfaceDefault]; ok {
+		return fmt.Errorf("vpp.Profiles %s is reserved", radius.InterfaceDefault)
+	}
+	if _, ok := c.Vpp.VRFs[radius.InterfaceDefault]; ok {
+		return fmt.Errorf("vpp.VRFs %s is reserved", radius.InterfaceDefault)
+	}
+	if err := c.Circuit.Validate(); err != nil {
+		return err
+	}
+	if err := c.Kea.Validate(); err != nil {
+		return err
+	}
+	if err := c.Queue.Validate(); err != nil {
+		return err
+	}
+
+	return c.Vpp.Validate()
+}
+
+func (c *Core) LoadConfig() error {
+	var err error
+	c.config, err = ReadConfig(c.configFile)
 
 	if err != nil {
-		log.Fatalf("Error decoding configuration file")
+		return fmt.Errorf("loading configuration file %s, %w", c.configFile, err)
 	}
+
+	if err = c.config.Validate(); err != nil {
+		return fmt.Errorf("validating configuration file %s, %w", c.configFile, err)
+	}
+
+	return nil
 }
 
-func (c *Core) WriteConfig() {
+func (c *Core) WriteConfig() error {
 	buf := new(bytes.Buffer)
 	err := toml.NewEncoder(buf).Encode(c.config)
 
 	if err != nil {
-		log.Fatalf("Error encoding configuration in TOML")
+		return fmt.Errorf("encoding configuration in TOML, %w", err)
 	}
 
 	err = os.WriteFile(c.configFile, buf.Bytes(), 0666)
 
 	if err != nil {
-		log.Fatalf("Error writing configuration file")
+		return fmt.Errorf("writing configuration file %s, %w", c.configFile, err)
 	}
+
+	return nil
 }
 
-func (c *Core) Init() {
+// initVpp connects to VPP and provisions it. Provisioning failures only
+// stop glubngd when startup policy is fail-fast.
+func (c *Core) initVpp() error {
+	client := &vpp.Client{}
+	// A channel per worker processing events, and one for everything else
+	client.SetChannels(c.events.Workers() + 1)
+	err := client.Init(&c.config.Vpp, c.ifacesFile)
+	c.vpp = client
+
+	var perr vpp.ProvisionErrors
+	if err == nil {
+		return nil
+	} else if !errors.As(err, &perr) {
+		return err
+	}
+
+	if c.config.Misc.StartupPolicy == StartupDegraded {
+		for _, e := range perr {
+			log.Printf("Provisioning failed, running degraded, %s", e.Error())
+		}
+		return nil
+	}
+
+	client.Close()
+	return err
+}
+
+func (c *Core) Init() error {
 	// Define flags
 	configFile := flag.String("config", "/etc/glubng.toml", "Config source path")
 	ifacesFile := flag.String("interfaces", "/etc/interfaces.toml", "Config interfaces source path")
@@ -78,71 +213,319 @@ func (c *Core) Init() {
 	c.ifacesFile = *ifacesFile
 
 	// Load initial configuration
-	c.LoadConfig()
+	if err := c.LoadConfig(); err != nil {
+		return err
+	}
+
+	// Kea events are queued until workers process them
+	c.events.Init(&c.config.Queue)
 
 	// Init VPP
-	c.vpp.Init(&c.config.Vpp, c.ifacesFile)
+	if err := c.initVpp(); err != nil {
+		return err
+	}
 
-	// Init kea listener
-	c.kea.Init(c.config.Misc.SrcKeaSocket, c.vpp.GetIfacesSwMap())
+	// Map relay agent ids of queries to CPE interfaces
+	if err := c.circuits.Init(&c.config.Circuit, c.vpp); err != nil {
+		c.vpp.Close()
+		return err
+	}
 
 	// Init Sessions
-	c.sessions.Init(&c.vpp)
+	var store SessionStore
+	if c.config.Misc.SessionStorePath != "" {
+		store = NewJournalStore(c.config.Misc.SessionStorePath, c.config.Misc.SessionStoreCompactEvery)
+	}
+	c.sessions.Init(c.vpp, store)
+	c.sessions.SetTimers(time.Duration(c.config.Misc.DeclineQuarantine)*time.Second,
+		time.Duration(c.config.Misc.ExpiredRetention)*time.Second)
+
+	// Init RADIUS client, before Kea asks to authorize subscribers
+	if err := c.initRadius(); err != nil {
+		c.vpp.Close()
+		c.sessions.Close()
+		return fmt.Errorf("starting RADIUS client, %w", err)
+	}
+
+	// Init kea listener
+	c.authorizer.c = c
+	c.kea.SetAuthorizer(&c.authorizer)
+	if err := c.kea.Init(c.config.Misc.SrcKeaSocket, &c.events, c.vpp, &c.circuits); err != nil {
+		c.closeRadius()
+		c.vpp.Close()
+		c.sessions.Close()
+		return err
+	}
+
+	// Sync VPP FIB with sessions, now and every time VPP comes back
+	c.reconcileSessions()
+	c.vpp.OnReconnect(c.vppReconnected)
+
+	// Init REST API, it serves metrics too
+	c.initMetrics()
+	if c.config.Misc.RestListen != "" {
+		if err := c.rest.Init(c.config.Misc.RestListen, &restBackend{c: c}); err != nil {
+			c.kea.Close()
+			c.closeRadius()
+			c.vpp.Close()
+			c.sessions.Close()
+			return fmt.Errorf("starting REST API, %w", err)
+		}
+	}
 
 	// Create a channel to process signals
 	c.control = make(chan os.Signal, 1)
+	c.stop = make(chan struct{})
 	signal.Notify(c.control, syscall.SIGINT, syscall.SIGTERM)
+	c.reload = make(chan os.Signal, 1)
+	signal.Notify(c.reload, syscall.SIGHUP)
 
 	// Process messages received from Kea DHCP Server
+	c.keaDone = make(chan struct{})
 	c.wg.Add(1)
 	go c.ProcessKeaMessages()
 
+	// Expire sessions whose lease timer has passed
+	c.wg.Add(1)
+	go c.sweepSessions()
+
+	// Apply changes of interfaces file and service profiles
+	c.wg.Add(1)
+	go c.watchFiles()
+
+	// Collect traffic counters of sessions
+	if c.config.Vpp.SrcStatsSocket != "" {
+		c.wg.Add(1)
+		go c.sampleCounters(time.Duration(c.config.Vpp.Stats.IntervalSeconds()) * time.Second)
+	}
+
+	// Send Interim-Update of active sessions
+	if c.radius != nil && c.config.Radius.AcctServer != "" && c.config.Radius.InterimInterval > 0 {
+		c.wg.Add(1)
+		go c.accountSessions(time.Duration(c.config.Radius.InterimInterval) * time.Second)
+	}
+
 	fmt.Println("Running GluBNGd...")
 
 	// Add 1 to wg counter
 	c.wg.Add(1)
 	go func() {
 		<-c.control
+		// Stop goroutines before closing resources used by them
+		close(c.stop)
+		c.rest.Close()
+		c.events.Close()
 		c.kea.Close()
+		// Requests of workers are sent before VPP is torn down
+		<-c.keaDone
+		c.closeRadius()
+		c.teardownVpp()
 		c.vpp.Close()
+		c.sessions.Close()
 		c.wg.Done()
 	}()
 
 	c.wg.Wait()
 	fmt.Println("Exiting GluBNGd...")
+
+	return nil
 }
 
-func (c *Core) ProcessKeaMessages() {
+// teardownVpp removes objects created in VPP when shutdown mode is cleanup.
+// Sessions are kept in the store, their routes are added again on next start.
+func (c *Core) teardownVpp() {
+	if c.config.Misc.ShutdownMode != ShutdownCleanup {
+		return
+	}
+	if !c.vpp.IsConnected() {
+		log.Println("VPP is not connected, its configuration is not removed")
+		return
+	}
+
+	if err := c.vpp.Teardown(); err != nil {
+		log.Printf("Error removing configuration from VPP, %s", err.Error())
+	}
+}
+
+func (c *Core) reconcileSessions() {
+	if err := c.sessions.Reconcile(); err != nil {
+		log.Printf("Error reconciling sessions with VPP, %s", err.Error())
+	}
+}
+
+// vppReconnected replays sessions once VPP is back, after a restart they are
+// moved to the new SwIf of their CPE interface first
+func (c *Core) vppReconnected(ev vpp.ReconnectEvent) {
+	if len(ev.SwIfRemap) > 0 {
+		c.sessions.RemapIfaces(ev.SwIfRemap)
+	}
+	c.reconcileSessions()
+}
+
+func (c *Core) sweepSessions() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-c.control:
-			// Receive CONTROL-C exit goroutine
-			goto endLoop
-		case msg := <-c.kea.Message:
-			switch msg.Callout {
-			case kea.CALLOUT_LEASE4_SELECT:
-				// New Lease selected
-				iface, err := utils.ConvertCIDToInt(msg.Query.Option82CID)
-				if err != nil {
-					// Error parsing circuit-id
-					log.Printf("Error in ProcessKeaMessages, %s", err.Error())
-					break
-				}
-				// ParseIP is an slice[16], positions 12,13,14,15 are used for IPv4
-				goip := net.ParseIP(msg.Lease.Address)
-
-				if goip == nil {
-					log.Println("Error adding session in parse ip")
-					break
-				}
-				ses := &Session{Iface: int(iface), IPv4: goip}
-				c.sessions.AddSession(ses)
-			case kea.CALLOUT_LEASE4_RELEASE, kea.CALLOUT_LEASE4_EXPIRE:
-				// Remove Session when a lease expires
-				c.sessions.RemoveSession(msg.Lease.Address)
-			}
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.sessions.Sweep(now)
+		}
+	}
+}
+
+// sessionFromKea builds a session from a lease and the relay agent ids of
+// the query
+func sessionFromKea(msg *kea.KeaResult, circuits kea.CircuitMapper) (*Session, error) {
+	iface, err := circuits.Resolve(msg.Query.Option82CID, msg.Query.Option82RID)
+	if err != nil {
+		// Error mapping circuit-id
+		return nil, err
+	}
+	// ParseIP is an slice[16], positions 12,13,14,15 are used for IPv4
+	goip := net.ParseIP(msg.Lease.Address)
+	if goip == nil {
+		return nil, fmt.Errorf("malformed lease address, %s", msg.Lease.Address)
+	}
+
+	return &Session{
+		Iface:     iface,
+		IPv4:      goip,
+		Expires:   leaseExpires(&msg.Lease),
+		Profile:   msg.Lease.UserContext.ServiceProfile,
+		MAC:       msg.Query.HwAddr,
+		CircuitID: msg.Query.Option82CID,
+	}, nil
+}
+
+// ipv6LeaseFromKea builds an IPv6 binding from a lease6, relay agent ids are
+// only needed to bind it to a CPE interface
+func ipv6LeaseFromKea(msg *kea.KeaResult, circuits kea.CircuitMapper) (*IPv6Lease, error) {
+	l := &IPv6Lease{Iface: -1, Delegated: msg.Lease.Type == kea.LEASE6_TYPE_PD, Expires: leaseExpires(&msg.Lease)}
+
+	if circuits != nil {
+		iface, err := circuits.Resolve(msg.Query.CircuitID(), msg.Query.RemoteID())
+		if err != nil {
+			// Error mapping interface-id
+			return nil, err
+		}
+		l.Iface = iface
+	}
+
+	goip := net.ParseIP(msg.Lease.Address)
+	if goip == nil || goip.To4() != nil {
+		return nil, fmt.Errorf("malformed lease6 address, %s", msg.Lease.Address)
+	}
+
+	if !l.Delegated {
+		l.Prefix = ipv6Host(goip)
+		return l, nil
+	}
+
+	if msg.Lease.PrefixLen <= 0 || msg.Lease.PrefixLen > 128 {
+		return nil, fmt.Errorf("malformed lease6 prefix length, %d", msg.Lease.PrefixLen)
+	}
+	mask := net.CIDRMask(msg.Lease.PrefixLen, 128)
+	l.Prefix = &net.IPNet{IP: goip.Mask(mask), Mask: mask}
+
+	return l, nil
+}
+
+func leaseExpires(l *kea.Lease) time.Time {
+	if l.Cltt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(l.Cltt+l.ValidLft), 0)
+}
+
+// ProcessKeaMessages processes events of Kea hook with concurrent workers
+// until queue is closed, keaDone is closed when workers have finished
+func (c *Core) ProcessKeaMessages() {
+	defer c.wg.Done()
+	defer close(c.keaDone)
+
+	// Leases of Kea are applied first, events received meanwhile are newer
+	// and wait in the queue
+	c.bootstrapLeases(time.Now())
+
+	var workers sync.WaitGroup
+	for i := 0; i < c.events.Workers(); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			c.processEvents()
+		}()
+	}
+	workers.Wait()
+}
+
+// processEvents processes events of the queue in order per address
+func (c *Core) processEvents() {
+	for {
+		msg, ok := c.events.Pop()
+		if !ok {
+			return
+		}
+		if err := c.processKeaMessage(&msg); err != nil {
+			log.Printf("Error in ProcessKeaMessages, %s", err.Error())
 		}
+		c.events.Done(&msg)
 	}
-endLoop:
-	c.wg.Done()
+}
+
+func (c *Core) processKeaMessage(msg *kea.KeaResult) error {
+	switch msg.Callout {
+	case kea.CALLOUT_LEASE4_SELECT:
+		// New Lease selected
+		ses, err := sessionFromKea(msg, &c.circuits)
+		if err != nil {
+			return err
+		}
+		// Rejected subscribers get no route
+		if err = c.authorize(msg, ses); err != nil {
+			return err
+		}
+		return c.sessions.AddSession(ses)
+	case kea.CALLOUT_LEASE4_RENEW:
+		// Refresh lease timer, session is moved if circuit-id changed
+		ses, err := sessionFromKea(msg, &c.circuits)
+		if err != nil {
+			return err
+		}
+		if err = c.authorize(msg, ses); err != nil {
+			return err
+		}
+		return c.sessions.RenewSession(ses)
+	case kea.CALLOUT_LEASE4_RELEASE:
+		return c.sessions.ReleaseSession(msg.Lease.Address)
+	case kea.CALLOUT_LEASE4_EXPIRE:
+		return c.sessions.ExpireSession(msg.Lease.Address)
+	case kea.CALLOUT_LEASE4_DECLINE:
+		goip := net.ParseIP(msg.Lease.Address)
+		if goip == nil {
+			return fmt.Errorf("malformed lease address, %s", msg.Lease.Address)
+		}
+		return c.sessions.DeclineSession(goip)
+	case kea.CALLOUT_LEASE4_RECOVER:
+		return c.sessions.RecoverSession(msg.Lease.Address, leaseExpires(&msg.Lease))
+	case kea.CALLOUT_LEASE6_SELECT, kea.CALLOUT_LEASE6_RENEW, kea.CALLOUT_LEASE6_REBIND:
+		// Bind IA_NA address or IA_PD prefix, it's moved if interface-id changed
+		l, err := ipv6LeaseFromKea(msg, &c.circuits)
+		if err != nil {
+			return err
+		}
+		return c.sessions.AddIPv6(l)
+	case kea.CALLOUT_LEASE6_RELEASE, kea.CALLOUT_LEASE6_EXPIRE:
+		l, err := ipv6LeaseFromKea(msg, nil)
+		if err != nil {
+			return err
+		}
+		return c.sessions.RemoveIPv6(l)
+	}
+
+	return nil
 }
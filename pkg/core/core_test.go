@@ -0,0 +1,59 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/circuit"
+	"github.com/glutechnologies/glubng/pkg/kea"
+)
+
+func TestReadConfigDefault(t *testing.T) {
+	config, err := ReadConfig("../../glubng.default.toml")
+	if err != nil {
+		t.Fatalf("ReadConfig() error = %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if config.Vpp.SrcStatsSocket == "" {
+		t.Error("vpp.SrcStatsSocket is not read")
+	}
+}
+
+// A release queued after a select of an active session releases it, the
+// select is not processed
+func TestQueuedReleaseOfActiveSession(t *testing.T) {
+	f := newSessionsFixture(t)
+	c := &Core{vpp: f.client}
+	c.sessions.Init(f.client, nil)
+	if err := c.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe1"), Expires: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.circuits.Init(&circuit.Config{}, f.client); err != nil {
+		t.Fatal(err)
+	}
+	c.events.Init(&kea.QueueConfig{})
+	defer c.events.Close()
+
+	for _, callout := range []int{kea.CALLOUT_LEASE4_SELECT, kea.CALLOUT_LEASE4_RELEASE} {
+		if err := c.events.Push(kea.KeaResult{Callout: callout, Lease: kea.Lease{Address: testIPv4}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for c.events.Len() > 0 {
+		msg, _ := c.events.Pop()
+		if err := c.processKeaMessage(&msg); err != nil {
+			t.Errorf("processing %d error = %v", msg.Callout, err)
+		}
+		c.events.Done(&msg)
+	}
+
+	if ses := c.sessions.GetSession(testIPv4); ses != nil {
+		t.Errorf("session = %+v, want it released", ses)
+	}
+	if got := f.route(testIPv4 + "/32"); got != nil {
+		t.Errorf("route to SwIfs %v, want none", got)
+	}
+}
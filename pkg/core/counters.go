@@ -0,0 +1,156 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+// SessionCounters is traffic of a session, counted from the first sample of
+// VPP stats after it became active. In is traffic sent by the subscriber,
+// received in its CPE interface. Out is traffic routed to its addresses, or
+// sent by its CPE interface when route counters are not collected. Traffic of
+// a CPE interface is only counted once, in the oldest session active on it.
+type SessionCounters struct {
+	InPackets  uint64    `json:"in-packets"`
+	InBytes    uint64    `json:"in-bytes"`
+	OutPackets uint64    `json:"out-packets"`
+	OutBytes   uint64    `json:"out-bytes"`
+	Updated    time.Time `json:"updated,omitempty"`
+	// Values of last sample, VPP counters restart when objects are created
+	// again
+	sampled bool
+	lastIn  vpp.Counter
+	lastOut vpp.Counter
+}
+
+// delta returns traffic since last sample, a counter smaller than the last
+// one was restarted
+func delta(cur vpp.Counter, last vpp.Counter) vpp.Counter {
+	if cur.Packets < last.Packets || cur.Bytes < last.Bytes {
+		return cur
+	}
+	return vpp.Counter{Packets: cur.Packets - last.Packets, Bytes: cur.Bytes - last.Bytes}
+}
+
+// add adds traffic since last sample, samples of counters not counted are
+// kept so later ones only count new traffic
+func (sc *SessionCounters) add(in vpp.Counter, countIn bool, out vpp.Counter, countOut bool, now time.Time) {
+	if sc.sampled {
+		if countIn {
+			d := delta(in, sc.lastIn)
+			sc.InPackets += d.Packets
+			sc.InBytes += d.Bytes
+		}
+		if countOut {
+			d := delta(out, sc.lastOut)
+			sc.OutPackets += d.Packets
+			sc.OutBytes += d.Bytes
+		}
+	}
+
+	sc.sampled = true
+	sc.lastIn, sc.lastOut = in, out
+	sc.Updated = now
+}
+
+// routePrefixes returns prefixes of routes installed for a session
+func (ses *Session) routePrefixes() []string {
+	var prefixes []string
+
+	if ses.State == SessionActive && ses.IPv4 != nil {
+		prefixes = append(prefixes, (&net.IPNet{IP: ses.IPv4, Mask: net.CIDRMask(32, 32)}).String())
+	}
+	for _, delegated := range []bool{false, true} {
+		if prefix := ses.ipv6Binding(delegated); prefix != nil {
+			prefixes = append(prefixes, prefix.String())
+		}
+	}
+
+	return prefixes
+}
+
+// ifaceOwners returns the key of the oldest session with routes of every
+// CPE interface, traffic of interfaces is counted in it
+func (s *Sessions) ifaceOwners() map[int]string {
+	owners := make(map[int]string)
+	for key, ses := range s.sessions {
+		if len(ses.routePrefixes()) == 0 {
+			continue
+		}
+		cur, ok := owners[ses.Iface]
+		if !ok {
+			owners[ses.Iface] = key
+			continue
+		}
+		o := s.sessions[cur]
+		if ses.Started.Before(o.Started) || (ses.Started.Equal(o.Started) && key < cur) {
+			owners[ses.Iface] = key
+		}
+	}
+
+	return owners
+}
+
+// UpdateCounters adds traffic of a sample of VPP counters to active
+// sessions. Counters are stored with other changes of sessions.
+func (s *Sessions) UpdateCounters(counters *vpp.Counters, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owners := s.ifaceOwners()
+	for key, ses := range s.sessions {
+		prefixes := ses.routePrefixes()
+		if len(prefixes) == 0 {
+			continue
+		}
+
+		iface, hasIface := counters.Ifaces[ses.Iface]
+		owner := owners[ses.Iface] == key
+		var out vpp.Counter
+		hasRoutes := false
+		for _, prefix := range prefixes {
+			if c, ok := counters.Routes[prefix]; ok {
+				out.Packets += c.Packets
+				out.Bytes += c.Bytes
+				hasRoutes = true
+			}
+		}
+		if !hasRoutes {
+			if !hasIface {
+				continue
+			}
+			out = iface.Tx
+		}
+
+		ses.Counters.add(iface.Rx, owner, out, hasRoutes || owner, now)
+	}
+}
+
+// sampleCounters reads counters of subscribers from VPP stats periodically
+func (c *Core) sampleCounters(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			counters, err := c.vpp.ReadCounters()
+			if errors.Is(err, vpp.ErrStatsDisabled) {
+				log.Println("VPP stats are not connected, counters are not collected")
+				return
+			} else if err != nil {
+				log.Printf("Error reading VPP counters, %s", err.Error())
+				continue
+			}
+			c.sessions.UpdateCounters(counters, now)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+func TestSessionsUpdateCounters(t *testing.T) {
+	f := newSessionsFixture(t)
+	expires := time.Now().Add(time.Hour)
+	cpe1, cpe2 := f.swIf("cpe1"), f.swIf("cpe2")
+
+	for _, ses := range []*Session{
+		{IPv4: net.ParseIP("100.64.0.10"), Iface: cpe1, Expires: expires},
+		{IPv4: net.ParseIP("100.64.0.11"), Iface: cpe1, Expires: expires},
+		{IPv4: net.ParseIP("100.64.0.12"), Iface: cpe2, Expires: expires},
+	} {
+		if err := f.sessions.AddSession(ses); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 100.64.0.10 is the oldest session of cpe1
+	f.sessions.sessions["100.64.0.10"].Started = time.Now().Add(-time.Minute)
+
+	sample := func(n uint64) *vpp.Counters {
+		return &vpp.Counters{
+			Ifaces: map[int]vpp.IfaceCounters{
+				cpe1: {Rx: vpp.Counter{Packets: 10 * n, Bytes: 1000 * n}, Tx: vpp.Counter{Packets: 20 * n, Bytes: 2000 * n}},
+				cpe2: {Rx: vpp.Counter{Packets: n, Bytes: 100 * n}, Tx: vpp.Counter{Packets: 2 * n, Bytes: 200 * n}},
+			},
+			Routes: map[string]vpp.Counter{
+				"100.64.0.10/32": {Packets: 5 * n, Bytes: 500 * n},
+				"100.64.0.11/32": {Packets: 15 * n, Bytes: 1500 * n},
+			},
+		}
+	}
+	now := time.Now()
+	f.sessions.UpdateCounters(sample(1), now)
+	f.sessions.UpdateCounters(sample(3), now.Add(10*time.Second))
+
+	tests := []struct {
+		key     string
+		inBytes uint64
+		out     uint64
+	}{
+		// Traffic received in cpe1 is counted once
+		{key: "100.64.0.10", inBytes: 2000, out: 1000},
+		{key: "100.64.0.11", inBytes: 0, out: 3000},
+		// Sent by the interface without route counters
+		{key: "100.64.0.12", inBytes: 200, out: 400},
+	}
+	for _, tt := range tests {
+		c := f.sessions.GetSession(tt.key).Counters
+		if c.InBytes != tt.inBytes || c.OutBytes != tt.out {
+			t.Errorf("counters of %s = %d/%d bytes, want %d/%d", tt.key, c.InBytes, c.OutBytes, tt.inBytes, tt.out)
+		}
+	}
+
+	// The other session counts traffic of the interface once the oldest one
+	// is released, from the last sample
+	if err := f.sessions.ReleaseSession("100.64.0.10"); err != nil {
+		t.Fatal(err)
+	}
+	f.sessions.UpdateCounters(sample(4), now.Add(20*time.Second))
+	if c := f.sessions.GetSession("100.64.0.11").Counters; c.InBytes != 1000 {
+		t.Errorf("in bytes of 100.64.0.11 = %d, want 1000", c.InBytes)
+	}
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/radius"
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+// daeBackend applies CoA and Disconnect requests to sessions
+type daeBackend struct {
+	c *Core
+}
+
+// matches reports if an active IPv4 session has every identification
+// attribute of a request
+func (b *daeBackend) matches(ses *Session, id *radius.SessionID) bool {
+	if ses.State != SessionActive || ses.IPv4 == nil {
+		return false
+	}
+
+	return (id.IPv4 == nil || id.IPv4.Equal(ses.IPv4)) &&
+		(id.AcctSessionID == "" || id.AcctSessionID == ses.AcctSessionID) &&
+		(id.Username == "" || id.Username == ses.Username) &&
+		(id.CircuitID == "" || id.CircuitID == ses.CircuitID) &&
+		(id.FlexID == "" || id.FlexID == flexID(b.c.vpp, ses.Iface))
+}
+
+func (b *daeBackend) find(id *radius.SessionID) ([]string, error) {
+	keys := b.c.sessions.MatchSessions(func(ses *Session) bool {
+		return b.matches(ses, id)
+	})
+	if len(keys) == 0 {
+		return nil, radius.ErrSessionNotFound
+	}
+
+	return keys, nil
+}
+
+func (b *daeBackend) ChangeAuthorization(id *radius.SessionID, auth *radius.Authorization) error {
+	keys, err := b.find(id)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := b.c.sessions.Reauthorize(key, auth); err != nil {
+			return err
+		}
+		log.Printf("Session %s authorization changed by CoA-Request", key)
+	}
+
+	return nil
+}
+
+func (b *daeBackend) Disconnect(id *radius.SessionID) error {
+	keys, err := b.find(id)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := b.c.sessions.RemoveSession(key); err != nil {
+			return fmt.Errorf("%w, %s", radius.ErrSessionNotFound, err.Error())
+		}
+		log.Printf("Session %s removed by Disconnect-Request", key)
+	}
+
+	return nil
+}
+
+// MatchSessions returns keys of sessions selected by match
+func (s *Sessions) MatchSessions(match func(ses *Session) bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key, ses := range s.sessions {
+		if match(ses) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Reauthorize applies attributes of a CoA-Request to an active session, only
+// attributes present are changed. Filter-Id and VRF radius.InterfaceDefault
+// restore the ones of the CPE interface. A new Pool is given to Kea when the
+// subscriber renews its lease.
+func (s *Sessions) Reauthorize(key string, auth *radius.Authorization) (err error) {
+	defer s.lockAddress(key)()
+
+	// VPP is changed without holding mu, in order of queued requests of the
+	// route and the interface, the session is kept by holding its address
+	s.mu.Lock()
+	ses := s.sessions[key]
+	if ses == nil || ses.State != SessionActive || ses.IPv4 == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%w, %s", radius.ErrSessionNotFound, key)
+	}
+	cur := ses.clone()
+	s.mu.Unlock()
+
+	profile, vrf := cur.Profile, cur.VRF
+	if auth.Profile != "" {
+		profile = defaultAttribute(auth.Profile)
+	}
+	if auth.VRF != "" {
+		vrf = defaultAttribute(auth.VRF)
+	}
+	// Nothing is changed when an attribute can not be applied
+	if _, ok := s.vpp.Profiles()[profile]; profile != "" && !ok {
+		return fmt.Errorf("%w, %s %q", radius.ErrInvalidAttribute, vpp.ErrUnknownProfile.Error(), profile)
+	}
+
+	if vrf != cur.VRF {
+		moved, err := s.moveVRF(key, cur, vrf)
+		if moved {
+			s.mu.Lock()
+			ses.VRF = vrf
+			s.persist(ses)
+			s.unlock(nil)
+		}
+		if errors.Is(err, vpp.ErrUnknownVRF) || errors.Is(err, vpp.ErrVRFInUse) {
+			return fmt.Errorf("%w, %s", radius.ErrInvalidAttribute, err.Error())
+		} else if err != nil {
+			return err
+		}
+	}
+	if profile != cur.Profile {
+		var perr error
+		s.mu.Lock()
+		s.send(func() error {
+			perr = s.vpp.SetIfaceProfile(cur.Iface, key, profile)
+			return nil
+		}, ifaceKey(cur.Iface))
+		s.unlock(nil)
+		if perr != nil {
+			return perr
+		}
+	}
+
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	ses.Profile = profile
+	if auth.SessionTimeout > 0 {
+		ses.Deadline = time.Now().Add(auth.SessionTimeout)
+	}
+	if auth.Pool != "" {
+		ses.Pool = auth.Pool
+	}
+	if len(auth.Class) > 0 {
+		ses.Class = auth.Class
+	}
+	s.persist(ses)
+
+	return nil
+}
+
+// defaultAttribute returns the value of a CoA attribute, empty when it
+// restores the setting of the interface
+func defaultAttribute(v string) string {
+	if v == radius.InterfaceDefault {
+		return ""
+	}
+	return v
+}
+
+// moveVRF moves the route of an active session to another VRF, the session
+// keeps its VRF when it can not be moved. Sessions of an interface share its
+// VRF, so it's only moved when no other session is active on it. It reports
+// if the interface was moved, even when its route could not be added again.
+func (s *Sessions) moveVRF(key string, ses *Session, vrf string) (moved bool, err error) {
+	s.mu.Lock()
+	for k, o := range s.sessions {
+		if k != key && o.State == SessionActive && o.IPv4 != nil && o.Iface == ses.Iface {
+			s.mu.Unlock()
+			return false, fmt.Errorf("%w, session %s is active on SwIf %d", vpp.ErrVRFInUse, k, ses.Iface)
+		}
+	}
+
+	// Errors are returned to the CoA instead of being retried by Sweep
+	s.send(func() error {
+		moved, err = s.moveRoute(key, ses, vrf)
+		return nil
+	}, ipv4Key(ses.IPv4), ifaceKey(ses.Iface))
+	s.unlock(nil)
+
+	return moved, err
+}
+
+func (s *Sessions) moveRoute(key string, ses *Session, vrf string) (bool, error) {
+	if err := s.vpp.RemoveSession(ses.IPv4, uint32(ses.Iface)); err != nil {
+		return false, err
+	}
+	err := s.vpp.SetIfaceVRF(ses.Iface, key, vrf)
+	// Route is added to the table the interface is in, moved or not
+	if aerr := s.vpp.AddSession(ses.IPv4, uint32(ses.Iface)); aerr != nil {
+		s.failed.Store(true)
+		if err == nil {
+			return true, aerr
+		}
+	}
+
+	return err == nil, err
+}
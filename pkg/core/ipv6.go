@@ -0,0 +1,229 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// IPv6Lease is a DHCPv6 binding, an IA_NA address or an IA_PD delegated
+// prefix. A CPE interface has one binding of each type, bound to the session
+// of the interface.
+type IPv6Lease struct {
+	Iface int
+	// Address of an IA_NA as a /128 or masked prefix of an IA_PD
+	Prefix    *net.IPNet
+	Delegated bool
+	Expires   time.Time
+}
+
+func ipv6Host(ip net.IP) *net.IPNet {
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// ipv6Binding returns the address or the delegated prefix of a session as a
+// route prefix, nil if it's not bound
+func (ses *Session) ipv6Binding(delegated bool) *net.IPNet {
+	if !delegated {
+		if ses.IPv6 == nil {
+			return nil
+		}
+		return ipv6Host(ses.IPv6)
+	}
+
+	_, prefix, err := net.ParseCIDR(ses.IPv6Prefix)
+	if err != nil {
+		return nil
+	}
+	return prefix
+}
+
+func (ses *Session) setIPv6(l *IPv6Lease) {
+	if l.Delegated {
+		ses.IPv6Prefix = l.Prefix.String()
+		ses.IPv6PrefixExpires = l.Expires
+	} else {
+		ses.IPv6 = l.Prefix.IP
+		ses.IPv6Expires = l.Expires
+	}
+}
+
+func (ses *Session) clearIPv6(delegated bool) {
+	if delegated {
+		ses.IPv6Prefix = ""
+		ses.IPv6PrefixExpires = time.Time{}
+	} else {
+		ses.IPv6 = nil
+		ses.IPv6Expires = time.Time{}
+	}
+}
+
+func (s *Sessions) addIPv6Routes(ses *Session) {
+	for _, delegated := range []bool{false, true} {
+		if prefix := ses.ipv6Binding(delegated); prefix != nil {
+			s.addPrefix(prefix, ses.Iface)
+		}
+	}
+}
+
+func (s *Sessions) removeIPv6Routes(ses *Session) {
+	for _, delegated := range []bool{false, true} {
+		if prefix := ses.ipv6Binding(delegated); prefix != nil {
+			s.removePrefix(prefix, ses.Iface)
+		}
+	}
+}
+
+func (s *Sessions) addPrefix(prefix *net.IPNet, iface int) {
+	s.send(func() error { return s.vpp.AddSessionPrefix(prefix, uint32(iface)) }, prefixKey(prefix.String()), ifaceKey(iface))
+}
+
+func (s *Sessions) removePrefix(prefix *net.IPNet, iface int) {
+	s.send(func() error { return s.vpp.RemoveSessionPrefix(prefix, uint32(iface)) }, prefixKey(prefix.String()), ifaceKey(iface))
+}
+
+// moveIPv6 moves IPv6 bindings from a session to another one, routes are
+// moved when sessions are in different interfaces
+func (s *Sessions) moveIPv6(from *Session, to *Session) {
+	if from == to || !from.hasIPv6() {
+		return
+	}
+
+	if from.Iface != to.Iface {
+		s.removeIPv6Routes(from)
+	}
+	to.IPv6, to.IPv6Expires = from.IPv6, from.IPv6Expires
+	to.IPv6Prefix, to.IPv6PrefixExpires = from.IPv6Prefix, from.IPv6PrefixExpires
+	if from.Iface != to.Iface {
+		s.addIPv6Routes(to)
+	}
+
+	from.clearIPv6(false)
+	from.clearIPv6(true)
+}
+
+// ipv6Owner returns the session holding an IPv6 binding
+func (s *Sessions) ipv6Owner(l *IPv6Lease) (string, *Session) {
+	for key, ses := range s.sessions {
+		if l.Delegated && ses.IPv6Prefix == l.Prefix.String() ||
+			!l.Delegated && l.Prefix.IP.Equal(ses.IPv6) {
+			return key, ses
+		}
+	}
+
+	return "", nil
+}
+
+// ifaceSession returns the active session of an interface, the IPv4 one is
+// preferred over an IPv6 only session
+func (s *Sessions) ifaceSession(iface int) (string, *Session) {
+	var key string
+	var found *Session
+
+	for k, ses := range s.sessions {
+		if ses.Iface != iface || ses.State != SessionActive {
+			continue
+		}
+		if ses.IPv4 != nil {
+			return k, ses
+		}
+		key, found = k, ses
+	}
+
+	return key, found
+}
+
+// ipv6Only returns the IPv6 only session of an interface
+func (s *Sessions) ipv6Only(iface int) (string, *Session) {
+	for key, ses := range s.sessions {
+		if ses.Iface == iface && ses.IPv4 == nil {
+			return key, ses
+		}
+	}
+
+	return "", nil
+}
+
+// AddIPv6 binds a selected or renewed IPv6 lease to the session of its CPE
+// interface, an IPv6 only session is created if the client has no IPv4 lease
+func (s *Sessions) AddIPv6(l *IPv6Lease) (err error) {
+	defer s.lockAddress(l.Prefix.String())()
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	if s.tornDown[l.Iface] {
+		return fmt.Errorf("interface SwIf %d of IPv6 %s is being deleted", l.Iface, l.Prefix.String())
+	}
+
+	if key, owner := s.ipv6Owner(l); owner != nil {
+		if owner.Iface == l.Iface {
+			// Same binding, only refresh lease timer
+			owner.setIPv6(l)
+			s.persist(owner)
+			return nil
+		}
+		// Circuit changed, move route to new iface
+		log.Printf("Moving IPv6 %s from SwIf %d to SwIf %d", l.Prefix.String(), owner.Iface, l.Iface)
+		s.removePrefix(l.Prefix, owner.Iface)
+		owner.clearIPv6(l.Delegated)
+		s.update(key, owner)
+	}
+
+	key, ses := s.ifaceSession(l.Iface)
+	if ses == nil {
+		ses = &Session{Iface: l.Iface, State: SessionActive}
+	}
+	// A new binding of the same type replaces the previous one
+	if old := ses.ipv6Binding(l.Delegated); old != nil {
+		s.removePrefix(old, ses.Iface)
+	}
+
+	ses.setIPv6(l)
+	s.addPrefix(l.Prefix, ses.Iface)
+	s.update(key, ses)
+
+	return nil
+}
+
+// RemoveIPv6 removes a released or expired IPv6 lease, its session is
+// removed when it has no other address
+func (s *Sessions) RemoveIPv6(l *IPv6Lease) (err error) {
+	defer s.lockAddress(l.Prefix.String())()
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	key, ses := s.ipv6Owner(l)
+	if ses == nil {
+		return fmt.Errorf("session with IPv6 %s not exists", l.Prefix.String())
+	}
+
+	s.removePrefix(l.Prefix, ses.Iface)
+	ses.clearIPv6(l.Delegated)
+	s.update(key, ses)
+
+	return nil
+}
+
+// sweepIPv6 removes IPv6 bindings whose lease timer has passed, it reports
+// if the session changed
+func (s *Sessions) sweepIPv6(ses *Session, now time.Time) bool {
+	changed := false
+
+	for _, delegated := range []bool{false, true} {
+		expires := ses.IPv6Expires
+		if delegated {
+			expires = ses.IPv6PrefixExpires
+		}
+
+		prefix := ses.ipv6Binding(delegated)
+		if prefix == nil || expires.IsZero() || !now.After(expires) {
+			continue
+		}
+		s.removePrefix(prefix, ses.Iface)
+		ses.clearIPv6(delegated)
+		changed = true
+	}
+
+	return changed
+}
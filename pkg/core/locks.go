@@ -0,0 +1,170 @@
+package core
+
+import (
+	"net"
+	"strconv"
+	"sync"
+)
+
+// addressLocks serializes changes of each address, locks are forgotten once
+// nobody holds or waits for them
+type addressLocks struct {
+	mu    sync.Mutex
+	locks map[string]*addressLock
+}
+
+type addressLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock waits for the lock of an address, it returns the function releasing it
+func (l *addressLocks) lock(addr string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*addressLock)
+	}
+	a := l.locks[addr]
+	if a == nil {
+		a = &addressLock{}
+		l.locks[addr] = a
+	}
+	a.refs++
+	l.mu.Unlock()
+
+	a.mu.Lock()
+
+	return func() {
+		a.mu.Unlock()
+
+		l.mu.Lock()
+		a.refs--
+		if a.refs == 0 {
+			delete(l.locks, addr)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// lockAddress serializes a change of the sessions of an address with other
+// changes of it. It returns the function releasing the address.
+func (s *Sessions) lockAddress(addr string) func() {
+	s.all.RLock()
+	unlock := s.addresses.lock(addr)
+
+	return func() {
+		unlock()
+		s.all.RUnlock()
+	}
+}
+
+// lockAll waits until no address is being changed and holds changes of every
+// address, it returns the function releasing them
+func (s *Sessions) lockAll() func() {
+	s.all.Lock()
+	return s.all.Unlock
+}
+
+// request is a VPP request or a store write queued by send, keys are the
+// prefixes, interfaces and stored sessions it changes
+type request struct {
+	keys []string
+	run  func() error
+}
+
+// Keys of requests
+func prefixKey(prefix string) string { return "prefix " + prefix }
+func ipv4Key(ipv4 net.IP) string     { return prefixKey(ipv4.String() + "/32") }
+func ifaceKey(iface int) string      { return "swif " + strconv.Itoa(iface) }
+func storeKey(key string) string     { return "store " + key }
+
+// requestOrder makes requests of a key in the order they were queued. A
+// session is changed under the locks of its IPv4 and its IPv6 addresses, so
+// requests of changes of different addresses may touch the same route.
+// Keys are forgotten once their last request is made.
+type requestOrder struct {
+	mu    sync.Mutex
+	tails map[string]chan struct{}
+}
+
+// turn of a request, it waits for previous requests of its keys
+type turn struct {
+	keys []string
+	prev []chan struct{}
+	done chan struct{}
+}
+
+// take queues a request of keys, it must be called under mu of sessions so
+// turns follow the order of changes
+func (o *requestOrder) take(keys []string) *turn {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.tails == nil {
+		o.tails = make(map[string]chan struct{})
+	}
+	t := &turn{keys: keys, done: make(chan struct{})}
+	for _, key := range keys {
+		if prev := o.tails[key]; prev != nil && prev != t.done {
+			t.prev = append(t.prev, prev)
+		}
+		o.tails[key] = t.done
+	}
+
+	return t
+}
+
+// wait waits until previous requests of the keys of a turn are made
+func (t *turn) wait() {
+	for _, prev := range t.prev {
+		<-prev
+	}
+}
+
+// finish lets next requests of the keys of a turn be made
+func (o *requestOrder) finish(t *turn) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	close(t.done)
+	for _, key := range t.keys {
+		if o.tails[key] == t.done {
+			delete(o.tails, key)
+		}
+	}
+}
+
+// send queues a VPP request or a store write of the change being made under
+// mu, requests are made in order by unlock. Keys are the prefixes,
+// interfaces and stored sessions changed by the request.
+func (s *Sessions) send(run func() error, keys ...string) {
+	s.requests = append(s.requests, request{keys: keys, run: run})
+}
+
+// unlock releases mu and makes the requests queued while it was held, so
+// other addresses are not held up by VPP or the disk. Requests wait for the
+// ones queued before by other changes of their keys. The error of the first
+// failed request is set in err, unless err is nil or holds another one.
+// Sessions are reconciled with VPP on next Sweep to retry failed requests.
+func (s *Sessions) unlock(err *error) {
+	requests := s.requests
+	s.requests = nil
+	turns := make([]*turn, len(requests))
+	for i, r := range requests {
+		turns[i] = s.order.take(r.keys)
+	}
+	s.mu.Unlock()
+
+	for i, r := range requests {
+		turns[i].wait()
+		rerr := r.run()
+		s.order.finish(turns[i])
+		if rerr == nil {
+			continue
+		}
+		s.failed.Store(true)
+		if err != nil && *err == nil {
+			*err = rerr
+		}
+	}
+}
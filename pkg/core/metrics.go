@@ -0,0 +1,130 @@
+package core
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/glutechnologies/glubng/pkg/vpp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gaugeFunc is a gauge with one label computed when metrics are scraped, used
+// for state owned by other components like sessions
+type gaugeFunc struct {
+	desc *prometheus.Desc
+	fn   func() map[string]float64
+}
+
+func newGaugeFunc(name string, help string, label string, fn func() map[string]float64) *gaugeFunc {
+	return &gaugeFunc{desc: prometheus.NewDesc(name, help, []string{label}, nil), fn: fn}
+}
+
+func (g *gaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *gaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	for label, value := range g.fn() {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, value, label)
+	}
+}
+
+// initMetrics registers gauges computed from sessions and VPP state when
+// metrics are scraped
+func (c *Core) initMetrics() {
+	prometheus.MustRegister(
+		newGaugeFunc("glubng_sessions_active", "Active sessions by CPE interface.",
+			"iface", c.sessionsByIface),
+		newGaugeFunc("glubng_pool_sessions_active", "Active IPv4 sessions by pool of vpp.IPv4Pool.",
+			"pool", c.sessionsByPool),
+		newGaugeFunc("glubng_iface_provisioned", "Provisioning status of CPE interfaces, 1 when ok.",
+			"iface", c.ifacesProvisioned),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "glubng_vpp_connected",
+			Help: "Connection to VPP API, 1 when connected.",
+		}, func() float64 {
+			return boolValue(c.vpp.IsConnected())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "glubng_kea_listening",
+			Help: "Socket of Kea hook, 1 when accepting connections.",
+		}, func() float64 {
+			return boolValue(c.kea.IsListening())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "glubng_kea_queue_events",
+			Help: "Events of Kea hook waiting to be processed.",
+		}, func() float64 {
+			return float64(c.events.Len())
+		}),
+	)
+}
+
+func boolValue(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// sessionsByIface counts active sessions by name of their CPE interface,
+// sessions of SwIfs of no provisioned interface are labeled by SwIf
+func (c *Core) sessionsByIface() map[string]float64 {
+	names := make(map[int]string)
+	values := make(map[string]float64)
+	for name, st := range c.vpp.GetIfacesStatus() {
+		// Interfaces without sessions are exported too, failed ones have
+		// no SwIf
+		values[name] = 0
+		if st.State == vpp.IfaceOK {
+			names[st.SwIf] = name
+		}
+	}
+
+	for _, ses := range c.sessions.ListSessions() {
+		if ses.State != SessionActive {
+			continue
+		}
+		name, ok := names[ses.Iface]
+		if !ok {
+			name = strconv.Itoa(ses.Iface)
+		}
+		values[name]++
+	}
+
+	return values
+}
+
+func (c *Core) sessionsByPool() map[string]float64 {
+	var pools []*net.IPNet
+	values := make(map[string]float64)
+	for _, v := range c.config.Vpp.IPv4Pool {
+		if _, pool, err := net.ParseCIDR(v); err == nil {
+			pools = append(pools, pool)
+			values[pool.String()] = 0
+		}
+	}
+
+	for _, ses := range c.sessions.ListSessions() {
+		if ses.State != SessionActive || ses.IPv4 == nil {
+			continue
+		}
+		for _, pool := range pools {
+			if pool.Contains(ses.IPv4) {
+				values[pool.String()]++
+				break
+			}
+		}
+	}
+
+	return values
+}
+
+func (c *Core) ifacesProvisioned() map[string]float64 {
+	values := make(map[string]float64)
+	for name, st := range c.vpp.GetIfacesStatus() {
+		values[name] = boolValue(st.State == vpp.IfaceOK)
+	}
+
+	return values
+}
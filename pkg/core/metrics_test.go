@@ -0,0 +1,77 @@
+package core
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/glutechnologies/glubng/internal/vpptest"
+	"github.com/glutechnologies/glubng/pkg/vpp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGaugeFunc(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newGaugeFunc("glubng_test_sessions", "Sessions.", "iface", func() map[string]float64 {
+		return map[string]float64{"cpe1": 2, "cpe2": 0}
+	}))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	if len(families) != 1 || families[0].GetName() != "glubng_test_sessions" {
+		t.Fatalf("families = %v, want glubng_test_sessions", families)
+	}
+
+	got := make(map[string]float64)
+	for _, m := range families[0].GetMetric() {
+		got[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+	}
+	if len(got) != 2 || got["cpe1"] != 2 || got["cpe2"] != 0 {
+		t.Errorf("samples = %v, want cpe1 2 and cpe2 0", got)
+	}
+}
+
+func TestSessionsByIface(t *testing.T) {
+	m := vpptest.NewVPP()
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+	// Parent interfaces of cpe2 and cpe3 do not exist, they are not
+	// provisioned
+	client, err := m.NewClient(&vpp.VPPConfig{
+		SrcVPPSocket:      "/run/vpp/api.sock",
+		GatewayIfaceAddrs: []string{"100.64.0.1"},
+		IPv4Pool:          []string{"100.64.0.0/24"},
+		TapIfaceName:      "tap-kea",
+		TapNetworkPrefix:  "192.168.254.0/30",
+	}, vpptest.WriteIfaces(t, map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 101, MTU: 1500, FlexId: "cpe1"},
+		"cpe2": {VPPSrcIface: eth + 10, IsSubIf: true, OuterVLAN: 102, MTU: 1500, FlexId: "cpe2"},
+		"cpe3": {VPPSrcIface: eth + 11, IsSubIf: true, OuterVLAN: 103, MTU: 1500, FlexId: "cpe3"},
+	}))
+	if client == nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+
+	c := &Core{vpp: client}
+	c.sessions.Init(client, nil)
+	cpe1, _ := client.LookupIfaceName("cpe1")
+	expires := time.Now().Add(time.Hour)
+	for _, ses := range []*Session{
+		{IPv4: net.ParseIP("100.64.0.10"), Iface: cpe1.SwIf, Expires: expires},
+		{IPv4: net.ParseIP("100.64.0.11"), Iface: cpe1.SwIf, Expires: expires},
+		// Restored in an interface not provisioned any more
+		{IPv4: net.ParseIP("100.64.0.12"), Iface: 0, Expires: expires},
+	} {
+		if err := c.sessions.AddSession(ses); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]float64{"cpe1": 2, "cpe2": 0, "cpe3": 0, "0": 1}
+	if got := c.sessionsByIface(); !reflect.DeepEqual(got, want) {
+		t.Errorf("sessionsByIface() = %v, want %v", got, want)
+	}
+}
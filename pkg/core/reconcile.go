@@ -0,0 +1,178 @@
+package core
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/glutechnologies/glubng/pkg/radius"
+)
+
+type sessionRoute struct {
+	prefix *net.IPNet
+	iface  uint32
+}
+
+// sessionRoutes returns routes sessions need in VPP keyed by prefix
+func (s *Sessions) sessionRoutes() map[string]sessionRoute {
+	routes := make(map[string]sessionRoute, len(s.sessions))
+
+	for _, ses := range s.sessions {
+		iface := uint32(ses.Iface)
+		if ses.State == SessionActive && ses.IPv4 != nil {
+			prefix := &net.IPNet{IP: ses.IPv4, Mask: net.CIDRMask(32, 32)}
+			routes[prefix.String()] = sessionRoute{prefix, iface}
+		}
+		for _, delegated := range []bool{false, true} {
+			if prefix := ses.ipv6Binding(delegated); prefix != nil {
+				routes[prefix.String()] = sessionRoute{prefix, iface}
+			}
+		}
+	}
+
+	return routes
+}
+
+// Reconcile compares routes installed in VPP towards CPE interfaces with
+// current sessions. Orphaned routes are removed and missing ones added.
+func (s *Sessions) Reconcile() (err error) {
+	defer s.lockAll()()
+
+	// Routes are installed in the VRF of their interface, lost with VPP state
+	s.mu.Lock()
+	for _, ses := range s.sessions {
+		if ses.State == SessionActive && ses.IPv4 != nil && ses.VRF != "" {
+			s.setVRF(ses, ses.VRF)
+		}
+	}
+	s.unlock(nil)
+
+	routes, err := s.vpp.DumpSessionRoutes()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	var added, removed int
+	desired := s.sessionRoutes()
+
+	// Remove routes without a session or pointing to another iface
+	for key, swIf := range routes {
+		if r, ok := desired[key]; ok && r.iface == swIf {
+			continue
+		}
+		_, prefix, err := net.ParseCIDR(key)
+		if err != nil {
+			continue
+		}
+		s.removePrefix(prefix, int(swIf))
+		delete(routes, key)
+		removed++
+	}
+
+	// Add routes for sessions not present in VPP, present ones are adopted
+	for key, r := range desired {
+		if _, ok := routes[key]; ok {
+			prefix, iface := r.prefix, r.iface
+			s.send(func() error {
+				s.vpp.AdoptSessionPrefix(prefix, iface)
+				return nil
+			}, prefixKey(prefix.String()), ifaceKey(int(iface)))
+			continue
+		}
+		s.addPrefix(r.prefix, int(r.iface))
+		added++
+	}
+
+	// Policers of lease profiles are lost with VPP state
+	for _, ses := range s.sessions {
+		if ses.State == SessionActive && ses.IPv4 != nil && ses.Profile != "" {
+			s.setProfile(ses, ses.Profile)
+		}
+	}
+
+	log.Printf("Reconciled sessions with VPP, added: %d, removed: %d", added, removed)
+
+	return nil
+}
+
+// RemapIfaces moves sessions to the new SwIf of their CPE interface after VPP
+// recreated it. Active sessions of interfaces which could not be recreated are
+// expired and their IPv6 bindings dropped, routes can not be installed.
+func (s *Sessions) RemapIfaces(remap map[int]int) {
+	defer s.lockAll()()
+	s.mu.Lock()
+	defer s.unlock(nil)
+
+	now := time.Now()
+	for key, ses := range s.sessions {
+		to, ok := remap[ses.Iface]
+		if !ok {
+			continue
+		}
+
+		if to >= 0 {
+			ses.Iface = to
+			s.persist(ses)
+			continue
+		}
+
+		active := ses.State == SessionActive && ses.IPv4 != nil
+		if !active && !ses.hasIPv6() {
+			continue
+		}
+		// Routes are already gone, expire without removing them
+		log.Printf("Interface SwIf %d of session %s not provisioned, expiring it", ses.Iface, key)
+		if active {
+			s.stopAccounting(ses, radius.CausePortError)
+			ses.State = SessionExpired
+			ses.Expires = now
+		}
+		ses.clearIPv6(false)
+		ses.clearIPv6(true)
+		s.update(key, ses)
+	}
+}
+
+// RemoveIfaceSessions tears down sessions bound to CPE interfaces about to be
+// deleted, declined addresses are kept in quarantine. No session is bound to
+// the interfaces until UnblockIfaces is called once they are deleted.
+func (s *Sessions) RemoveIfaceSessions(swIfs []int) {
+	defer s.lockAll()()
+	s.mu.Lock()
+	defer s.unlock(nil)
+
+	for _, swIf := range swIfs {
+		s.tornDown[swIf] = true
+	}
+
+	for key, ses := range s.sessions {
+		if !s.tornDown[ses.Iface] {
+			continue
+		}
+		if ses.State == SessionDeclined && ses.IPv4 != nil {
+			if ses.hasIPv6() {
+				s.removeIPv6Routes(ses)
+				ses.clearIPv6(false)
+				ses.clearIPv6(true)
+				s.persist(ses)
+			}
+			continue
+		}
+		s.remove(key, ses, radius.CausePortUnneeded)
+		log.Printf("Session %s removed, interface SwIf %d was deleted", key, ses.Iface)
+	}
+}
+
+// UnblockIfaces allows sessions on SwIfs blocked by RemoveIfaceSessions again,
+// VPP may reuse them for new interfaces
+func (s *Sessions) UnblockIfaces(swIfs []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, swIf := range swIfs {
+		delete(s.tornDown, swIf)
+	}
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"log"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Editors write files in several steps, wait until they are done
+const reloadDelay = 500 * time.Millisecond
+
+// watchFiles reloads interfaces file, and service profiles of configuration
+// file, when they change or SIGHUP is received
+func (c *Core) watchFiles() {
+	defer c.wg.Done()
+
+	var events chan fsnotify.Event
+	var errors chan error
+
+	// Directories are watched, files may be replaced instead of written
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(filepath.Dir(c.ifacesFile))
+	}
+	if err == nil && filepath.Dir(c.configFile) != filepath.Dir(c.ifacesFile) {
+		err = watcher.Add(filepath.Dir(c.configFile))
+	}
+	if err != nil {
+		log.Printf("Error watching configuration files, only SIGHUP reloads them, %s", err.Error())
+	} else {
+		events = watcher.Events
+		errors = watcher.Errors
+	}
+
+	ifacesFile := filepath.Clean(c.ifacesFile)
+	configFile := filepath.Clean(c.configFile)
+	var ifacesDelay, configDelay <-chan time.Time
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.reload:
+			c.reloadIfaces()
+			c.reloadProfiles()
+		case ev := <-events:
+			if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			switch filepath.Clean(ev.Name) {
+			case ifacesFile:
+				ifacesDelay = time.After(reloadDelay)
+			case configFile:
+				configDelay = time.After(reloadDelay)
+			}
+		case err := <-errors:
+			log.Printf("Error watching configuration files, %s", err.Error())
+		case <-ifacesDelay:
+			ifacesDelay = nil
+			c.reloadIfaces()
+		case <-configDelay:
+			configDelay = nil
+			c.reloadProfiles()
+		}
+	}
+}
+
+// reloadIfaces applies changes of interfaces file, running interfaces are not
+// touched if the file is invalid
+func (c *Core) reloadIfaces() {
+	diff, err := c.vpp.DiffIfacesConfig()
+	if err != nil {
+		log.Printf("Interfaces file rejected, %s", err.Error())
+		return
+	}
+	if diff.IsEmpty() {
+		return
+	}
+
+	log.Printf("Reloading interfaces, added: %v, removed: %v, changed: %v", diff.Added, diff.Removed, diff.Changed)
+
+	// Routes are removed before their interfaces, sessions are not bound to
+	// them until they are deleted
+	c.sessions.RemoveIfaceSessions(diff.TornDown)
+	defer c.sessions.UnblockIfaces(diff.TornDown)
+
+	if err = c.vpp.ApplyIfacesDiff(diff); err != nil {
+		log.Printf("Error reloading interfaces, %s", err.Error())
+	}
+}
+
+// reloadProfiles applies changes of service profiles, other changes of
+// configuration file need a restart
+func (c *Core) reloadProfiles() {
+	config, err := ReadConfig(c.configFile)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		log.Printf("Configuration file rejected, %s", err.Error())
+		return
+	}
+	if reflect.DeepEqual(config.Vpp.Profiles, c.vpp.Profiles()) {
+		return
+	}
+
+	log.Println("Reloading service profiles")
+	if err = c.vpp.UpdateProfiles(config.Vpp.Profiles); err != nil {
+		log.Printf("Error reloading service profiles, %s", err.Error())
+	}
+}
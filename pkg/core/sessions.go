@@ -1,47 +1,592 @@
 package core
 
 import (
+	"fmt"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/glutechnologies/glubng/pkg/radius"
 	"github.com/glutechnologies/glubng/pkg/vpp"
 )
 
+type SessionState string
+
+const (
+	// Lease is bound and route installed in VPP
+	SessionActive SessionState = "active"
+	// Lease expired, session is kept without route until retention ends
+	// so it can be recovered
+	SessionExpired SessionState = "expired"
+	// Client declined the address, it's kept in quarantine
+	SessionDeclined SessionState = "declined"
+)
+
+const (
+	defaultDeclineQuarantine = 24 * time.Hour
+	defaultExpiredRetention  = time.Hour
+)
+
 type Sessions struct {
-	sessions map[string]*Session
-	vpp      vpp.Client
+	sessions   map[string]*Session
+	vpp        vpp.Dataplane
+	store      SessionStore
+	quarantine time.Duration
+	retention  time.Duration
+	acct       Accounting
+	// SwIf of CPE interfaces being deleted, sessions are not bound to them
+	tornDown map[int]bool
+	// Changes of an address are made one at a time, changes of different
+	// addresses are made concurrently. Changes of many sessions, like
+	// reconciling them with VPP, hold every address.
+	addresses addressLocks
+	all       sync.RWMutex
+	// Sessions are changed under mu, VPP requests and store writes of a
+	// change are queued in requests and made once mu is released, in order
+	// of their keys
+	mu       sync.Mutex
+	requests []request
+	order    requestOrder
+	// Set when a VPP request failed, until sessions are reconciled
+	failed atomic.Bool
 }
 
 type Session struct {
-	Iface int // VPP Iface
-	IPv4  net.IP
+	Iface           int          `json:"iface"` // VPP Iface
+	IPv4            net.IP       `json:"ipv4"`
+	State           SessionState `json:"state"`
+	Expires         time.Time    `json:"expires"`
+	QuarantineUntil time.Time    `json:"quarantine-until,omitempty"`
+	// DHCPv6 IA_NA address and IA_PD delegated prefix routed to Iface, their
+	// leases are tracked apart from the IPv4 one
+	IPv6              net.IP    `json:"ipv6,omitempty"`
+	IPv6Expires       time.Time `json:"ipv6-expires,omitempty"`
+	IPv6Prefix        string    `json:"ipv6-prefix,omitempty"`
+	IPv6PrefixExpires time.Time `json:"ipv6-prefix-expires,omitempty"`
+	// Service profile given by the IPv4 lease, it replaces the one of the
+	// interface while session is active
+	Profile string `json:"profile,omitempty"`
+	// Identify the subscriber in RADIUS requests
+	MAC       string `json:"mac,omitempty"`
+	CircuitID string `json:"circuit-id,omitempty"`
+	// Set when the IPv4 session becomes active, accounting session is only
+	// opened when accounting is enabled
+	Started       time.Time `json:"started,omitempty"`
+	AcctSessionID string    `json:"acct-session-id,omitempty"`
+	// Attributes given by RADIUS, session is expired at Deadline even if its
+	// lease is renewed
+	Username string          `json:"username,omitempty"`
+	VRF      string          `json:"vrf,omitempty"`
+	Pool     string          `json:"pool,omitempty"`
+	Class    []byte          `json:"class,omitempty"`
+	Deadline time.Time       `json:"deadline,omitempty"`
+	Counters SessionCounters `json:"counters"`
 }
 
-func (s *Sessions) Init(vpp *vpp.Client) {
+// Key identifies a session, it's the IPv4 address unless the session is
+// IPv6 only
+func (ses *Session) Key() string {
+	switch {
+	case ses.IPv4 != nil:
+		return ses.IPv4.String()
+	case ses.IPv6 != nil:
+		return ses.IPv6.String()
+	}
+	return ses.IPv6Prefix
+}
+
+// clone returns a copy of a session not sharing addresses or class
+func (ses *Session) clone() *Session {
+	cp := *ses
+	if ses.IPv4 != nil {
+		cp.IPv4 = append(net.IP{}, ses.IPv4...)
+	}
+	if ses.IPv6 != nil {
+		cp.IPv6 = append(net.IP{}, ses.IPv6...)
+	}
+	if ses.Class != nil {
+		cp.Class = append([]byte{}, ses.Class...)
+	}
+	return &cp
+}
+
+func (ses *Session) hasIPv6() bool {
+	return ses.IPv6 != nil || ses.IPv6Prefix != ""
+}
+
+func (s *Sessions) Init(vpp vpp.Dataplane, store SessionStore) {
 	// Init vpp client
-	s.vpp = *vpp
+	s.vpp = vpp
+	s.store = store
 	s.sessions = make(map[string]*Session)
+	s.tornDown = make(map[int]bool)
+	s.quarantine = defaultDeclineQuarantine
+	s.retention = defaultExpiredRetention
+
+	if s.store == nil {
+		return
+	}
+
+	// Rebuild sessions from persistent store
+	stored, err := s.store.Load()
+	if err != nil {
+		log.Printf("Error loading sessions from store, %s", err.Error())
+		return
+	}
+
+	for _, ses := range stored {
+		// Sessions stored before states were introduced are active
+		if ses.State == "" {
+			ses.State = SessionActive
+		}
+		s.sessions[ses.Key()] = ses
+	}
+	log.Printf("Loaded %d sessions from store", len(stored))
 }
 
-func (s *Sessions) AddSession(ses *Session) {
-	// Check if session exists and it's equal
-	if s.sessions[ses.IPv4.String()] == nil ||
-		s.sessions[ses.IPv4.String()].Iface != ses.Iface {
-		s.sessions[ses.IPv4.String()] = ses
-		s.vpp.AddSession(ses.IPv4, uint32(ses.Iface))
+// SetTimers configures how long declined addresses are quarantined and how
+// long expired sessions are kept to be recovered
+func (s *Sessions) SetTimers(quarantine time.Duration, retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if quarantine > 0 {
+		s.quarantine = quarantine
+	}
+	if retention > 0 {
+		s.retention = retention
 	}
 }
 
-func (s *Sessions) RemoveSession(ipv4 string) {
+func (s *Sessions) Close() {
+	if s.store == nil {
+		return
+	}
+
+	if err := s.store.Close(); err != nil {
+		log.Printf("Error closing session store, %s", err.Error())
+	}
+}
+
+// persist queues a write of a copy of a session to the store, it's synced to
+// disk once mu is released
+func (s *Sessions) persist(ses *Session) {
+	if s.store == nil {
+		return
+	}
+
+	cp := ses.clone()
+	s.send(func() error {
+		if err := s.store.Put(cp); err != nil {
+			log.Printf("Error storing session %s, %s", cp.Key(), err.Error())
+		}
+		return nil
+	}, storeKey(cp.Key()))
+}
+
+func (s *Sessions) unpersist(key string) {
+	if s.store == nil {
+		return
+	}
+
+	s.send(func() error {
+		if err := s.store.Delete(key); err != nil {
+			log.Printf("Error deleting stored session %s, %s", key, err.Error())
+		}
+		return nil
+	}, storeKey(key))
+}
+
+// update stores a modified session under its key, which changes when the
+// address it was keyed by is gone. Sessions left without addresses are deleted.
+func (s *Sessions) update(oldKey string, ses *Session) {
+	key := ses.Key()
+	if oldKey != "" && oldKey != key {
+		delete(s.sessions, oldKey)
+		s.unpersist(oldKey)
+	}
+	if key == "" {
+		return
+	}
+
+	s.sessions[key] = ses
+	s.persist(ses)
+}
+
+// dropIPv4 forgets the IPv4 part of a session without touching its route,
+// session is kept while it has IPv6 bindings
+func (s *Sessions) dropIPv4(key string, ses *Session) {
+	ses.IPv4 = nil
+	ses.Profile = ""
+	ses.MAC = ""
+	ses.CircuitID = ""
+	ses.Started = time.Time{}
+	ses.AcctSessionID = ""
+	ses.copyAAA(&Session{})
+	ses.State = SessionActive
+	ses.Expires = time.Time{}
+	ses.QuarantineUntil = time.Time{}
+	s.update(key, ses)
+}
+
+// addIPv4Route installs route of a session, in its VRF, and applies its
+// profile
+func (s *Sessions) addIPv4Route(ses *Session) {
+	if ses.VRF != "" {
+		s.setVRF(ses, ses.VRF)
+	}
+	ipv4, iface := ses.IPv4, uint32(ses.Iface)
+	s.send(func() error { return s.vpp.AddSession(ipv4, iface) }, ipv4Key(ipv4), ifaceKey(ses.Iface))
+	if ses.Profile != "" {
+		s.setProfile(ses, ses.Profile)
+	}
+}
+
+// removeIPv4Route removes route of a session, profile and VRF of the
+// interface are restored
+func (s *Sessions) removeIPv4Route(ses *Session) {
+	ipv4, iface := ses.IPv4, uint32(ses.Iface)
+	s.send(func() error { return s.vpp.RemoveSession(ipv4, iface) }, ipv4Key(ipv4), ifaceKey(ses.Iface))
+	if ses.Profile != "" {
+		s.setProfile(ses, "")
+	}
+	if ses.VRF != "" {
+		s.setVRF(ses, "")
+	}
+}
+
+// setVRF places the CPE interface of a session in a VRF, on failure route of
+// the session is installed in the table of the interface
+func (s *Sessions) setVRF(ses *Session, vrf string) {
+	iface, key := ses.Iface, ses.IPv4.String()
+	s.send(func() error {
+		if err := s.vpp.SetIfaceVRF(iface, key, vrf); err != nil {
+			log.Printf("Error placing SwIf %d in VRF %q, %s", iface, vrf, err.Error())
+		}
+		return nil
+	}, ifaceKey(iface))
+}
+
+// setProfile applies a service profile of a session to its CPE interface, on
+// failure the interface keeps its previous policer
+func (s *Sessions) setProfile(ses *Session, profile string) {
+	iface, key := ses.Iface, ses.IPv4.String()
+	s.send(func() error {
+		if err := s.vpp.SetIfaceProfile(iface, key, profile); err != nil {
+			log.Printf("Error applying service profile %q to SwIf %d, %s", profile, iface, err.Error())
+		}
+		return nil
+	}, ifaceKey(iface))
+}
+
+// AddSession binds a selected lease, moving it if it was active in another iface
+func (s *Sessions) AddSession(ses *Session) (err error) {
+	defer s.lockAddress(ses.IPv4.String())()
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	return s.activate(ses)
+}
+
+func (s *Sessions) activate(ses *Session) error {
+	key := ses.IPv4.String()
+	cur := s.sessions[key]
+
+	if s.tornDown[ses.Iface] {
+		return fmt.Errorf("interface SwIf %d of session %s is being deleted", ses.Iface, key)
+	}
+
+	if cur != nil && cur.State == SessionDeclined && time.Now().Before(cur.QuarantineUntil) {
+		return fmt.Errorf("address %s is quarantined until %s", key, cur.QuarantineUntil.Format(time.RFC3339))
+	}
+
+	if cur != nil && cur.State == SessionActive {
+		if cur.Iface == ses.Iface && cur.VRF == ses.VRF {
+			// Same binding, only refresh lease timer, profile and
+			// authorization
+			cur.Expires = ses.Expires
+			cur.copyAAA(ses)
+			if cur.Profile != ses.Profile {
+				cur.Profile = ses.Profile
+				s.setProfile(cur, cur.Profile)
+			}
+			s.persist(cur)
+			return nil
+		}
+		// Circuit or VRF changed, move route to new iface or table
+		log.Printf("Moving session %s from SwIf %d to SwIf %d, VRF %q", key, cur.Iface, ses.Iface, ses.VRF)
+		s.stopAccounting(cur, radius.CausePortUnneeded)
+		s.removeIPv4Route(cur)
+	}
+
+	if cur != nil {
+		s.moveIPv6(cur, ses)
+	} else if v6Key, v6 := s.ipv6Only(ses.Iface); v6 != nil {
+		// Client got its IPv6 lease first
+		s.moveIPv6(v6, ses)
+		delete(s.sessions, v6Key)
+		s.unpersist(v6Key)
+	}
+
+	ses.State = SessionActive
+	ses.QuarantineUntil = time.Time{}
+	s.sessions[key] = ses
+	s.addIPv4Route(ses)
+	s.startAccounting(ses)
+	s.persist(ses)
+
+	return nil
+}
+
+// RenewSession refreshes lease timer of an active session. Unknown sessions
+// are installed as if they were selected.
+func (s *Sessions) RenewSession(ses *Session) (err error) {
+	defer s.lockAddress(ses.IPv4.String())()
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	return s.activate(ses)
+}
+
+// RemoveSession removes a session with its IPv4 and IPv6 routes
+func (s *Sessions) RemoveSession(key string) (err error) {
+	defer s.lockAddress(key)()
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	ses := s.sessions[key]
+	if ses == nil {
+		return fmt.Errorf("session %s not exists", key)
+	}
+	s.remove(key, ses, radius.CauseAdminReset)
+
+	return nil
+}
+
+func (s *Sessions) remove(key string, ses *Session, cause radius.TerminateCause) {
+	if ses.State == SessionActive && ses.IPv4 != nil {
+		s.stopAccounting(ses, cause)
+		s.removeIPv4Route(ses)
+	}
+	s.removeIPv6Routes(ses)
+	delete(s.sessions, key)
+	s.unpersist(key)
+}
+
+// ReleaseSession removes a released IPv4 lease, IPv6 bindings of the session
+// are kept until they are released
+func (s *Sessions) ReleaseSession(ipv4 string) (err error) {
+	defer s.lockAddress(ipv4)()
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	ses := s.sessions[ipv4]
+	if ses == nil || ses.IPv4 == nil {
+		return fmt.Errorf("session with IPv4 %s not exists", ipv4)
+	}
+	if ses.State == SessionActive {
+		s.stopAccounting(ses, radius.CauseUserRequest)
+		s.removeIPv4Route(ses)
+	}
+	s.dropIPv4(ipv4, ses)
+
+	return nil
+}
+
+// ExpireSession removes route of a session whose lease expired, session is
+// retained to allow a later recover
+func (s *Sessions) ExpireSession(ipv4 string) (err error) {
+	defer s.lockAddress(ipv4)()
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	ses := s.sessions[ipv4]
+	if ses == nil || ses.IPv4 == nil {
+		return fmt.Errorf("session with IPv4 %s not exists", ipv4)
+	}
+
+	s.expire(ses, time.Now(), radius.CauseIdleTimeout)
+
+	return nil
+}
+
+func (s *Sessions) expire(ses *Session, now time.Time, cause radius.TerminateCause) {
+	if ses.State != SessionActive || ses.IPv4 == nil {
+		return
+	}
+
+	s.stopAccounting(ses, cause)
+	s.removeIPv4Route(ses)
+	ses.State = SessionExpired
+	ses.Expires = now
+	s.persist(ses)
+}
+
+// DeclineSession tears down a session and quarantines its address
+func (s *Sessions) DeclineSession(ipv4 net.IP) (err error) {
+	key := ipv4.String()
+	defer s.lockAddress(key)()
+	s.mu.Lock()
+	defer s.unlock(&err)
+
+	ses := s.sessions[key]
+	if ses == nil {
+		// Quarantine addresses even if they were never bound
+		ses = &Session{IPv4: ipv4}
+		s.sessions[key] = ses
+	} else if ses.State == SessionActive && ses.IPv4 != nil {
+		s.stopAccounting(ses, radius.CauseUserError)
+		s.removeIPv4Route(ses)
+	}
+
+	ses.State = SessionDeclined
+	ses.QuarantineUntil = time.Now().Add(s.quarantine)
+	s.persist(ses)
+
+	log.Printf("Address %s declined, quarantined until %s", key, ses.QuarantineUntil.Format(time.RFC3339))
+
+	return nil
+}
+
+// RecoverSession re-installs route of an expired session. A declined address
+// recovered by Kea after its probation period leaves quarantine.
+func (s *Sessions) RecoverSession(ipv4 string, expires time.Time) (err error) {
+	defer s.lockAddress(ipv4)()
+	s.mu.Lock()
+	defer s.unlock(&err)
+
 	ses := s.sessions[ipv4]
+	if ses == nil || ses.IPv4 == nil {
+		return fmt.Errorf("session with IPv4 %s not exists", ipv4)
+	}
+
+	switch ses.State {
+	case SessionActive:
+		ses.Expires = expires
+		s.persist(ses)
+	case SessionExpired:
+		ses.State = SessionActive
+		ses.Expires = expires
+		s.addIPv4Route(ses)
+		s.startAccounting(ses)
+		s.persist(ses)
+	case SessionDeclined:
+		// Address is free again, it's bound when it's selected
+		s.dropIPv4(ipv4, ses)
+	default:
+		return fmt.Errorf("session with IPv4 %s can not be recovered from state %s", ipv4, ses.State)
+	}
+
+	return nil
+}
+
+// Sweep expires active sessions whose lease timer has passed and forgets
+// expired and declined sessions after their retention or quarantine. IPv6
+// bindings are removed once their own lease timer has passed. VPP requests
+// which failed since last Sweep are retried by reconciling sessions.
+func (s *Sessions) Sweep(now time.Time) {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.sessions))
+	for key := range s.sessions {
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+
+	// Sessions are swept one at a time, so events of other addresses are not
+	// held up
+	for _, key := range keys {
+		s.sweep(key, now)
+	}
+
+	if s.failed.Swap(false) {
+		log.Println("Reconciling sessions with VPP to retry failed requests")
+		if err := s.Reconcile(); err != nil {
+			log.Printf("Error reconciling sessions with VPP, %s", err.Error())
+			s.failed.Store(true)
+		}
+	}
+}
+
+func (s *Sessions) sweep(key string, now time.Time) {
+	defer s.lockAddress(key)()
+	s.mu.Lock()
+	defer s.unlock(nil)
+
+	ses := s.sessions[key]
 	if ses == nil {
-		log.Printf("Session with IPv4 %s not exists", ipv4)
 		return
 	}
-	s.vpp.RemoveSession(ses.IPv4, uint32(ses.Iface))
-	delete(s.sessions, ipv4)
+	if s.sweepIPv6(ses, now) {
+		log.Printf("IPv6 lease timer of session %s expired", key)
+		s.update(key, ses)
+		if ses.Key() != key {
+			return
+		}
+	}
+	if ses.IPv4 == nil {
+		return
+	}
+
+	switch ses.State {
+	case SessionActive:
+		if !ses.Deadline.IsZero() && now.After(ses.Deadline) {
+			log.Printf("Session-Timeout of session %s reached", key)
+			s.expire(ses, now, radius.CauseSessionTimeout)
+		} else if !ses.Expires.IsZero() && now.After(ses.Expires) {
+			log.Printf("Lease timer of session %s expired", key)
+			s.expire(ses, now, radius.CauseIdleTimeout)
+		}
+	case SessionExpired:
+		if now.After(ses.Expires.Add(s.retention)) {
+			s.dropIPv4(key, ses)
+		}
+	case SessionDeclined:
+		if now.After(ses.QuarantineUntil) {
+			s.dropIPv4(key, ses)
+		}
+	}
+}
+
+// GetSession returns a session by its key
+func (s *Sessions) GetSession(key string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ses := s.sessions[key]
+	if ses == nil {
+		return nil
+	}
+
+	// Return a copy, session is modified under lock
+	return ses.clone()
 }
 
-func (s *Sessions) GetSession(ipv4 string) *Session {
-	return s.sessions[ipv4]
+// activeByMAC returns a copy of the active IPv4 session of a subscriber on
+// an interface
+func (s *Sessions) activeByMAC(iface int, mac string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ses := range s.sessions {
+		if ses.State == SessionActive && ses.IPv4 != nil && ses.Iface == iface && ses.MAC == mac {
+			return ses.clone()
+		}
+	}
+
+	return nil
+}
+
+// ListSessions returns a copy of every session
+func (s *Sessions) ListSessions() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Session, 0, len(s.sessions))
+	for _, ses := range s.sessions {
+		list = append(list, *ses)
+	}
+
+	return list
 }
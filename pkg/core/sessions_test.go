@@ -0,0 +1,702 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glutechnologies/glubng/internal/vpptest"
+	"github.com/glutechnologies/glubng/pkg/radius"
+	"github.com/glutechnologies/glubng/pkg/vpp"
+	"go.fd.io/govpp/api"
+)
+
+const testIPv4 = "100.64.0.10"
+
+type sessionsFixture struct {
+	vpp        *vpptest.VPP
+	config     *vpp.VPPConfig
+	ifacesFile string
+	client     *vpp.Client
+	sessions   *Sessions
+}
+
+func newSessionsFixture(t *testing.T) *sessionsFixture {
+	t.Helper()
+
+	m := vpptest.NewVPP()
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+	config := &vpp.VPPConfig{
+		SrcVPPSocket:      "/run/vpp/api.sock",
+		GatewayIfaceAddrs: []string{"100.64.0.1"},
+		IPv4Pool:          []string{"100.64.0.0/24"},
+		TapIfaceName:      "tap-kea",
+		TapNetworkPrefix:  "192.168.254.0/30",
+		Profiles: map[string]vpp.ServiceProfile{
+			"basic":   {Rate: 10000},
+			"premium": {Rate: 100000},
+		},
+	}
+	ifacesFile := vpptest.WriteIfaces(t, map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 101, MTU: 1500, FlexId: "cpe1", Profile: "basic"},
+		"cpe2": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 102, MTU: 1500, FlexId: "cpe2", Profile: "basic"},
+	})
+
+	f := &sessionsFixture{vpp: m, config: config, ifacesFile: ifacesFile}
+	f.connect(t)
+	t.Cleanup(func() { f.client.Close() })
+
+	return f
+}
+
+// connect creates a client and sessions, objects already in VPP are adopted
+func (f *sessionsFixture) connect(t *testing.T) {
+	t.Helper()
+
+	client, err := f.vpp.NewClient(f.config, f.ifacesFile)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	f.client = client
+
+	f.sessions = &Sessions{}
+	f.sessions.Init(client, nil)
+}
+
+// restart connects again like a new run of glubngd, VPP keeps its state and
+// sessions are kept
+func (f *sessionsFixture) restart(t *testing.T) {
+	t.Helper()
+
+	sessions := f.sessions.sessions
+	f.client.Close()
+	f.connect(t)
+	f.sessions.sessions = sessions
+}
+
+// swIf returns SwIf of a CPE interface
+func (f *sessionsFixture) swIf(name string) int {
+	v, _ := f.client.LookupIfaceName(name)
+	return v.SwIf
+}
+
+// route returns SwIfs of the route of a prefix
+func (f *sessionsFixture) route(prefix string) []uint32 {
+	return f.vpp.Routes()[prefix]
+}
+
+type sessionOp func(f *sessionsFixture) error
+
+func selectLease(cpe string, expires time.Time) sessionOp {
+	return func(f *sessionsFixture) error {
+		return f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf(cpe), Expires: expires})
+	}
+}
+
+func renewLease(cpe string, expires time.Time) sessionOp {
+	return func(f *sessionsFixture) error {
+		return f.sessions.RenewSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf(cpe), Expires: expires})
+	}
+}
+
+func releaseLease(f *sessionsFixture) error {
+	return f.sessions.ReleaseSession(testIPv4)
+}
+
+func declineLease(f *sessionsFixture) error {
+	return f.sessions.DeclineSession(net.ParseIP(testIPv4))
+}
+
+func expireLease(f *sessionsFixture) error {
+	return f.sessions.ExpireSession(testIPv4)
+}
+
+func recoverLease(expires time.Time) sessionOp {
+	return func(f *sessionsFixture) error {
+		return f.sessions.RecoverSession(testIPv4, expires)
+	}
+}
+
+func sweep(now time.Time) sessionOp {
+	return func(f *sessionsFixture) error {
+		f.sessions.Sweep(now)
+		return nil
+	}
+}
+
+func TestSessionsLifecycle(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(time.Hour).Truncate(time.Second)
+	renewed := expires.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		ops  []sessionOp
+		// Last operation fails
+		wantErr bool
+		// No session when empty
+		wantState   SessionState
+		wantIface   string
+		wantExpires time.Time
+		// Route of the session is installed to wantIface
+		wantRoute bool
+	}{
+		{
+			name:        "select",
+			ops:         []sessionOp{selectLease("cpe1", expires)},
+			wantState:   SessionActive,
+			wantIface:   "cpe1",
+			wantExpires: expires,
+			wantRoute:   true,
+		},
+		{
+			name:        "renew same iface",
+			ops:         []sessionOp{selectLease("cpe1", expires), renewLease("cpe1", renewed)},
+			wantState:   SessionActive,
+			wantIface:   "cpe1",
+			wantExpires: renewed,
+			wantRoute:   true,
+		},
+		{
+			name:        "renew changed iface",
+			ops:         []sessionOp{selectLease("cpe1", expires), renewLease("cpe2", renewed)},
+			wantState:   SessionActive,
+			wantIface:   "cpe2",
+			wantExpires: renewed,
+			wantRoute:   true,
+		},
+		{
+			name:        "renew unknown session",
+			ops:         []sessionOp{renewLease("cpe2", renewed)},
+			wantState:   SessionActive,
+			wantIface:   "cpe2",
+			wantExpires: renewed,
+			wantRoute:   true,
+		},
+		{
+			name: "release",
+			ops:  []sessionOp{selectLease("cpe1", expires), releaseLease},
+		},
+		{
+			name:    "release unknown session",
+			ops:     []sessionOp{releaseLease},
+			wantErr: true,
+		},
+		{
+			name:      "decline",
+			ops:       []sessionOp{selectLease("cpe1", expires), declineLease},
+			wantState: SessionDeclined,
+			wantIface: "cpe1",
+		},
+		{
+			name:      "select quarantined",
+			ops:       []sessionOp{declineLease, selectLease("cpe1", expires)},
+			wantErr:   true,
+			wantState: SessionDeclined,
+		},
+		{
+			name:        "expire",
+			ops:         []sessionOp{selectLease("cpe1", expires), expireLease},
+			wantState:   SessionExpired,
+			wantIface:   "cpe1",
+			wantExpires: time.Time{},
+		},
+		{
+			name:        "sweep expired lease timer",
+			ops:         []sessionOp{selectLease("cpe1", now.Add(-time.Minute)), sweep(now)},
+			wantState:   SessionExpired,
+			wantIface:   "cpe1",
+			wantExpires: now,
+		},
+		{
+			name: "sweep after retention",
+			ops:  []sessionOp{selectLease("cpe1", now.Add(-time.Minute)), sweep(now), sweep(now.Add(2 * time.Hour))},
+		},
+		{
+			name:        "recover expired",
+			ops:         []sessionOp{selectLease("cpe1", expires), expireLease, recoverLease(renewed)},
+			wantState:   SessionActive,
+			wantIface:   "cpe1",
+			wantExpires: renewed,
+			wantRoute:   true,
+		},
+		{
+			name: "recover declined",
+			ops:  []sessionOp{selectLease("cpe1", expires), declineLease, recoverLease(renewed)},
+		},
+		{
+			name:        "select recovered declined",
+			ops:         []sessionOp{declineLease, recoverLease(renewed), selectLease("cpe2", expires)},
+			wantState:   SessionActive,
+			wantIface:   "cpe2",
+			wantExpires: expires,
+			wantRoute:   true,
+		},
+		{
+			name:    "recover unknown session",
+			ops:     []sessionOp{recoverLease(renewed)},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newSessionsFixture(t)
+
+			for i, op := range tt.ops {
+				err := op(f)
+				last := i == len(tt.ops)-1
+				if err != nil && !(last && tt.wantErr) {
+					t.Fatalf("operation %d error = %v", i, err)
+				}
+				if err == nil && last && tt.wantErr {
+					t.Fatalf("operation %d succeeded, want error", i)
+				}
+			}
+
+			ses := f.sessions.GetSession(testIPv4)
+			if tt.wantState == "" {
+				if ses != nil {
+					t.Errorf("session = %+v, want none", ses)
+				}
+			} else if ses == nil || ses.State != tt.wantState {
+				t.Fatalf("session = %+v, want state %s", ses, tt.wantState)
+			}
+			if tt.wantIface != "" && ses.Iface != f.swIf(tt.wantIface) {
+				t.Errorf("session SwIf = %d, want %s with SwIf %d", ses.Iface, tt.wantIface, f.swIf(tt.wantIface))
+			}
+			if tt.wantState == SessionActive && !ses.Expires.Equal(tt.wantExpires) {
+				t.Errorf("session expires = %s, want %s", ses.Expires, tt.wantExpires)
+			}
+			if tt.wantState == SessionExpired && !tt.wantExpires.IsZero() && !ses.Expires.Equal(tt.wantExpires) {
+				t.Errorf("session expired at %s, want %s", ses.Expires, tt.wantExpires)
+			}
+			if tt.wantState == SessionDeclined && !time.Now().Before(ses.QuarantineUntil) {
+				t.Errorf("declined session quarantined until %s, want in the future", ses.QuarantineUntil)
+			}
+
+			route := f.route(testIPv4 + "/32")
+			switch {
+			case tt.wantRoute && !reflect.DeepEqual(route, []uint32{uint32(f.swIf(tt.wantIface))}):
+				t.Errorf("route = %v, want SwIf %d", route, f.swIf(tt.wantIface))
+			case !tt.wantRoute && route != nil:
+				t.Errorf("route = %v, want none", route)
+			}
+		})
+	}
+}
+
+func TestSessionsRenewSameIfaceKeepsRoute(t *testing.T) {
+	f := newSessionsFixture(t)
+	expires := time.Now().Add(time.Hour)
+
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe1"), Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	requests := len(f.vpp.Requests())
+
+	if err := f.sessions.RenewSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe1"), Expires: expires.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.vpp.Requests()[requests:]; len(got) != 0 {
+		t.Errorf("renew sent %v to VPP, want no requests", got)
+	}
+}
+
+func TestSessionsMoveIPv6WithIPv4(t *testing.T) {
+	f := newSessionsFixture(t)
+	expires := time.Now().Add(time.Hour)
+	_, prefix, _ := net.ParseCIDR("2001:db8:100::/56")
+
+	// Client got its IPv6 lease first
+	if err := f.sessions.AddIPv6(&IPv6Lease{Iface: f.swIf("cpe1"), Prefix: prefix, Delegated: true, Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe1"), Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if list := f.sessions.ListSessions(); len(list) != 1 || list[0].IPv6Prefix != prefix.String() {
+		t.Fatalf("sessions = %+v, want one session with IPv4 and delegated prefix", list)
+	}
+
+	// Circuit changed, both routes move
+	if err := f.sessions.RenewSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe2"), Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{uint32(f.swIf("cpe2"))}
+	if got := f.route(prefix.String()); !reflect.DeepEqual(got, want) {
+		t.Errorf("IPv6 route = %v, want %v", got, want)
+	}
+
+	// Release of IPv4 keeps the IPv6 only session
+	if err := f.sessions.ReleaseSession(testIPv4); err != nil {
+		t.Fatal(err)
+	}
+	if ses := f.sessions.GetSession(prefix.String()); ses == nil || ses.Iface != f.swIf("cpe2") {
+		t.Errorf("IPv6 only session = %+v, want it on cpe2", ses)
+	}
+}
+
+// slowDataplane delays removing prefixes of an interface, so requests of
+// other changes of a session are made meanwhile
+type slowDataplane struct {
+	vpp.Dataplane
+	iface    uint32
+	removing chan struct{}
+}
+
+func (d *slowDataplane) RemoveSessionPrefix(prefix *net.IPNet, iface uint32) error {
+	if iface == d.iface {
+		close(d.removing)
+		time.Sleep(50 * time.Millisecond)
+	}
+	return d.Dataplane.RemoveSessionPrefix(prefix, iface)
+}
+
+// IPv4 and IPv6 events of a session hold different addresses, routes they
+// both change are still changed in order
+func TestSessionsConcurrentIPv4AndIPv6(t *testing.T) {
+	f := newSessionsFixture(t)
+	expires := time.Now().Add(time.Hour)
+	_, prefix, _ := net.ParseCIDR("2001:db8:100::/56")
+	slow := &slowDataplane{Dataplane: f.client, iface: uint32(f.swIf("cpe1")), removing: make(chan struct{})}
+	f.sessions.Init(slow, nil)
+
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe1"), Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.sessions.AddIPv6(&IPv6Lease{Iface: f.swIf("cpe1"), Prefix: prefix, Delegated: true, Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Circuit changed, prefix is released while its route is being moved
+	renewed := make(chan error)
+	go func() {
+		renewed <- f.sessions.RenewSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe2"), Expires: expires})
+	}()
+	<-slow.removing
+	if err := f.sessions.RemoveIPv6(&IPv6Lease{Prefix: prefix, Delegated: true}); err != nil {
+		t.Errorf("RemoveIPv6() error = %v", err)
+	}
+	if err := <-renewed; err != nil {
+		t.Errorf("RenewSession() error = %v", err)
+	}
+
+	if got := f.route(prefix.String()); got != nil {
+		t.Errorf("released IPv6 route to SwIfs %v, want none", got)
+	}
+	want := []uint32{uint32(f.swIf("cpe2"))}
+	if got := f.route(testIPv4 + "/32"); !reflect.DeepEqual(got, want) {
+		t.Errorf("IPv4 route = %v, want %v", got, want)
+	}
+}
+
+func TestSessionsTornDownIface(t *testing.T) {
+	f := newSessionsFixture(t)
+	expires := time.Now().Add(time.Hour)
+	cpe1 := f.swIf("cpe1")
+	_, prefix, _ := net.ParseCIDR("2001:db8:100::/56")
+
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: cpe1, Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	f.sessions.RemoveIfaceSessions([]int{cpe1})
+	if ses := f.sessions.GetSession(testIPv4); ses != nil {
+		t.Errorf("session = %+v, want it removed", ses)
+	}
+
+	// Leases handled before the interface is deleted are rejected
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: cpe1, Expires: expires}); err == nil {
+		t.Error("AddSession() on torn down interface succeeded, want error")
+	}
+	if err := f.sessions.AddIPv6(&IPv6Lease{Iface: cpe1, Prefix: prefix, Delegated: true, Expires: expires}); err == nil {
+		t.Error("AddIPv6() on torn down interface succeeded, want error")
+	}
+	if routes := f.vpp.Routes(); routes[testIPv4+"/32"] != nil || routes[prefix.String()] != nil {
+		t.Errorf("routes = %v, want none of the session", routes)
+	}
+
+	f.sessions.UnblockIfaces([]int{cpe1})
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: cpe1, Expires: expires}); err != nil {
+		t.Errorf("AddSession() after unblocking error = %v", err)
+	}
+}
+
+func TestSessionsReconcileAdoptsRoutes(t *testing.T) {
+	f := newSessionsFixture(t)
+	expires := time.Now().Add(time.Hour)
+
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe1"), Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	f.restart(t)
+
+	if err := f.sessions.Reconcile(); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var owned bool
+	for _, o := range f.client.Owned() {
+		owned = owned || (o.Kind == vpp.OwnedRoute && o.Name == testIPv4+"/32")
+	}
+	if !owned {
+		t.Fatalf("route of session not owned after Reconcile, owned %+v", f.client.Owned())
+	}
+
+	if err := f.client.Teardown(); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if route := f.route(testIPv4 + "/32"); route != nil {
+		t.Errorf("route = %v after Teardown, want none", route)
+	}
+}
+
+func TestSessionsConcurrent(t *testing.T) {
+	f := newSessionsFixture(t)
+	expires := time.Now().Add(time.Hour)
+
+	addrs := []string{"100.64.0.10", "100.64.0.11", "100.64.0.12", "100.64.0.13"}
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		cpe := []string{"cpe1", "cpe2"}[i%2]
+		wg.Add(1)
+		go func(addr string, swIf int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(addr), Iface: swIf, Expires: expires}); err != nil {
+					t.Errorf("AddSession(%s) error = %v", addr, err)
+				}
+				if err := f.sessions.RenewSession(&Session{IPv4: net.ParseIP(addr), Iface: swIf, Expires: expires}); err != nil {
+					t.Errorf("RenewSession(%s) error = %v", addr, err)
+				}
+			}
+		}(addr, f.swIf(cpe))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 5; j++ {
+			f.sessions.Sweep(time.Now())
+			if err := f.sessions.Reconcile(); err != nil {
+				t.Errorf("Reconcile() error = %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	for _, addr := range addrs {
+		if ses := f.sessions.GetSession(addr); ses == nil || ses.State != SessionActive {
+			t.Errorf("session of %s = %+v, want active", addr, ses)
+		}
+		if route := f.route(addr + "/32"); route == nil {
+			t.Errorf("no route of %s", addr)
+		}
+	}
+}
+
+func TestSessionsRouteFailure(t *testing.T) {
+	f := newSessionsFixture(t)
+	expires := time.Now().Add(time.Hour)
+
+	f.vpp.FailNext("ip_route_add_del", api.SYSCALL_ERROR_1)
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe1"), Expires: expires}); err == nil {
+		t.Fatal("AddSession() with failed route succeeded, want error")
+	}
+	if ses := f.sessions.GetSession(testIPv4); ses == nil || ses.State != SessionActive {
+		t.Fatalf("session = %+v, want active until its route is retried", ses)
+	}
+	if route := f.route(testIPv4 + "/32"); route != nil {
+		t.Fatalf("route = %v, want none", route)
+	}
+
+	// Route is retried by next Sweep
+	f.sessions.Sweep(time.Now())
+	if route := f.route(testIPv4 + "/32"); !reflect.DeepEqual(route, []uint32{uint32(f.swIf("cpe1"))}) {
+		t.Errorf("route after Sweep = %v, want SwIf %d", route, f.swIf("cpe1"))
+	}
+
+	f.vpp.FailNext("ip_route_add_del", api.SYSCALL_ERROR_1)
+	if err := f.sessions.ReleaseSession(testIPv4); err == nil {
+		t.Error("ReleaseSession() with failed route succeeded, want error")
+	}
+	f.sessions.Sweep(time.Now())
+	if route := f.route(testIPv4 + "/32"); route != nil {
+		t.Errorf("route of released session after Sweep = %v, want none", route)
+	}
+}
+
+func TestSessionsProfilePerSession(t *testing.T) {
+	f := newSessionsFixture(t)
+	expires := time.Now().Add(time.Hour)
+	cpe1 := f.swIf("cpe1")
+
+	rate := func() uint32 {
+		iface, _ := f.vpp.Iface(uint32(cpe1))
+		return f.vpp.Policers()[iface.Policer].Rate
+	}
+
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP("100.64.0.10"), Iface: cpe1, Expires: expires, Profile: "premium"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP("100.64.0.11"), Iface: cpe1, Expires: expires, Profile: "basic"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rate(); got != 10000 {
+		t.Errorf("rate = %d, want 10000 of the last session", got)
+	}
+
+	// Profile of the remaining session is applied again
+	if err := f.sessions.ReleaseSession("100.64.0.11"); err != nil {
+		t.Fatal(err)
+	}
+	if got := rate(); got != 100000 {
+		t.Errorf("rate = %d after release, want 100000 of the remaining session", got)
+	}
+}
+
+func TestSessionsVRF(t *testing.T) {
+	f := newSessionsFixture(t)
+	f.vpp.AddTable(20)
+	f.config.VRFs = map[string]uint32{"isp-b": 20}
+	f.client.Close()
+	f.connect(t)
+	expires := time.Now().Add(time.Hour)
+	cpe1 := f.swIf("cpe1")
+
+	table := func() uint32 {
+		iface, _ := f.vpp.Iface(uint32(cpe1))
+		return iface.Table
+	}
+
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: cpe1, Expires: expires, VRF: "isp-b"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := table(); got != 20 {
+		t.Errorf("table of cpe1 = %d, want 20", got)
+	}
+	if got, ok := f.vpp.RouteTable(testIPv4 + "/32"); !ok || got != 20 {
+		t.Errorf("table of session route = %d, %t, want 20", got, ok)
+	}
+
+	if err := f.sessions.ReleaseSession(testIPv4); err != nil {
+		t.Fatal(err)
+	}
+	if got := table(); got != 0 {
+		t.Errorf("table of cpe1 after release = %d, want 0", got)
+	}
+	if routes := f.vpp.Routes(); len(routes) != 0 {
+		t.Errorf("routes after release = %v, want none", routes)
+	}
+}
+
+func TestSessionsReauthorize(t *testing.T) {
+	f := newSessionsFixture(t)
+	f.vpp.AddTable(20)
+	f.config.VRFs = map[string]uint32{"isp-b": 20}
+	f.client.Close()
+	f.connect(t)
+	expires := time.Now().Add(time.Hour)
+	cpe1 := f.swIf("cpe1")
+
+	state := func() (uint32, uint32) {
+		iface, _ := f.vpp.Iface(uint32(cpe1))
+		return iface.Table, f.vpp.Policers()[iface.Policer].Rate
+	}
+
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: cpe1, Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name      string
+		auth      radius.Authorization
+		wantErr   error
+		wantTable uint32
+		wantRate  uint32
+	}{
+		{name: "vrf and profile", auth: radius.Authorization{VRF: "isp-b", Profile: "premium"}, wantTable: 20, wantRate: 100000},
+		{name: "unknown vrf", auth: radius.Authorization{VRF: "isp-c", Profile: "basic"}, wantErr: radius.ErrInvalidAttribute, wantTable: 20, wantRate: 100000},
+		{name: "unknown profile", auth: radius.Authorization{VRF: radius.InterfaceDefault, Profile: "gold"}, wantErr: radius.ErrInvalidAttribute, wantTable: 20, wantRate: 100000},
+		{name: "pool only", auth: radius.Authorization{Pool: "pool-b"}, wantTable: 20, wantRate: 100000},
+		{name: "interface defaults", auth: radius.Authorization{VRF: radius.InterfaceDefault, Profile: radius.InterfaceDefault}, wantTable: 0, wantRate: 10000},
+	}
+	for _, s := range steps {
+		err := f.sessions.Reauthorize(testIPv4, &s.auth)
+		if !errors.Is(err, s.wantErr) {
+			t.Fatalf("%s: Reauthorize() error = %v, want %v", s.name, err, s.wantErr)
+		}
+		if table, rate := state(); table != s.wantTable || rate != s.wantRate {
+			t.Errorf("%s: table and rate = %d, %d, want %d, %d", s.name, table, rate, s.wantTable, s.wantRate)
+		}
+		if table, ok := f.vpp.RouteTable(testIPv4 + "/32"); !ok || table != s.wantTable {
+			t.Errorf("%s: table of session route = %d, %t, want %d", s.name, table, ok, s.wantTable)
+		}
+	}
+
+	ses := f.sessions.GetSession(testIPv4)
+	if ses.VRF != "" || ses.Profile != "" || ses.Pool != "pool-b" {
+		t.Errorf("session = %+v, want no VRF and profile and pool-b", ses)
+	}
+
+	// VRF of an interface with other sessions can not change
+	if err := f.sessions.AddSession(&Session{IPv4: net.ParseIP("100.64.0.11"), Iface: cpe1, Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.sessions.Reauthorize(testIPv4, &radius.Authorization{VRF: "isp-b"}); !errors.Is(err, radius.ErrInvalidAttribute) {
+		t.Errorf("Reauthorize() of shared interface error = %v, want %v", err, radius.ErrInvalidAttribute)
+	}
+}
+
+// blockingStore holds writes until release is closed
+type blockingStore struct {
+	writing chan string
+	release chan struct{}
+}
+
+func (b *blockingStore) Load() ([]*Session, error) { return nil, nil }
+func (b *blockingStore) Close() error              { return nil }
+
+func (b *blockingStore) Put(ses *Session) error {
+	b.writing <- ses.Key()
+	<-b.release
+	return nil
+}
+
+func (b *blockingStore) Delete(key string) error {
+	return b.Put(&Session{IPv4: net.ParseIP(key)})
+}
+
+func TestSessionsStoreWritesOutsideLock(t *testing.T) {
+	f := newSessionsFixture(t)
+	store := &blockingStore{writing: make(chan string, 1), release: make(chan struct{})}
+	f.sessions.Init(f.client, store)
+	expires := time.Now().Add(time.Hour)
+
+	added := make(chan error)
+	go func() {
+		added <- f.sessions.AddSession(&Session{IPv4: net.ParseIP(testIPv4), Iface: f.swIf("cpe1"), Expires: expires})
+	}()
+	if key := <-store.writing; key != testIPv4 {
+		t.Fatalf("stored session %s, want %s", key, testIPv4)
+	}
+
+	// Sessions are read and other addresses changed while the disk is busy
+	if ses := f.sessions.GetSession(testIPv4); ses == nil || ses.State != SessionActive {
+		t.Errorf("session being stored = %+v, want active", ses)
+	}
+	go func() {
+		added <- f.sessions.AddSession(&Session{IPv4: net.ParseIP("100.64.0.11"), Iface: f.swIf("cpe2"), Expires: expires})
+	}()
+	if key := <-store.writing; key != "100.64.0.11" {
+		t.Errorf("stored session %s, want 100.64.0.11", key)
+	}
+
+	close(store.release)
+	for i := 0; i < 2; i++ {
+		if err := <-added; err != nil {
+			t.Errorf("AddSession() error = %v", err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const defaultCompactEvery = 1000
+
+// SessionStore persists sessions so they survive glubngd restarts
+type SessionStore interface {
+	// Load returns sessions stored, it's called once at boot
+	Load() ([]*Session, error)
+	Put(ses *Session) error
+	Delete(key string) error
+	Close() error
+}
+
+const (
+	journalOpAdd = "add"
+	journalOpDel = "del"
+)
+
+// Key is stored as ipv4, sessions were keyed by IPv4 only before IPv6
+type journalRecord struct {
+	Op      string   `json:"op"`
+	Key     string   `json:"ipv4"`
+	Session *Session `json:"session,omitempty"`
+}
+
+// JournalStore is an append-only journal of session events. Every record is
+// written in its own line prefixed with a CRC32 checksum and synced to disk,
+// so a torn write after a power loss is detected and discarded at load time.
+// Journal is compacted to a snapshot of live sessions after a number of writes.
+type JournalStore struct {
+	path         string
+	compactEvery int
+	file         *os.File
+	live         map[string]*Session
+	records      int
+	size         int64
+	mu           sync.Mutex
+}
+
+func NewJournalStore(path string, compactEvery int) *JournalStore {
+	if compactEvery <= 0 {
+		compactEvery = defaultCompactEvery
+	}
+
+	return &JournalStore{path: path, compactEvery: compactEvery, live: make(map[string]*Session)}
+}
+
+func (j *JournalStore) Load() ([]*Session, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// A pending compaction may be left if process died before renaming it
+	os.Remove(j.path + ".tmp")
+
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := j.replay(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Discard incomplete or corrupted tail
+	if err = f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.file = f
+	j.size = valid
+
+	// Sessions are modified by their owner, journal keeps its own copies
+	sessions := make([]*Session, 0, len(j.live))
+	for _, ses := range j.live {
+		sessions = append(sessions, ses.clone())
+	}
+
+	return sessions, nil
+}
+
+// replay applies every valid record and returns offset after last one
+func (j *JournalStore) replay(f *os.File) (int64, error) {
+	var valid int64
+	r := bufio.NewReader(f)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Discarding incomplete record at the end of %s", j.path)
+			}
+			return valid, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		rec, err := decodeJournalRecord(line)
+		if err != nil {
+			log.Printf("Discarding journal %s from offset %d, %s", j.path, valid, err.Error())
+			return valid, nil
+		}
+
+		j.apply(rec)
+		j.records++
+		valid += int64(len(line))
+	}
+}
+
+func (j *JournalStore) apply(rec *journalRecord) {
+	switch rec.Op {
+	case journalOpAdd:
+		j.live[rec.Key] = rec.Session.clone()
+	case journalOpDel:
+		delete(j.live, rec.Key)
+	}
+}
+
+func (j *JournalStore) Put(ses *Session) error {
+	return j.write(&journalRecord{Op: journalOpAdd, Key: ses.Key(), Session: ses})
+}
+
+func (j *JournalStore) Delete(key string) error {
+	return j.write(&journalRecord{Op: journalOpDel, Key: key})
+}
+
+func (j *JournalStore) write(rec *journalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return errors.New("journal store is not loaded")
+	}
+
+	line, err := encodeJournalRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err = j.file.Write(line); err != nil {
+		// Do not leave a partial record before following ones
+		j.file.Truncate(j.size)
+		j.file.Seek(j.size, io.SeekStart)
+		return err
+	}
+	if err = j.file.Sync(); err != nil {
+		return err
+	}
+	j.size += int64(len(line))
+
+	j.apply(rec)
+	j.records++
+
+	if j.records >= j.compactEvery && j.records > 2*len(j.live) {
+		if err = j.compact(); err != nil {
+			log.Printf("Error compacting journal %s, %s", j.path, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// compact writes live sessions to a temporary file and atomically replaces
+// the journal with it
+func (j *JournalStore) compact() error {
+	tmp := j.path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	w := bufio.NewWriter(f)
+	for key, ses := range j.live {
+		line, err := encodeJournalRecord(&journalRecord{Op: journalOpAdd, Key: key, Session: ses})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(line)
+		size += int64(len(line))
+	}
+
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		f.Close()
+		return err
+	}
+	syncDir(filepath.Dir(j.path))
+
+	// Continue appending to compacted file through the handle it was written
+	// with, so journal is never left without one
+	j.file.Close()
+	j.file = f
+	j.records = len(j.live)
+	j.size = size
+
+	return nil
+}
+
+func (j *JournalStore) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+
+	return err
+}
+
+func encodeJournalRecord(rec *journalRecord) ([]byte, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(body), body)), nil
+}
+
+func decodeJournalRecord(line []byte) (*journalRecord, error) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+
+	sum, body, found := bytes.Cut(line, []byte(" "))
+	if !found {
+		return nil, errors.New("malformed record")
+	}
+
+	var crc uint32
+	if _, err := fmt.Sscanf(string(sum), "%08x", &crc); err != nil {
+		return nil, errors.New("malformed record checksum")
+	}
+	if crc != crc32.ChecksumIEEE(body) {
+		return nil, errors.New("record checksum mismatch")
+	}
+
+	var rec journalRecord
+	if err := json.Unmarshal(body, &rec); err != nil {
+		return nil, err
+	}
+	if rec.Op == journalOpAdd && rec.Session == nil {
+		return nil, errors.New("record without session")
+	}
+
+	return &rec, nil
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,267 @@
+package core
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSession(ipv4 string, iface int) *Session {
+	return &Session{
+		Iface:   iface,
+		IPv4:    net.ParseIP(ipv4).To4(),
+		State:   SessionActive,
+		Expires: time.Unix(1700000000, 0).UTC(),
+		Class:   []byte("class"),
+	}
+}
+
+func openJournal(t *testing.T, path string, compactEvery int) (*JournalStore, map[string]*Session) {
+	t.Helper()
+
+	j := NewJournalStore(path, compactEvery)
+	stored, err := j.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+
+	sessions := make(map[string]*Session)
+	for _, ses := range stored {
+		sessions[ses.Key()] = ses
+	}
+	return j, sessions
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.journal")
+
+	j, _ := openJournal(t, path, 0)
+	for _, ses := range []*Session{testSession("100.64.0.1", 1), testSession("100.64.0.2", 2)} {
+		if err := j.Put(ses); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	moved := testSession("100.64.0.1", 3)
+	if err := j.Put(moved); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := j.Delete("100.64.0.2"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	j.Close()
+
+	_, sessions := openJournal(t, path, 0)
+	if len(sessions) != 1 {
+		t.Fatalf("replayed %d sessions, want 1", len(sessions))
+	}
+	ses := sessions["100.64.0.1"]
+	if ses == nil || ses.Iface != 3 || !ses.Expires.Equal(moved.Expires) || string(ses.Class) != "class" {
+		t.Errorf("replayed session = %+v, want last record of 100.64.0.1", ses)
+	}
+}
+
+func TestJournalCrashReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		{
+			name: "torn tail",
+			corrupt: func(data []byte) []byte {
+				return append(data, []byte(`1234abcd {"op":"add","ipv4":"100.64.0.9","sess`)...)
+			},
+		},
+		{
+			name: "checksum mismatch",
+			corrupt: func(data []byte) []byte {
+				line, _ := encodeJournalRecord(&journalRecord{Op: journalOpAdd, Key: "100.64.0.9", Session: testSession("100.64.0.9", 9)})
+				line[0] ^= 0x01
+				return append(data, line...)
+			},
+		},
+		{
+			name: "malformed line",
+			corrupt: func(data []byte) []byte {
+				return append(data, []byte("garbage\n")...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sessions.journal")
+
+			j, _ := openJournal(t, path, 0)
+			j.Put(testSession("100.64.0.1", 1))
+			j.Put(testSession("100.64.0.2", 2))
+			j.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			valid := len(data)
+			if err := os.WriteFile(path, tt.corrupt(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			j, sessions := openJournal(t, path, 0)
+			if len(sessions) != 2 || sessions["100.64.0.9"] != nil {
+				t.Fatalf("replayed sessions %v, want 100.64.0.1 and 100.64.0.2", sessions)
+			}
+			if info, _ := os.Stat(path); info.Size() != int64(valid) {
+				t.Errorf("journal size = %d, want truncated to %d", info.Size(), valid)
+			}
+
+			// Records after a discarded tail are replayed again
+			if err := j.Put(testSession("100.64.0.3", 3)); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			j.Close()
+			_, sessions = openJournal(t, path, 0)
+			if len(sessions) != 3 || sessions["100.64.0.3"] == nil {
+				t.Errorf("replayed sessions %v, want 3 sessions", sessions)
+			}
+		})
+	}
+}
+
+func TestJournalPendingCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.journal")
+
+	j, _ := openJournal(t, path, 0)
+	j.Put(testSession("100.64.0.1", 1))
+	j.Close()
+
+	// Process died before renaming a compaction
+	if err := os.WriteFile(path+".tmp", []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, sessions := openJournal(t, path, 0)
+	if len(sessions) != 1 {
+		t.Errorf("replayed %d sessions, want 1", len(sessions))
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("pending compaction was not removed, %v", err)
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.journal")
+
+	j, _ := openJournal(t, path, 10)
+	j.Put(testSession("100.64.0.2", 2))
+	for i := 0; i < 25; i++ {
+		ses := testSession("100.64.0.1", 1)
+		ses.Counters.InBytes = uint64(i)
+		if err := j.Put(ses); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	if n := countLines(t, path); n >= 10 {
+		t.Errorf("journal has %d records, want it compacted below 10", n)
+	}
+	j.Close()
+
+	_, sessions := openJournal(t, path, 10)
+	if len(sessions) != 2 {
+		t.Fatalf("replayed %d sessions, want 2", len(sessions))
+	}
+	if got := sessions["100.64.0.1"].Counters.InBytes; got != 24 {
+		t.Errorf("replayed InBytes = %d, want 24", got)
+	}
+}
+
+func TestJournalCompactionFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.journal")
+
+	j, _ := openJournal(t, path, 5)
+	// Compacted journal can not be created
+	if err := os.Mkdir(path+".tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := j.Put(testSession("100.64.0.1", i)); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	if n := countLines(t, path); n != 10 {
+		t.Errorf("journal has %d records, want 10 after failed compactions", n)
+	}
+
+	// Compaction succeeds once it can, writes continue in compacted journal
+	os.Remove(path + ".tmp")
+	for i := 10; i < 20; i++ {
+		if err := j.Put(testSession("100.64.0.1", i)); err != nil {
+			t.Fatalf("Put() after compaction error = %v", err)
+		}
+	}
+	j.Close()
+
+	_, sessions := openJournal(t, path, 5)
+	if ses := sessions["100.64.0.1"]; ses == nil || ses.Iface != 19 {
+		t.Errorf("replayed session = %+v, want SwIf 19", ses)
+	}
+}
+
+func TestJournalStoresCopies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.journal")
+
+	j, _ := openJournal(t, path, 0)
+	ses := testSession("100.64.0.1", 1)
+	if err := j.Put(ses); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// Changes not stored yet must not reach the journal
+	ses.Iface = 7
+	ses.Class[0] = 'X'
+
+	j.mu.Lock()
+	err := j.compact()
+	j.mu.Unlock()
+	if err != nil {
+		t.Fatalf("compact() error = %v", err)
+	}
+	j.Close()
+
+	_, sessions := openJournal(t, path, 0)
+	got := sessions["100.64.0.1"]
+	if got == nil || got.Iface != 1 || string(got.Class) != "class" {
+		t.Errorf("compacted session = %+v, want the one stored by Put", got)
+	}
+}
+
+func TestJournalLoadReturnsCopies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.journal")
+
+	j, _ := openJournal(t, path, 0)
+	j.Put(testSession("100.64.0.1", 1))
+	j.Close()
+
+	j, sessions := openJournal(t, path, 0)
+	sessions["100.64.0.1"].Iface = 7
+
+	j.mu.Lock()
+	live := j.live["100.64.0.1"].Iface
+	j.mu.Unlock()
+	if live != 1 {
+		t.Errorf("journal session changed to SwIf %d by its owner", live)
+	}
+}
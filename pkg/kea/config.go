@@ -0,0 +1,307 @@
+package kea
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+const (
+	defaultHookLibrary = "/usr/lib/kea/hooks/libdhcp_glubng.so"
+	sharedNetworkName  = "glubng"
+)
+
+// Settings of kea-dhcp4 not found in glubng configuration, used to generate
+// its configuration
+type DHCP4Config struct {
+	// glubng hook library, a default path is used when empty
+	HookLibrary string
+	// lease_cmds hook library, only loaded when set
+	LeaseCmdsLibrary string
+	// Unix control socket of Kea, disabled when empty
+	ControlSocket string
+	// memfile lease database, Kea default when empty
+	LeaseFile string
+	// Seconds, Kea default when zero
+	ValidLifetime uint32
+	// Active leases are loaded on startup from BootstrapControlSocket or
+	// BootstrapMemfile, disabled when empty
+	Bootstrap string
+}
+
+// GenerateParams are the sources of a generated kea-dhcp4 configuration
+type GenerateParams struct {
+	// Socket of glubng hook, MiscConfig.SrcKeaSocket
+	Socket string
+	Kea    *DHCP4Config
+	Vpp    *vpp.VPPConfig
+	Ifaces map[string]vpp.Iface
+}
+
+type dhcp4Document struct {
+	Dhcp4 dhcp4 `json:"Dhcp4"`
+}
+
+type dhcp4 struct {
+	InterfacesConfig     interfacesConfig `json:"interfaces-config"`
+	ControlSocket        *controlSocket   `json:"control-socket,omitempty"`
+	LeaseDatabase        leaseDatabase    `json:"lease-database"`
+	ValidLifetime        uint32           `json:"valid-lifetime,omitempty"`
+	StoreExtendedInfo    bool             `json:"store-extended-info,omitempty"`
+	ReservationIDs       []string         `json:"host-reservation-identifiers"`
+	ReservationsGlobal   bool             `json:"reservations-global"`
+	ReservationsInSubnet bool             `json:"reservations-in-subnet"`
+	HooksLibraries       []hookLibrary    `json:"hooks-libraries"`
+	SharedNetworks       []sharedNetwork  `json:"shared-networks"`
+	Reservations         []reservation    `json:"reservations"`
+}
+
+type interfacesConfig struct {
+	Interfaces     []string `json:"interfaces"`
+	DHCPSocketType string   `json:"dhcp-socket-type"`
+}
+
+type controlSocket struct {
+	SocketType string `json:"socket-type"`
+	SocketName string `json:"socket-name"`
+}
+
+type leaseDatabase struct {
+	Type    string `json:"type"`
+	Persist bool   `json:"persist"`
+	Name    string `json:"name,omitempty"`
+}
+
+type hookLibrary struct {
+	Library    string            `json:"library"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+type sharedNetwork struct {
+	Name    string    `json:"name"`
+	Relay   relay     `json:"relay"`
+	Subnets []subnet4 `json:"subnet4"`
+}
+
+type relay struct {
+	IPAddresses []string `json:"ip-addresses"`
+}
+
+type subnet4 struct {
+	ID           int           `json:"id"`
+	Subnet       string        `json:"subnet"`
+	Pools        []pool        `json:"pools"`
+	OptionData   []KeaOption   `json:"option-data"`
+	Reservations []reservation `json:"reservations,omitempty"`
+}
+
+type pool struct {
+	Pool string `json:"pool"`
+}
+
+type reservation struct {
+	FlexID        string      `json:"flex-id"`
+	IPAddress     string      `json:"ip-address,omitempty"`
+	ClientClasses []string    `json:"client-classes,omitempty"`
+	OptionData    []KeaOption `json:"option-data,omitempty"`
+}
+
+// GenerateDHCP4Config renders a kea-dhcp4 configuration matching glubng
+// configuration. Kea listens in the tap of DHCP relay, subnets of IPv4Pool
+// are relayed from GatewayIfaceAddrs and CPE interfaces are reserved by
+// their flex-id.
+func GenerateDHCP4Config(p *GenerateParams) ([]byte, error) {
+	if p.Socket == "" {
+		return nil, errors.New("hook socket is empty")
+	}
+	if len(p.Vpp.IPv4Pool) == 0 {
+		return nil, errors.New("vpp.IPv4Pool is empty")
+	}
+
+	kea := p.Kea
+	if kea == nil {
+		kea = &DHCP4Config{}
+	}
+
+	d := dhcp4{
+		InterfacesConfig:     interfacesConfig{Interfaces: []string{p.Vpp.TapIfaceName}, DHCPSocketType: "udp"},
+		LeaseDatabase:        leaseDatabase{Type: "memfile", Persist: true, Name: kea.LeaseFile},
+		ValidLifetime:        kea.ValidLifetime,
+		StoreExtendedInfo:    kea.Bootstrap != "",
+		ReservationIDs:       []string{"flex-id"},
+		ReservationsGlobal:   true,
+		ReservationsInSubnet: true,
+		Reservations:         []reservation{},
+	}
+	if kea.ControlSocket != "" {
+		d.ControlSocket = &controlSocket{SocketType: "unix", SocketName: kea.ControlSocket}
+	}
+
+	library := kea.HookLibrary
+	if library == "" {
+		library = defaultHookLibrary
+	}
+	d.HooksLibraries = []hookLibrary{{Library: library, Parameters: map[string]string{"socket": p.Socket}}}
+	if kea.LeaseCmdsLibrary != "" {
+		d.HooksLibraries = append(d.HooksLibraries, hookLibrary{Library: kea.LeaseCmdsLibrary})
+	}
+
+	gateways, err := parseGateways(p.Vpp.GatewayIfaceAddrs)
+	if err != nil {
+		return nil, err
+	}
+	network := sharedNetwork{Name: sharedNetworkName, Relay: relay{IPAddresses: []string{}}}
+	for _, gw := range gateways {
+		network.Relay.IPAddresses = append(network.Relay.IPAddresses, gw.String())
+	}
+
+	prefixes := make([]netip.Prefix, len(p.Vpp.IPv4Pool))
+	for i, v := range p.Vpp.IPv4Pool {
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil || !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("vpp.IPv4Pool %q is not an IPv4 prefix", v)
+		}
+		prefixes[i] = prefix.Masked()
+		network.Subnets = append(network.Subnets, newSubnet4(i+1, prefixes[i], gateways))
+	}
+
+	// Interfaces are sorted so output is stable
+	names := make([]string, 0, len(p.Ifaces))
+	for name := range p.Ifaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		v := p.Ifaces[name]
+		dhcp := v.DHCP
+		if profile, ok := p.Vpp.Profiles[v.Profile]; ok {
+			dhcp = dhcp.Merge(&profile.DHCP)
+		}
+
+		r := reservation{
+			FlexID:        quoteFlexID(v.FlexId),
+			ClientClasses: dhcp.ClientClasses,
+			OptionData:    dhcpOptions(&dhcp, false),
+		}
+		if dhcp.FixedAddress == "" {
+			d.Reservations = append(d.Reservations, r)
+			continue
+		}
+
+		r.IPAddress = dhcp.FixedAddress
+		i := subnetOf(prefixes, dhcp.FixedAddress)
+		if i < 0 {
+			return nil, fmt.Errorf("interface %s, FixedAddress %s is not in vpp.IPv4Pool", name, dhcp.FixedAddress)
+		}
+		network.Subnets[i].Reservations = append(network.Subnets[i].Reservations, r)
+	}
+	d.SharedNetworks = []sharedNetwork{network}
+
+	body, err := json.MarshalIndent(&dhcp4Document{Dhcp4: d}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(body, '\n'), nil
+}
+
+func parseGateways(addrs []string) ([]netip.Addr, error) {
+	var gateways []netip.Addr
+	for _, v := range addrs {
+		a, err := netip.ParseAddr(v)
+		if err != nil || !a.Is4() {
+			return nil, fmt.Errorf("vpp.GatewayIfaceAddrs %q is not an IPv4 address", v)
+		}
+		gateways = append(gateways, a)
+	}
+	if len(gateways) == 0 {
+		return nil, errors.New("vpp.GatewayIfaceAddrs is empty")
+	}
+
+	return gateways, nil
+}
+
+// newSubnet4 builds a subnet of a pool prefix, its hosts are leased except
+// gateway addresses. Gateways in the prefix are routers of subscribers, or
+// the first gateway if there are none, as CPE interfaces are unnumbered.
+func newSubnet4(id int, prefix netip.Prefix, gateways []netip.Addr) subnet4 {
+	s := subnet4{ID: id, Subnet: prefix.String(), Pools: []pool{}}
+
+	var routers []string
+	var excluded []netip.Addr
+	for _, gw := range gateways {
+		if prefix.Contains(gw) {
+			routers = append(routers, gw.String())
+			excluded = append(excluded, gw)
+		}
+	}
+	if len(routers) == 0 {
+		routers = []string{gateways[0].String()}
+	}
+	s.OptionData = []KeaOption{{Name: "routers", Code: OPTION4_ROUTERS, Data: strings.Join(routers, ", ")}}
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].Less(excluded[j]) })
+
+	first, last := prefix.Addr(), lastAddr(prefix)
+	// Network and broadcast addresses, /31 and /32 have none
+	if prefix.Bits() < 31 {
+		first, last = first.Next(), last.Prev()
+	}
+
+	// Ranges between gateways
+	start := first
+	for _, gw := range excluded {
+		if gw.Less(start) || last.Less(gw) {
+			continue
+		}
+		if start.Less(gw) {
+			s.Pools = append(s.Pools, pool{Pool: start.String() + "-" + gw.Prev().String()})
+		}
+		if gw == last {
+			return s
+		}
+		start = gw.Next()
+	}
+	s.Pools = append(s.Pools, pool{Pool: start.String() + "-" + last.String()})
+
+	return s
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	a := prefix.Addr().As4()
+	bits := prefix.Bits()
+	for i := 0; i < 4; i++ {
+		keep := bits - i*8
+		switch {
+		case keep <= 0:
+			a[i] = 0xff
+		case keep < 8:
+			a[i] |= byte(0xff >> keep)
+		}
+	}
+
+	return netip.AddrFrom4(a)
+}
+
+func subnetOf(prefixes []netip.Prefix, address string) int {
+	a, err := netip.ParseAddr(address)
+	if err != nil {
+		return -1
+	}
+	for i, p := range prefixes {
+		if p.Contains(a) {
+			return i
+		}
+	}
+	return -1
+}
+
+// quoteFlexID returns a flex-id as a textual identifier of Kea reservations
+func quoteFlexID(flexID string) string {
+	return "'" + flexID + "'"
+}
@@ -1,11 +1,15 @@
 package kea
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"strings"
 
-	"github.com/glutechnologies/glubng/pkg/utils"
+	"github.com/glutechnologies/glubng/pkg/vpp"
 )
 
 const CALLOUT_LEASE4_SELECT = 1
@@ -15,6 +19,41 @@ const CALLOUT_LEASE4_DECLINE = 4
 const CALLOUT_LEASE4_EXPIRE = 5
 const CALLOUT_LEASE4_RECOVER = 6
 const CALLOUT_PKT4_CIRCUIT_ID = 7
+const CALLOUT_LEASE6_SELECT = 8
+const CALLOUT_LEASE6_RENEW = 9
+const CALLOUT_LEASE6_REBIND = 10
+const CALLOUT_LEASE6_RELEASE = 11
+const CALLOUT_LEASE6_EXPIRE = 12
+const CALLOUT_PKT6_INTERFACE_ID = 13
+
+var calloutNames = map[int]string{
+	CALLOUT_LEASE4_SELECT:     "lease4_select",
+	CALLOUT_LEASE4_RENEW:      "lease4_renew",
+	CALLOUT_LEASE4_RELEASE:    "lease4_release",
+	CALLOUT_LEASE4_DECLINE:    "lease4_decline",
+	CALLOUT_LEASE4_EXPIRE:     "lease4_expire",
+	CALLOUT_LEASE4_RECOVER:    "lease4_recover",
+	CALLOUT_PKT4_CIRCUIT_ID:   "pkt4_circuit_id",
+	CALLOUT_LEASE6_SELECT:     "lease6_select",
+	CALLOUT_LEASE6_RENEW:      "lease6_renew",
+	CALLOUT_LEASE6_REBIND:     "lease6_rebind",
+	CALLOUT_LEASE6_RELEASE:    "lease6_release",
+	CALLOUT_LEASE6_EXPIRE:     "lease6_expire",
+	CALLOUT_PKT6_INTERFACE_ID: "pkt6_interface_id",
+}
+
+// CalloutName returns the hook point of a callout, "unknown" if it is not
+// handled by glubngd
+func CalloutName(callout int) string {
+	if name, ok := calloutNames[callout]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Types of lease6
+const LEASE6_TYPE_NA = "IA_NA"
+const LEASE6_TYPE_PD = "IA_PD"
 
 type Envelope struct {
 	Callout int             `json:"callout"`
@@ -30,6 +69,20 @@ type Lease struct {
 	Hostname  string `json:"hostname"`
 	Cltt      int    `json:"cltt"`
 	ValidLft  int    `json:"valid-lft"`
+	// Only in lease6
+	Type      string `json:"type"`
+	PrefixLen int    `json:"prefix-len"`
+	IAID      int    `json:"iaid"`
+	DUID      string `json:"duid"`
+	// Set by host reservations
+	UserContext LeaseContext `json:"user-context"`
+}
+
+// LeaseContext holds user-context keys of a lease used by glubngd
+type LeaseContext struct {
+	// Service profile of the subscriber, profile of its CPE interface is
+	// used when empty
+	ServiceProfile string `json:"service-profile"`
 }
 
 type Query struct {
@@ -41,8 +94,77 @@ type Query struct {
 	HwAddrSource string `json:"hw-addr-source"`
 	Option60     string `json:"option60"`
 	Option82     string `json:"option82"`
-	Option82CID  string `json:"option82-circuit-id"`
-	Option82RID  string `json:"option82-remote-id"`
+	// Relay agent ids, as text when printable or in hex with 0x prefix
+	Option82CID string `json:"option82-circuit-id"`
+	Option82RID string `json:"option82-remote-id"`
+	// Relay options of DHCPv6 queries
+	Option18IID string `json:"option18-interface-id"`
+	Option37RID string `json:"option37-remote-id"`
+}
+
+// UnmarshalJSON decodes a query of the hook, its relay agent ids are
+// normalized as relayID does
+func (q *Query) UnmarshalJSON(data []byte) error {
+	type query Query
+	if err := json.Unmarshal(data, (*query)(q)); err != nil {
+		return err
+	}
+
+	for _, id := range []*string{&q.Option82CID, &q.Option82RID, &q.Option18IID, &q.Option37RID} {
+		*id = normalizeRelayID(*id)
+	}
+
+	return nil
+}
+
+// relayID represents a relay agent id as text when it's printable, like
+// circuit-ids of access switches matched by templates, or in hex with 0x
+// prefix otherwise, like the SwIf inserted by VPP relay
+func relayID(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	for _, c := range data {
+		if c < 0x20 || c > 0x7e {
+			return "0x" + strings.ToUpper(hex.EncodeToString(data))
+		}
+	}
+
+	return string(data)
+}
+
+// normalizeRelayID represents an id sent by the hook as relayID does, the
+// hook may send every id in hex
+func normalizeRelayID(v string) string {
+	if !strings.HasPrefix(v, "0x") && !strings.HasPrefix(v, "0X") {
+		return v
+	}
+	data, err := hex.DecodeString(v[2:])
+	if err != nil {
+		return v
+	}
+
+	return relayID(data)
+}
+
+// CircuitID returns circuit-id of a DHCPv4 query or interface-id of a DHCPv6
+// query, both are set by VPP relay to the SwIf of the CPE interface
+func (q *Query) CircuitID() string {
+	if q.Option82CID != "" {
+		return q.Option82CID
+	}
+
+	return q.Option18IID
+}
+
+// RemoteID returns remote-id of a DHCPv4 or DHCPv6 query, set by relays of
+// access switches
+func (q *Query) RemoteID() string {
+	if q.Option82RID != "" {
+		return q.Option82RID
+	}
+
+	return q.Option37RID
 }
 
 type Subnet struct {
@@ -58,38 +180,193 @@ type KeaResult struct {
 	Lease   Lease
 }
 
+// KeaResponse tells Kea hook how to serve a query of a CPE interface. It's
+// sent for queries with relay agent ids, only with FlexId unless
+// dhcp-settings capability was negotiated. Empty fields are omitted and the
+// hook keeps what Kea would do without them. Pool and FixedAddress are never
+// sent for DHCPv6 queries.
 type KeaResponse struct {
+	// Host identifier of the query, hosts reserved by flex-id match it. It's
+	// empty for unknown interfaces.
 	FlexId string `json:"flex-id"`
+	// Added to classes of the query before subnet selection
+	ClientClasses []string `json:"client-classes,omitempty"`
+	// Subnet the query is served from, instead of the one Kea selects
+	SubnetID uint32 `json:"subnet-id,omitempty"`
+	// Pool of the subnet leases are allocated from, as written in Kea
+	// configuration, an IPv4 prefix or a range first-last
+	Pool string `json:"pool,omitempty"`
+	// IPv4 address leased to the client, as a host reservation
+	FixedAddress string `json:"fixed-address,omitempty"`
+	// Valid lifetime of the lease in seconds
+	ValidLifetime uint32 `json:"valid-lifetime,omitempty"`
+	// Added to the response, replacing options with the same code. Options
+	// are of the DHCP version of the query.
+	Options []KeaOption `json:"options,omitempty"`
 }
 
-func sendResponse(k *KeaSocket, r *KeaResult, conn net.Conn) {
-	// Prepare Kea Result
+// KeaOption is an option added to the response to the client, as in
+// option-data of Kea configuration
+type KeaOption struct {
+	Name string `json:"name"`
+	Code int    `json:"code"`
+	Data string `json:"data"`
+}
 
-	if len(r.Query.Option82CID) > 0 {
-		ifSw, err := utils.ConvertCIDToInt(r.Query.Option82CID)
+// DHCP options set from settings of CPE interfaces
+const (
+	OPTION4_ROUTERS       = 3
+	OPTION4_DNS_SERVERS   = 6
+	OPTION4_DOMAIN_NAME   = 15
+	OPTION6_DNS_SERVERS   = 23
+	OPTION6_DOMAIN_SEARCH = 24
+)
 
-		if err != nil {
-			log.Printf("Error parsing response Kea, %s", err.Error())
-			return
+// isDHCPv6 reports if a callout is sent for a DHCPv6 query
+func isDHCPv6(callout int) bool {
+	switch callout {
+	case CALLOUT_LEASE6_SELECT, CALLOUT_LEASE6_RENEW, CALLOUT_LEASE6_REBIND,
+		CALLOUT_LEASE6_RELEASE, CALLOUT_LEASE6_EXPIRE, CALLOUT_PKT6_INTERFACE_ID:
+		return true
+	}
+	return false
+}
+
+// newKeaResponse builds the response for a CPE interface and its DHCP
+// settings. Settings only valid in DHCPv4 are not sent for DHCPv6 queries.
+func newKeaResponse(iface *vpp.Iface, dhcp *vpp.DHCPSettings, v6 bool) *KeaResponse {
+	resp := &KeaResponse{
+		FlexId:        iface.FlexId,
+		ClientClasses: dhcp.ClientClasses,
+		SubnetID:      dhcp.SubnetID,
+		ValidLifetime: dhcp.LeaseTime,
+		Options:       dhcpOptions(dhcp, v6),
+	}
+	if !v6 {
+		resp.Pool = dhcp.Pool
+		resp.FixedAddress = dhcp.FixedAddress
+	}
+
+	return resp
+}
+
+// dhcpOptions returns options of DNS servers and domain of DHCP settings,
+// only servers of the IP version of the query are sent
+func dhcpOptions(dhcp *vpp.DHCPSettings, v6 bool) []KeaOption {
+	var options []KeaOption
+
+	var dns []string
+	for _, v := range dhcp.DNSServers {
+		if ip := net.ParseIP(v); ip != nil && (ip.To4() == nil) == v6 {
+			dns = append(dns, v)
+		}
+	}
+
+	if v6 {
+		if len(dns) > 0 {
+			options = append(options, KeaOption{Name: "dns-servers", Code: OPTION6_DNS_SERVERS, Data: strings.Join(dns, ", ")})
 		}
+		if dhcp.DomainName != "" {
+			options = append(options, KeaOption{Name: "domain-search", Code: OPTION6_DOMAIN_SEARCH, Data: dhcp.DomainName})
+		}
+		return options
+	}
+
+	if len(dns) > 0 {
+		options = append(options, KeaOption{Name: "domain-name-servers", Code: OPTION4_DNS_SERVERS, Data: strings.Join(dns, ", ")})
+	}
+	if dhcp.DomainName != "" {
+		options = append(options, KeaOption{Name: "domain-name", Code: OPTION4_DOMAIN_NAME, Data: dhcp.DomainName})
+	}
+
+	return options
+}
+
+// errRejected is returned when the subscriber of a query is not authorized
+var errRejected = errors.New("subscriber not authorized")
+
+// response builds the response to a query with relay agent ids, it's nil
+// for other callouts
+func (k *KeaSocket) response(r *KeaResult, caps capabilities) (*KeaResponse, error) {
+	cid, rid := r.Query.CircuitID(), r.Query.RemoteID()
+	if len(cid) == 0 && len(rid) == 0 {
+		return nil, nil
+	}
 
-		resp := &KeaResponse{FlexId: k.ifacesSwIf[int(ifSw)].FlexId}
+	ifSw, err := k.circuits.Resolve(cid, rid)
+	if err != nil {
+		return nil, err
+	}
 
-		e := json.NewEncoder(conn)
-		err = e.Encode(resp)
+	// Unknown interfaces get an empty flex-id
+	iface, ok := k.ifaces.LookupIface(int(ifSw))
+	if !ok {
+		log.Printf("No CPE interface with SwIf %d for Kea response", ifSw)
+	}
 
+	// Subscribers are authorized once per query, before Kea allocates the
+	// lease
+	var auth *Authorization
+	if ok && k.authorizer != nil && r.Callout == CALLOUT_PKT4_CIRCUIT_ID {
+		auth, err = k.authorizer.Authorize(ifSw, &r.Query)
 		if err != nil {
-			log.Printf("Error sending response Kea, %s", err.Error())
-			return
+			return nil, fmt.Errorf("%w, %s", errRejected, err.Error())
+		}
+	}
+
+	if !caps[CapabilityDHCPSettings] {
+		return &KeaResponse{FlexId: iface.FlexId}, nil
+	}
+	dhcp, _ := k.ifaces.IfaceDHCP(int(ifSw))
+	if auth != nil {
+		if auth.Pool != "" {
+			dhcp.Pool = auth.Pool
+		}
+		dhcp.ClientClasses = appendMissing(append([]string(nil), dhcp.ClientClasses...), auth.ClientClasses...)
+	}
+
+	return newKeaResponse(&iface, &dhcp, isDHCPv6(r.Callout)), nil
+}
+
+// appendMissing appends values not already in a list
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		if !contains(list, v) {
+			list = append(list, v)
 		}
 	}
+	return list
+}
+
+func sendResponse(k *KeaSocket, r *KeaResult, conn net.Conn) {
+	// Prepare Kea Result
+	resp, err := k.response(r, legacyCapabilities)
+	if err != nil {
+		log.Printf("Error parsing response Kea, %s", err.Error())
+		return
+	}
+	if resp == nil {
+		return
+	}
+
+	e := json.NewEncoder(conn)
+	err = e.Encode(resp)
+
+	if err != nil {
+		log.Printf("Error sending response Kea, %s", err.Error())
+		return
+	}
 }
 
-func processDataFromConnection(k *KeaSocket, env *Envelope) *KeaResult {
+// processDataFromConnection decodes an envelope and publishes lease callouts,
+// error is the one of publishing
+func processDataFromConnection(k *KeaSocket, env *Envelope) (*KeaResult, error) {
 	var r KeaResult
+	var err error
 	r.Callout = env.Callout
 	switch env.Callout {
-	case CALLOUT_LEASE4_RENEW, CALLOUT_LEASE4_SELECT:
+	case CALLOUT_LEASE4_RENEW, CALLOUT_LEASE4_SELECT,
+		CALLOUT_LEASE6_SELECT, CALLOUT_LEASE6_RENEW, CALLOUT_LEASE6_REBIND:
 		if err := json.Unmarshal(env.Lease, &r.Lease); err != nil {
 			log.Println(err)
 		}
@@ -100,8 +377,8 @@ func processDataFromConnection(k *KeaSocket, env *Envelope) *KeaResult {
 			log.Println(err)
 		}
 		// Send message to other goroutines
-		k.Message <- r
-	case CALLOUT_LEASE4_RELEASE, CALLOUT_LEASE4_DECLINE:
+		err = k.publish(r)
+	case CALLOUT_LEASE4_RELEASE, CALLOUT_LEASE4_DECLINE, CALLOUT_LEASE6_RELEASE:
 		if err := json.Unmarshal(env.Lease, &r.Lease); err != nil {
 			log.Println(err)
 		}
@@ -109,14 +386,14 @@ func processDataFromConnection(k *KeaSocket, env *Envelope) *KeaResult {
 			log.Println(err)
 		}
 		// Send message to other goroutines
-		k.Message <- r
-	case CALLOUT_LEASE4_EXPIRE, CALLOUT_LEASE4_RECOVER:
+		err = k.publish(r)
+	case CALLOUT_LEASE4_EXPIRE, CALLOUT_LEASE4_RECOVER, CALLOUT_LEASE6_EXPIRE:
 		if err := json.Unmarshal(env.Lease, &r.Lease); err != nil {
 			log.Println(err)
 		}
 		// Send message to other goroutines
-		k.Message <- r
-	case CALLOUT_PKT4_CIRCUIT_ID:
+		err = k.publish(r)
+	case CALLOUT_PKT4_CIRCUIT_ID, CALLOUT_PKT6_INTERFACE_ID:
 		if err := json.Unmarshal(env.Query, &r.Query); err != nil {
 			log.Println(err)
 		}
@@ -124,5 +401,5 @@ func processDataFromConnection(k *KeaSocket, env *Envelope) *KeaResult {
 		log.Printf("Process from connection, unknown message type: %q", env.Callout)
 	}
 
-	return &r
+	return &r, err
 }
@@ -0,0 +1,188 @@
+package kea
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/glutechnologies/glubng/pkg/vpp"
+)
+
+type fakeIfaces map[int]vpp.Iface
+
+func (f fakeIfaces) LookupIface(swIf int) (vpp.Iface, bool) {
+	v, ok := f[swIf]
+	return v, ok
+}
+
+func (f fakeIfaces) IfaceDHCP(swIf int) (vpp.DHCPSettings, bool) {
+	v, ok := f[swIf]
+	return v.DHCP, ok
+}
+
+// fakeCircuits resolves circuit-ids naming a SwIf
+type fakeCircuits map[string]int
+
+func (f fakeCircuits) Resolve(circuitID string, remoteID string) (int, error) {
+	swIf, ok := f[circuitID]
+	if !ok {
+		return 0, errors.New("unknown circuit-id " + circuitID)
+	}
+	return swIf, nil
+}
+
+type fakeAuthorizer struct {
+	auth    *Authorization
+	err     error
+	queries []Query
+}
+
+func (a *fakeAuthorizer) Authorize(swIf int, q *Query) (*Authorization, error) {
+	a.queries = append(a.queries, *q)
+	return a.auth, a.err
+}
+
+func newTestSocket(a Authorizer) *KeaSocket {
+	k := &KeaSocket{
+		ifaces: fakeIfaces{3: {SwIf: 3, FlexId: "cpe1", DHCP: vpp.DHCPSettings{
+			ClientClasses: []string{"residential"},
+			Pool:          "100.64.0.0/24",
+			DNSServers:    []string{"192.0.2.53", "2001:db8::53"},
+		}}},
+		circuits: fakeCircuits{"cpe1": 3},
+	}
+	if a != nil {
+		k.SetAuthorizer(a)
+	}
+	return k
+}
+
+func TestResponseAuthorized(t *testing.T) {
+	a := &fakeAuthorizer{auth: &Authorization{Pool: "100.64.1.0/24", ClientClasses: []string{"premium", "residential"}}}
+	k := newTestSocket(a)
+
+	r := &KeaResult{Callout: CALLOUT_PKT4_CIRCUIT_ID, Query: Query{Option82CID: "cpe1", HwAddr: "aa:bb:cc:dd:ee:ff"}}
+	resp, err := k.response(r, legacyCapabilities)
+	if err != nil {
+		t.Fatalf("response() error = %v", err)
+	}
+	if resp.Pool != "100.64.1.0/24" {
+		t.Errorf("pool = %q, want the one of the authorization", resp.Pool)
+	}
+	if !reflect.DeepEqual(resp.ClientClasses, []string{"residential", "premium"}) {
+		t.Errorf("client classes = %v, want residential and premium", resp.ClientClasses)
+	}
+	if len(a.queries) != 1 || a.queries[0].HwAddr != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("authorizer got %+v, want the query", a.queries)
+	}
+
+	// Settings of the interface are not changed
+	if dhcp, _ := k.ifaces.IfaceDHCP(3); !reflect.DeepEqual(dhcp.ClientClasses, []string{"residential"}) {
+		t.Errorf("interface classes = %v, want residential", dhcp.ClientClasses)
+	}
+}
+
+func TestResponseRejected(t *testing.T) {
+	a := &fakeAuthorizer{err: errors.New("access rejected")}
+	k := newTestSocket(a)
+
+	var queue Queue
+	queue.Init(&QueueConfig{})
+	defer queue.Close()
+	k.events = &queue
+
+	req := &Request{ID: 7, Envelope: Envelope{
+		Callout: CALLOUT_PKT4_CIRCUIT_ID,
+		Query:   []byte(`{"option82-circuit-id": "cpe1", "hw-addr": "aa:bb:cc:dd:ee:ff"}`),
+	}}
+	reply := k.serveRequest(req, legacyCapabilities)
+	if reply.ID != 7 || reply.Response != nil || reply.Error == nil || reply.Error.Code != ErrorRejected {
+		t.Errorf("serveRequest() = %+v, want %s error", reply, ErrorRejected)
+	}
+
+	// DHCPv6 queries and lease callouts are not authorized
+	for _, callout := range []int{CALLOUT_PKT6_INTERFACE_ID, CALLOUT_LEASE4_SELECT} {
+		r := &KeaResult{Callout: callout, Query: Query{Option82CID: "cpe1", Option18IID: "cpe1"}}
+		if _, err := k.response(r, legacyCapabilities); err != nil {
+			t.Errorf("response() of callout %d error = %v", callout, err)
+		}
+	}
+	if len(a.queries) != 1 {
+		t.Errorf("authorizer got %d queries, want 1", len(a.queries))
+	}
+}
+
+func TestResponseWithoutAuthorizer(t *testing.T) {
+	k := newTestSocket(nil)
+
+	r := &KeaResult{Callout: CALLOUT_PKT4_CIRCUIT_ID, Query: Query{Option82CID: "cpe1"}}
+	resp, err := k.response(r, legacyCapabilities)
+	if err != nil {
+		t.Fatalf("response() error = %v", err)
+	}
+	if resp.Pool != "100.64.0.0/24" || !reflect.DeepEqual(resp.ClientClasses, []string{"residential"}) {
+		t.Errorf("response() = %+v, want settings of the interface", resp)
+	}
+}
+
+func TestNewKeaResponse(t *testing.T) {
+	iface := &vpp.Iface{FlexId: "cpe1"}
+	dhcp := &vpp.DHCPSettings{
+		ClientClasses: []string{"residential"},
+		SubnetID:      10,
+		Pool:          "100.64.0.0/24",
+		FixedAddress:  "100.64.0.10",
+		DNSServers:    []string{"192.0.2.53", "2001:db8::53", "192.0.2.54", "not-an-address"},
+		DomainName:    "example.net",
+		LeaseTime:     3600,
+	}
+
+	tests := []struct {
+		name string
+		v6   bool
+		want *KeaResponse
+	}{
+		{name: "dhcpv4", want: &KeaResponse{
+			FlexId:        "cpe1",
+			ClientClasses: []string{"residential"},
+			SubnetID:      10,
+			Pool:          "100.64.0.0/24",
+			FixedAddress:  "100.64.0.10",
+			ValidLifetime: 3600,
+			Options: []KeaOption{
+				{Name: "domain-name-servers", Code: OPTION4_DNS_SERVERS, Data: "192.0.2.53, 192.0.2.54"},
+				{Name: "domain-name", Code: OPTION4_DOMAIN_NAME, Data: "example.net"},
+			},
+		}},
+		{name: "dhcpv6", v6: true, want: &KeaResponse{
+			FlexId:        "cpe1",
+			ClientClasses: []string{"residential"},
+			SubnetID:      10,
+			ValidLifetime: 3600,
+			Options: []KeaOption{
+				{Name: "dns-servers", Code: OPTION6_DNS_SERVERS, Data: "2001:db8::53"},
+				{Name: "domain-search", Code: OPTION6_DOMAIN_SEARCH, Data: "example.net"},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newKeaResponse(iface, dhcp, tt.v6); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newKeaResponse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKeaResponseOmitsEmpty(t *testing.T) {
+	resp := newKeaResponse(&vpp.Iface{FlexId: "cpe1"}, &vpp.DHCPSettings{DNSServers: []string{"2001:db8::53"}}, false)
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"flex-id":"cpe1"}` {
+		t.Errorf("response = %s, want flex-id only", b)
+	}
+}
@@ -0,0 +1,267 @@
+package kea
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Lines of context around changes in diffs
+const diffContext = 3
+
+// NormalizeConfig decodes a Kea configuration, which may have comments, and
+// encodes it again indented with sorted keys, so configurations can be
+// compared regardless of formatting
+func NormalizeConfig(body []byte) ([]byte, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(stripComments(body)))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(out, '\n'), nil
+}
+
+// stripComments removes //, # and /* */ comments allowed by Kea outside of
+// strings
+func stripComments(body []byte) []byte {
+	out := make([]byte, 0, len(body))
+	inString := false
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(body) {
+				i++
+				out = append(out, body[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '#' || (c == '/' && i+1 < len(body) && body[i+1] == '/'):
+			for i < len(body) && body[i] != '\n' {
+				i++
+			}
+			if i < len(body) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(body) && body[i+1] == '*':
+			end := bytes.Index(body[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
+
+// DiffConfig compares an existing Kea configuration with a generated one,
+// both are normalized. It returns a unified diff, empty when they are equal.
+func DiffConfig(existingName string, existing []byte, generatedName string, generated []byte) (string, error) {
+	a, err := NormalizeConfig(existing)
+	if err != nil {
+		return "", fmt.Errorf("decoding %s, %w", existingName, err)
+	}
+	b, err := NormalizeConfig(generated)
+	if err != nil {
+		return "", fmt.Errorf("decoding %s, %w", generatedName, err)
+	}
+	if bytes.Equal(a, b) {
+		return "", nil
+	}
+
+	return unifiedDiff(existingName, splitLines(a), generatedName, splitLines(b)), nil
+}
+
+func splitLines(body []byte) []string {
+	return strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+}
+
+// diffOp is a line kept, deleted from a or inserted from b
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns a shortest edit script turning a into b, with the
+// O(ND) algorithm of Myers where D is the number of changed lines. Common
+// prefix and suffix are trimmed first.
+func diffLines(a []string, b []string) []diffOp {
+	var prefix, suffix []diffOp
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffOp{{' ', a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	ops := append(prefix, myers(a, b)...)
+	return append(ops, suffix...)
+}
+
+// myers finds the furthest reaching path of every diagonal k = x - y for
+// each number of edits d, until one reaches the end of a and b. Paths are
+// kept per d, only for diagonals -d..d, to walk the shortest one back.
+func myers(a []string, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// v[max+k] is x of the furthest path on diagonal k
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				// Insertion, down from diagonal k+1
+				x = v[max+k+1]
+			} else {
+				// Deletion, right from diagonal k-1
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[max-d:max+d+1]...))
+	}
+
+	// Walk back from the end, ops are collected in reverse
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			ops = append(ops, diffOp{' ', a[x]})
+		}
+		if prevK == k+1 {
+			ops = append(ops, diffOp{'+', b[prevY]})
+		} else {
+			ops = append(ops, diffOp{'-', a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		ops = append(ops, diffOp{' ', a[x]})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
+
+// unifiedDiff renders changes of an edit script in hunks with context
+func unifiedDiff(aName string, a []string, bName string, b []string) string {
+	ops := diffLines(a, b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+
+	for start := 0; start < len(ops); {
+		// Next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Hunk ends when there are more unchanged lines than both contexts
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				break
+			}
+			end = run
+		}
+
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		// Line numbers of hunk in a and b
+		aLine, bLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+		for _, op := range ops[from:to] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+
+		start = to
+	}
+
+	return out.String()
+}
@@ -0,0 +1,154 @@
+package kea
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// applyOps returns both sides of an edit script
+func applyOps(ops []diffOp) ([]string, []string) {
+	var a, b []string
+	for _, op := range ops {
+		if op.kind != '+' {
+			a = append(a, op.line)
+		}
+		if op.kind != '-' {
+			b = append(b, op.line)
+		}
+	}
+	return a, b
+}
+
+func countEdits(ops []diffOp) int {
+	n := 0
+	for _, op := range ops {
+		if op.kind != ' ' {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{a: "", b: "", edits: 0},
+		{a: "a b c", b: "a b c", edits: 0},
+		{a: "", b: "a b", edits: 2},
+		{a: "a b", b: "", edits: 2},
+		{a: "a b c a b b a", b: "c b a b a c", edits: 5},
+		{a: "x a b c", b: "a b c y", edits: 2},
+		{a: "a b c d e", b: "a x c y e", edits: 4},
+	}
+
+	for _, tt := range tests {
+		a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+		ops := diffLines(a, b)
+		gotA, gotB := applyOps(ops)
+		if strings.Join(gotA, " ") != tt.a || strings.Join(gotB, " ") != tt.b {
+			t.Errorf("diffLines(%q, %q) sides = %q, %q", tt.a, tt.b, gotA, gotB)
+		}
+		if n := countEdits(ops); n != tt.edits {
+			t.Errorf("diffLines(%q, %q) has %d edits, want %d", tt.a, tt.b, n, tt.edits)
+		}
+	}
+}
+
+// lcsLength is the length of a longest common subsequence, by dynamic
+// programming
+func lcsLength(a []string, b []string) int {
+	l := make([][]int, len(a)+1)
+	for i := range l {
+		l[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				l[i][j] = l[i+1][j+1] + 1
+			case l[i+1][j] > l[i][j+1]:
+				l[i][j] = l[i+1][j]
+			default:
+				l[i][j] = l[i][j+1]
+			}
+		}
+	}
+	return l[0][0]
+}
+
+func TestDiffLinesRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := func() []string {
+		w := make([]string, r.Intn(30))
+		for i := range w {
+			w[i] = strconv.Itoa(r.Intn(4))
+		}
+		return w
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := words(), words()
+		ops := diffLines(a, b)
+		gotA, gotB := applyOps(ops)
+		if strings.Join(gotA, " ") != strings.Join(a, " ") || strings.Join(gotB, " ") != strings.Join(b, " ") {
+			t.Fatalf("diffLines(%q, %q) sides = %q, %q", a, b, gotA, gotB)
+		}
+		if n, want := countEdits(ops), len(a)+len(b)-2*lcsLength(a, b); n != want {
+			t.Fatalf("diffLines(%q, %q) has %d edits, want %d", a, b, n, want)
+		}
+	}
+}
+
+// Big configurations with scattered changes are compared without memory
+// quadratic in their size
+func TestDiffLinesLarge(t *testing.T) {
+	const n = 200000
+	a := make([]string, n)
+	for i := range a {
+		a[i] = "line " + strconv.Itoa(i)
+	}
+	b := append([]string(nil), a...)
+	b[10] = "changed"
+	b[n/2] = "changed"
+	b = append(b[:n-10], append([]string{"inserted"}, b[n-10:]...)...)
+
+	start := time.Now()
+	ops := diffLines(a, b)
+	if n := countEdits(ops); n != 5 {
+		t.Errorf("diffLines() has %d edits, want 5", n)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("diffLines() took %s", d)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := strings.Fields("1 2 3 4 5 6 7 8 9 10 11 12")
+	b := strings.Fields("1 2 3 4 x 6 7 8 9 10 11 12 13")
+
+	want := `--- a
++++ b
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++x
+ 6
+ 7
+ 8
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`
+	if got := unifiedDiff("a", a, "b", b); got != want {
+		t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+}
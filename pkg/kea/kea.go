@@ -2,51 +2,160 @@ package kea
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/glutechnologies/glubng/pkg/vpp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+const acceptRetryInterval = 100 * time.Millisecond
+
+var (
+	metricCallouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "glubng_kea_callouts_total",
+		Help: "Messages received from Kea hook by callout.",
+	}, []string{"callout"})
+	metricDecodeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "glubng_kea_decode_errors_total",
+		Help: "Messages from Kea hook which could not be decoded.",
+	})
+	metricTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "glubng_kea_timeouts_total",
+		Help: "Connections from Kea hook closed without a message in time.",
+	})
+	metricErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "glubng_kea_error_replies_total",
+		Help: "Error replies sent to Kea hook by code.",
+	}, []string{"code"})
+)
+
+// SocketError is returned when the socket for Kea hook can not be set up
+type SocketError struct {
+	Filename string
+	Op       string
+	Err      error
+}
+
+func (e *SocketError) Error() string {
+	return "kea socket " + e.Filename + ", " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *SocketError) Unwrap() error {
+	return e.Err
+}
+
+// IfaceLookup resolves CPE interfaces and their DHCP settings by SwIf, they
+// may change while the socket is running
+type IfaceLookup interface {
+	LookupIface(swIf int) (vpp.Iface, bool)
+	IfaceDHCP(swIf int) (vpp.DHCPSettings, bool)
+}
+
+// CircuitMapper resolves SwIf of the CPE interface of a query from its relay
+// agent ids
+type CircuitMapper interface {
+	Resolve(circuitID string, remoteID string) (int, error)
+}
+
+// Authorizer authorizes the subscriber of a DHCPv4 query of a CPE interface
+// before Kea allocates its lease. Subscribers are rejected with an error, a
+// nil Authorization allows them without changes.
+type Authorizer interface {
+	Authorize(swIf int, q *Query) (*Authorization, error)
+}
+
+// Authorization changes how Kea serves an authorized subscriber
+type Authorization struct {
+	// Pool leases are allocated from, the one of the interface when empty
+	Pool string
+	// Added to client classes of the interface
+	ClientClasses []string
+}
+
 type KeaSocket struct {
 	Filename   string
 	Listener   net.Listener
-	Message    chan KeaResult
+	authorizer Authorizer
+	events     *Queue
 	stop       chan bool
-	wg         sync.WaitGroup
-	ifacesSwIf map[int]vpp.Iface
+	// Set while accept loop is running
+	listening atomic.Bool
+	wg        sync.WaitGroup
+	ifaces    IfaceLookup
+	circuits  CircuitMapper
+	// Open connections, persistent ones are closed by Close
+	conns map[net.Conn]struct{}
+	mu    sync.Mutex
 }
 
 func (k *KeaSocket) handleConection(conn net.Conn) {
 	defer conn.Close()
+	if !k.track(conn) {
+		return
+	}
+	defer k.untrack(conn)
 
-	conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	conn.SetDeadline(time.Now().Add(legacyTimeout))
 	d := json.NewDecoder(conn)
-	var env Envelope
-	err := d.Decode(&env)
+	var msg firstMessage
+	err := d.Decode(&msg)
 	if err != nil {
 		if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+			metricTimeouts.Inc()
 			return
 		} else if err != io.EOF {
+			metricDecodeErrors.Inc()
 			log.Println("read error", err)
 			return
 		}
 	}
-	// Process data from Kea
-	res := processDataFromConnection(k, &env)
 
-	// Send response
-	sendResponse(k, res, conn)
+	// Hooks with a version keep the connection open
+	if msg.Version != nil {
+		msg.Hello.Version = *msg.Version
+		conn.SetDeadline(time.Time{})
+		k.servePersistent(conn, d, &msg.Hello)
+		return
+	}
+	k.serveLegacy(conn, &msg.Envelope)
+}
+
+// track adds a connection to the open ones, it reports false when socket is
+// being closed
+func (k *KeaSocket) track(conn net.Conn) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	select {
+	case <-k.stop:
+		return false
+	default:
+	}
+	k.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (k *KeaSocket) untrack(conn net.Conn) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	delete(k.conns, conn)
 }
 
 func (k *KeaSocket) runUnixSocketServer() {
 	// https://eli.thegreenplace.net/2020/graceful-shutdown-of-a-tcp-server-in-go/
 	defer k.wg.Done()
+	defer k.listening.Store(false)
 
 	for {
 		// Accept new connections, dispatching them to echoServer
@@ -60,7 +169,13 @@ func (k *KeaSocket) runUnixSocketServer() {
 				return
 			default:
 			}
-			log.Fatal("accept error:", err)
+			if errors.Is(err, net.ErrClosed) {
+				log.Println("Kea socket closed unexpectedly")
+				return
+			}
+			// Temporary errors like reaching file descriptors limit
+			log.Println("accept error:", err)
+			time.Sleep(acceptRetryInterval)
 		} else {
 			k.wg.Add(1)
 			go func() {
@@ -71,30 +186,66 @@ func (k *KeaSocket) runUnixSocketServer() {
 	}
 }
 
-func (k *KeaSocket) Init(filename string, ifaces map[int]vpp.Iface) {
+func (k *KeaSocket) Init(filename string, events *Queue, ifaces IfaceLookup, circuits CircuitMapper) error {
 	k.Filename = filename
-	k.stop = make(chan bool)
-	k.Message = make(chan KeaResult)
-	k.ifacesSwIf = ifaces
+	k.events = events
+	k.ifaces = ifaces
+	k.circuits = circuits
+	k.conns = make(map[net.Conn]struct{})
 
 	if err := os.RemoveAll(filename); err != nil {
-		log.Fatal(err)
+		return &SocketError{Filename: filename, Op: "remove", Err: err}
 	}
 
 	var err error
 	k.Listener, err = net.Listen("unix", filename)
 
 	if err != nil {
-		log.Fatal("listen error:", err)
+		return &SocketError{Filename: filename, Op: "listen", Err: err}
 	}
+	k.stop = make(chan bool)
+	k.listening.Store(true)
 
 	// Add one level to WaitGroup
 	k.wg.Add(1)
 	go k.runUnixSocketServer()
+
+	return nil
+}
+
+// SetAuthorizer makes queries be authorized before responding to them, it
+// must be called before Init
+func (k *KeaSocket) SetAuthorizer(a Authorizer) {
+	k.authorizer = a
+}
+
+// publish queues a result to be processed, it fails when queue is full and
+// its overflow policy drops events
+func (k *KeaSocket) publish(r KeaResult) error {
+	return k.events.Push(r)
+}
+
+// IsListening reports if socket is accepting connections from Kea, its accept
+// loop is running and its file was not removed
+func (k *KeaSocket) IsListening() bool {
+	if !k.listening.Load() {
+		return false
+	}
+
+	info, err := os.Lstat(k.Filename)
+	return err == nil && info.Mode()&os.ModeSocket != 0
 }
 
 func (k *KeaSocket) Close() {
+	if k.stop == nil {
+		return
+	}
+	k.mu.Lock()
 	close(k.stop)
+	for conn := range k.conns {
+		conn.Close()
+	}
+	k.mu.Unlock()
 	k.Listener.Close()
 	k.wg.Wait()
 }
@@ -0,0 +1,39 @@
+package kea
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsListening(t *testing.T) {
+	var queue Queue
+	queue.Init(&QueueConfig{})
+	defer queue.Close()
+
+	var k KeaSocket
+	if k.IsListening() {
+		t.Error("socket not initialized is listening")
+	}
+
+	name := filepath.Join(t.TempDir(), "kea.sock")
+	if err := k.Init(name, &queue, nil, nil); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if !k.IsListening() {
+		t.Error("socket is not listening after Init")
+	}
+
+	// Kea can not connect once socket file is gone
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if k.IsListening() {
+		t.Error("socket without file is listening")
+	}
+
+	k.Close()
+	if k.IsListening() {
+		t.Error("socket is listening after Close")
+	}
+}
@@ -0,0 +1,340 @@
+package kea
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources of active leases loaded on startup
+const (
+	// lease4-get-all command of lease_cmds hook over ControlSocket
+	BootstrapControlSocket = "control-socket"
+	// Memfile lease database of kea-dhcp4, LeaseFile
+	BootstrapMemfile = "memfile"
+)
+
+const (
+	defaultLeaseFile      = "/var/lib/kea/kea-leases4.csv"
+	controlSocketTimeout  = 10 * time.Second
+	controlResultSuccess  = 0
+	controlResultNotFound = 3
+)
+
+// States of leases in Kea
+const (
+	LEASE_STATE_DEFAULT           = 0
+	LEASE_STATE_DECLINED          = 1
+	LEASE_STATE_EXPIRED_RECLAIMED = 2
+)
+
+var leaseStateNames = map[int]string{
+	LEASE_STATE_DEFAULT:           "default",
+	LEASE_STATE_DECLINED:          "declined",
+	LEASE_STATE_EXPIRED_RECLAIMED: "expired-reclaimed",
+}
+
+// Relay agent sub-options stored by Kea in leases
+const (
+	relaySubOptionCircuitID = 1
+	relaySubOptionRemoteID  = 2
+)
+
+// Validate checks the lease source of bootstrap
+func (c *DHCP4Config) Validate() error {
+	switch c.Bootstrap {
+	case "", BootstrapMemfile:
+	case BootstrapControlSocket:
+		if c.ControlSocket == "" {
+			return errors.New("kea.ControlSocket is empty, it is needed by kea.Bootstrap")
+		}
+	default:
+		return fmt.Errorf("kea.Bootstrap %q is not %s or %s", c.Bootstrap, BootstrapControlSocket, BootstrapMemfile)
+	}
+
+	return nil
+}
+
+// LoadLeases4 returns leases of Kea from the source of bootstrap, nil when
+// bootstrap is disabled
+func (c *DHCP4Config) LoadLeases4() ([]Lease4, error) {
+	switch c.Bootstrap {
+	case BootstrapControlSocket:
+		return GetLeases4(c.ControlSocket)
+	case BootstrapMemfile:
+		name := c.LeaseFile
+		if name == "" {
+			name = defaultLeaseFile
+		}
+		return ReadLeases4File(name)
+	}
+
+	return nil, nil
+}
+
+// Lease4 is a lease as returned by lease4-get-all
+type Lease4 struct {
+	IPAddress   string          `json:"ip-address"`
+	HwAddress   string          `json:"hw-address"`
+	ClientID    string          `json:"client-id,omitempty"`
+	Cltt        int             `json:"cltt"`
+	ValidLft    int             `json:"valid-lft"`
+	SubnetID    uint32          `json:"subnet-id"`
+	State       int             `json:"state"`
+	Hostname    string          `json:"hostname"`
+	UserContext json.RawMessage `json:"user-context,omitempty"`
+}
+
+// Expires returns when the lease expires
+func (l *Lease4) Expires() time.Time {
+	return time.Unix(int64(l.Cltt+l.ValidLft), 0)
+}
+
+// IsActive reports if the lease is assigned to a subscriber at a time
+func (l *Lease4) IsActive(now time.Time) bool {
+	return l.State == LEASE_STATE_DEFAULT && l.ValidLft > 0 && now.Before(l.Expires())
+}
+
+// Result returns the lease as if it was selected by Kea hook. Relay agent
+// ids are only known when Kea stores them in the lease, with
+// store-extended-info enabled.
+func (l *Lease4) Result(now time.Time) KeaResult {
+	r := KeaResult{Callout: CALLOUT_LEASE4_SELECT}
+	r.Lease = Lease{
+		State:     leaseStateNames[l.State],
+		IsExpired: !now.Before(l.Expires()),
+		Address:   l.IPAddress,
+		Hostname:  l.Hostname,
+		Cltt:      l.Cltt,
+		ValidLft:  l.ValidLft,
+	}
+	r.Query.HwAddr = l.HwAddress
+
+	if len(l.UserContext) > 0 {
+		var ctx struct {
+			LeaseContext
+			ISC struct {
+				RelayAgentInfo json.RawMessage `json:"relay-agent-info"`
+			} `json:"ISC"`
+		}
+		if err := json.Unmarshal(l.UserContext, &ctx); err == nil {
+			r.Lease.UserContext = ctx.LeaseContext
+			r.Query.Option82CID, r.Query.Option82RID = relayAgentIDs(ctx.ISC.RelayAgentInfo)
+		}
+	}
+
+	return r
+}
+
+// relayAgentIDs returns circuit-id and remote-id of relay agent info stored
+// by Kea, represented as in queries of the hook. Kea stores sub-options of
+// option 82 as a hex string, or since 2.4 in sub-options of an object.
+func relayAgentIDs(info json.RawMessage) (string, string) {
+	if len(info) == 0 {
+		return "", ""
+	}
+
+	var subOptions string
+	if err := json.Unmarshal(info, &subOptions); err != nil {
+		var v struct {
+			SubOptions string `json:"sub-options"`
+		}
+		if err := json.Unmarshal(info, &v); err != nil {
+			return "", ""
+		}
+		subOptions = v.SubOptions
+	}
+
+	data, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(subOptions, "0x"), "0X"))
+	if err != nil {
+		return "", ""
+	}
+
+	var cid, rid string
+	for len(data) >= 2 && len(data) >= 2+int(data[1]) {
+		value := relayID(data[2 : 2+data[1]])
+		switch data[0] {
+		case relaySubOptionCircuitID:
+			cid = value
+		case relaySubOptionRemoteID:
+			rid = value
+		}
+		data = data[2+data[1]:]
+	}
+
+	return cid, rid
+}
+
+type controlCommand struct {
+	Command string `json:"command"`
+}
+
+type controlResponse struct {
+	Result    int             `json:"result"`
+	Text      string          `json:"text"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// GetLeases4 returns leases of kea-dhcp4 with lease4-get-all command over its
+// unix control socket, lease_cmds hook must be loaded
+func GetLeases4(socket string) ([]Lease4, error) {
+	conn, err := net.DialTimeout("unix", socket, controlSocketTimeout)
+	if err != nil {
+		return nil, &SocketError{Filename: socket, Op: "dial", Err: err}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlSocketTimeout))
+
+	if err := json.NewEncoder(conn).Encode(&controlCommand{Command: "lease4-get-all"}); err != nil {
+		return nil, &SocketError{Filename: socket, Op: "write", Err: err}
+	}
+
+	body, err := io.ReadAll(conn)
+	if err != nil {
+		return nil, &SocketError{Filename: socket, Op: "read", Err: err}
+	}
+
+	// Control agent wraps responses of each server in a list
+	var resp controlResponse
+	var list []controlResponse
+	if err := json.Unmarshal(body, &list); err == nil && len(list) > 0 {
+		resp = list[0]
+	} else if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("decoding lease4-get-all response, %w", err)
+	}
+
+	switch resp.Result {
+	case controlResultSuccess:
+	case controlResultNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("lease4-get-all failed, %s", resp.Text)
+	}
+
+	var args struct {
+		Leases []Lease4 `json:"leases"`
+	}
+	if err := json.Unmarshal(resp.Arguments, &args); err != nil {
+		return nil, fmt.Errorf("decoding lease4-get-all leases, %w", err)
+	}
+
+	return args.Leases, nil
+}
+
+// ReadLeases4File returns leases of a memfile lease database. Kea appends
+// every change of a lease, so the last line of an address wins and leases
+// with no valid lifetime are deleted. Files of lease file cleanup are read
+// first when they exist, the previous one with suffix .2 and then the one
+// being cleaned up with suffix .1.
+func ReadLeases4File(name string) ([]Lease4, error) {
+	leases := make(map[string]Lease4)
+	var order []string
+
+	for _, filename := range []string{name + ".2", name + ".1", name} {
+		f, err := os.Open(filename)
+		if errors.Is(err, os.ErrNotExist) && filename != name {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		err = readLeases4CSV(f, func(l Lease4) {
+			if _, ok := leases[l.IPAddress]; !ok {
+				order = append(order, l.IPAddress)
+			}
+			leases[l.IPAddress] = l
+		})
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s, %w", filename, err)
+		}
+	}
+
+	list := make([]Lease4, 0, len(order))
+	for _, address := range order {
+		if l := leases[address]; l.ValidLft > 0 {
+			list = append(list, l)
+		}
+	}
+
+	return list, nil
+}
+
+// readLeases4CSV decodes leases of a memfile, columns are found by the
+// header as they change between Kea versions
+func readLeases4CSV(r io.Reader, fn func(l Lease4)) error {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	// Quotes of user context are not escaped
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"address", "valid_lifetime", "expire"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("column %s not found", name)
+		}
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+
+		validLft, err1 := strconv.Atoi(field("valid_lifetime"))
+		expire, err2 := strconv.Atoi(field("expire"))
+		if err1 != nil || err2 != nil {
+			line, _ := cr.FieldPos(0)
+			return fmt.Errorf("line %d, malformed lifetime", line)
+		}
+		subnetID, _ := strconv.ParseUint(field("subnet_id"), 10, 32)
+		state, _ := strconv.Atoi(field("state"))
+
+		l := Lease4{
+			IPAddress: field("address"),
+			HwAddress: field("hwaddr"),
+			ClientID:  field("client_id"),
+			Cltt:      expire - validLft,
+			ValidLft:  validLft,
+			SubnetID:  uint32(subnetID),
+			State:     state,
+			Hostname:  unescapeCSV(field("hostname")),
+		}
+		if ctx := unescapeCSV(field("user_context")); ctx != "" {
+			l.UserContext = json.RawMessage(ctx)
+		}
+		fn(l)
+	}
+}
+
+// unescapeCSV reverts escaping of commas in text columns of memfile
+func unescapeCSV(v string) string {
+	return strings.ReplaceAll(v, "&#x2c", ",")
+}
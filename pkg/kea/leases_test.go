@@ -0,0 +1,142 @@
+package kea
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestControlSocket(t *testing.T) *MockControlSocket {
+	t.Helper()
+
+	m, err := NewMockControlSocket(filepath.Join(t.TempDir(), "kea4-ctrl-socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func TestGetLeases4(t *testing.T) {
+	m := newTestControlSocket(t)
+	want := []Lease4{
+		{IPAddress: "100.64.0.10", HwAddress: "aa:bb:cc:dd:ee:ff", Cltt: 1700000000, ValidLft: 3600, SubnetID: 1},
+		{IPAddress: "100.64.0.11", HwAddress: "aa:bb:cc:dd:ee:00", Cltt: 1700000000, ValidLft: 3600, SubnetID: 1, State: LEASE_STATE_DECLINED},
+	}
+	m.SetLeases(want)
+
+	leases, err := GetLeases4(m.Filename)
+	if err != nil {
+		t.Fatalf("GetLeases4() error = %v", err)
+	}
+	if !reflect.DeepEqual(leases, want) {
+		t.Errorf("GetLeases4() = %+v, want %+v", leases, want)
+	}
+	if cmds := m.Commands(); !reflect.DeepEqual(cmds, []string{"lease4-get-all"}) {
+		t.Errorf("commands = %v, want lease4-get-all", cmds)
+	}
+
+	// No leases is not an error
+	m.SetLeases(nil)
+	if leases, err := GetLeases4(m.Filename); err != nil || len(leases) != 0 {
+		t.Errorf("GetLeases4() of no leases = %v, %v, want none", leases, err)
+	}
+
+	m.SetResult(1)
+	if _, err := GetLeases4(m.Filename); err == nil {
+		t.Error("GetLeases4() of failed command succeeded")
+	}
+
+	if _, err := GetLeases4(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("GetLeases4() of missing socket succeeded")
+	}
+}
+
+const leasesHeader = "address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context\n"
+
+func writeLeases(t *testing.T, name string, lines ...string) {
+	t.Helper()
+
+	if err := os.WriteFile(name, []byte(leasesHeader+strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadLeases4File(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "kea-leases4.csv")
+	writeLeases(t, name+".2",
+		"100.64.0.10,aa:bb:cc:dd:ee:01,,3600,1700003600,1,0,0,old,0,",
+		"100.64.0.11,aa:bb:cc:dd:ee:02,,3600,1700003600,1,0,0,,0,",
+		"100.64.0.12,aa:bb:cc:dd:ee:03,,3600,1700003600,1,0,0,,0,")
+	writeLeases(t, name+".1",
+		"100.64.0.10,aa:bb:cc:dd:ee:01,,3600,1700007200,1,0,0,cleanup,0,",
+		// Deleted
+		"100.64.0.12,aa:bb:cc:dd:ee:03,,0,1700003600,1,0,0,,0,")
+	writeLeases(t, name,
+		"100.64.0.11,aa:bb:cc:dd:ee:02,,7200,1700010800,1,0,0,host&#x2c name,0,",
+		`100.64.0.13,aa:bb:cc:dd:ee:04,,3600,1700003600,1,0,0,,0,{ "ISC": { "relay-agent-info": "0x010565746830310204000000AB" } }`)
+
+	leases, err := ReadLeases4File(name)
+	if err != nil {
+		t.Fatalf("ReadLeases4File() error = %v", err)
+	}
+
+	want := []Lease4{
+		{IPAddress: "100.64.0.10", HwAddress: "aa:bb:cc:dd:ee:01", Cltt: 1700003600, ValidLft: 3600, SubnetID: 1, Hostname: "cleanup"},
+		{IPAddress: "100.64.0.11", HwAddress: "aa:bb:cc:dd:ee:02", Cltt: 1700003600, ValidLft: 7200, SubnetID: 1, Hostname: "host, name"},
+		{IPAddress: "100.64.0.13", HwAddress: "aa:bb:cc:dd:ee:04", Cltt: 1700000000, ValidLft: 3600, SubnetID: 1,
+			UserContext: json.RawMessage(`{ "ISC": { "relay-agent-info": "0x010565746830310204000000AB" } }`)},
+	}
+	if !reflect.DeepEqual(leases, want) {
+		t.Errorf("ReadLeases4File() = %+v, want %+v", leases, want)
+	}
+
+	r := leases[2].Result(time.Unix(1700000000, 0))
+	if r.Query.Option82CID != "eth01" || r.Query.Option82RID != "0x000000AB" {
+		t.Errorf("relay agent ids = %q, %q, want eth01 and 0x000000AB", r.Query.Option82CID, r.Query.Option82RID)
+	}
+
+	if _, err := ReadLeases4File(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("ReadLeases4File() of missing file succeeded")
+	}
+}
+
+func TestRelayAgentIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     string
+		cid, rid string
+	}{
+		{name: "vpp relay", info: `"0x010400000003"`, cid: "0x00000003"},
+		{name: "access switch", info: `"0x010C657468302F312F333A313030020469643031"`, cid: "eth0/1/3:100", rid: "id01"},
+		{name: "kea 2.4 object", info: `{"sub-options": "0x010400000003"}`, cid: "0x00000003"},
+		{name: "malformed", info: `"0xZZ"`},
+		{name: "empty", info: ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cid, rid := relayAgentIDs(json.RawMessage(tt.info))
+			if cid != tt.cid || rid != tt.rid {
+				t.Errorf("relayAgentIDs() = %q, %q, want %q, %q", cid, rid, tt.cid, tt.rid)
+			}
+		})
+	}
+}
+
+// Ids of queries and of leases are represented the same way
+func TestQueryRelayIDs(t *testing.T) {
+	var q Query
+	body := `{"option82-circuit-id": "0x657468302F312F333A313030", "option82-remote-id": "0x0000000a", "option18-interface-id": "eth0/1/3:100"}`
+	if err := json.Unmarshal([]byte(body), &q); err != nil {
+		t.Fatal(err)
+	}
+	if q.Option82CID != "eth0/1/3:100" || q.Option82RID != "0x0000000A" || q.Option18IID != "eth0/1/3:100" {
+		t.Errorf("query = %+v, want text circuit-id and hex remote-id", q)
+	}
+}
@@ -0,0 +1,110 @@
+package kea
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// MockControlSocket is an in-process control socket of kea-dhcp4. It answers
+// lease4-get-all with its leases and records commands received.
+type MockControlSocket struct {
+	Filename string
+	leases   []Lease4
+	commands []string
+	// Result returned instead of leases when not zero
+	result   int
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+// NewMockControlSocket starts listening on a unix socket
+func NewMockControlSocket(filename string) (*MockControlSocket, error) {
+	if err := os.RemoveAll(filename); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MockControlSocket{Filename: filename, listener: l}
+	m.wg.Add(1)
+	go m.serve()
+
+	return m, nil
+}
+
+// SetLeases replaces leases returned by lease4-get-all
+func (m *MockControlSocket) SetLeases(leases []Lease4) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.leases = append([]Lease4(nil), leases...)
+}
+
+// SetResult makes commands fail with a result code, 0 restores success
+func (m *MockControlSocket) SetResult(result int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result = result
+}
+
+// Commands returns commands received, in order
+func (m *MockControlSocket) Commands() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.commands...)
+}
+
+func (m *MockControlSocket) Close() {
+	m.listener.Close()
+	m.wg.Wait()
+}
+
+func (m *MockControlSocket) serve() {
+	defer m.wg.Done()
+
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		m.handle(conn)
+	}
+}
+
+// handle answers one command per connection, as Kea does
+func (m *MockControlSocket) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	var cmd controlCommand
+	if err := json.NewDecoder(conn).Decode(&cmd); err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.commands = append(m.commands, cmd.Command)
+	resp := controlResponse{Result: m.result}
+	switch {
+	case m.result != controlResultSuccess:
+		resp.Text = "mock failure"
+	case cmd.Command != "lease4-get-all":
+		resp.Result, resp.Text = 2, "'"+cmd.Command+"' command not supported."
+	case len(m.leases) == 0:
+		resp.Result, resp.Text = controlResultNotFound, "0 IPv4 lease(s) found."
+	default:
+		resp.Arguments, _ = json.Marshal(map[string][]Lease4{"leases": m.leases})
+		resp.Text = "IPv4 lease(s) found."
+	}
+	m.mu.Unlock()
+
+	json.NewEncoder(conn).Encode(&resp)
+}
@@ -0,0 +1,253 @@
+package kea
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// ProtocolVersion is the highest version of the protocol of Kea hook socket
+// supported.
+//
+// Legacy hooks send one Envelope per connection and get a KeaResponse back
+// when the query has relay agent ids, then the connection is closed.
+//
+// Since version 1 the hook opens the connection with a Hello and keeps it
+// open. Messages are JSON objects, one per line in both directions:
+//
+//	-> {"version": 1, "capabilities": ["dhcpv6", "dhcp-settings"]}
+//	<- {"version": 1, "capabilities": ["dhcpv6", "dhcp-settings"]}
+//	-> {"id": 1, "callout": 1, "lease": {...}, "subnet": {...}, "query": {...}}
+//	<- {"id": 1, "response": {"flex-id": "cpe1"}}
+//	-> {"id": 2, "callout": 3, "lease": {...}, "query": {...}}
+//	<- {"id": 2}
+//	-> {"id": 3, "callout": 99}
+//	<- {"id": 3, "error": {"code": "unknown-callout", "message": "..."}}
+//
+// Server answers with the highest version it supports not above the one of
+// the hook, and with the capabilities supported by both. Every request gets
+// one reply with its id, replies are sent in order of requests. The protocol
+// is specified in docs/kea-hook-protocol.md.
+const ProtocolVersion = 1
+
+// Capabilities negotiated in Hello
+const (
+	// Hook sends DHCPv6 callouts, they are refused otherwise
+	CapabilityDHCPv6 = "dhcpv6"
+	// Responses have client classes, pool, reservation and options of CPE
+	// interfaces besides flex-id
+	CapabilityDHCPSettings = "dhcp-settings"
+)
+
+var serverCapabilities = []string{CapabilityDHCPv6, CapabilityDHCPSettings}
+
+// Codes of error replies
+const (
+	ErrorMalformed          = "malformed"
+	ErrorUnsupportedVersion = "unsupported-version"
+	ErrorUnknownCallout     = "unknown-callout"
+	ErrorNotNegotiated      = "not-negotiated"
+	ErrorNoInterface        = "no-interface"
+	ErrorOverloaded         = "overloaded"
+	// Subscriber of a query was not authorized, Kea must drop the query
+	ErrorRejected = "rejected"
+)
+
+const (
+	// Legacy hooks send their envelope right after connecting
+	legacyTimeout = 200 * time.Millisecond
+	// Persistent connections are closed when idle for longer
+	idleTimeout  = 5 * time.Minute
+	writeTimeout = time.Second
+)
+
+// Hello opens a persistent connection and negotiates version and
+// capabilities, the server replies with a Hello too
+type Hello struct {
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Request is an envelope of a persistent connection
+type Request struct {
+	ID uint64 `json:"id"`
+	Envelope
+}
+
+// Reply answers a Request, Response is only set for queries with relay
+// agent ids
+type Reply struct {
+	ID       uint64       `json:"id"`
+	Response *KeaResponse `json:"response,omitempty"`
+	Error    *ReplyError  `json:"error,omitempty"`
+}
+
+type ReplyError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// firstMessage is a Hello, or an Envelope of legacy hooks which have no
+// version. Version is nil when the key is missing, so a Hello with version 0
+// is refused instead of being taken for a legacy envelope.
+type firstMessage struct {
+	Version *int `json:"version"`
+	Hello
+	Envelope
+}
+
+// capabilities negotiated in a connection
+type capabilities map[string]bool
+
+// legacyCapabilities are assumed for hooks without Hello
+var legacyCapabilities = capabilities{CapabilityDHCPv6: true, CapabilityDHCPSettings: true}
+
+// negotiate returns the capabilities of a Hello supported by the server
+func negotiate(requested []string) capabilities {
+	caps := make(capabilities)
+	for _, c := range requested {
+		if contains(serverCapabilities, c) {
+			caps[c] = true
+		}
+	}
+	return caps
+}
+
+func (c capabilities) list() []string {
+	list := []string{}
+	for _, v := range serverCapabilities {
+		if c[v] {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// serveLegacy answers the only envelope of a legacy connection
+func (k *KeaSocket) serveLegacy(conn net.Conn, env *Envelope) {
+	metricCallouts.WithLabelValues(CalloutName(env.Callout)).Inc()
+	// Process data from Kea
+	res, err := processDataFromConnection(k, env)
+	if err != nil {
+		log.Printf("Error queueing %s of %s, %s", CalloutName(env.Callout), res.Lease.Address, err.Error())
+	}
+
+	// Send response
+	sendResponse(k, res, conn)
+}
+
+// servePersistent negotiates a Hello and answers requests until the hook
+// closes the connection or it's idle
+func (k *KeaSocket) servePersistent(conn net.Conn, d *json.Decoder, hello *Hello) {
+	e := json.NewEncoder(conn)
+
+	if hello.Version < 1 {
+		metricErrors.WithLabelValues(ErrorUnsupportedVersion).Inc()
+		k.write(conn, e, &Reply{Error: &ReplyError{Code: ErrorUnsupportedVersion,
+			Message: fmt.Sprintf("version %d is not supported", hello.Version)}})
+		return
+	}
+
+	caps := negotiate(hello.Capabilities)
+	if !k.write(conn, e, &Hello{Version: ProtocolVersion, Capabilities: caps.list()}) {
+		return
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		var req Request
+		err := d.Decode(&req)
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case err == nil:
+		case errors.As(err, &typeErr):
+			// Value was read, next requests can be decoded
+			metricDecodeErrors.Inc()
+			if !k.replyError(conn, e, req.ID, ErrorMalformed, err.Error()) {
+				return
+			}
+			continue
+		case errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed):
+			return
+		default:
+			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+				return
+			}
+			// Stream can not be decoded any more
+			metricDecodeErrors.Inc()
+			k.replyError(conn, e, req.ID, ErrorMalformed, err.Error())
+			return
+		}
+
+		if !k.write(conn, e, k.serveRequest(&req, caps)) {
+			return
+		}
+	}
+}
+
+// serveRequest processes an envelope and returns its reply
+func (k *KeaSocket) serveRequest(req *Request, caps capabilities) *Reply {
+	reply := &Reply{ID: req.ID}
+
+	if _, ok := calloutNames[req.Callout]; !ok {
+		metricErrors.WithLabelValues(ErrorUnknownCallout).Inc()
+		reply.Error = &ReplyError{Code: ErrorUnknownCallout, Message: fmt.Sprintf("callout %d is not handled", req.Callout)}
+		return reply
+	}
+	if isDHCPv6(req.Callout) && !caps[CapabilityDHCPv6] {
+		metricErrors.WithLabelValues(ErrorNotNegotiated).Inc()
+		reply.Error = &ReplyError{Code: ErrorNotNegotiated, Message: CapabilityDHCPv6 + " capability was not negotiated"}
+		return reply
+	}
+
+	metricCallouts.WithLabelValues(CalloutName(req.Callout)).Inc()
+	res, err := processDataFromConnection(k, &req.Envelope)
+	if err != nil {
+		metricErrors.WithLabelValues(ErrorOverloaded).Inc()
+		reply.Error = &ReplyError{Code: ErrorOverloaded, Message: err.Error()}
+		return reply
+	}
+
+	resp, err := k.response(res, caps)
+	if err != nil {
+		code := ErrorNoInterface
+		if errors.Is(err, errRejected) {
+			code = ErrorRejected
+		}
+		metricErrors.WithLabelValues(code).Inc()
+		reply.Error = &ReplyError{Code: code, Message: err.Error()}
+		return reply
+	}
+	reply.Response = resp
+
+	return reply
+}
+
+func (k *KeaSocket) replyError(conn net.Conn, e *json.Encoder, id uint64, code string, message string) bool {
+	metricErrors.WithLabelValues(code).Inc()
+	return k.write(conn, e, &Reply{ID: id, Error: &ReplyError{Code: code, Message: message}})
+}
+
+// write sends a message to the hook, it reports if the connection can be
+// used again
+func (k *KeaSocket) write(conn net.Conn, e *json.Encoder, v interface{}) bool {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := e.Encode(v); err != nil {
+		log.Printf("Error sending reply to Kea, %s", err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+package kea
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const circuitQuery = `{"hw-addr": "aa:bb:cc:dd:ee:ff", "option82-circuit-id": "cpe1"}`
+
+type hookConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// dial connects a hook to a socket through a pipe
+func dial(t *testing.T, k *KeaSocket) *hookConn {
+	t.Helper()
+
+	var queue Queue
+	queue.Init(&QueueConfig{})
+	t.Cleanup(queue.Close)
+	k.events = &queue
+	k.conns = make(map[net.Conn]struct{})
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		k.handleConection(server)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return &hookConn{Conn: client, r: bufio.NewReader(client)}
+}
+
+func (c *hookConn) send(t *testing.T, line string) {
+	t.Helper()
+
+	if _, err := io.WriteString(c, line+"\n"); err != nil {
+		t.Fatalf("sending %s, %v", line, err)
+	}
+}
+
+func (c *hookConn) receive(t *testing.T, v interface{}) {
+	t.Helper()
+
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("receiving reply, %v", err)
+	}
+	if err := json.Unmarshal(line, v); err != nil {
+		t.Fatalf("decoding %q, %v", line, err)
+	}
+}
+
+// closed reports if the server closed the connection
+func (c *hookConn) closed() bool {
+	_, err := c.r.ReadByte()
+	return err == io.EOF
+}
+
+func (c *hookConn) hello(t *testing.T, caps ...string) {
+	t.Helper()
+
+	body, _ := json.Marshal(&Hello{Version: 1, Capabilities: caps})
+	c.send(t, string(body))
+	var h Hello
+	c.receive(t, &h)
+}
+
+func TestProtocolLegacy(t *testing.T) {
+	k := newTestSocket(nil)
+	c := dial(t, k)
+
+	c.send(t, fmt.Sprintf(`{"callout": %d, "query": %s}`, CALLOUT_PKT4_CIRCUIT_ID, circuitQuery))
+	var resp KeaResponse
+	c.receive(t, &resp)
+	if resp.FlexId != "cpe1" || resp.Pool != "100.64.0.0/24" {
+		t.Errorf("response = %+v, want flex-id and pool of cpe1", resp)
+	}
+	if !c.closed() {
+		t.Error("legacy connection was not closed after the response")
+	}
+
+	// Lease callouts are queued without a response
+	c = dial(t, k)
+	c.send(t, fmt.Sprintf(`{"callout": %d, "lease": {"address": "100.64.0.10"}, "query": {"hw-addr": "aa:bb:cc:dd:ee:ff"}}`, CALLOUT_LEASE4_SELECT))
+	if !c.closed() {
+		t.Error("legacy connection of a lease callout was not closed")
+	}
+	if n := k.events.Len(); n != 1 {
+		t.Errorf("queued %d events, want 1", n)
+	}
+}
+
+func TestProtocolHello(t *testing.T) {
+	tests := []struct {
+		name    string
+		hello   string
+		want    Hello
+		wantErr string
+	}{
+		{name: "version 1", hello: `{"version": 1, "capabilities": ["dhcpv6", "dhcp-settings"]}`,
+			want: Hello{Version: 1, Capabilities: []string{CapabilityDHCPv6, CapabilityDHCPSettings}}},
+		{name: "newer hook", hello: `{"version": 2, "capabilities": ["dhcp-settings", "unknown"]}`,
+			want: Hello{Version: 1, Capabilities: []string{CapabilityDHCPSettings}}},
+		{name: "no capabilities", hello: `{"version": 1}`, want: Hello{Version: 1, Capabilities: []string{}}},
+		{name: "version 0", hello: `{"version": 0, "capabilities": ["dhcpv6"]}`, wantErr: ErrorUnsupportedVersion},
+		{name: "negative version", hello: `{"version": -1}`, wantErr: ErrorUnsupportedVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dial(t, newTestSocket(nil))
+			c.send(t, tt.hello)
+
+			if tt.wantErr != "" {
+				var reply Reply
+				c.receive(t, &reply)
+				if reply.Error == nil || reply.Error.Code != tt.wantErr {
+					t.Errorf("reply = %+v, want error %s", reply, tt.wantErr)
+				}
+				if !c.closed() {
+					t.Error("connection was not closed after the error")
+				}
+				return
+			}
+
+			var h Hello
+			c.receive(t, &h)
+			if !reflect.DeepEqual(h, tt.want) {
+				t.Errorf("hello = %+v, want %+v", h, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtocolNotNegotiated(t *testing.T) {
+	c := dial(t, newTestSocket(nil))
+	c.hello(t, CapabilityDHCPSettings)
+
+	c.send(t, fmt.Sprintf(`{"id": 1, "callout": %d, "query": {"option18-interface-id": "cpe1"}}`, CALLOUT_PKT6_INTERFACE_ID))
+	var reply Reply
+	c.receive(t, &reply)
+	if reply.ID != 1 || reply.Error == nil || reply.Error.Code != ErrorNotNegotiated {
+		t.Errorf("reply = %+v, want %s", reply, ErrorNotNegotiated)
+	}
+}
+
+func TestProtocolMalformed(t *testing.T) {
+	c := dial(t, newTestSocket(nil))
+	c.hello(t, CapabilityDHCPSettings)
+
+	// Connection is kept after a value of a wrong type
+	c.send(t, `{"id": 1, "callout": "select"}`)
+	var reply Reply
+	c.receive(t, &reply)
+	if reply.ID != 1 || reply.Error == nil || reply.Error.Code != ErrorMalformed {
+		t.Errorf("reply = %+v, want %s", reply, ErrorMalformed)
+	}
+
+	c.send(t, fmt.Sprintf(`{"id": 2, "callout": %d, "query": %s}`, CALLOUT_PKT4_CIRCUIT_ID, circuitQuery))
+	reply = Reply{}
+	c.receive(t, &reply)
+	if reply.ID != 2 || reply.Error != nil || reply.Response == nil || reply.Response.FlexId != "cpe1" {
+		t.Errorf("reply after malformed request = %+v, want response of cpe1", reply)
+	}
+
+	// It's closed when the stream can not be decoded
+	c.send(t, `{"id": 3, "callout": ]`)
+	reply = Reply{}
+	c.receive(t, &reply)
+	if reply.Error == nil || reply.Error.Code != ErrorMalformed {
+		t.Errorf("reply = %+v, want %s", reply, ErrorMalformed)
+	}
+	if !c.closed() {
+		t.Error("connection was not closed after invalid JSON")
+	}
+}
+
+func TestProtocolOrder(t *testing.T) {
+	k := newTestSocket(nil)
+	c := dial(t, k)
+	c.hello(t, CapabilityDHCPv6, CapabilityDHCPSettings)
+
+	lease := `"lease": {"address": "100.64.0.10"}`
+	requests := []struct {
+		line string
+		want Reply
+	}{
+		{line: fmt.Sprintf(`{"id": 1, "callout": %d, "query": %s}`, CALLOUT_PKT4_CIRCUIT_ID, circuitQuery),
+			want: Reply{Response: &KeaResponse{FlexId: "cpe1"}}},
+		{line: fmt.Sprintf(`{"id": 2, "callout": %d, %s, "query": %s}`, CALLOUT_LEASE4_SELECT, lease, circuitQuery),
+			want: Reply{Response: &KeaResponse{FlexId: "cpe1"}}},
+		{line: `{"id": 3, "callout": 99}`, want: Reply{Error: &ReplyError{Code: ErrorUnknownCallout}}},
+		{line: fmt.Sprintf(`{"id": 4, "callout": %d, "query": {"option82-circuit-id": "cpe9"}}`, CALLOUT_PKT4_CIRCUIT_ID),
+			want: Reply{Error: &ReplyError{Code: ErrorNoInterface}}},
+		{line: fmt.Sprintf(`{"id": 5, "callout": %d, %s}`, CALLOUT_LEASE4_RELEASE, lease)},
+	}
+
+	// Requests are pipelined, hook does not wait for replies
+	go func() {
+		for _, r := range requests {
+			if _, err := io.WriteString(c, r.line+"\n"); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i, r := range requests {
+		var reply Reply
+		c.receive(t, &reply)
+		if reply.ID != uint64(i+1) {
+			t.Fatalf("reply %d has id %d, want replies in order of requests", i+1, reply.ID)
+		}
+		if (reply.Error == nil) != (r.want.Error == nil) || reply.Error != nil && reply.Error.Code != r.want.Error.Code {
+			t.Errorf("reply %d error = %+v, want %+v", i+1, reply.Error, r.want.Error)
+		}
+		if (reply.Response == nil) != (r.want.Response == nil) || reply.Response != nil && reply.Response.FlexId != r.want.Response.FlexId {
+			t.Errorf("reply %d response = %+v, want %+v", i+1, reply.Response, r.want.Response)
+		}
+	}
+}
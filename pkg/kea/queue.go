@@ -0,0 +1,243 @@
+package kea
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Overflow policies of a full queue
+const (
+	// Kea hook waits until there is room, Kea is slowed down
+	OverflowBlock = "block"
+	// Events are dropped, hooks with protocol version 1 get an error reply
+	OverflowDrop = "drop"
+)
+
+const (
+	defaultQueueDepth   = 1024
+	defaultQueueWorkers = 4
+)
+
+var (
+	ErrQueueFull   = errors.New("event queue is full")
+	ErrQueueClosed = errors.New("event queue is closed")
+)
+
+var (
+	metricQueueDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "glubng_kea_queue_dropped_total",
+		Help: "Events of Kea hook dropped as queue was full.",
+	})
+	metricQueueCoalesced = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "glubng_kea_queue_coalesced_total",
+		Help: "Events of Kea hook merged with a pending event of the same address.",
+	})
+)
+
+// Events of Kea hook waiting to be processed
+type QueueConfig struct {
+	// Events waiting in the queue, 1024 when zero
+	Depth int
+	// Events of different addresses processed concurrently, 4 when zero
+	Workers int
+	// OverflowBlock or OverflowDrop, block when empty
+	Overflow string
+}
+
+// Validate checks queue settings
+func (c *QueueConfig) Validate() error {
+	if c.Depth < 0 || c.Workers < 0 {
+		return errors.New("queue.Depth and queue.Workers are negative")
+	}
+	switch c.Overflow {
+	case "", OverflowBlock, OverflowDrop:
+	default:
+		return fmt.Errorf("queue.Overflow %q is not %s or %s", c.Overflow, OverflowBlock, OverflowDrop)
+	}
+
+	return nil
+}
+
+// addressEvents are pending events of an address, busy while one of its
+// events is being processed
+type addressEvents struct {
+	events []KeaResult
+	busy   bool
+}
+
+// Queue is a bounded queue of events of Kea hook. Events of an address are
+// popped in order and one at a time, events of different addresses can be
+// processed concurrently.
+type Queue struct {
+	depth     int
+	workers   int
+	overflow  string
+	addresses map[string]*addressEvents
+	// Addresses with pending events and not busy, in order of arrival
+	ready  []string
+	size   int
+	closed bool
+	mu     sync.Mutex
+	cond   *sync.Cond
+}
+
+func (q *Queue) Init(config *QueueConfig) {
+	q.depth = config.Depth
+	if q.depth == 0 {
+		q.depth = defaultQueueDepth
+	}
+	q.workers = config.Workers
+	if q.workers == 0 {
+		q.workers = defaultQueueWorkers
+	}
+	q.overflow = config.Overflow
+	q.addresses = make(map[string]*addressEvents)
+	q.ready = nil
+	q.size = 0
+	q.closed = false
+	q.cond = sync.NewCond(&q.mu)
+}
+
+// Workers returns how many events should be processed concurrently
+func (q *Queue) Workers() int {
+	return q.workers
+}
+
+// Len returns events waiting in the queue
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.size
+}
+
+// Push adds an event, merging it with a pending one of its address when
+// possible. When queue is full it waits or fails with ErrQueueFull, as set
+// by overflow policy.
+func (q *Queue) Push(r KeaResult) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.closed {
+			return ErrQueueClosed
+		}
+		if q.coalesce(&r) {
+			metricQueueCoalesced.Inc()
+			return nil
+		}
+		if q.size < q.depth {
+			break
+		}
+		if q.overflow == OverflowDrop {
+			metricQueueDropped.Inc()
+			return ErrQueueFull
+		}
+		q.cond.Wait()
+	}
+
+	key := r.Lease.Address
+	a := q.addresses[key]
+	if a == nil {
+		a = &addressEvents{}
+		q.addresses[key] = a
+	}
+	a.events = append(a.events, r)
+	if !a.busy && len(a.events) == 1 {
+		q.ready = append(q.ready, key)
+	}
+	q.size++
+	q.cond.Broadcast()
+
+	return nil
+}
+
+// coalesce merges an event with the last pending one of its address. A
+// release replaces a select not processed yet, so a session already active
+// on the address is still released, and a renew replaces a previous renew.
+func (q *Queue) coalesce(r *KeaResult) bool {
+	a := q.addresses[r.Lease.Address]
+	if a == nil || len(a.events) == 0 {
+		return false
+	}
+	last := &a.events[len(a.events)-1]
+	if last.Lease.Type != r.Lease.Type {
+		return false
+	}
+
+	switch {
+	case r.Callout == CALLOUT_LEASE4_RELEASE && last.Callout == CALLOUT_LEASE4_SELECT,
+		r.Callout == CALLOUT_LEASE6_RELEASE && last.Callout == CALLOUT_LEASE6_SELECT,
+		r.Callout == CALLOUT_LEASE4_RENEW && last.Callout == CALLOUT_LEASE4_RENEW,
+		isLease6Renew(r.Callout) && isLease6Renew(last.Callout):
+		*last = *r
+		return true
+	}
+
+	return false
+}
+
+func isLease6Renew(callout int) bool {
+	return callout == CALLOUT_LEASE6_RENEW || callout == CALLOUT_LEASE6_REBIND
+}
+
+// Pop waits for the next event of an address not being processed, Done must
+// be called once it's processed. It returns false when queue is closed.
+func (q *Queue) Pop() (KeaResult, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.ready) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return KeaResult{}, false
+	}
+
+	key := q.ready[0]
+	q.ready = q.ready[1:]
+	a := q.addresses[key]
+	r := a.events[0]
+	a.events = a.events[1:]
+	a.busy = true
+	q.size--
+	q.cond.Broadcast()
+
+	return r, true
+}
+
+// Done releases the address of a popped event, so its next event can be
+// popped
+func (q *Queue) Done(r *KeaResult) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := r.Lease.Address
+	a := q.addresses[key]
+	if a == nil {
+		return
+	}
+	a.busy = false
+	if len(a.events) == 0 {
+		delete(q.addresses, key)
+		return
+	}
+	q.ready = append(q.ready, key)
+	q.cond.Broadcast()
+}
+
+// Close wakes up waiting Push and Pop, pending events are discarded
+func (q *Queue) Close() {
+	if q.cond == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,322 @@
+package kea
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func event(callout int, addr string, hostname string) KeaResult {
+	return KeaResult{Callout: callout, Lease: Lease{Address: addr, Hostname: hostname}}
+}
+
+func event6(callout int, addr string, leaseType string) KeaResult {
+	return KeaResult{Callout: callout, Lease: Lease{Address: addr, Type: leaseType}}
+}
+
+func newQueue(t *testing.T, config *QueueConfig) *Queue {
+	t.Helper()
+
+	q := &Queue{}
+	q.Init(config)
+	t.Cleanup(q.Close)
+	return q
+}
+
+func push(t *testing.T, q *Queue, events ...KeaResult) {
+	t.Helper()
+
+	for _, r := range events {
+		if err := q.Push(r); err != nil {
+			t.Fatalf("Push(%d %s) error = %v", r.Callout, r.Lease.Address, err)
+		}
+	}
+}
+
+// pop pops an event and releases its address
+func pop(t *testing.T, q *Queue) KeaResult {
+	t.Helper()
+
+	r, ok := q.Pop()
+	if !ok {
+		t.Fatal("Pop() = false, want an event")
+	}
+	q.Done(&r)
+	return r
+}
+
+// popAll pops pending events, returning their callouts by address
+func popAll(t *testing.T, q *Queue) map[string][]int {
+	t.Helper()
+
+	got := make(map[string][]int)
+	for q.Len() > 0 {
+		r := pop(t, q)
+		got[r.Lease.Address] = append(got[r.Lease.Address], r.Callout)
+	}
+	return got
+}
+
+func TestQueueDefaults(t *testing.T) {
+	q := newQueue(t, &QueueConfig{})
+	if q.Workers() != defaultQueueWorkers || q.depth != defaultQueueDepth {
+		t.Errorf("workers %d and depth %d, want %d and %d", q.Workers(), q.depth, defaultQueueWorkers, defaultQueueDepth)
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	q := newQueue(t, &QueueConfig{})
+	push(t, q,
+		event(CALLOUT_LEASE4_SELECT, "100.64.0.10", ""),
+		event(CALLOUT_LEASE4_SELECT, "100.64.0.11", ""),
+		event(CALLOUT_LEASE4_RENEW, "100.64.0.10", ""),
+		event(CALLOUT_LEASE4_EXPIRE, "100.64.0.11", ""),
+		event(CALLOUT_LEASE4_RELEASE, "100.64.0.10", ""),
+	)
+	if q.Len() != 5 {
+		t.Fatalf("Len() = %d, want 5", q.Len())
+	}
+
+	want := map[string][]int{
+		"100.64.0.10": {CALLOUT_LEASE4_SELECT, CALLOUT_LEASE4_RENEW, CALLOUT_LEASE4_RELEASE},
+		"100.64.0.11": {CALLOUT_LEASE4_SELECT, CALLOUT_LEASE4_EXPIRE},
+	}
+	if got := popAll(t, q); !reflect.DeepEqual(got, want) {
+		t.Errorf("popped %v, want %v", got, want)
+	}
+}
+
+func TestQueueBusyAddress(t *testing.T) {
+	q := newQueue(t, &QueueConfig{})
+	push(t, q,
+		event(CALLOUT_LEASE4_SELECT, "100.64.0.10", ""),
+		event(CALLOUT_LEASE4_RENEW, "100.64.0.10", ""),
+		event(CALLOUT_LEASE4_SELECT, "100.64.0.11", ""),
+	)
+
+	first, _ := q.Pop()
+	if first.Lease.Address != "100.64.0.10" || first.Callout != CALLOUT_LEASE4_SELECT {
+		t.Fatalf("first event = %d %s, want select of 100.64.0.10", first.Callout, first.Lease.Address)
+	}
+
+	// Other addresses are popped while 100.64.0.10 is busy
+	second, _ := q.Pop()
+	if second.Lease.Address != "100.64.0.11" {
+		t.Fatalf("second event of %s, want 100.64.0.11", second.Lease.Address)
+	}
+	q.Done(&second)
+
+	popped := make(chan KeaResult)
+	go func() {
+		r, _ := q.Pop()
+		popped <- r
+	}()
+	select {
+	case r := <-popped:
+		t.Fatalf("event %d of busy %s popped", r.Callout, r.Lease.Address)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.Done(&first)
+	select {
+	case r := <-popped:
+		if r.Lease.Address != "100.64.0.10" || r.Callout != CALLOUT_LEASE4_RENEW {
+			t.Errorf("event after Done = %d %s, want renew of 100.64.0.10", r.Callout, r.Lease.Address)
+		}
+		q.Done(&r)
+	case <-time.After(time.Second):
+		t.Fatal("renew of 100.64.0.10 not popped after Done")
+	}
+	if q.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", q.Len())
+	}
+}
+
+func TestQueueCoalesce(t *testing.T) {
+	const addr = "100.64.0.10"
+	const prefix = "2001:db8:100::"
+
+	tests := []struct {
+		name   string
+		events []KeaResult
+		want   []int
+	}{
+		{
+			name: "release replaces select",
+			events: []KeaResult{
+				event(CALLOUT_LEASE4_SELECT, addr, ""),
+				event(CALLOUT_LEASE4_RELEASE, addr, ""),
+			},
+			want: []int{CALLOUT_LEASE4_RELEASE},
+		},
+		{
+			name: "release after renew is kept",
+			events: []KeaResult{
+				event(CALLOUT_LEASE4_SELECT, addr, ""),
+				event(CALLOUT_LEASE4_RENEW, addr, ""),
+				event(CALLOUT_LEASE4_RELEASE, addr, ""),
+			},
+			want: []int{CALLOUT_LEASE4_SELECT, CALLOUT_LEASE4_RENEW, CALLOUT_LEASE4_RELEASE},
+		},
+		{
+			name: "renew replaces renew",
+			events: []KeaResult{
+				event(CALLOUT_LEASE4_SELECT, addr, ""),
+				event(CALLOUT_LEASE4_RENEW, addr, ""),
+				event(CALLOUT_LEASE4_RENEW, addr, ""),
+				event(CALLOUT_LEASE4_RENEW, addr, ""),
+			},
+			want: []int{CALLOUT_LEASE4_SELECT, CALLOUT_LEASE4_RENEW},
+		},
+		{
+			name: "decline is kept",
+			events: []KeaResult{
+				event(CALLOUT_LEASE4_SELECT, addr, ""),
+				event(CALLOUT_LEASE4_DECLINE, addr, ""),
+			},
+			want: []int{CALLOUT_LEASE4_SELECT, CALLOUT_LEASE4_DECLINE},
+		},
+		{
+			name: "lease6 release replaces select",
+			events: []KeaResult{
+				event6(CALLOUT_LEASE6_SELECT, prefix, "IA_PD"),
+				event6(CALLOUT_LEASE6_RELEASE, prefix, "IA_PD"),
+			},
+			want: []int{CALLOUT_LEASE6_RELEASE},
+		},
+		{
+			name: "lease6 rebind replaces renew",
+			events: []KeaResult{
+				event6(CALLOUT_LEASE6_RENEW, prefix, "IA_PD"),
+				event6(CALLOUT_LEASE6_REBIND, prefix, "IA_PD"),
+			},
+			want: []int{CALLOUT_LEASE6_REBIND},
+		},
+		{
+			name: "lease6 of other type is kept",
+			events: []KeaResult{
+				event6(CALLOUT_LEASE6_SELECT, prefix, "IA_NA"),
+				event6(CALLOUT_LEASE6_RELEASE, prefix, "IA_PD"),
+				event6(CALLOUT_LEASE6_RENEW, prefix, "IA_NA"),
+			},
+			want: []int{CALLOUT_LEASE6_SELECT, CALLOUT_LEASE6_RELEASE, CALLOUT_LEASE6_RENEW},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue(t, &QueueConfig{})
+			push(t, q, tt.events...)
+			if q.Len() != len(tt.want) {
+				t.Errorf("Len() = %d, want %d", q.Len(), len(tt.want))
+			}
+
+			var got []int
+			for _, callouts := range popAll(t, q) {
+				got = append(got, callouts...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("popped %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueCoalesceKeepsLatest(t *testing.T) {
+	q := newQueue(t, &QueueConfig{})
+	push(t, q,
+		event(CALLOUT_LEASE4_RENEW, "100.64.0.10", "first"),
+		event(CALLOUT_LEASE4_RENEW, "100.64.0.10", "second"),
+	)
+
+	if r := pop(t, q); r.Lease.Hostname != "second" {
+		t.Errorf("renew of hostname %q, want second", r.Lease.Hostname)
+	}
+}
+
+func TestQueueCoalesceBusyAddress(t *testing.T) {
+	q := newQueue(t, &QueueConfig{})
+	push(t, q, event(CALLOUT_LEASE4_SELECT, "100.64.0.10", ""))
+	r, _ := q.Pop()
+
+	// A release does not replace a select being processed
+	push(t, q, event(CALLOUT_LEASE4_RELEASE, "100.64.0.10", ""))
+	if q.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", q.Len())
+	}
+	q.Done(&r)
+
+	if r := pop(t, q); r.Callout != CALLOUT_LEASE4_RELEASE {
+		t.Errorf("event = %d, want release", r.Callout)
+	}
+}
+
+func TestQueueOverflowDrop(t *testing.T) {
+	q := newQueue(t, &QueueConfig{Depth: 2, Overflow: OverflowDrop})
+	push(t, q,
+		event(CALLOUT_LEASE4_SELECT, "100.64.0.10", ""),
+		event(CALLOUT_LEASE4_SELECT, "100.64.0.11", ""),
+	)
+
+	if err := q.Push(event(CALLOUT_LEASE4_SELECT, "100.64.0.12", "")); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Push() on full queue error = %v, want %v", err, ErrQueueFull)
+	}
+	// Coalesced events need no room
+	if err := q.Push(event(CALLOUT_LEASE4_RELEASE, "100.64.0.11", "")); err != nil {
+		t.Errorf("Push() of coalesced release error = %v", err)
+	}
+	pop(t, q)
+	if err := q.Push(event(CALLOUT_LEASE4_SELECT, "100.64.0.12", "")); err != nil {
+		t.Errorf("Push() after Pop error = %v", err)
+	}
+}
+
+func TestQueueOverflowBlock(t *testing.T) {
+	q := newQueue(t, &QueueConfig{Depth: 1})
+	push(t, q, event(CALLOUT_LEASE4_SELECT, "100.64.0.10", ""))
+
+	pushed := make(chan error)
+	go func() {
+		pushed <- q.Push(event(CALLOUT_LEASE4_SELECT, "100.64.0.11", ""))
+	}()
+	select {
+	case err := <-pushed:
+		t.Fatalf("Push() on full queue returned %v, want it to wait", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pop(t, q)
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Errorf("Push() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Push() still waits after Pop")
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	q := newQueue(t, &QueueConfig{})
+
+	popped := make(chan bool)
+	go func() {
+		_, ok := q.Pop()
+		popped <- ok
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+
+	select {
+	case ok := <-popped:
+		if ok {
+			t.Error("Pop() after Close = true, want false")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pop() not woken up by Close")
+	}
+	if err := q.Push(event(CALLOUT_LEASE4_SELECT, "100.64.0.10", "")); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Push() after Close error = %v, want %v", err, ErrQueueClosed)
+	}
+}
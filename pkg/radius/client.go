@@ -0,0 +1,384 @@
+package radius
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	rad "layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+const (
+	defaultTimeout = 3 * time.Second
+	defaultRetry   = time.Second
+	// Accounting requests waiting to be sent, more are dropped
+	acctQueueSize = 1024
+)
+
+// Vendor-Specific attributes
+const (
+	vendorCisco      = 9
+	ciscoAVPair      = 1
+	vendorDSLForum   = 3561
+	dslAgentCircuit  = 1
+	dslAgentRemoteID = 2
+	// Cisco-AVPair giving the VRF of a subscriber
+	avPairVRF = "ip:vrf-id="
+)
+
+// InterfaceDefault is the Filter-Id or VRF of a CoA-Request restoring the
+// service profile or the VRF of the CPE interface. Access-Accept attributes
+// with it are ignored, profiles and VRFs can not be named after it.
+const InterfaceDefault = "default"
+
+// ErrRejected is returned when the server answers with an Access-Reject
+var ErrRejected = errors.New("access rejected")
+
+// TerminateCause is sent in Accounting-Request Stop
+type TerminateCause = rfc2866.AcctTerminateCause
+
+const (
+	CauseUserRequest    = rfc2866.AcctTerminateCause_Value_UserRequest
+	CauseIdleTimeout    = rfc2866.AcctTerminateCause_Value_IdleTimeout
+	CauseSessionTimeout = rfc2866.AcctTerminateCause_Value_SessionTimeout
+	CauseAdminReset     = rfc2866.AcctTerminateCause_Value_AdminReset
+	CausePortError      = rfc2866.AcctTerminateCause_Value_PortError
+	CausePortUnneeded   = rfc2866.AcctTerminateCause_Value_PortUnneeded
+	CauseUserError      = rfc2866.AcctTerminateCause_Value_UserError
+	CauseNASRequest     = rfc2866.AcctTerminateCause_Value_NASRequest
+)
+
+// Subscriber identifies a DHCP client in Access-Requests
+type Subscriber struct {
+	CircuitID string
+	RemoteID  string
+	MAC       string
+	IPv4      net.IP
+	// SwIf of CPE interface, sent as NAS-Port
+	Iface int
+	// Flex-id of CPE interface, sent as NAS-Port-Id
+	FlexID string
+}
+
+// Authorization holds attributes of an Access-Accept applied to a session
+type Authorization struct {
+	Username string
+	// Filter-Id, name of the service profile limiting bandwidth
+	Profile string
+	// Framed-Pool
+	Pool string
+	// Cisco-AVPair ip:vrf-id
+	VRF string
+	// Session-Timeout, zero when session is not limited
+	SessionTimeout time.Duration
+	// Class, echoed in accounting requests
+	Class []byte
+}
+
+// AcctSession describes a session in accounting requests
+type AcctSession struct {
+	ID       string
+	Username string
+	MAC      string
+	IPv4     net.IP
+	Iface    int
+	FlexID   string
+	Started  time.Time
+	Class    []byte
+	// Traffic sent and received by the subscriber
+	Input  Counter
+	Output Counter
+}
+
+// Counter is traffic of a session in accounting requests
+type Counter struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+type acctRequest struct {
+	packet *rad.Packet
+	queued time.Time
+}
+
+// Client authenticates subscribers and sends accounting of their sessions
+type Client struct {
+	config  Config
+	timeout time.Duration
+	client  rad.Client
+	acct    chan acctRequest
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func seconds(s int, def time.Duration) time.Duration {
+	if s == 0 {
+		return def
+	}
+	return time.Duration(s) * time.Second
+}
+
+func (c *Client) Init(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	c.config = *config
+	c.timeout = seconds(config.Timeout, defaultTimeout)
+	c.client = rad.Client{Retry: seconds(config.Retry, defaultRetry)}
+	c.stop = make(chan struct{})
+
+	// Accounting is sent in background, sessions must not wait for it
+	if config.AcctServer != "" {
+		c.acct = make(chan acctRequest, acctQueueSize)
+		c.wg.Add(1)
+		go c.sendAccounting()
+	}
+
+	return nil
+}
+
+func (c *Client) Close() {
+	if c.stop == nil {
+		return
+	}
+	close(c.stop)
+	c.wg.Wait()
+}
+
+// Username builds User-Name of a subscriber from the configured template
+func (c *Client) Username(sub *Subscriber) string {
+	tmpl := c.config.Username
+	if tmpl == "" {
+		tmpl = UsernameMAC
+	}
+
+	r := strings.NewReplacer(UsernameCircuitID, sub.CircuitID, UsernameRemoteID, sub.RemoteID, UsernameMAC, sub.MAC)
+	return r.Replace(tmpl)
+}
+
+func (c *Client) exchange(packet *rad.Packet, server string) (*rad.Packet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	return c.client.Exchange(ctx, packet, server)
+}
+
+// addNAS adds attributes identifying glubngd and the CPE interface
+func (c *Client) addNAS(p *rad.Packet, iface int, flexID string, mac string) {
+	if c.config.NASIdentifier != "" {
+		rfc2865.NASIdentifier_SetString(p, c.config.NASIdentifier)
+	}
+	rfc2865.NASPort_Set(p, rfc2865.NASPort(iface))
+	if flexID != "" {
+		rfc2869.NASPortID_SetString(p, flexID)
+	}
+	rfc2865.NASPortType_Set(p, rfc2865.NASPortType_Value_Ethernet)
+	if mac != "" {
+		rfc2865.CallingStationID_SetString(p, mac)
+	}
+}
+
+func addVendorString(p *rad.Packet, vendor uint32, typ byte, value string) {
+	if value == "" || len(value) > 247 {
+		return
+	}
+
+	vsa, err := rad.NewVendorSpecific(vendor, append([]byte{typ, byte(len(value) + 2)}, value...))
+	if err == nil {
+		p.Add(rfc2865.VendorSpecific_Type, vsa)
+	}
+}
+
+// vendorStrings returns values of a Vendor-Specific attribute
+func vendorStrings(p *rad.Packet, vendor uint32, typ byte) []string {
+	var values []string
+
+	for _, a := range p.Attributes[rfc2865.VendorSpecific_Type] {
+		id, vsa, err := rad.VendorSpecific(a)
+		if err != nil || id != vendor {
+			continue
+		}
+		for len(vsa) >= 2 && int(vsa[1]) >= 2 && int(vsa[1]) <= len(vsa) {
+			if vsa[0] == typ {
+				values = append(values, string(vsa[2:vsa[1]]))
+			}
+			vsa = vsa[vsa[1]:]
+		}
+	}
+
+	return values
+}
+
+// padPassword pads a password with nulls to a multiple of 16 bytes, as it's
+// sent, the library does not pad it
+func padPassword(password string) []byte {
+	n := (len(password) + 15) / 16 * 16
+	if n == 0 {
+		n = 16
+	}
+	padded := make([]byte, n)
+	copy(padded, password)
+
+	return padded
+}
+
+// Authenticate sends an Access-Request for a subscriber, ErrRejected is
+// returned if it's not allowed. Only username is returned when
+// authentication is disabled.
+func (c *Client) Authenticate(sub *Subscriber) (*Authorization, error) {
+	auth := &Authorization{Username: c.Username(sub)}
+	if c.config.AuthServer == "" {
+		return auth, nil
+	}
+
+	password := c.config.Password
+	if password == "" {
+		password = auth.Username
+	}
+
+	p := rad.New(rad.CodeAccessRequest, []byte(c.config.Secret))
+	rfc2865.UserName_SetString(p, auth.Username)
+	if err := rfc2865.UserPassword_Set(p, padPassword(password)); err != nil {
+		return nil, fmt.Errorf("password of %s, %w", auth.Username, err)
+	}
+	rfc2865.ServiceType_Set(p, rfc2865.ServiceType_Value_FramedUser)
+	c.addNAS(p, sub.Iface, sub.FlexID, sub.MAC)
+	if sub.IPv4 != nil {
+		rfc2865.FramedIPAddress_Set(p, sub.IPv4)
+	}
+	addVendorString(p, vendorDSLForum, dslAgentCircuit, sub.CircuitID)
+	addVendorString(p, vendorDSLForum, dslAgentRemoteID, sub.RemoteID)
+
+	reply, err := c.exchange(p, c.config.AuthServer)
+	if err != nil {
+		return nil, fmt.Errorf("access-request of %s, %w", auth.Username, err)
+	}
+
+	switch reply.Code {
+	case rad.CodeAccessAccept:
+	case rad.CodeAccessReject:
+		return nil, fmt.Errorf("%w, user %s, %s", ErrRejected, auth.Username, rfc2865.ReplyMessage_GetString(reply))
+	default:
+		return nil, fmt.Errorf("access-request of %s answered with %s", auth.Username, reply.Code.String())
+	}
+
+	auth = authorizationFromPacket(reply, auth.Username)
+	if auth.Profile == InterfaceDefault {
+		auth.Profile = ""
+	}
+	if auth.VRF == InterfaceDefault {
+		auth.VRF = ""
+	}
+
+	return auth, nil
+}
+
+// authorizationFromPacket reads attributes of an Access-Accept or a
+// CoA-Request
+func authorizationFromPacket(p *rad.Packet, username string) *Authorization {
+	auth := &Authorization{
+		Username:       username,
+		Profile:        rfc2865.FilterID_GetString(p),
+		Pool:           rfc2869.FramedPool_GetString(p),
+		SessionTimeout: time.Duration(rfc2865.SessionTimeout_Get(p)) * time.Second,
+		Class:          rfc2865.Class_Get(p),
+	}
+	for _, pair := range vendorStrings(p, vendorCisco, ciscoAVPair) {
+		if strings.HasPrefix(pair, avPairVRF) {
+			auth.VRF = strings.TrimPrefix(pair, avPairVRF)
+		}
+	}
+
+	return auth
+}
+
+// Start sends Accounting-Request Start of a session
+func (c *Client) Start(s *AcctSession) {
+	c.account(s, rfc2866.AcctStatusType_Value_Start, 0)
+}
+
+// Interim sends Accounting-Request Interim-Update of an active session
+func (c *Client) Interim(s *AcctSession) {
+	c.account(s, rfc2866.AcctStatusType_Value_InterimUpdate, 0)
+}
+
+// Stop sends Accounting-Request Stop of a session
+func (c *Client) Stop(s *AcctSession, cause TerminateCause) {
+	c.account(s, rfc2866.AcctStatusType_Value_Stop, cause)
+}
+
+// account queues an accounting request, it's dropped when queue is full
+func (c *Client) account(s *AcctSession, status rfc2866.AcctStatusType, cause TerminateCause) {
+	if c.acct == nil {
+		return
+	}
+
+	now := time.Now()
+	p := rad.New(rad.CodeAccountingRequest, []byte(c.config.Secret))
+	rfc2866.AcctStatusType_Set(p, status)
+	rfc2866.AcctSessionID_SetString(p, s.ID)
+	if s.Username != "" {
+		rfc2865.UserName_SetString(p, s.Username)
+	}
+	c.addNAS(p, s.Iface, s.FlexID, s.MAC)
+	if s.IPv4 != nil {
+		rfc2865.FramedIPAddress_Set(p, s.IPv4)
+	}
+	if len(s.Class) > 0 {
+		rfc2865.Class_Set(p, s.Class)
+	}
+	rfc2869.EventTimestamp_Set(p, now)
+	if status != rfc2866.AcctStatusType_Value_Start && !s.Started.IsZero() {
+		rfc2866.AcctSessionTime_Set(p, rfc2866.AcctSessionTime(now.Sub(s.Started)/time.Second))
+	}
+	if status != rfc2866.AcctStatusType_Value_Start {
+		// Octets above 32 bits are sent in gigawords
+		rfc2866.AcctInputPackets_Set(p, rfc2866.AcctInputPackets(s.Input.Packets))
+		rfc2866.AcctInputOctets_Set(p, rfc2866.AcctInputOctets(s.Input.Bytes))
+		rfc2869.AcctInputGigawords_Set(p, rfc2869.AcctInputGigawords(s.Input.Bytes>>32))
+		rfc2866.AcctOutputPackets_Set(p, rfc2866.AcctOutputPackets(s.Output.Packets))
+		rfc2866.AcctOutputOctets_Set(p, rfc2866.AcctOutputOctets(s.Output.Bytes))
+		rfc2869.AcctOutputGigawords_Set(p, rfc2869.AcctOutputGigawords(s.Output.Bytes>>32))
+	}
+	if status == rfc2866.AcctStatusType_Value_Stop {
+		rfc2866.AcctTerminateCause_Set(p, cause)
+	}
+
+	select {
+	case c.acct <- acctRequest{packet: p, queued: now}:
+	default:
+		log.Printf("Accounting queue is full, %s of session %s dropped", status.String(), s.ID)
+	}
+}
+
+func (c *Client) sendAccounting() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case r := <-c.acct:
+			// Time spent in queue is reported to the server
+			delay := time.Since(r.queued) / time.Second
+			rfc2866.AcctDelayTime_Set(r.packet, rfc2866.AcctDelayTime(delay))
+
+			reply, err := c.exchange(r.packet, c.config.AcctServer)
+			if err == nil && reply.Code != rad.CodeAccountingResponse {
+				err = fmt.Errorf("answered with %s", reply.Code.String())
+			}
+			if err != nil {
+				log.Printf("Error sending accounting of session %s, %s", rfc2866.AcctSessionID_GetString(r.packet), err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package radius
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, m *MockServer, username string) *Client {
+	t.Helper()
+
+	config := m.Config()
+	config.Username = username
+	c := &Client{}
+	if err := c.Init(config); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+func newTestServer(t *testing.T) *MockServer {
+	t.Helper()
+
+	m, err := NewMockServer("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func TestAuthenticateAccept(t *testing.T) {
+	m := newTestServer(t)
+	m.SetUser("cpe1/aa:bb:cc:dd:ee:ff", MockUser{
+		Profile:        "gold",
+		Pool:           "pool-b",
+		VRF:            "isp-b",
+		SessionTimeout: time.Hour,
+		Class:          []byte("plan-42"),
+	})
+	c := newTestClient(t, m, "{circuit-id}/{mac}")
+
+	auth, err := c.Authenticate(&Subscriber{
+		CircuitID: "cpe1",
+		RemoteID:  "olt1",
+		MAC:       "aa:bb:cc:dd:ee:ff",
+		IPv4:      net.ParseIP("100.64.0.10"),
+		Iface:     3,
+		FlexID:    "cpe1",
+	})
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	want := &Authorization{
+		Username:       "cpe1/aa:bb:cc:dd:ee:ff",
+		Profile:        "gold",
+		Pool:           "pool-b",
+		VRF:            "isp-b",
+		SessionTimeout: time.Hour,
+		Class:          []byte("plan-42"),
+	}
+	if !reflect.DeepEqual(auth, want) {
+		t.Errorf("Authenticate() = %+v, want %+v", auth, want)
+	}
+
+	access := m.Access()
+	if len(access) != 1 {
+		t.Fatalf("server got %d Access-Requests, want 1", len(access))
+	}
+	req := access[0]
+	if req.NASPort != 3 || req.MAC != "aa:bb:cc:dd:ee:ff" || req.CircuitID != "cpe1" || req.RemoteID != "olt1" ||
+		!req.IPv4.Equal(net.ParseIP("100.64.0.10")) {
+		t.Errorf("Access-Request = %+v, want attributes of subscriber", req)
+	}
+}
+
+func TestAuthenticateInterfaceDefault(t *testing.T) {
+	m := newTestServer(t)
+	m.SetUser("aa:bb:cc:dd:ee:ff", MockUser{Profile: InterfaceDefault, VRF: InterfaceDefault})
+	c := newTestClient(t, m, "")
+
+	auth, err := c.Authenticate(&Subscriber{MAC: "aa:bb:cc:dd:ee:ff", Iface: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Profile != "" || auth.VRF != "" {
+		t.Errorf("Authenticate() = %+v, want settings of the interface", auth)
+	}
+}
+
+func TestAuthenticateReject(t *testing.T) {
+	m := newTestServer(t)
+	m.SetUser("aa:bb:cc:dd:ee:ff", MockUser{Password: "other"})
+	c := newTestClient(t, m, "")
+
+	for _, mac := range []string{"aa:bb:cc:dd:ee:ff", "aa:bb:cc:dd:ee:00"} {
+		_, err := c.Authenticate(&Subscriber{MAC: mac, Iface: 3})
+		if !errors.Is(err, ErrRejected) {
+			t.Errorf("Authenticate(%s) error = %v, want ErrRejected", mac, err)
+		}
+	}
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	c := &Client{}
+	if err := c.Init(&Config{AcctServer: "127.0.0.1:1813", Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	auth, err := c.Authenticate(&Subscriber{MAC: "aa:bb:cc:dd:ee:ff"})
+	if err != nil || auth.Username != "aa:bb:cc:dd:ee:ff" || auth.Profile != "" {
+		t.Errorf("Authenticate() = %+v, %v, want username only", auth, err)
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	m := newTestServer(t)
+	c := newTestClient(t, m, "")
+
+	s := &AcctSession{
+		ID:       "0011223344556677",
+		Username: "aa:bb:cc:dd:ee:ff",
+		MAC:      "aa:bb:cc:dd:ee:ff",
+		IPv4:     net.ParseIP("100.64.0.10"),
+		Iface:    3,
+		Started:  time.Now().Add(-2 * time.Minute),
+		Class:    []byte("plan-42"),
+		Input:    Counter{Packets: 10, Bytes: 5<<32 + 100},
+		Output:   Counter{Packets: 20, Bytes: 200},
+	}
+	c.Start(s)
+	c.Interim(s)
+	c.Stop(s, CauseUserRequest)
+
+	acct := m.WaitAccounting(3, 2*time.Second)
+	if len(acct) != 3 {
+		t.Fatalf("server got %d Accounting-Requests, want 3", len(acct))
+	}
+
+	for i, status := range []string{"Start", "Interim-Update", "Stop"} {
+		a := acct[i]
+		if a.Status != status {
+			t.Errorf("request %d status = %s, want %s", i, a.Status, status)
+		}
+		if a.SessionID != s.ID || a.Username != s.Username || a.NASPort != 3 ||
+			!a.IPv4.Equal(s.IPv4) || string(a.Class) != "plan-42" {
+			t.Errorf("request %d = %+v, want attributes of session", i, a)
+		}
+	}
+
+	if acct[0].InputBytes != 0 || acct[0].SessionTime != 0 {
+		t.Errorf("Start = %+v, want no counters", acct[0])
+	}
+	for _, a := range acct[1:] {
+		if a.InputBytes != s.Input.Bytes || a.OutputBytes != s.Output.Bytes {
+			t.Errorf("%s bytes = %d/%d, want %d/%d", a.Status, a.InputBytes, a.OutputBytes, s.Input.Bytes, s.Output.Bytes)
+		}
+		if a.SessionTime < 119 {
+			t.Errorf("%s session time = %d, want 120", a.Status, a.SessionTime)
+		}
+	}
+	if acct[1].Cause != "" || acct[2].Cause != "User-Request" {
+		t.Errorf("terminate causes = %q, %q, want none and User-Request", acct[1].Cause, acct[2].Cause)
+	}
+}
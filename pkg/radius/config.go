@@ -0,0 +1,74 @@
+package radius
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// RADIUS related configuration, AAA is disabled when servers and DAEListen
+// are empty
+type Config struct {
+	// Access-Requests are sent to AuthServer and accounting requests to
+	// AcctServer, as host:port. Leases are accepted without authentication
+	// when AuthServer is empty and accounting is disabled when AcctServer is.
+	AuthServer string
+	AcctServer string
+	Secret     string
+	// NAS-Identifier sent in every request, not sent when empty
+	NASIdentifier string
+	// User-Name template, {circuit-id}, {remote-id} and {mac} are replaced
+	// with values of the DHCP query. It's {mac} when empty.
+	Username string
+	// User-Password of Access-Requests, username is used when empty
+	Password string
+	// Seconds waiting for a reply and between retransmissions, 3 and 1 when
+	// zero
+	Timeout int
+	Retry   int
+	// Seconds between Interim-Update of active sessions, disabled when zero
+	InterimInterval int
+	// Listen address of CoA and Disconnect requests of RFC 5176, usually
+	// port 3799, disabled when empty. Requests are signed with Secret.
+	DAEListen string
+}
+
+// Username placeholders
+const (
+	UsernameCircuitID = "{circuit-id}"
+	UsernameRemoteID  = "{remote-id}"
+	UsernameMAC       = "{mac}"
+)
+
+// IsEnabled reports if a RADIUS server is configured
+func (c *Config) IsEnabled() bool {
+	return c.AuthServer != "" || c.AcctServer != "" || c.DAEListen != ""
+}
+
+// Validate checks configuration values before using them
+func (c *Config) Validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+
+	for _, server := range []string{c.AuthServer, c.AcctServer, c.DAEListen} {
+		if server == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return errors.New("radius server " + server + " is not host:port")
+		}
+	}
+	if c.Secret == "" {
+		return errors.New("radius.Secret is empty")
+	}
+	if c.Timeout < 0 || c.Retry < 0 || c.InterimInterval < 0 {
+		return errors.New("radius timers are negative")
+	}
+	if c.Username != "" && !strings.Contains(c.Username, UsernameCircuitID) &&
+		!strings.Contains(c.Username, UsernameRemoteID) && !strings.Contains(c.Username, UsernameMAC) {
+		return errors.New("radius.Username has no " + UsernameCircuitID + ", " + UsernameRemoteID + " or " + UsernameMAC)
+	}
+
+	return nil
+}
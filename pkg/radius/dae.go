@@ -0,0 +1,232 @@
+package radius
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	rad "layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/rfc3576"
+)
+
+// Errors of DAEHandler, they are replied with their Error-Cause
+var (
+	// No session matches identification attributes, Session-Context-Not-Found
+	ErrSessionNotFound = errors.New("session not found")
+	// An attribute can not be applied, Invalid-Attribute-Value
+	ErrInvalidAttribute = errors.New("invalid attribute value")
+)
+
+// Invalid-Attribute-Value of RFC 5176, missing in RFC 3576 values
+const errorCauseInvalidAttributeValue rfc3576.ErrorCause = 407
+
+// SessionID holds identification attributes of a CoA or Disconnect request,
+// sessions must match every attribute present
+type SessionID struct {
+	// Framed-IP-Address
+	IPv4 net.IP
+	// Acct-Session-Id
+	AcctSessionID string
+	// User-Name
+	Username string
+	// Agent-Circuit-Id
+	CircuitID string
+	// NAS-Port-Id, flex-id of the CPE interface
+	FlexID string
+}
+
+// IsEmpty reports if request has no identification attribute
+func (id *SessionID) IsEmpty() bool {
+	return id.IPv4 == nil && id.AcctSessionID == "" && id.Username == "" && id.CircuitID == "" && id.FlexID == ""
+}
+
+// DAEHandler applies requests of a Dynamic Authorization Client to sessions
+type DAEHandler interface {
+	// ChangeAuthorization applies attributes of a CoA-Request to sessions
+	ChangeAuthorization(id *SessionID, auth *Authorization) error
+	// Disconnect removes sessions of a Disconnect-Request
+	Disconnect(id *SessionID) error
+}
+
+// Attributes accepted in CoA and Disconnect requests, others are replied with
+// Unsupported-Attribute
+var daeAttributes = map[rad.Type]bool{
+	rfc2865.UserName_Type:             true,
+	rfc2865.NASIPAddress_Type:         true,
+	rfc2865.NASIdentifier_Type:        true,
+	rfc2865.FramedIPAddress_Type:      true,
+	rfc2865.FilterID_Type:             true,
+	rfc2865.SessionTimeout_Type:       true,
+	rfc2865.Class_Type:                true,
+	rfc2865.State_Type:                true,
+	rfc2865.ProxyState_Type:           true,
+	rfc2865.VendorSpecific_Type:       true,
+	rfc2866.AcctSessionID_Type:        true,
+	rfc2869.EventTimestamp_Type:       true,
+	rfc2869.MessageAuthenticator_Type: true,
+	rfc2869.NASPortID_Type:            true,
+	rfc2869.FramedPool_Type:           true,
+}
+
+// DAEServer is a Dynamic Authorization Server of RFC 5176, it listens for
+// CoA-Request and Disconnect-Request
+type DAEServer struct {
+	Addr    string
+	config  Config
+	handler DAEHandler
+	conn    net.PacketConn
+	server  *rad.PacketServer
+	done    chan struct{}
+}
+
+func (d *DAEServer) Init(config *Config, handler DAEHandler) error {
+	d.config = *config
+	d.handler = handler
+
+	var err error
+	d.conn, err = net.ListenPacket("udp", config.DAEListen)
+	if err != nil {
+		return err
+	}
+	d.Addr = d.conn.LocalAddr().String()
+
+	d.server = &rad.PacketServer{
+		SecretSource: rad.StaticSecretSource([]byte(config.Secret)),
+		Handler:      rad.HandlerFunc(d.serve),
+	}
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		if err := d.server.Serve(d.conn); err != nil && !errors.Is(err, rad.ErrServerShutdown) {
+			log.Printf("Error serving CoA and Disconnect requests, %s", err.Error())
+		}
+	}()
+
+	return nil
+}
+
+func (d *DAEServer) Close() {
+	if d.server == nil {
+		return
+	}
+	d.server.Shutdown(context.Background())
+	d.conn.Close()
+	<-d.done
+}
+
+// sessionID reads identification attributes of a request
+func sessionID(p *rad.Packet) *SessionID {
+	id := &SessionID{
+		IPv4:          rfc2865.FramedIPAddress_Get(p),
+		AcctSessionID: rfc2866.AcctSessionID_GetString(p),
+		Username:      rfc2865.UserName_GetString(p),
+		FlexID:        rfc2869.NASPortID_GetString(p),
+	}
+	if v := vendorStrings(p, vendorDSLForum, dslAgentCircuit); len(v) > 0 {
+		id.CircuitID = v[0]
+	}
+
+	return id
+}
+
+// checkRequest returns Error-Cause of a request which can not be applied
+func (d *DAEServer) checkRequest(p *rad.Packet, id *SessionID) (rfc3576.ErrorCause, error) {
+	for typ := range p.Attributes {
+		if !daeAttributes[typ] {
+			return rfc3576.ErrorCause_Value_UnsupportedAttribute, fmt.Errorf("attribute %d not supported", typ)
+		}
+	}
+	if err := checkVendorAttributes(p); err != nil {
+		return rfc3576.ErrorCause_Value_UnsupportedAttribute, err
+	}
+	if nasID := rfc2865.NASIdentifier_GetString(p); nasID != "" && nasID != d.config.NASIdentifier {
+		return rfc3576.ErrorCause_Value_NASIdentificationMismatch, fmt.Errorf("NAS-Identifier %s is not %s", nasID, d.config.NASIdentifier)
+	}
+	if id.IsEmpty() {
+		return rfc3576.ErrorCause_Value_MissingAttribute, errors.New("no session identification attribute")
+	}
+
+	return 0, nil
+}
+
+// checkVendorAttributes returns an error when a request has Vendor-Specific
+// attributes other than Agent-Circuit-Id and the Cisco-AVPair of the VRF
+func checkVendorAttributes(p *rad.Packet) error {
+	for _, a := range p.Attributes[rfc2865.VendorSpecific_Type] {
+		vendor, vsa, err := rad.VendorSpecific(a)
+		if err != nil {
+			return err
+		}
+		for len(vsa) >= 2 && int(vsa[1]) >= 2 && int(vsa[1]) <= len(vsa) {
+			typ, value := vsa[0], string(vsa[2:vsa[1]])
+			switch {
+			case vendor == vendorDSLForum && typ == dslAgentCircuit:
+			case vendor == vendorCisco && typ == ciscoAVPair && strings.HasPrefix(value, avPairVRF):
+			case vendor == vendorCisco && typ == ciscoAVPair:
+				return fmt.Errorf("Cisco-AVPair %s not supported", value)
+			default:
+				return fmt.Errorf("attribute %d of vendor %d not supported", typ, vendor)
+			}
+			vsa = vsa[vsa[1]:]
+		}
+	}
+
+	return nil
+}
+
+// errorCause returns Error-Cause of an error returned by handler
+func errorCause(err error) rfc3576.ErrorCause {
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		return rfc3576.ErrorCause_Value_SessionContextNotFound
+	case errors.Is(err, ErrInvalidAttribute):
+		return errorCauseInvalidAttributeValue
+	}
+	return rfc3576.ErrorCause_Value_ResourcesUnavailable
+}
+
+func (d *DAEServer) serve(w rad.ResponseWriter, r *rad.Request) {
+	var ack, nak rad.Code
+	switch r.Code {
+	case rad.CodeCoARequest:
+		ack, nak = rad.CodeCoAACK, rad.CodeCoANAK
+	case rad.CodeDisconnectRequest:
+		ack, nak = rad.CodeDisconnectACK, rad.CodeDisconnectNAK
+	default:
+		return
+	}
+
+	id := sessionID(r.Packet)
+	cause, err := d.checkRequest(r.Packet, id)
+	if err == nil {
+		if r.Code == rad.CodeCoARequest {
+			err = d.handler.ChangeAuthorization(id, authorizationFromPacket(r.Packet, ""))
+		} else {
+			err = d.handler.Disconnect(id)
+		}
+		if err != nil {
+			cause = errorCause(err)
+		}
+	}
+
+	resp := r.Response(ack)
+	if err != nil {
+		log.Printf("%s from %s rejected, %s", r.Code.String(), r.RemoteAddr.String(), err.Error())
+		resp = r.Response(nak)
+		rfc3576.ErrorCause_Set(resp, cause)
+	}
+	// Proxy-State is echoed in order
+	for _, a := range r.Attributes[rfc2865.ProxyState_Type] {
+		resp.Add(rfc2865.ProxyState_Type, a)
+	}
+
+	if err := w.Write(resp); err != nil {
+		log.Printf("Error replying %s, %s", r.Code.String(), err.Error())
+	}
+}
@@ -0,0 +1,133 @@
+package radius
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	rad "layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc3576"
+)
+
+// fakeDAEHandler is called by the goroutines of the server
+type fakeDAEHandler struct {
+	mu   sync.Mutex
+	auth []*Authorization
+	err  error
+}
+
+func (h *fakeDAEHandler) ChangeAuthorization(id *SessionID, auth *Authorization) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.auth = append(h.auth, auth)
+	return h.err
+}
+
+func (h *fakeDAEHandler) Disconnect(id *SessionID) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.err
+}
+
+func (h *fakeDAEHandler) authorizations() []*Authorization {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]*Authorization(nil), h.auth...)
+}
+
+func (h *fakeDAEHandler) setError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.err = err
+}
+
+func newTestDAE(t *testing.T, h DAEHandler) *MockDAC {
+	t.Helper()
+
+	var d DAEServer
+	if err := d.Init(&Config{DAEListen: "127.0.0.1:0", Secret: "secret"}, h); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	t.Cleanup(d.Close)
+
+	return &MockDAC{Addr: d.Addr, Secret: "secret"}
+}
+
+func TestDAECoA(t *testing.T) {
+	h := &fakeDAEHandler{}
+	dac := newTestDAE(t, h)
+
+	id := &SessionID{IPv4: net.ParseIP("100.64.0.10")}
+	want := &Authorization{Profile: InterfaceDefault, Pool: "pool-b", VRF: "isp-b", SessionTimeout: time.Hour}
+	reply, err := dac.CoA(id, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reply.ACK {
+		t.Fatalf("CoA reply = %+v, want ACK", reply)
+	}
+	if auth := h.authorizations(); len(auth) != 1 || !reflect.DeepEqual(auth[0], want) {
+		t.Errorf("handler got %+v, want %+v", auth, want)
+	}
+
+	h.setError(fmt.Errorf("%w, unknown VRF", ErrInvalidAttribute))
+	if reply, err := dac.CoA(id, want); err != nil || reply.ACK || reply.Cause != errorCauseInvalidAttributeValue.String() {
+		t.Errorf("CoA reply = %+v, %v, want NAK %s", reply, err, errorCauseInvalidAttributeValue.String())
+	}
+	if reply, err := dac.CoA(&SessionID{}, want); err != nil || reply.Cause != rfc3576.ErrorCause_Value_MissingAttribute.String() {
+		t.Errorf("CoA reply without identification = %+v, %v, want NAK Missing-Attribute", reply, err)
+	}
+}
+
+func TestDAEUnsupportedAttributes(t *testing.T) {
+	var d DAEServer
+	id := &SessionID{IPv4: net.ParseIP("100.64.0.10")}
+
+	tests := []struct {
+		name    string
+		add     func(p *rad.Packet)
+		wantErr bool
+	}{
+		{name: "supported", add: func(p *rad.Packet) {
+			rfc2865.FilterID_SetString(p, "gold")
+			addVendorString(p, vendorCisco, ciscoAVPair, avPairVRF+"isp-b")
+			addVendorString(p, vendorDSLForum, dslAgentCircuit, "cpe1")
+		}},
+		{name: "callback number", add: func(p *rad.Packet) {
+			rfc2865.CallbackNumber_SetString(p, "1234")
+		}, wantErr: true},
+		{name: "other Cisco-AVPair", add: func(p *rad.Packet) {
+			addVendorString(p, vendorCisco, ciscoAVPair, "subscriber:command=account-logoff")
+		}, wantErr: true},
+		{name: "remote-id", add: func(p *rad.Packet) {
+			addVendorString(p, vendorDSLForum, dslAgentRemoteID, "olt1")
+		}, wantErr: true},
+		{name: "other vendor", add: func(p *rad.Packet) {
+			addVendorString(p, 14988, 8, "rate-limit")
+		}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := rad.New(rad.CodeCoARequest, []byte("secret"))
+			rfc2865.FramedIPAddress_Set(p, id.IPv4)
+			tt.add(p)
+
+			cause, err := d.checkRequest(p, id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkRequest() error = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr && cause != rfc3576.ErrorCause_Value_UnsupportedAttribute {
+				t.Errorf("Error-Cause = %s, want Unsupported-Attribute", cause.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package radius
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	rad "layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/rfc3576"
+)
+
+// MockUser is a subscriber known by MockServer, its attributes are returned
+// in Access-Accept
+type MockUser struct {
+	// Checked when not empty
+	Password       string
+	Profile        string
+	Pool           string
+	VRF            string
+	SessionTimeout time.Duration
+	Class          []byte
+}
+
+// MockAccess is an Access-Request received by MockServer
+type MockAccess struct {
+	Username  string
+	NASPort   int
+	MAC       string
+	IPv4      net.IP
+	CircuitID string
+	RemoteID  string
+	Accepted  bool
+}
+
+// MockAcct is an Accounting-Request received by MockServer
+type MockAcct struct {
+	Status      string
+	SessionID   string
+	Username    string
+	NASPort     int
+	IPv4        net.IP
+	Class       []byte
+	SessionTime int
+	Cause       string
+	InputBytes  uint64
+	OutputBytes uint64
+}
+
+// MockServer is an in-process RADIUS server listening on loopback. It
+// accepts known users and records requests so they can be inspected.
+type MockServer struct {
+	secret   []byte
+	users    map[string]MockUser
+	access   []MockAccess
+	acct     []MockAcct
+	authConn net.PacketConn
+	acctConn net.PacketConn
+	servers  []*rad.PacketServer
+	mu       sync.Mutex
+}
+
+// NewMockServer starts authentication and accounting servers on random
+// loopback ports
+func NewMockServer(secret string) (*MockServer, error) {
+	m := &MockServer{secret: []byte(secret), users: make(map[string]MockUser)}
+
+	var err error
+	if m.authConn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	if m.acctConn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		m.authConn.Close()
+		return nil, err
+	}
+
+	for _, s := range []struct {
+		conn    net.PacketConn
+		handler rad.HandlerFunc
+	}{{m.authConn, m.serveAuth}, {m.acctConn, m.serveAcct}} {
+		server := &rad.PacketServer{SecretSource: rad.StaticSecretSource(m.secret), Handler: s.handler}
+		m.servers = append(m.servers, server)
+		go server.Serve(s.conn)
+	}
+
+	return m, nil
+}
+
+// AuthAddr returns address of authentication server
+func (m *MockServer) AuthAddr() string {
+	return m.authConn.LocalAddr().String()
+}
+
+// AcctAddr returns address of accounting server
+func (m *MockServer) AcctAddr() string {
+	return m.acctConn.LocalAddr().String()
+}
+
+// Config returns a client configuration pointing to MockServer
+func (m *MockServer) Config() *Config {
+	return &Config{AuthServer: m.AuthAddr(), AcctServer: m.AcctAddr(), Secret: string(m.secret), Timeout: 1}
+}
+
+// SetUser adds or replaces a known user
+func (m *MockServer) SetUser(name string, u MockUser) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users[name] = u
+}
+
+// DeleteUser makes a user be rejected
+func (m *MockServer) DeleteUser(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.users, name)
+}
+
+// Access returns Access-Requests received, in order
+func (m *MockServer) Access() []MockAccess {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]MockAccess(nil), m.access...)
+}
+
+// Accounting returns Accounting-Requests received, in order
+func (m *MockServer) Accounting() []MockAcct {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]MockAcct(nil), m.acct...)
+}
+
+// WaitAccounting waits until n Accounting-Requests were received, accounting
+// is sent in background. It returns the ones received at timeout.
+func (m *MockServer) WaitAccounting(n int, timeout time.Duration) []MockAcct {
+	deadline := time.Now().Add(timeout)
+	for {
+		acct := m.Accounting()
+		if len(acct) >= n || time.Now().After(deadline) {
+			return acct
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (m *MockServer) Close() {
+	for _, s := range m.servers {
+		s.Shutdown(context.Background())
+	}
+	m.authConn.Close()
+	m.acctConn.Close()
+}
+
+func (m *MockServer) serveAuth(w rad.ResponseWriter, r *rad.Request) {
+	if r.Code != rad.CodeAccessRequest {
+		return
+	}
+
+	req := MockAccess{
+		Username: rfc2865.UserName_GetString(r.Packet),
+		NASPort:  int(rfc2865.NASPort_Get(r.Packet)),
+		MAC:      rfc2865.CallingStationID_GetString(r.Packet),
+		IPv4:     rfc2865.FramedIPAddress_Get(r.Packet),
+	}
+	if v := vendorStrings(r.Packet, vendorDSLForum, dslAgentCircuit); len(v) > 0 {
+		req.CircuitID = v[0]
+	}
+	if v := vendorStrings(r.Packet, vendorDSLForum, dslAgentRemoteID); len(v) > 0 {
+		req.RemoteID = v[0]
+	}
+
+	m.mu.Lock()
+	u, ok := m.users[req.Username]
+	if ok && u.Password != "" {
+		ok = u.Password == rfc2865.UserPassword_GetString(r.Packet)
+	}
+	req.Accepted = ok
+	m.access = append(m.access, req)
+	m.mu.Unlock()
+
+	if !ok {
+		resp := r.Response(rad.CodeAccessReject)
+		rfc2865.ReplyMessage_SetString(resp, "unknown user")
+		w.Write(resp)
+		return
+	}
+
+	resp := r.Response(rad.CodeAccessAccept)
+	if u.Profile != "" {
+		rfc2865.FilterID_SetString(resp, u.Profile)
+	}
+	if u.Pool != "" {
+		rfc2869.FramedPool_SetString(resp, u.Pool)
+	}
+	if u.SessionTimeout > 0 {
+		rfc2865.SessionTimeout_Set(resp, rfc2865.SessionTimeout(u.SessionTimeout/time.Second))
+	}
+	if len(u.Class) > 0 {
+		rfc2865.Class_Set(resp, u.Class)
+	}
+	if u.VRF != "" {
+		addVendorString(resp, vendorCisco, ciscoAVPair, avPairVRF+u.VRF)
+	}
+	w.Write(resp)
+}
+
+func (m *MockServer) serveAcct(w rad.ResponseWriter, r *rad.Request) {
+	if r.Code != rad.CodeAccountingRequest {
+		return
+	}
+
+	req := MockAcct{
+		Status:      rfc2866.AcctStatusType_Get(r.Packet).String(),
+		SessionID:   rfc2866.AcctSessionID_GetString(r.Packet),
+		Username:    rfc2865.UserName_GetString(r.Packet),
+		NASPort:     int(rfc2865.NASPort_Get(r.Packet)),
+		IPv4:        rfc2865.FramedIPAddress_Get(r.Packet),
+		Class:       rfc2865.Class_Get(r.Packet),
+		SessionTime: int(rfc2866.AcctSessionTime_Get(r.Packet)),
+		InputBytes:  uint64(rfc2869.AcctInputGigawords_Get(r.Packet))<<32 | uint64(rfc2866.AcctInputOctets_Get(r.Packet)),
+		OutputBytes: uint64(rfc2869.AcctOutputGigawords_Get(r.Packet))<<32 | uint64(rfc2866.AcctOutputOctets_Get(r.Packet)),
+	}
+	if _, err := rfc2866.AcctTerminateCause_Lookup(r.Packet); err == nil {
+		req.Cause = rfc2866.AcctTerminateCause_Get(r.Packet).String()
+	}
+
+	m.mu.Lock()
+	m.acct = append(m.acct, req)
+	m.mu.Unlock()
+
+	w.Write(r.Response(rad.CodeAccountingResponse))
+}
+
+// MockDAC is a Dynamic Authorization Client, it sends CoA and Disconnect
+// requests to a DAEServer as a billing system would
+type MockDAC struct {
+	Addr   string
+	Secret string
+}
+
+// MockDAEReply is the answer of a DAEServer
+type MockDAEReply struct {
+	ACK bool
+	// Error-Cause of a NAK
+	Cause string
+}
+
+// CoA sends a CoA-Request with identification attributes and new
+// attributes of sessions
+func (d *MockDAC) CoA(id *SessionID, auth *Authorization) (MockDAEReply, error) {
+	p := d.request(rad.CodeCoARequest, id)
+	if auth.Profile != "" {
+		rfc2865.FilterID_SetString(p, auth.Profile)
+	}
+	if auth.Pool != "" {
+		rfc2869.FramedPool_SetString(p, auth.Pool)
+	}
+	if auth.SessionTimeout > 0 {
+		rfc2865.SessionTimeout_Set(p, rfc2865.SessionTimeout(auth.SessionTimeout/time.Second))
+	}
+	if len(auth.Class) > 0 {
+		rfc2865.Class_Set(p, auth.Class)
+	}
+	if auth.VRF != "" {
+		addVendorString(p, vendorCisco, ciscoAVPair, avPairVRF+auth.VRF)
+	}
+
+	return d.exchange(p, rad.CodeCoAACK)
+}
+
+// Disconnect sends a Disconnect-Request with identification attributes
+func (d *MockDAC) Disconnect(id *SessionID) (MockDAEReply, error) {
+	return d.exchange(d.request(rad.CodeDisconnectRequest, id), rad.CodeDisconnectACK)
+}
+
+func (d *MockDAC) request(code rad.Code, id *SessionID) *rad.Packet {
+	p := rad.New(code, []byte(d.Secret))
+	if id.IPv4 != nil {
+		rfc2865.FramedIPAddress_Set(p, id.IPv4)
+	}
+	if id.AcctSessionID != "" {
+		rfc2866.AcctSessionID_SetString(p, id.AcctSessionID)
+	}
+	if id.Username != "" {
+		rfc2865.UserName_SetString(p, id.Username)
+	}
+	if id.FlexID != "" {
+		rfc2869.NASPortID_SetString(p, id.FlexID)
+	}
+	addVendorString(p, vendorDSLForum, dslAgentCircuit, id.CircuitID)
+
+	return p
+}
+
+func (d *MockDAC) exchange(p *rad.Packet, ack rad.Code) (MockDAEReply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := rad.Exchange(ctx, p, d.Addr)
+	if err != nil {
+		return MockDAEReply{}, err
+	}
+
+	r := MockDAEReply{ACK: reply.Code == ack}
+	if !r.ACK {
+		r.Cause = rfc3576.ErrorCause_Get(reply).String()
+	}
+
+	return r, nil
+}
@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client consumes the API served by glubngd
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient returns a client for an API listening in addr, addr can be
+// host:port or an URL
+func NewClient(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	return &Client{
+		baseURL: strings.TrimSuffix(addr, "/") + apiPrefix,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) ListSessions() ([]Session, error) {
+	var sessions []Session
+	err := c.do(http.MethodGet, "/sessions", &sessions)
+	return sessions, err
+}
+
+func (c *Client) GetSession(key string) (Session, error) {
+	var ses Session
+	err := c.do(http.MethodGet, "/sessions/"+url.PathEscape(key), &ses)
+	return ses, err
+}
+
+func (c *Client) DeleteSession(key string) error {
+	return c.do(http.MethodDelete, "/sessions/"+url.PathEscape(key), nil)
+}
+
+func (c *Client) ListInterfaces() ([]Interface, error) {
+	var ifaces []Interface
+	err := c.do(http.MethodGet, "/interfaces", &ifaces)
+	return ifaces, err
+}
+
+// Config returns configuration as served by glubngd
+func (c *Client) Config() (json.RawMessage, error) {
+	var config json.RawMessage
+	err := c.do(http.MethodGet, "/config", &config)
+	return config, err
+}
+
+func (c *Client) Health() (Health, error) {
+	var h Health
+	err := c.do(http.MethodGet, "/health", &h)
+	return h, err
+}
+
+func (c *Client) do(method string, path string, out interface{}) error {
+	req, err := http.NewRequest(method, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Health is reported with its body even when degraded
+	if resp.StatusCode >= 400 && !(resp.StatusCode == http.StatusServiceUnavailable && path == "/health") {
+		var e errorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return fmt.Errorf("%s (%d)", e.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -1 +1,281 @@
 package rest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const apiPrefix = "/api/v1"
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// ErrNotFound is returned by backends when a resource does not exist
+var ErrNotFound = errors.New("not found")
+
+// Backend exposes glubngd state to the API
+type Backend interface {
+	ListSessions() []Session
+	GetSession(key string) (Session, error)
+	DeleteSession(key string) error
+	ListInterfaces() []Interface
+	Config() interface{}
+	Health() Health
+}
+
+// Session is identified by its key, IPv4 address or, in IPv6 only sessions,
+// IPv6 address or delegated prefix
+type Session struct {
+	Key               string    `json:"key"`
+	Iface             int       `json:"iface"`
+	IPv4              string    `json:"ipv4"`
+	State             string    `json:"state"`
+	Expires           time.Time `json:"expires"`
+	QuarantineUntil   time.Time `json:"quarantine-until"`
+	IPv6              string    `json:"ipv6"`
+	IPv6Expires       time.Time `json:"ipv6-expires"`
+	IPv6Prefix        string    `json:"ipv6-prefix"`
+	IPv6PrefixExpires time.Time `json:"ipv6-prefix-expires"`
+	Profile           string    `json:"profile"`
+	MAC               string    `json:"mac"`
+	Username          string    `json:"username"`
+	AcctSessionID     string    `json:"acct-session-id"`
+	VRF               string    `json:"vrf"`
+	Pool              string    `json:"pool"`
+	Deadline          time.Time `json:"deadline"`
+	InPackets         uint64    `json:"in-packets"`
+	InBytes           uint64    `json:"in-bytes"`
+	OutPackets        uint64    `json:"out-packets"`
+	OutBytes          uint64    `json:"out-bytes"`
+	CountersUpdated   time.Time `json:"counters-updated"`
+}
+
+type Interface struct {
+	Name        string `json:"name"`
+	SwIf        int    `json:"sw-if"`
+	VPPSrcIface int    `json:"vpp-src-iface"`
+	IsSubIf     bool   `json:"is-sub-if"`
+	HasQinQ     bool   `json:"has-qinq"`
+	OuterVLAN   int    `json:"outer-vlan"`
+	InnerVLAN   int    `json:"inner-vlan"`
+	MTU         uint32 `json:"mtu"`
+	FlexId      string `json:"flex-id"`
+	Profile     string `json:"profile"`
+	CircuitID   string `json:"circuit-id,omitempty"`
+	RemoteID    string `json:"remote-id,omitempty"`
+	Status      string `json:"status"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+type Health struct {
+	Status string    `json:"status"`
+	Vpp    VppHealth `json:"vpp"`
+	Kea    KeaHealth `json:"kea"`
+}
+
+type VppHealth struct {
+	Connected    bool `json:"connected"`
+	FailedIfaces int  `json:"failed-ifaces"`
+}
+
+type KeaHealth struct {
+	Socket    string `json:"socket"`
+	Listening bool   `json:"listening"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type Server struct {
+	backend  Backend
+	listener net.Listener
+	srv      *http.Server
+	wg       sync.WaitGroup
+}
+
+// Init starts serving the API in addr
+func (s *Server) Init(addr string, backend Backend) error {
+	s.backend = backend
+
+	var err error
+	s.listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.srv = &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 5 * time.Second}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.srv.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error serving REST API, %s", err.Error())
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) Close() {
+	if s.srv == nil {
+		return
+	}
+	s.srv.Close()
+	s.wg.Wait()
+}
+
+// Handler returns API routes, it can be used without Init
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPrefix+"/sessions", s.handleSessions)
+	mux.HandleFunc(apiPrefix+"/sessions/", s.handleSession)
+	mux.HandleFunc(apiPrefix+"/interfaces", s.handleInterfaces)
+	mux.HandleFunc(apiPrefix+"/config", s.handleConfig)
+	mux.HandleFunc(apiPrefix+"/health", s.handleHealth)
+	mux.HandleFunc(apiPrefix+"/openapi.json", s.handleOpenAPI)
+	// Prometheus scrapes its default path
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// NewHandler returns API routes for a backend
+func NewHandler(backend Backend) http.Handler {
+	s := &Server{backend: backend}
+	return s.Handler()
+}
+
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.backend.ListSessions())
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, apiPrefix+"/sessions/")
+	if !isSessionKey(key) {
+		writeError(w, http.StatusNotFound, ErrNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ses, err := s.backend.GetSession(key)
+		if err != nil {
+			writeError(w, statusFromError(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ses)
+	case http.MethodDelete:
+		if err := s.backend.DeleteSession(key); err != nil {
+			writeError(w, statusFromError(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		allowMethods(w, r, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// isSessionKey checks a session key has no path separators, except the one of
+// a delegated prefix
+func isSessionKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	if !strings.Contains(key, "/") {
+		return true
+	}
+	_, _, err := net.ParseCIDR(key)
+	return err == nil
+}
+
+func (s *Server) handleInterfaces(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.backend.ListInterfaces())
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.backend.Config())
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	h := s.backend.Health()
+	status := http.StatusOK
+	if h.Status != HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, h)
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// HealthStatus computes overall status from components
+func HealthStatus(h *Health) string {
+	if h.Vpp.Connected && h.Vpp.FailedIfaces == 0 && h.Kea.Listening {
+		return HealthOK
+	}
+	return HealthDegraded
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+func statusFromError(err error) int {
+	if errors.Is(err, ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding REST response, %s", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorResponse{Error: err.Error()})
+}
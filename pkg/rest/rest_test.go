@@ -0,0 +1,245 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeBackend struct {
+	sessions map[string]Session
+	ifaces   []Interface
+	health   Health
+	deleted  []string
+}
+
+func (b *fakeBackend) ListSessions() []Session {
+	list := []Session{}
+	for _, ses := range b.sessions {
+		list = append(list, ses)
+	}
+	return list
+}
+
+func (b *fakeBackend) GetSession(key string) (Session, error) {
+	ses, ok := b.sessions[key]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+	return ses, nil
+}
+
+func (b *fakeBackend) DeleteSession(key string) error {
+	if key == "100.64.0.99" {
+		return errors.New("VPP is not connected")
+	}
+	if _, ok := b.sessions[key]; !ok {
+		return ErrNotFound
+	}
+	delete(b.sessions, key)
+	b.deleted = append(b.deleted, key)
+	return nil
+}
+
+func (b *fakeBackend) ListInterfaces() []Interface {
+	return b.ifaces
+}
+
+func (b *fakeBackend) Config() interface{} {
+	return map[string]string{"SrcKeaSocket": "/run/kea/glubng.sock"}
+}
+
+func (b *fakeBackend) Health() Health {
+	h := b.health
+	h.Status = HealthStatus(&h)
+	return h
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		sessions: map[string]Session{
+			"100.64.0.10":       {Key: "100.64.0.10", Iface: 3, IPv4: "100.64.0.10", State: "active"},
+			"2001:db8:100::/56": {Key: "2001:db8:100::/56", Iface: 4, IPv6Prefix: "2001:db8:100::/56", State: "active"},
+		},
+		ifaces: []Interface{{Name: "cpe1", SwIf: 3, Status: "ok"}},
+		health: Health{
+			Vpp: VppHealth{Connected: true},
+			Kea: KeaHealth{Socket: "/run/kea/glubng.sock", Listening: true},
+		},
+	}
+}
+
+func serve(t *testing.T, b Backend, method string, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	NewHandler(b).ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q, %v", w.Body.String(), err)
+	}
+}
+
+func TestHandlerStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+		allow  string
+	}{
+		{name: "list sessions", method: http.MethodGet, path: "/api/v1/sessions", want: http.StatusOK},
+		{name: "get session", method: http.MethodGet, path: "/api/v1/sessions/100.64.0.10", want: http.StatusOK},
+		{name: "get delegated prefix", method: http.MethodGet, path: "/api/v1/sessions/2001:db8:100::/56", want: http.StatusOK},
+		{name: "get unknown session", method: http.MethodGet, path: "/api/v1/sessions/100.64.0.20", want: http.StatusNotFound},
+		{name: "get nested path", method: http.MethodGet, path: "/api/v1/sessions/100.64.0.10/routes", want: http.StatusNotFound},
+		{name: "get empty key", method: http.MethodGet, path: "/api/v1/sessions/", want: http.StatusNotFound},
+		{name: "delete session", method: http.MethodDelete, path: "/api/v1/sessions/100.64.0.10", want: http.StatusNoContent},
+		{name: "delete unknown session", method: http.MethodDelete, path: "/api/v1/sessions/100.64.0.20", want: http.StatusNotFound},
+		{name: "delete failure", method: http.MethodDelete, path: "/api/v1/sessions/100.64.0.99", want: http.StatusInternalServerError},
+		{name: "post session", method: http.MethodPost, path: "/api/v1/sessions/100.64.0.10", want: http.StatusMethodNotAllowed, allow: "GET, DELETE"},
+		{name: "post sessions", method: http.MethodPost, path: "/api/v1/sessions", want: http.StatusMethodNotAllowed, allow: "GET"},
+		{name: "list interfaces", method: http.MethodGet, path: "/api/v1/interfaces", want: http.StatusOK},
+		{name: "delete interfaces", method: http.MethodDelete, path: "/api/v1/interfaces", want: http.StatusMethodNotAllowed, allow: "GET"},
+		{name: "config", method: http.MethodGet, path: "/api/v1/config", want: http.StatusOK},
+		{name: "health", method: http.MethodGet, path: "/api/v1/health", want: http.StatusOK},
+		{name: "openapi", method: http.MethodGet, path: "/api/v1/openapi.json", want: http.StatusOK},
+		{name: "unknown path", method: http.MethodGet, path: "/api/v1/unknown", want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, newFakeBackend(), tt.method, tt.path)
+			if w.Code != tt.want {
+				t.Errorf("%s %s status = %d, want %d, body %s", tt.method, tt.path, w.Code, tt.want, w.Body.String())
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestHandlerSessions(t *testing.T) {
+	b := newFakeBackend()
+
+	var list []Session
+	decode(t, serve(t, b, http.MethodGet, "/api/v1/sessions"), &list)
+	if len(list) != 2 {
+		t.Errorf("listed %d sessions, want 2", len(list))
+	}
+
+	var ses Session
+	decode(t, serve(t, b, http.MethodGet, "/api/v1/sessions/2001:db8:100::/56"), &ses)
+	if !reflect.DeepEqual(ses, b.sessions["2001:db8:100::/56"]) {
+		t.Errorf("session = %+v, want %+v", ses, b.sessions["2001:db8:100::/56"])
+	}
+
+	var e errorResponse
+	decode(t, serve(t, b, http.MethodGet, "/api/v1/sessions/100.64.0.20"), &e)
+	if e.Error != ErrNotFound.Error() {
+		t.Errorf("error = %q, want %q", e.Error, ErrNotFound.Error())
+	}
+
+	if w := serve(t, b, http.MethodDelete, "/api/v1/sessions/100.64.0.10"); w.Body.Len() != 0 {
+		t.Errorf("delete body = %q, want empty", w.Body.String())
+	}
+	if !reflect.DeepEqual(b.deleted, []string{"100.64.0.10"}) {
+		t.Errorf("deleted sessions = %v, want 100.64.0.10", b.deleted)
+	}
+}
+
+func TestHandlerHealth(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(h *Health)
+		want   int
+		status string
+	}{
+		{name: "ok", change: func(h *Health) {}, want: http.StatusOK, status: HealthOK},
+		{name: "vpp disconnected", change: func(h *Health) { h.Vpp.Connected = false }, want: http.StatusServiceUnavailable, status: HealthDegraded},
+		{name: "failed interfaces", change: func(h *Health) { h.Vpp.FailedIfaces = 1 }, want: http.StatusServiceUnavailable, status: HealthDegraded},
+		{name: "kea not listening", change: func(h *Health) { h.Kea.Listening = false }, want: http.StatusServiceUnavailable, status: HealthDegraded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFakeBackend()
+			tt.change(&b.health)
+
+			w := serve(t, b, http.MethodGet, "/api/v1/health")
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			var h Health
+			decode(t, w, &h)
+			if h.Status != tt.status {
+				t.Errorf("health status = %q, want %q", h.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestHandlerInterfacesAndConfig(t *testing.T) {
+	b := newFakeBackend()
+
+	var ifaces []Interface
+	decode(t, serve(t, b, http.MethodGet, "/api/v1/interfaces"), &ifaces)
+	if !reflect.DeepEqual(ifaces, b.ifaces) {
+		t.Errorf("interfaces = %+v, want %+v", ifaces, b.ifaces)
+	}
+
+	var config map[string]string
+	decode(t, serve(t, b, http.MethodGet, "/api/v1/config"), &config)
+	if config["SrcKeaSocket"] != "/run/kea/glubng.sock" {
+		t.Errorf("config = %v, want SrcKeaSocket", config)
+	}
+
+	var doc map[string]interface{}
+	decode(t, serve(t, b, http.MethodGet, "/api/v1/openapi.json"), &doc)
+	if v, _ := doc["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		t.Errorf("openapi version = %v, want 3.x", doc["openapi"])
+	}
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	w := serve(t, newFakeBackend(), http.MethodGet, "/metrics")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+	if !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Errorf("metrics have no go_goroutines, body %s", w.Body.String())
+	}
+}
+
+func TestServer(t *testing.T) {
+	var s Server
+	if err := s.Init("127.0.0.1:0", newFakeBackend()); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer s.Close()
+
+	resp, err := http.Get("http://" + s.listener.Addr().String() + "/api/v1/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -3,18 +3,20 @@ package utils
 import (
 	"errors"
 	"strconv"
+	"strings"
 )
 
+// ConvertCIDToInt parses a circuit-id with a SwIf in hex, i.e. 0x00000001
 func ConvertCIDToInt(cid string) (int, error) {
 	// Test cid
-	if len(cid) < 6 {
+	if len(cid) < 6 || !strings.HasPrefix(cid, "0x") {
 		return 0, errors.New("malformed cid, " + cid)
 	}
 	// Remove 2 initial chars 0x
-	res, err := strconv.ParseInt(cid[2:], 16, 32)
+	res, err := strconv.ParseUint(cid[2:], 16, 32)
 
 	if err != nil {
-		return int(res), err
+		return 0, err
 	}
 
 	return int(res), nil
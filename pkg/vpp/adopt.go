@@ -0,0 +1,181 @@
+package vpp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+
+	"go.fd.io/govpp/api"
+	interfaces "go.fd.io/govpp/binapi/interface"
+	"go.fd.io/govpp/binapi/interface_types"
+)
+
+// Interfaces created by glubngd are tagged, so they are adopted instead of
+// created again when glubngd restarts without a VPP restart
+const (
+	tagPrefix     = "glubng:"
+	tagGwLoopback = tagPrefix + "gw-loopback"
+	tagDHCPTap    = tagPrefix + "dhcp-tap"
+	tagCPEPrefix  = tagPrefix + "cpe:"
+	maxTagLength  = 63
+)
+
+// cpeTag returns tag of a CPE interface. Long names are truncated and
+// suffixed with a hash of the whole name, so they do not collide.
+func cpeTag(name string) string {
+	tag := tagCPEPrefix + name
+	if len(tag) > maxTagLength {
+		sum := sha256.Sum256([]byte(name))
+		suffix := "~" + hex.EncodeToString(sum[:4])
+		tag = tag[:maxTagLength-len(suffix)] + suffix
+	}
+
+	return tag
+}
+
+// existingIfaces holds interfaces found in VPP before provisioning
+type existingIfaces struct {
+	all     map[int]*interfaces.SwInterfaceDetails
+	tagged  map[string]*interfaces.SwInterfaceDetails
+	adopted map[int]bool
+}
+
+// loadExisting dumps interfaces from VPP to adopt them while provisioning.
+// If dump fails, every object is created as on a fresh VPP.
+func (c *Client) loadExisting() error {
+	c.existing = nil
+
+	dump, err := c.dumpInterfaces(-1)
+	if err != nil {
+		return &ProvisionError{Object: "interfaces", Step: "dump existing", Err: err}
+	}
+
+	e := &existingIfaces{
+		all:     dump,
+		tagged:  make(map[string]*interfaces.SwInterfaceDetails),
+		adopted: make(map[int]bool),
+	}
+	for _, v := range dump {
+		if strings.HasPrefix(v.Tag, tagPrefix) {
+			e.tagged[v.Tag] = v
+		}
+	}
+	c.existing = e
+
+	return nil
+}
+
+// adopt returns SwIf of an existing interface with tag
+func (c *Client) adopt(tag string) (int, bool) {
+	if c.existing == nil {
+		return -1, false
+	}
+
+	v, ok := c.existing.tagged[tag]
+	if !ok {
+		return -1, false
+	}
+	c.existing.adopted[int(v.SwIfIndex)] = true
+	log.Printf("Adopting interface %s with SwIf %d tagged %s", v.InterfaceName, v.SwIfIndex, tag)
+
+	return int(v.SwIfIndex), true
+}
+
+// adoptSubInterface returns SwIf of an existing sub-interface of a CPE. A
+// tagged one not matching the CPE configuration is deleted, an untagged one
+// matching it, i.e. created by a version not tagging interfaces, is tagged.
+func (c *Client) adoptSubInterface(name string, parent int, subID int) (int, bool, error) {
+	if c.existing == nil {
+		return -1, false, nil
+	}
+
+	tag := cpeTag(name)
+	if v, ok := c.existing.tagged[tag]; ok {
+		if int(v.SupSwIfIndex) == parent && int(v.SubID) == subID {
+			swIf, _ := c.adopt(tag)
+			return swIf, true, nil
+		}
+
+		log.Printf("Deleting interface %s tagged %s, configuration changed", v.InterfaceName, tag)
+		if err := c.deleteSubInterface(int(v.SwIfIndex)); err != nil {
+			return -1, false, err
+		}
+		delete(c.existing.tagged, tag)
+		delete(c.existing.all, int(v.SwIfIndex))
+	}
+
+	for swIf, v := range c.existing.all {
+		if v.Tag != "" || int(v.SwIfIndex) == parent || int(v.SupSwIfIndex) != parent || int(v.SubID) != subID {
+			continue
+		}
+
+		log.Printf("Adopting untagged interface %s with SwIf %d", v.InterfaceName, swIf)
+		c.existing.adopted[swIf] = true
+		c.tagInterface(swIf, tag)
+		return swIf, true, nil
+	}
+
+	return -1, false, nil
+}
+
+// removeStale deletes CPE sub-interfaces tagged by glubngd which are no
+// longer in interfaces file
+func (c *Client) removeStale() error {
+	if c.existing == nil {
+		return nil
+	}
+
+	var errs ProvisionErrors
+	for tag, v := range c.existing.tagged {
+		if !strings.HasPrefix(tag, tagCPEPrefix) || c.existing.adopted[int(v.SwIfIndex)] {
+			continue
+		}
+
+		log.Printf("Deleting stale interface %s tagged %s", v.InterfaceName, tag)
+		if err := c.deleteSubInterface(int(v.SwIfIndex)); err != nil {
+			errs = append(errs, &ProvisionError{Object: "stale " + v.InterfaceName, Step: "delete sub-interface", Err: err})
+		}
+	}
+
+	return errs.OrNil()
+}
+
+// tagInterface tags an interface created by glubngd, a failure only prevents
+// adopting it on next start
+func (c *Client) tagInterface(swIf int, tag string) {
+	req := &interfaces.SwInterfaceTagAddDel{
+		IsAdd:     true,
+		SwIfIndex: interface_types.InterfaceIndex(swIf),
+		Tag:       tag,
+	}
+	reply := &interfaces.SwInterfaceTagAddDelReply{}
+
+	if err := c.request(req, reply); err != nil {
+		log.Printf("Error tagging interface SwIf %d as %s, %s", swIf, tag, err.Error())
+	}
+}
+
+// isVPPError reports if err is a VPP API error with one of retvals, used to
+// accept objects already configured by a previous run
+func isVPPError(err error, retvals ...api.VPPApiError) bool {
+	var e api.VPPApiError
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	for _, r := range retvals {
+		if e == r {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAddressConfigured reports if adding an address failed because it was
+// already on the interface
+func isAddressConfigured(err error) bool {
+	return isVPPError(err, api.ADDRESS_IN_USE, api.ADDRESS_FOUND_FOR_INTERFACE)
+}
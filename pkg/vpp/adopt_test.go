@@ -0,0 +1,42 @@
+package vpp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCPETag(t *testing.T) {
+	long := strings.Repeat("a", 60)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "cpe1", want: "glubng:cpe:cpe1"},
+		{name: long[:52], want: "glubng:cpe:" + long[:52]},
+	}
+	for _, tt := range tests {
+		if got := cpeTag(tt.name); got != tt.want {
+			t.Errorf("cpeTag(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Names sharing the truncated part get different tags
+	seen := make(map[string]string)
+	for _, name := range []string{long[:53], long + "1", long + "2", long + "12"} {
+		tag := cpeTag(name)
+		if len(tag) > maxTagLength {
+			t.Errorf("cpeTag(%q) has length %d, want at most %d", name, len(tag), maxTagLength)
+		}
+		if !strings.HasPrefix(tag, tagCPEPrefix) {
+			t.Errorf("cpeTag(%q) = %q, want prefix %q", name, tag, tagCPEPrefix)
+		}
+		if other, ok := seen[tag]; ok {
+			t.Errorf("cpeTag(%q) = cpeTag(%q) = %q", name, other, tag)
+		}
+		seen[tag] = name
+		if cpeTag(name) != tag {
+			t.Errorf("cpeTag(%q) is not stable", name)
+		}
+	}
+}
@@ -1,11 +1,19 @@
 package vpp
 
 import (
+	"fmt"
 	"log"
 	"net"
 	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.fd.io/govpp"
+	"go.fd.io/govpp/adapter"
 	"go.fd.io/govpp/api"
 	"go.fd.io/govpp/binapi/arp"
 	"go.fd.io/govpp/binapi/fib_types"
@@ -14,54 +22,330 @@ import (
 	"go.fd.io/govpp/core"
 )
 
+var (
+	metricRequestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "glubng_vpp_request_duration_seconds",
+		Help:    "Latency of VPP API requests by message.",
+		Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+	}, []string{"message"})
+	metricRequestFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "glubng_vpp_request_failures_total",
+		Help: "Failed VPP API requests by message.",
+	}, []string{"message"})
+)
+
 type Client struct {
 	config     VPPConfig
 	ifaces     map[string]Iface
 	ifacesSwIf map[int]Iface
+	ifacesMu   sync.RWMutex
 	ifacesFile string
 	conn       *core.Connection
-	ch         api.Channel
+	// API channels, a request takes one so requests of concurrent callers
+	// are not serialized. Replies of concurrent requests in the same channel
+	// are dropped.
+	channels   int
+	chans      chan api.Channel
 	gwLoopSwIf int
+	tapSwIf    int
+	connEv     chan core.ConnectionEvent
+	done       chan struct{}
+	connected  atomic.Bool
+	hooksMu    sync.Mutex
+	onConnect  []func(ev ReconnectEvent)
+	// Serializes changes of CPE interfaces
+	provisionMu sync.Mutex
+	statusMu    sync.Mutex
+	status      map[string]IfaceStatus
+	owned       ownership
+	// Interfaces found in VPP, only set while provisioning
+	existing *existingIfaces
+	// Provisioned CPE interfaces by CircuitID and RemoteID
+	ifacesRelay map[[2]string]Iface
+	// Service profiles applied by policers by SwIf, and set by leases of
+	// sessions on each SwIf in the order they were set
+	policerMu     sync.Mutex
+	policers      map[int]ServiceProfile
+	leaseProfiles map[int][]leaseProfile
+	// VRFs CPE interfaces were placed in by sessions, by SwIf
+	vrfMu     sync.Mutex
+	ifaceVRFs map[int]*ifaceVRF
+	// Stats segment and stats index of session routes by prefix
+	stats        adapter.StatsAPI
+	routeStatsMu sync.Mutex
+	routeStats   map[string]uint32
 }
 
-func (c *Client) Init(config *VPPConfig, ifacesFile string) {
+func (c *Client) Init(config *VPPConfig, ifacesFile string) error {
+	conn, connEv, err := govpp.AsyncConnect(config.SrcVPPSocket, core.DefaultMaxReconnectAttempts, core.DefaultReconnectInterval)
+	if err != nil {
+		return fmt.Errorf("async connect to VPP, %w", err)
+	}
+
+	// wait for Connected event
+	e := <-connEv
+	if e.State != core.Connected {
+		conn.Disconnect()
+		return fmt.Errorf("connecting to VPP failed, %v", e.Error)
+	}
+
+	return c.InitConn(config, ifacesFile, &Conn{API: conn, Events: connEv})
+}
+
+// Conn is an established connection to VPP
+type Conn struct {
+	API *core.Connection
+	// Events of the connection after it was established
+	Events chan core.ConnectionEvent
+	// Stats segment, SrcStatsSocket is connected when nil
+	Stats adapter.StatsAPI
+}
+
+// InitConn works like Init over an established connection, i.e. to an
+// in-memory VPP in tests. Connection is closed when it fails.
+func (c *Client) InitConn(config *VPPConfig, ifacesFile string, conn *Conn) error {
 	// Initialize all struct members
 	c.config = *config
 	c.ifacesFile = ifacesFile
+	c.conn = conn.API
+	c.connEv = conn.Events
+	c.done = make(chan struct{})
+	c.connected.Store(true)
+
+	if err := c.openChannels(); err != nil {
+		c.connected.Store(false)
+		c.conn.Disconnect()
+		return fmt.Errorf("creating VPP channel, %w", err)
+	}
 
-	conn, connEv, err := govpp.AsyncConnect(c.config.SrcVPPSocket, core.DefaultMaxReconnectAttempts, core.DefaultReconnectInterval)
+	// Load CPE Interface configurations
+	err := c.LoadIfacesConfig()
 	if err != nil {
-		log.Fatalln("Async connect to VPP", err)
+		c.connected.Store(false)
+		c.closeChannels()
+		c.conn.Disconnect()
+		return err
 	}
 
-	c.conn = conn
-	// wait for Connected event
-	e := <-connEv
-	if e.State != core.Connected {
-		log.Fatalln("Connecting to VPP failed:", e.Error)
+	// Keep watching connection events to detect VPP reconnections
+	go c.watchConnection()
+
+	// Counters are optional, VPP is configured without them
+	c.stats = conn.Stats
+	if c.stats == nil {
+		if err = c.connectStats(); err != nil {
+			log.Printf("Error connecting to VPP stats, counters are not collected, %s", err.Error())
+		}
+	}
+
+	// Configure VPP, failures are returned after configuring everything
+	// else, so caller decides if it can run degraded
+	c.provisionMu.Lock()
+	defer c.provisionMu.Unlock()
+
+	return c.provision()
+}
+
+// provision configures VPP from configuration and CPE interfaces
+func (c *Client) provision() error {
+	var errs ProvisionErrors
+	// Objects left by a previous run are adopted
+	errs = errs.Append(c.loadExisting())
+	errs = errs.Append(c.configUplinks())
+	errs = errs.Append(c.configProxyArp())
+	errs = errs.Append(c.configIPv4GwLoopback())
+	errs = errs.Append(c.configIPv6GwLoopback())
+	errs = errs.Append(c.configCPEInterfaces())
+	errs = errs.Append(c.configDHCPRelay())
+	errs = errs.Append(c.configDHCPv6Relay())
+	errs = errs.Append(c.removeStale())
+	c.existing = nil
+
+	return errs.OrNil()
+}
+
+// OnReconnect registers a function called every time the connection to VPP
+// is established again after being lost. When VPP lost its state, function
+// is called once configuration has been provisioned again.
+func (c *Client) OnReconnect(fn func(ev ReconnectEvent)) {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+	c.onConnect = append(c.onConnect, fn)
+}
+
+func (c *Client) watchConnection() {
+	for {
+		var e core.ConnectionEvent
+		select {
+		case <-c.done:
+			return
+		case e = <-c.connEv:
+		}
+
+		switch e.State {
+		case core.Connected:
+			log.Println("Connection to VPP established again")
+			c.connected.Store(true)
+
+			ev, err := c.reprovision()
+			if err != nil {
+				log.Printf("Error provisioning VPP after reconnection, %s", err.Error())
+			}
+
+			c.hooksMu.Lock()
+			hooks := append([]func(ReconnectEvent){}, c.onConnect...)
+			c.hooksMu.Unlock()
+
+			for _, fn := range hooks {
+				fn(ev)
+			}
+		case core.Disconnected, core.NotResponding:
+			c.connected.Store(false)
+			log.Printf("Connection to VPP lost, %v", e.Error)
+		case core.Failed:
+			c.connected.Store(false)
+			log.Printf("Reconnecting to VPP failed, %v", e.Error)
+		}
+	}
+}
+
+// reprovision configures VPP again if it lost its state, i.e. it was
+// restarted. CPE interfaces are recreated and may get a different SwIf.
+func (c *Client) reprovision() (ReconnectEvent, error) {
+	var ev ReconnectEvent
+
+	lost, err := c.lostState()
+	if err != nil || !lost {
+		return ev, err
+	}
+	log.Println("VPP lost its configuration, provisioning it again")
+
+	c.provisionMu.Lock()
+	defer c.provisionMu.Unlock()
+
+	oldIfaces := c.GetIfaces()
+	oldStatus := c.GetIfacesStatus()
+
+	// Objects created before were lost with VPP state
+	c.owned.reset()
+	c.resetPolicers()
+	c.resetVRFs()
+	c.resetRouteStats()
+	err = c.provision()
+
+	ev.Reprovisioned = true
+	ev.SwIfRemap = make(map[int]int)
+	ifaces := c.GetIfaces()
+	status := c.GetIfacesStatus()
+
+	for name, v := range oldIfaces {
+		if oldStatus[name].State != IfaceOK {
+			continue
+		}
+		if status[name].State != IfaceOK {
+			ev.SwIfRemap[v.SwIf] = -1
+		} else if ifaces[name].SwIf != v.SwIf {
+			ev.SwIfRemap[v.SwIf] = ifaces[name].SwIf
+		}
 	}
 
-	c.ch, err = c.conn.NewAPIChannel()
+	return ev, err
+}
+
+// lostState checks if interfaces created by provisioning still exist
+func (c *Client) lostState() (bool, error) {
+	created := map[int]string{}
+	if c.gwLoopSwIf >= 0 {
+		created[c.gwLoopSwIf] = "loop"
+	}
+	if c.tapSwIf >= 0 {
+		created[c.tapSwIf] = "tap"
+	}
+	if len(created) == 0 {
+		// Nothing to compare with, provisioning again is harmless
+		return true, nil
+	}
+
+	dump, err := c.dumpInterfaces(-1)
 	if err != nil {
-		log.Fatalf("Error creating channel failed, %s", err.Error())
+		return false, err
 	}
 
-	// Load CPE Interface configurations
-	c.LoadIfacesConfig()
+	for swIf, prefix := range created {
+		v, ok := dump[swIf]
+		if !ok || !strings.HasPrefix(v.InterfaceName, prefix) {
+			return true, nil
+		}
+	}
 
-	// Configure VPP
-	c.configProxyArp()
-	c.configIPv4GwLoopback()
-	c.configCPEInterfaces()
-	c.configDHCPRelay()
+	return false, nil
 }
 
 func (c *Client) Close() {
-	c.ch.Close()
+	close(c.done)
+	c.closeStats()
+	c.closeChannels()
 	c.conn.Disconnect()
 }
 
-func (c *Client) AddSession(ipv4 net.IP, iface uint32) {
+// SetChannels sets how many requests are sent concurrently, usually one per
+// worker processing events of Kea. It must be called before Init, one
+// channel is used otherwise.
+func (c *Client) SetChannels(n int) {
+	c.channels = n
+}
+
+func (c *Client) openChannels() error {
+	n := c.channels
+	if n < 1 {
+		n = 1
+	}
+
+	c.chans = make(chan api.Channel, n)
+	for i := 0; i < n; i++ {
+		ch, err := c.conn.NewAPIChannel()
+		if err != nil {
+			for len(c.chans) > 0 {
+				(<-c.chans).Close()
+			}
+			c.chans = nil
+			return err
+		}
+		c.chans <- ch
+	}
+
+	return nil
+}
+
+// closeChannels closes API channels, it waits for requests being sent
+func (c *Client) closeChannels() {
+	for i := 0; i < cap(c.chans); i++ {
+		(<-c.chans).Close()
+	}
+}
+
+// channel takes an API channel, it must be given back with release. It fails
+// once client is closed instead of waiting for channels being closed.
+func (c *Client) channel() (api.Channel, error) {
+	select {
+	case <-c.done:
+		return nil, ErrClosed
+	default:
+	}
+
+	select {
+	case ch := <-c.chans:
+		return ch, nil
+	case <-c.done:
+		return nil, ErrClosed
+	}
+}
+
+func (c *Client) release(ch api.Channel) {
+	c.chans <- ch
+}
+
+func (c *Client) AddSession(ipv4 net.IP, iface uint32) error {
 	log.Printf("Add session to VPP, IPv4: %s, SwIf: %d", ipv4.String(), iface)
 
 	vppip := ip_types.Address{
@@ -71,10 +355,10 @@ func (c *Client) AddSession(ipv4 net.IP, iface uint32) {
 		}),
 	}
 
-	c.addDelRouteToVPP(&vppip, iface, true)
+	return c.addDelRouteToVPP(ip_types.Prefix{Address: vppip, Len: 32}, iface, true)
 }
 
-func (c *Client) RemoveSession(ipv4 net.IP, iface uint32) {
+func (c *Client) RemoveSession(ipv4 net.IP, iface uint32) error {
 	log.Printf("Remove session from VPP, IPv4: %s, SwIf: %d", ipv4.String(), iface)
 
 	vppip := ip_types.Address{
@@ -84,38 +368,290 @@ func (c *Client) RemoveSession(ipv4 net.IP, iface uint32) {
 		}),
 	}
 
-	c.addDelRouteToVPP(&vppip, iface, false)
+	return c.addDelRouteToVPP(ip_types.Prefix{Address: vppip, Len: 32}, iface, false)
 }
 
+// AddSessionPrefix installs route to an IPv6 address, as a /128, or to a
+// delegated prefix of a session
+func (c *Client) AddSessionPrefix(prefix *net.IPNet, iface uint32) error {
+	log.Printf("Add session to VPP, prefix: %s, SwIf: %d", prefix.String(), iface)
+
+	return c.addDelRouteToVPP(toVPPPrefix(prefix), iface, true)
+}
+
+func (c *Client) RemoveSessionPrefix(prefix *net.IPNet, iface uint32) error {
+	log.Printf("Remove session from VPP, prefix: %s, SwIf: %d", prefix.String(), iface)
+
+	return c.addDelRouteToVPP(toVPPPrefix(prefix), iface, false)
+}
+
+func toVPPPrefix(prefix *net.IPNet) ip_types.Prefix {
+	ones, _ := prefix.Mask.Size()
+
+	return ip_types.Prefix{Address: ip_types.AddressFromIP(prefix.IP), Len: uint8(ones)}
+}
+
+// GetIfacesSwMap returns a copy of provisioned CPE interfaces indexed by SwIf
 func (c *Client) GetIfacesSwMap() map[int]Iface {
-	return c.ifacesSwIf
+	c.ifacesMu.RLock()
+	defer c.ifacesMu.RUnlock()
+
+	ifaces := make(map[int]Iface, len(c.ifacesSwIf))
+	for k, v := range c.ifacesSwIf {
+		ifaces[k] = v
+	}
+
+	return ifaces
+}
+
+// LookupIface returns a provisioned CPE interface by its SwIf
+func (c *Client) LookupIface(swIf int) (Iface, bool) {
+	c.ifacesMu.RLock()
+	defer c.ifacesMu.RUnlock()
+
+	v, ok := c.ifacesSwIf[swIf]
+	return v, ok
 }
 
-func (c *Client) addDelRouteToVPP(ipv4 *ip_types.Address, iface uint32, isAdd bool) error {
-	path := fib_types.FibPath{SwIfIndex: iface}
+// LookupIfaceName returns a provisioned CPE interface by its name in
+// interfaces.toml
+func (c *Client) LookupIfaceName(name string) (Iface, bool) {
+	c.ifacesMu.RLock()
+	defer c.ifacesMu.RUnlock()
+
+	v, ok := c.ifaces[name]
+	if !ok {
+		return Iface{}, false
+	}
+	v, ok = c.ifacesSwIf[v.SwIf]
+	return v, ok
+}
+
+// LookupRelayIDs returns a provisioned CPE interface by relay agent ids of a
+// query. Interfaces with both ids are preferred over the ones with only one.
+func (c *Client) LookupRelayIDs(circuitID string, remoteID string) (Iface, bool) {
+	c.ifacesMu.RLock()
+	defer c.ifacesMu.RUnlock()
+
+	for _, key := range [][2]string{{circuitID, remoteID}, {circuitID, ""}, {"", remoteID}} {
+		if key[0] == "" && key[1] == "" {
+			continue
+		}
+		if v, ok := c.ifacesRelay[key]; ok {
+			return v, true
+		}
+	}
+
+	return Iface{}, false
+}
+
+// GetIfaces returns a copy of CPE interfaces indexed by their name in
+// interfaces.toml
+func (c *Client) GetIfaces() map[string]Iface {
+	c.ifacesMu.RLock()
+	defer c.ifacesMu.RUnlock()
+
+	ifaces := make(map[string]Iface, len(c.ifaces))
+	for k, v := range c.ifaces {
+		ifaces[k] = v
+	}
+
+	return ifaces
+}
+
+// GetIfacesStatus returns provisioning status of CPE interfaces indexed by name
+func (c *Client) GetIfacesStatus() map[string]IfaceStatus {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	status := make(map[string]IfaceStatus, len(c.status))
+	for k, v := range c.status {
+		status[k] = v
+	}
+
+	return status
+}
+
+func (c *Client) setIfaceStatus(name string, swIf int, err error) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	if c.status == nil {
+		c.status = make(map[string]IfaceStatus)
+	}
+
+	st := IfaceStatus{SwIf: swIf, State: IfaceOK}
+	if err != nil {
+		st.State = IfaceFailed
+		st.Reason = err.Error()
+		log.Printf("Error provisioning interface, %s", st.Reason)
+	}
+	c.status[name] = st
+}
+
+func (c *Client) deleteIfaceStatus(name string) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	delete(c.status, name)
+}
+
+// IsConnected reports if connection with VPP is currently established
+func (c *Client) IsConnected() bool {
+	return c.connected.Load()
+}
+
+// DumpSessionRoutes returns routes from table 0, and IPv4 tables of VRFs,
+// pointing to a CPE interface, /32 IPv4 routes and IPv6 routes to addresses
+// and delegated prefixes. They are indexed by prefix with the SwIf of its
+// path as value.
+func (c *Client) DumpSessionRoutes() (map[string]uint32, error) {
+	routes := make(map[string]uint32)
+
+	tables := []ip.IPTable{{TableID: 0}, {TableID: 0, IsIP6: true}}
+	for _, v := range c.vrfTables() {
+		tables = append(tables, ip.IPTable{TableID: v})
+	}
+	for _, table := range tables {
+		if err := c.dumpSessionRoutes(table, routes); err != nil {
+			return nil, err
+		}
+	}
+
+	return routes, nil
+}
+
+func (c *Client) dumpSessionRoutes(table ip.IPTable, routes map[string]uint32) (err error) {
+	isIP6 := table.IsIP6
+	req := &ip.IPRouteDump{Table: table}
+
+	ch, err := c.channel()
+	if err != nil {
+		return err
+	}
+	defer c.release(ch)
+	defer observeRequest(req, time.Now(), &err)
+	reqCtx := ch.SendMultiRequest(req)
+
+	for {
+		reply := &ip.IPRouteDetails{}
+		var stop bool
+		stop, err = reqCtx.ReceiveReply(reply)
+		if err != nil {
+			return err
+		}
+		if stop {
+			break
+		}
+
+		route := reply.Route
+		if (!isIP6 && route.Prefix.Len != 32) || len(route.Paths) == 0 {
+			continue
+		}
+		// Link-local and receive routes of interfaces are owned by VPP
+		if route.Prefix.Address.ToIP().IsLinkLocalUnicast() || route.Paths[0].Type != fib_types.FIB_API_PATH_TYPE_NORMAL {
+			continue
+		}
+
+		// Only routes managed by sessions are pointing to CPE interfaces
+		swIf := route.Paths[0].SwIfIndex
+		if _, ok := c.LookupIface(int(swIf)); !ok {
+			continue
+		}
+
+		routes[route.Prefix.String()] = swIf
+		c.setRouteStats(route.Prefix.String(), int64(route.StatsIndex))
+	}
+
+	return nil
+}
+
+// request sends a request and waits for its reply
+func (c *Client) request(req api.Message, reply api.Message) error {
+	ch, err := c.channel()
+	if err != nil {
+		return err
+	}
+	defer c.release(ch)
+
+	start := time.Now()
+	err = ch.SendRequest(req).ReceiveReply(reply)
+	observeRequest(req, start, &err)
+
+	return err
+}
+
+// observeRequest records latency and result of a request started at start
+func observeRequest(req api.Message, start time.Time, err *error) {
+	metricRequestSeconds.WithLabelValues(req.GetMessageName()).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		metricRequestFailures.WithLabelValues(req.GetMessageName()).Inc()
+	}
+}
+
+// addDelRouteToVPP adds or removes a route to a CPE interface, IPv4 routes
+// are in the table of the VRF of the interface
+func (c *Client) addDelRouteToVPP(prefix ip_types.Prefix, iface uint32, isAdd bool) error {
+	path := fib_types.FibPath{SwIfIndex: iface, Proto: fib_types.FIB_API_PATH_NH_PROTO_IP4}
+	var table uint32
+	if prefix.Address.Af == ip_types.ADDRESS_IP6 {
+		path.Proto = fib_types.FIB_API_PATH_NH_PROTO_IP6
+	} else {
+		table = c.ifaceTable(int(iface))
+	}
 
 	req := &ip.IPRouteAddDel{IsAdd: isAdd,
-		Route: ip.IPRoute{TableID: 0,
-			Prefix: ip_types.Prefix{Address: *ipv4, Len: 32},
+		Route: ip.IPRoute{TableID: table,
+			Prefix: prefix,
+			NPaths: 1,
 			Paths:  []fib_types.FibPath{path}}}
 
 	reply := &ip.IPRouteAddDelReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
-		log.Println("Error adding route", err)
-		return err
+	if err := c.request(req, reply); err != nil {
+		verb := "adding"
+		if !isAdd {
+			verb = "removing"
+		}
+		log.Printf("Error %s route %s to SwIf %d, %s", verb, prefix.String(), iface, err.Error())
+		return fmt.Errorf("%s route %s to SwIf %d, %w", verb, prefix.String(), iface, err)
+	}
+
+	name := req.Route.Prefix.String()
+	if isAdd {
+		c.ownRoute(prefix, iface)
+		c.setRouteStats(name, int64(reply.StatsIndex))
+	} else {
+		c.owned.remove(OwnedRoute, name)
+		c.setRouteStats(name, -1)
 	}
 
 	return nil
 }
 
-func (c *Client) configProxyArp() {
+// ownRoute registers a route to a CPE interface as owned by glubngd
+func (c *Client) ownRoute(prefix ip_types.Prefix, iface uint32) {
+	c.owned.add(OwnedRoute, prefix.String(), -1, func() error {
+		return c.addDelRouteToVPP(prefix, iface, false)
+	})
+}
+
+// AdoptSessionPrefix registers a route of a session found in VPP, i.e. left
+// by a previous run, as owned without installing it again
+func (c *Client) AdoptSessionPrefix(prefix *net.IPNet, iface uint32) {
+	c.ownRoute(toVPPPrefix(prefix), iface)
+}
+
+func (c *Client) configProxyArp() error {
+	var errs ProvisionErrors
+
 	// Configure ProxyArp
 	for _, v := range c.config.IPv4Pool {
 		net, err := netip.ParsePrefix(v)
 
 		if err != nil {
-			log.Fatalf("Error parsing IPv4Pool, %s", err.Error())
+			errs = append(errs, &ProvisionError{Object: "proxy-arp " + v, Step: "parse IPv4Pool", Err: err})
+			continue
 		}
 
 		// Very bad code :(
@@ -134,17 +670,25 @@ func (c *Client) configProxyArp() {
 
 		reply := &arp.ProxyArpAddDelReply{}
 
-		if err = c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
-			log.Fatalf("Error setting proxy-arp in VPP, %s", err.Error())
+		if err = c.request(req, reply); err != nil && !isVPPError(err, api.VALUE_EXIST) {
+			errs = append(errs, &ProvisionError{Object: "proxy-arp " + v, Step: "add range", Err: err})
+			continue
 		}
 
+		del := &arp.ProxyArpAddDel{IsAdd: false, Proxy: req.Proxy}
+		c.owned.add(OwnedProxyArp, v, -1, func() error {
+			return c.request(del, &arp.ProxyArpAddDelReply{})
+		})
 	}
+
+	return errs.OrNil()
 }
 
-func (c *Client) configDHCPRelay() {
+func (c *Client) configDHCPRelay() error {
+	c.tapSwIf = -1
 	net, err := netip.ParsePrefix(c.config.TapNetworkPrefix)
 	if err != nil {
-		log.Fatalf("Error parsing Tap IPv4Pool, %s", err.Error())
+		return &ProvisionError{Object: "dhcp-relay", Step: "parse TapNetworkPrefix", Err: err}
 	}
 
 	first := net.Addr().Next()
@@ -165,105 +709,226 @@ func (c *Client) configDHCPRelay() {
 	}
 
 	// Create Tap interface
-	var swIf int
-	swIf, err = c.createTapInterface(vppIPSecond, uint8(net.Bits()))
-	if err != nil {
-		log.Fatalf("Error creating Tap interface, %s", err.Error())
+	swIf, ok := c.adopt(tagDHCPTap)
+	if !ok {
+		swIf, err = c.createTapInterface(vppIPSecond, uint8(net.Bits()))
+		if err != nil {
+			return &ProvisionError{Object: "dhcp-relay", Step: "create tap interface", Err: err}
+		}
+		c.tagInterface(swIf, tagDHCPTap)
 	}
+	c.tapSwIf = swIf
+	c.owned.add(OwnedTap, swIfName(swIf), swIf, func() error {
+		return c.deleteTapInterface(swIf)
+	})
 
 	// Set Tap interface up
 	err = c.setInterfaceUp(swIf)
 	if err != nil {
-		log.Fatalf("Error setting Tap interface up, %s", err.Error())
+		return &ProvisionError{Object: "dhcp-relay", Step: "set tap interface up", Err: err}
 	}
 
 	// Add first IPv4 from net to early created Tap
-	err = c.setInterfaceAddrIPv4(swIf, vppIPFirst, uint8(net.Bits()))
-	if err != nil {
-		log.Fatalf("Error adding IPv4 to tap interface, %s", err.Error())
+	err = c.setInterfaceAddr(swIf, vppIPFirst, uint8(net.Bits()))
+	if err != nil && !isAddressConfigured(err) {
+		return &ProvisionError{Object: "dhcp-relay", Step: "add IPv4 to tap interface", Err: err}
 	}
 
 	// Enable DHCP Proxy to External Server
-	err = c.setProxyDHCPv4(vppIPFirst, vppIPSecond)
+	err = c.setProxyDHCP(vppIPFirst, vppIPSecond, true)
 	if err != nil {
-		log.Fatalf("Error setting up DHCPv4 proxy, %s", err.Error())
+		return &ProvisionError{Object: "dhcp-relay", Step: "set DHCPv4 proxy", Err: err}
 	}
+	c.owned.add(OwnedDHCPProxy, second.String(), -1, func() error {
+		return c.setProxyDHCP(vppIPFirst, vppIPSecond, false)
+	})
+
+	return nil
 }
 
-func (c *Client) configCPEInterfaces() {
-	for k, v := range c.ifaces {
-		// UP State to iface
-		err := c.setInterfaceUp(v.VPPSrcIface)
+func (c *Client) configCPEInterfaces() error {
+	var errs ProvisionErrors
+
+	// Maps are replaced once done, Kea responder keeps using current ones
+	ifaces := c.GetIfaces()
+
+	for k, v := range ifaces {
+		var err error
+		ifaces[k], err = c.provisionCPEInterface(k, v)
+		errs = errs.Append(err)
+	}
+
+	c.setIfaces(ifaces)
+
+	return errs.OrNil()
+}
+
+// provisionCPEInterface configures a CPE interface, keeps its status and
+// returns it with its SwIf when succeeded
+func (c *Client) provisionCPEInterface(name string, v Iface) (Iface, error) {
+	swIf, err := c.configCPEInterface(name, &v)
+	c.setIfaceStatus(name, swIf, err)
+	if swIf >= 0 {
+		// Interfaces partially configured are also removed on teardown
+		c.owned.add(OwnedCPEIface, name, swIf, func() error {
+			return c.unconfigCPEInterface(name, v, swIf)
+		})
+	}
+	if err != nil {
+		return v, err
+	}
+
+	// Store SwIf
+	v.SwIf = swIf
+
+	return v, nil
+}
+
+// setIfaces replaces CPE interfaces, only successfully provisioned ones are
+// indexed by SwIf
+func (c *Client) setIfaces(ifaces map[string]Iface) {
+	status := c.GetIfacesStatus()
+
+	// Pointer using SwIf
+	ifacesSwIf := make(map[int]Iface)
+	ifacesRelay := make(map[[2]string]Iface)
+	for k, v := range ifaces {
+		if status[k].State != IfaceOK {
+			continue
+		}
+		ifacesSwIf[v.SwIf] = v
+		if v.CircuitID != "" || v.RemoteID != "" {
+			ifacesRelay[v.relayKey()] = v
+		}
+	}
+
+	c.ifacesMu.Lock()
+	c.ifaces = ifaces
+	c.ifacesSwIf = ifacesSwIf
+	c.ifacesRelay = ifacesRelay
+	c.ifacesMu.Unlock()
+}
+
+// configCPEInterface provisions a CPE interface and returns its SwIf, which is
+// also returned on failure if it was created
+func (c *Client) configCPEInterface(name string, v *Iface) (int, error) {
+	fail := func(step string, err error) error {
+		return &ProvisionError{Object: "iface " + name, Step: step, Err: err}
+	}
+
+	if c.gwLoopSwIf < 0 {
+		return -1, fail("set unnumbered", ErrNoGwLoopback)
+	}
+
+	// UP State to iface
+	err := c.setInterfaceUp(v.VPPSrcIface)
+	if err != nil {
+		return -1, fail("set parent interface up", err)
+	}
+
+	// Test if it's a sub-interface
+	swIf := v.VPPSrcIface
+	if v.IsSubIf {
+		subID := v.OuterVLAN
+		if v.HasQinQ {
+			subID = (v.OuterVLAN << 12) + v.InnerVLAN
+		}
+
+		var adopted bool
+		swIf, adopted, err = c.adoptSubInterface(name, v.VPPSrcIface, subID)
 		if err != nil {
-			log.Fatalf("Error setting up interface, %s", err.Error())
-		}
-
-		// Test if it's a sub-interface
-		swIf := v.VPPSrcIface
-		if v.IsSubIf {
-			if v.HasQinQ {
-				// Add QinQ VLAN
-				swIf, err = c.createQinQInterface(v.VPPSrcIface, (v.OuterVLAN<<12)+v.InnerVLAN,
-					v.OuterVLAN, v.InnerVLAN)
-
-				if err != nil {
-					log.Fatalf("Error creating sub-interface QinQ, %s", err.Error())
-				}
-			} else {
-				// Modify ID using an autogenerated
-				swIf, err = c.createVlanInterface(v.VPPSrcIface, v.OuterVLAN, v.OuterVLAN)
-				if err != nil {
-					log.Fatalf("Error creating sub-interface VLAN, %s", err.Error())
-				}
-			}
+			return -1, fail("delete stale sub-interface", err)
+		}
 
-			// UP State to sub-interface
-			err := c.setInterfaceUp(swIf)
+		if !adopted && v.HasQinQ {
+			// Add QinQ VLAN
+			swIf, err = c.createQinQInterface(v.VPPSrcIface, subID, v.OuterVLAN, v.InnerVLAN)
 			if err != nil {
-				log.Fatalf("Error setting up interface, %s", err.Error())
+				return -1, fail("create sub-interface QinQ", err)
 			}
+			c.tagInterface(swIf, cpeTag(name))
+		} else if !adopted {
+			// Modify ID using an autogenerated
+			swIf, err = c.createVlanInterface(v.VPPSrcIface, subID, v.OuterVLAN)
+			if err != nil {
+				return -1, fail("create sub-interface VLAN", err)
+			}
+			c.tagInterface(swIf, cpeTag(name))
 		}
-		// Set MTU
-		err = c.setInterfaceMTU(swIf, v.MTU)
+
+		// UP State to sub-interface
+		err := c.setInterfaceUp(swIf)
 		if err != nil {
-			log.Fatalf("Error setting MTU in interface, %s", err.Error())
+			return swIf, fail("set interface up", err)
 		}
+	}
+	// Set MTU
+	err = c.setInterfaceMTU(swIf, v.MTU)
+	if err != nil {
+		return swIf, fail("set MTU", err)
+	}
 
-		// Set Unnumbered to loopback
-		err = c.setInterfaceUnnumbered(swIf, c.gwLoopSwIf)
+	// Set Unnumbered to loopback
+	err = c.setInterfaceUnnumbered(swIf, c.gwLoopSwIf, true)
+	if err != nil {
+		return swIf, fail("set unnumbered", err)
+	}
+
+	if c.config.EnableProxyARP {
+		// Enable ProxyARP in interface
+		err = c.setInterfaceProxyARP(swIf, true)
 		if err != nil {
-			log.Fatalf("Error setting unnumbered interface, %s", err.Error())
+			return swIf, fail("enable proxy-arp", err)
 		}
+	}
 
-		if c.config.EnableProxyARP {
-			// Enable ProxyARP in interface
-			c.setInterfaceProxyARP(swIf, true)
+	if c.config.IPv6.Enable {
+		if err = c.enableCPEIPv6(swIf); err != nil {
+			return swIf, fail("enable IPv6", err)
+		}
+		if err = c.configRouterAdvertisements(swIf); err != nil {
+			return swIf, fail("configure router advertisements", err)
 		}
+	}
 
-		// Store SwIf
-		v.SwIf = swIf
-		// Pointer using SwIf
-		c.ifacesSwIf[swIf] = c.ifaces[k]
+	if err = c.applyProfile(swIf, v.Profile); err != nil {
+		return swIf, fail("apply service profile", err)
 	}
+
+	return swIf, nil
 }
 
-func (c *Client) configIPv4GwLoopback() {
+func (c *Client) configIPv4GwLoopback() error {
 	// Create loopback iface
-	var err error
-	c.gwLoopSwIf, err = c.createLoopackIface()
-	if err != nil {
-		log.Fatalf("Error creating loopback interface, %s", err.Error())
+	swIf, ok := c.adopt(tagGwLoopback)
+	if !ok {
+		var err error
+		swIf, err = c.createLoopackIface()
+		if err != nil {
+			c.gwLoopSwIf = -1
+			return &ProvisionError{Object: "gateway loopback", Step: "create interface", Err: err}
+		}
+		c.tagInterface(swIf, tagGwLoopback)
 	}
+	c.gwLoopSwIf = swIf
+	c.owned.add(OwnedLoopback, swIfName(swIf), swIf, func() error {
+		return c.deleteLoopbackIface(swIf)
+	})
+
 	// Set loopback iface up
-	err = c.setInterfaceUp(c.gwLoopSwIf)
+	err := c.setInterfaceUp(c.gwLoopSwIf)
 	if err != nil {
-		log.Fatalf("Error setting up loopback interface, %s", err.Error())
+		return &ProvisionError{Object: "gateway loopback", Step: "set interface up", Err: err}
 	}
+
+	var errs ProvisionErrors
+
 	// Iterate over Gw IPv4 and set it to created loopback
 	for _, v := range c.config.GatewayIfaceAddrs {
 		ipv4 := net.ParseIP(v)
 		if ipv4 == nil {
-			log.Fatalf("Gateway IPv4 %s is not possible to parse", v)
+			errs = append(errs, &ProvisionError{Object: "gateway loopback", Step: "parse IPv4 " + v, Err: ErrInvalidAddress})
+			continue
 		}
 
 		vppip := ip_types.Address{
@@ -274,9 +939,11 @@ func (c *Client) configIPv4GwLoopback() {
 		}
 
 		// Set IPv4 to loopback
-		err = c.setInterfaceAddrIPv4(c.gwLoopSwIf, &vppip, 32)
-		if err != nil {
-			log.Fatalf("Error setting IPv4 in loopback interface, %s", err.Error())
+		err = c.setInterfaceAddr(c.gwLoopSwIf, &vppip, 32)
+		if err != nil && !isAddressConfigured(err) {
+			errs = append(errs, &ProvisionError{Object: "gateway loopback", Step: "add IPv4 " + v, Err: err})
 		}
 	}
+
+	return errs.OrNil()
 }
@@ -0,0 +1,559 @@
+package vpp_test
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glutechnologies/glubng/internal/vpptest"
+	"github.com/glutechnologies/glubng/pkg/vpp"
+	"go.fd.io/govpp/api"
+)
+
+func testConfig() *vpp.VPPConfig {
+	return &vpp.VPPConfig{
+		SrcVPPSocket:      "/run/vpp/api.sock",
+		GatewayIfaceAddrs: []string{"100.64.0.1"},
+		IPv4Pool:          []string{"100.64.0.0/24"},
+		EnableProxyARP:    true,
+		TapIfaceName:      "tap-kea",
+		TapNetworkPrefix:  "192.168.254.0/30",
+	}
+}
+
+func newClient(t *testing.T, m *vpptest.VPP, config *vpp.VPPConfig, ifaces map[string]vpp.Iface) *vpp.Client {
+	t.Helper()
+
+	c, err := m.NewClient(config, vpptest.WriteIfaces(t, ifaces))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+// findIface returns the interface of the fake VPP with a name prefix
+func findIface(t *testing.T, m *vpptest.VPP, prefix string) vpptest.Iface {
+	t.Helper()
+
+	for _, v := range m.Ifaces() {
+		if strings.HasPrefix(v.Name, prefix) {
+			return v
+		}
+	}
+	t.Fatalf("interface %s* not found", prefix)
+	return vpptest.Iface{}
+}
+
+func hasOwned(c *vpp.Client, kind vpp.OwnedKind, name string) bool {
+	for _, o := range c.Owned() {
+		if o.Kind == kind && o.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConfigCPEInterfaces(t *testing.T) {
+	m := vpptest.NewVPP()
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+
+	c := newClient(t, m, testConfig(), map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 100, MTU: 1500, FlexId: "cpe1"},
+		"cpe2": {VPPSrcIface: eth, IsSubIf: true, HasQinQ: true, OuterVLAN: 200, InnerVLAN: 10, MTU: 1492, FlexId: "cpe2"},
+	})
+	loop := findIface(t, m, "loop")
+
+	tests := []struct {
+		name      string
+		subID     uint32
+		outerVLAN uint16
+		innerVLAN uint16
+		mtu       uint32
+	}{
+		{name: "cpe1", subID: 100, outerVLAN: 100, mtu: 1500},
+		{name: "cpe2", subID: 200<<12 + 10, outerVLAN: 200, innerVLAN: 10, mtu: 1492},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := c.LookupIfaceName(tt.name)
+			if !ok {
+				t.Fatalf("interface %s is not provisioned", tt.name)
+			}
+			if st := c.GetIfacesStatus()[tt.name]; st.State != vpp.IfaceOK || st.SwIf != v.SwIf {
+				t.Errorf("status = %+v, want ok with SwIf %d", st, v.SwIf)
+			}
+
+			got, ok := m.Iface(uint32(v.SwIf))
+			if !ok {
+				t.Fatalf("SwIf %d does not exist in VPP", v.SwIf)
+			}
+			if got.Parent != uint32(eth) || got.SubID != tt.subID || got.OuterVLAN != tt.outerVLAN || got.InnerVLAN != tt.innerVLAN {
+				t.Errorf("sub-interface = %+v, want parent %d, sub-id %d and VLANs %d.%d", got, eth, tt.subID, tt.outerVLAN, tt.innerVLAN)
+			}
+			if !got.Up || got.MTU != tt.mtu {
+				t.Errorf("sub-interface up %v with MTU %d, want up with MTU %d", got.Up, got.MTU, tt.mtu)
+			}
+			if !got.Unnumbered || got.UnnumberedTo != loop.SwIfIndex {
+				t.Errorf("sub-interface unnumbered %v to %d, want unnumbered to loopback %d", got.Unnumbered, got.UnnumberedTo, loop.SwIfIndex)
+			}
+			if !got.ProxyARP {
+				t.Error("proxy-arp is not enabled")
+			}
+			if got.Tag != "glubng:cpe:"+tt.name {
+				t.Errorf("tag = %q, want glubng:cpe:%s", got.Tag, tt.name)
+			}
+			if !hasOwned(c, vpp.OwnedCPEIface, tt.name) {
+				t.Error("interface is not registered as owned")
+			}
+		})
+	}
+
+	if !loop.Up || !reflect.DeepEqual(loop.Addresses, []string{"100.64.0.1/32"}) {
+		t.Errorf("gateway loopback = %+v, want up with 100.64.0.1/32", loop)
+	}
+}
+
+func TestConfigCPEInterfacesFailure(t *testing.T) {
+	m := vpptest.NewVPP()
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+	m.FailNext("create_subif", api.SYSCALL_ERROR_1)
+
+	c, err := m.NewClient(testConfig(), vpptest.WriteIfaces(t, map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 100, MTU: 1500, FlexId: "cpe1"},
+	}))
+	var errs vpp.ProvisionErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("NewClient() error = %v, want one provisioning error", err)
+	}
+	defer c.Close()
+
+	if st := c.GetIfacesStatus()["cpe1"]; st.State != vpp.IfaceFailed || !strings.Contains(st.Reason, "create sub-interface VLAN") {
+		t.Errorf("status = %+v, want failed creating sub-interface", st)
+	}
+	if _, ok := c.LookupIfaceName("cpe1"); ok {
+		t.Error("failed interface can be looked up")
+	}
+	// Failure of an interface does not prevent configuring the others
+	if len(m.DHCPProxies()) != 1 {
+		t.Error("DHCP relay is not configured")
+	}
+}
+
+func TestInitDisconnectsOnFailure(t *testing.T) {
+	m := vpptest.NewVPP()
+
+	filename := filepath.Join(t.TempDir(), "missing.toml")
+	if c, err := m.NewClient(testConfig(), filename); err == nil {
+		c.Close()
+		t.Fatal("NewClient() succeeded without interfaces file, want error")
+	}
+	if n := m.Connected(); n != 0 {
+		t.Errorf("%d clients connected after failed Init, want 0", n)
+	}
+
+	c, err := m.NewClient(testConfig(), vpptest.WriteIfaces(t, map[string]vpp.Iface{}))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if n := m.Connected(); n != 1 {
+		t.Errorf("%d clients connected, want 1", n)
+	}
+	c.Close()
+	if n := m.Connected(); n != 0 {
+		t.Errorf("%d clients connected after Close, want 0", n)
+	}
+}
+
+func TestConfigProxyArp(t *testing.T) {
+	m := vpptest.NewVPP()
+	config := testConfig()
+	config.IPv4Pool = []string{"100.64.0.0/24", "100.65.0.0/22"}
+
+	c, err := m.NewClient(config, vpptest.WriteIfaces(t, map[string]vpp.Iface{}))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	want := []vpptest.ProxyArpRange{
+		{Low: "100.64.0.0", Hi: "100.64.0.255"},
+		{Low: "100.65.0.0", Hi: "100.65.3.255"},
+	}
+	if got := m.ProxyArpRanges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("proxy-arp ranges = %v, want %v", got, want)
+	}
+	for _, v := range config.IPv4Pool {
+		if !hasOwned(c, vpp.OwnedProxyArp, v) {
+			t.Errorf("proxy-arp %s is not registered as owned", v)
+		}
+	}
+
+	// Ranges left by a previous run are adopted
+	c.Close()
+	c = newClient(t, m, config, map[string]vpp.Iface{})
+	if got := m.ProxyArpRanges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("proxy-arp ranges after restart = %v, want %v", got, want)
+	}
+	if !hasOwned(c, vpp.OwnedProxyArp, config.IPv4Pool[0]) {
+		t.Error("adopted proxy-arp is not registered as owned")
+	}
+
+	if err := c.Teardown(); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if got := m.ProxyArpRanges(); len(got) != 0 {
+		t.Errorf("proxy-arp ranges after teardown = %v, want none", got)
+	}
+}
+
+func TestConfigDHCPRelay(t *testing.T) {
+	m := vpptest.NewVPP()
+	c, err := m.NewClient(testConfig(), vpptest.WriteIfaces(t, map[string]vpp.Iface{}))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	tap := findIface(t, m, "tap")
+	if !tap.Up || !reflect.DeepEqual(tap.Addresses, []string{"192.168.254.1/30"}) {
+		t.Errorf("tap = %+v, want up with 192.168.254.1/30", tap)
+	}
+	if tap.Tag != "glubng:dhcp-tap" {
+		t.Errorf("tap tag = %q, want glubng:dhcp-tap", tap.Tag)
+	}
+
+	want := []vpptest.DHCPProxy{{Server: "192.168.254.2", Src: "192.168.254.1"}}
+	if got := m.DHCPProxies(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DHCP proxies = %v, want %v", got, want)
+	}
+	if !hasOwned(c, vpp.OwnedTap, "SwIf "+strconv.Itoa(int(tap.SwIfIndex))) || !hasOwned(c, vpp.OwnedDHCPProxy, "192.168.254.2") {
+		t.Errorf("owned = %v, want tap and DHCP proxy", c.Owned())
+	}
+
+	// Tap of a previous run is adopted instead of creating another one
+	c.Close()
+	newClient(t, m, testConfig(), map[string]vpp.Iface{})
+	taps := 0
+	for _, v := range m.Ifaces() {
+		if strings.HasPrefix(v.Name, "tap") {
+			taps++
+		}
+	}
+	if taps != 1 {
+		t.Errorf("VPP has %d taps, want 1", taps)
+	}
+}
+
+func TestSessionRoutes(t *testing.T) {
+	m := vpptest.NewVPP()
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+	c := newClient(t, m, testConfig(), map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 100, MTU: 1500, FlexId: "cpe1"},
+	})
+	cpe, _ := c.LookupIfaceName("cpe1")
+	swIf := uint32(cpe.SwIf)
+
+	_, prefix, _ := net.ParseCIDR("2001:db8:100::/56")
+	c.AddSession(net.ParseIP("100.64.0.10"), swIf)
+	c.AddSessionPrefix(prefix, swIf)
+
+	routes := m.Routes()
+	if !reflect.DeepEqual(routes["100.64.0.10/32"], []uint32{swIf}) || !reflect.DeepEqual(routes["2001:db8:100::/56"], []uint32{swIf}) {
+		t.Fatalf("routes = %v, want session routes to SwIf %d", routes, swIf)
+	}
+	for _, name := range []string{"100.64.0.10/32", "2001:db8:100::/56"} {
+		if !hasOwned(c, vpp.OwnedRoute, name) {
+			t.Errorf("route %s is not registered as owned", name)
+		}
+	}
+
+	dump, err := c.DumpSessionRoutes()
+	if err != nil {
+		t.Fatalf("DumpSessionRoutes() error = %v", err)
+	}
+	want := map[string]uint32{"100.64.0.10/32": swIf, "2001:db8:100::/56": swIf}
+	if !reflect.DeepEqual(dump, want) {
+		t.Errorf("DumpSessionRoutes() = %v, want %v", dump, want)
+	}
+
+	c.RemoveSession(net.ParseIP("100.64.0.10"), swIf)
+	c.RemoveSessionPrefix(prefix, swIf)
+	if routes := m.Routes(); len(routes) != 0 {
+		t.Errorf("routes after removing sessions = %v, want none", routes)
+	}
+	if hasOwned(c, vpp.OwnedRoute, "100.64.0.10/32") {
+		t.Error("removed route is still owned")
+	}
+}
+
+func TestSessionRouteFailure(t *testing.T) {
+	m := vpptest.NewVPP()
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+	c := newClient(t, m, testConfig(), map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 100, MTU: 1500, FlexId: "cpe1"},
+	})
+	cpe, _ := c.LookupIfaceName("cpe1")
+	swIf := uint32(cpe.SwIf)
+
+	m.FailNext("ip_route_add_del", api.SYSCALL_ERROR_1)
+	err := c.AddSession(net.ParseIP("100.64.0.10"), swIf)
+	if err == nil || !strings.Contains(err.Error(), "adding route 100.64.0.10/32") {
+		t.Errorf("AddSession() error = %v, want failure adding route", err)
+	}
+	if hasOwned(c, vpp.OwnedRoute, "100.64.0.10/32") {
+		t.Error("route not added is owned")
+	}
+
+	if err = c.AddSession(net.ParseIP("100.64.0.10"), swIf); err != nil {
+		t.Fatalf("AddSession() error = %v", err)
+	}
+	m.FailNext("ip_route_add_del", api.SYSCALL_ERROR_1)
+	err = c.RemoveSession(net.ParseIP("100.64.0.10"), swIf)
+	if err == nil || !strings.Contains(err.Error(), "removing route 100.64.0.10/32") {
+		t.Errorf("RemoveSession() error = %v, want failure removing route", err)
+	}
+	if !hasOwned(c, vpp.OwnedRoute, "100.64.0.10/32") {
+		t.Error("route not removed is not owned")
+	}
+}
+
+// Requests after Close fail instead of waiting for a channel of the pool,
+// workers still processing events let shutdown finish
+func TestRequestAfterClose(t *testing.T) {
+	m := vpptest.NewVPP()
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+	c, err := m.NewClient(testConfig(), vpptest.WriteIfaces(t, map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 100, MTU: 1500, FlexId: "cpe1"},
+	}))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	cpe, _ := c.LookupIfaceName("cpe1")
+	c.Close()
+
+	added := make(chan error)
+	go func() {
+		added <- c.AddSession(net.ParseIP("100.64.0.10"), uint32(cpe.SwIf))
+	}()
+	select {
+	case err := <-added:
+		if !errors.Is(err, vpp.ErrClosed) {
+			t.Errorf("AddSession() after Close error = %v, want %v", err, vpp.ErrClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AddSession() after Close waits for a channel")
+	}
+}
+
+// Provisioned SwIf must be stored in interfaces returned by GetIfaces, they
+// are written back to interfaces file and compared on reload
+func TestGetIfacesReportsProvisionedSwIf(t *testing.T) {
+	m := vpptest.NewVPP()
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+	c := newClient(t, m, testConfig(), map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 100, MTU: 1500, FlexId: "cpe1"},
+	})
+
+	swIf := c.GetIfacesStatus()["cpe1"].SwIf
+	if swIf <= eth {
+		t.Fatalf("cpe1 provisioned with SwIf %d, want a new sub-interface", swIf)
+	}
+	if got := c.GetIfaces()["cpe1"].SwIf; got != swIf {
+		t.Errorf("GetIfaces() SwIf of cpe1 = %d, want %d", got, swIf)
+	}
+	if _, ok := c.LookupIface(swIf); !ok {
+		t.Errorf("LookupIface(%d) not found", swIf)
+	}
+}
+
+func TestConfigUplinksOwnAddedAddresses(t *testing.T) {
+	m := vpptest.NewVPP()
+	wan1 := m.AddHwInterface("GigabitEthernet0/1/0")
+	wan2 := m.AddHwInterface("GigabitEthernet0/2/0")
+	// Configured by the operator before glubngd started
+	m.AddAddress(wan1, "192.0.2.2/30")
+
+	config := testConfig()
+	config.Uplinks = []vpp.UplinkConfig{
+		{IfaceName: "GigabitEthernet0/1/0", IPv4: "192.0.2.2/30", Gateway: "192.0.2.1"},
+		{IfaceName: "GigabitEthernet0/2/0", IPv4: "198.51.100.2/30", Gateway: "198.51.100.1"},
+	}
+	c, err := m.NewClient(config, vpptest.WriteIfaces(t, map[string]vpp.Iface{}))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer c.Close()
+
+	if hasOwned(c, vpp.OwnedUplinkAddr, "GigabitEthernet0/1/0 192.0.2.2/30") {
+		t.Error("existing uplink address is owned, want it left to the operator")
+	}
+	if !hasOwned(c, vpp.OwnedUplinkAddr, "GigabitEthernet0/2/0 198.51.100.2/30") {
+		t.Error("added uplink address is not owned")
+	}
+
+	if err := c.Teardown(); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if v, _ := m.Iface(wan1); !reflect.DeepEqual(v.Addresses, []string{"192.0.2.2/30"}) {
+		t.Errorf("addresses of %s = %v after Teardown, want existing one kept", v.Name, v.Addresses)
+	}
+	if v, _ := m.Iface(wan2); len(v.Addresses) != 0 {
+		t.Errorf("addresses of %s = %v after Teardown, want none", v.Name, v.Addresses)
+	}
+}
+
+func TestSetIfaceProfileBySession(t *testing.T) {
+	m := vpptest.NewVPP()
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+	config := testConfig()
+	config.Profiles = map[string]vpp.ServiceProfile{
+		"basic":    {Rate: 10000},
+		"business": {Rate: 50000},
+		"premium":  {Rate: 100000, DHCP: vpp.DHCPSettings{ClientClasses: []string{"premium"}}},
+	}
+	c := newClient(t, m, config, map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 100, MTU: 1500, FlexId: "cpe1", Profile: "basic"},
+	})
+	v, _ := c.LookupIfaceName("cpe1")
+
+	// rate returns rate of the policer bound to cpe1
+	rate := func() uint32 {
+		iface, _ := m.Iface(uint32(v.SwIf))
+		return m.Policers()[iface.Policer].Rate
+	}
+
+	steps := []struct {
+		session string
+		profile string
+		want    uint32
+	}{
+		{session: "100.64.0.10", profile: "premium", want: 100000},
+		{session: "100.64.0.11", profile: "business", want: 50000},
+		// Profile of the other session is kept
+		{session: "100.64.0.11", profile: "", want: 100000},
+		{session: "100.64.0.10", profile: "", want: 10000},
+	}
+	for _, s := range steps {
+		if err := c.SetIfaceProfile(v.SwIf, s.session, s.profile); err != nil {
+			t.Fatalf("SetIfaceProfile(%s, %q) error = %v", s.session, s.profile, err)
+		}
+		if got := rate(); got != s.want {
+			t.Errorf("after SetIfaceProfile(%s, %q) rate = %d, want %d", s.session, s.profile, got, s.want)
+		}
+	}
+
+	if err := c.SetIfaceProfile(v.SwIf, "100.64.0.12", "unknown"); !errors.Is(err, vpp.ErrUnknownProfile) {
+		t.Errorf("SetIfaceProfile() of unknown profile error = %v, want %v", err, vpp.ErrUnknownProfile)
+	}
+	if err := c.SetIfaceProfile(v.SwIf, "100.64.0.10", "premium"); err != nil {
+		t.Fatal(err)
+	}
+	if got := rate(); got != 100000 {
+		t.Errorf("rate = %d after unknown profile was rejected, want 100000", got)
+	}
+	if dhcp, _ := c.IfaceDHCP(v.SwIf); !reflect.DeepEqual(dhcp.ClientClasses, []string{"premium"}) {
+		t.Errorf("IfaceDHCP() = %+v, want classes of premium", dhcp)
+	}
+}
+
+func TestServiceProfileValidateDirection(t *testing.T) {
+	tests := []struct {
+		direction string
+		wantErr   bool
+	}{
+		{direction: ""},
+		{direction: vpp.ProfileDirectionInput},
+		{direction: vpp.ProfileDirectionOutput, wantErr: true},
+		{direction: "both", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		p := vpp.ServiceProfile{Rate: 10000, Direction: tt.direction}
+		if err := p.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate() of direction %q error = %v, want error %t", tt.direction, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSetIfaceVRF(t *testing.T) {
+	m := vpptest.NewVPP()
+	m.AddTable(10)
+	m.AddTable(20)
+	eth := int(m.AddHwInterface("GigabitEthernet0/0/0"))
+	config := testConfig()
+	config.VRFs = map[string]uint32{"isp-a": 10, "isp-b": 20}
+	c := newClient(t, m, config, map[string]vpp.Iface{
+		"cpe1": {VPPSrcIface: eth, IsSubIf: true, OuterVLAN: 100, MTU: 1500, FlexId: "cpe1"},
+	})
+	v, _ := c.LookupIfaceName("cpe1")
+	swIf := uint32(v.SwIf)
+
+	table := func() uint32 {
+		iface, _ := m.Iface(swIf)
+		return iface.Table
+	}
+
+	if err := c.SetIfaceVRF(v.SwIf, "100.64.0.10", "isp-a"); err != nil {
+		t.Fatalf("SetIfaceVRF() error = %v", err)
+	}
+	if got := table(); got != 10 {
+		t.Errorf("table of cpe1 = %d, want 10", got)
+	}
+
+	// Session routes are added to the table of the interface
+	c.AddSession(net.ParseIP("100.64.0.10"), swIf)
+	if got, ok := m.RouteTable("100.64.0.10/32"); !ok || got != 10 {
+		t.Errorf("table of session route = %d, %t, want 10", got, ok)
+	}
+	dump, err := c.DumpSessionRoutes()
+	if err != nil || !reflect.DeepEqual(dump, map[string]uint32{"100.64.0.10/32": swIf}) {
+		t.Errorf("DumpSessionRoutes() = %v, %v, want route in VRF", dump, err)
+	}
+
+	if err := c.SetIfaceVRF(v.SwIf, "100.64.0.11", "isp-b"); !errors.Is(err, vpp.ErrVRFInUse) {
+		t.Errorf("SetIfaceVRF() of another VRF error = %v, want %v", err, vpp.ErrVRFInUse)
+	}
+	if err := c.SetIfaceVRF(v.SwIf, "100.64.0.11", "unknown"); !errors.Is(err, vpp.ErrUnknownVRF) {
+		t.Errorf("SetIfaceVRF() of unknown VRF error = %v, want %v", err, vpp.ErrUnknownVRF)
+	}
+	if err := c.SetIfaceVRF(v.SwIf, "100.64.0.11", "isp-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.RemoveSession(net.ParseIP("100.64.0.10"), swIf)
+	if routes := m.Routes(); len(routes) != 0 {
+		t.Errorf("routes after removing session = %v, want none", routes)
+	}
+
+	// Interface is back in table 0 once no session holds the VRF
+	if err := c.SetIfaceVRF(v.SwIf, "100.64.0.10", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := table(); got != 10 {
+		t.Errorf("table of cpe1 = %d while a session holds isp-a, want 10", got)
+	}
+	if err := c.SetIfaceVRF(v.SwIf, "100.64.0.11", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := table(); got != 0 {
+		t.Errorf("table of cpe1 = %d, want 0", got)
+	}
+}
+
+func TestVPPConfigValidateVRFs(t *testing.T) {
+	config := testConfig()
+	config.VRFs = map[string]uint32{"isp-a": 10}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	config.VRFs["isp-b"] = 0
+	if err := config.Validate(); err == nil {
+		t.Error("Validate() of VRF in table 0 succeeded, want error")
+	}
+}
@@ -2,22 +2,101 @@ package vpp
 
 import (
 	"bytes"
-	"log"
+	"errors"
+	"fmt"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
 
 // VPP related configuration
 type VPPConfig struct {
-	SrcVPPSocket      string
-	UplinkIfaceName   string
-	UplinkIfaceIPv4   string
+	SrcVPPSocket    string
+	UplinkIfaceName string
+	UplinkIfaceIPv4 string
+	// Next-hop of default route through UplinkIfaceName
+	UplinkGateway string
+	// More uplinks, default route is balanced between uplinks with gateway
+	Uplinks []UplinkConfig
+	// Prefix routed through uplinks, 0.0.0.0/0 when empty
+	DefaultRoute      string
 	GatewayIfaceAddrs []string
 	IPv4Pool          []string
 	EnableProxyARP    bool
 	TapIfaceName      string
 	TapNetworkPrefix  string
+	// DHCPv6 relay through the tap is disabled when empty
+	TapNetworkPrefixIPv6 string
+	IPv6                 IPv6Config
+	// Service profiles by name, assigned to CPE interfaces or by leases
+	Profiles map[string]ServiceProfile
+	// IPv4 tables of VRFs given to subscribers by RADIUS, by name. Tables
+	// and their routes towards the core network are created by the operator.
+	VRFs map[string]uint32
+	// Stats segment socket, counters of subscribers are not collected when
+	// empty
+	SrcStatsSocket string
+	Stats          StatsConfig
+}
+
+// IPv6 on CPE interfaces, disabled unless Enable is set
+type IPv6Config struct {
+	Enable bool
+	// Added to the gateway loopback, CPEs use link-local address of their
+	// interface as default router
+	GatewayIfaceAddrs []string
+	// Router advertisement M and O flags, CPEs get addresses and other
+	// configuration, i.e. DNS servers, from DHCPv6 when set
+	RAManaged bool
+	RAOther   bool
+	// Seconds between unsolicited router advertisements and router lifetime,
+	// VPP defaults are kept when zero
+	RAMaxInterval uint32
+	RAMinInterval uint32
+	RALifetime    uint32
+	RAPrefixes    []RAPrefix
+	// Not supported, VPP router advertisements have no RDNSS option. It's
+	// rejected instead of being ignored, DNS servers are handed out by
+	// DHCPv6 when RAOther is set.
+	RDNSS []string
+}
+
+// Prefix information advertised in router advertisements
+type RAPrefix struct {
+	Prefix string
+	// CPEs may configure addresses in prefix with SLAAC
+	Autoconfig bool
+	// Lifetimes in seconds, VPP defaults are used when both are zero
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+}
+
+// Uplink interface towards core network
+type UplinkConfig struct {
+	IfaceName string
+	IPv4      string
+	Gateway   string
+	// ECMP weight of the path through this uplink, 1 when zero
+	Weight uint8
+}
+
+// AllUplinks returns UplinkIfaceName, if set, followed by Uplinks
+func (c *VPPConfig) AllUplinks() []UplinkConfig {
+	var uplinks []UplinkConfig
+	if c.UplinkIfaceName != "" {
+		uplinks = append(uplinks, UplinkConfig{
+			IfaceName: c.UplinkIfaceName,
+			IPv4:      c.UplinkIfaceIPv4,
+			Gateway:   c.UplinkGateway,
+		})
+	}
+
+	return append(uplinks, c.Uplinks...)
 }
 
 // CPE Interfaces
@@ -30,37 +109,552 @@ type Iface struct {
 	MTU         uint32
 	SwIf        int
 	FlexId      string
+	// Service profile used unless a lease sets another one
+	Profile string
+	// Relay agent ids of subscribers, used by table mapping of circuit-ids.
+	// Both must match when both are set.
+	CircuitID string
+	RemoteID  string
+	// Returned to Kea hook for queries of subscribers
+	DHCP DHCPSettings
 }
 
-func (c *Client) LoadIfacesConfig() {
-	// Init map pointer to Iface using SwIf as Index
-	c.ifacesSwIf = make(map[int]Iface)
+// relayKey returns key of relay agent ids of an interface in table mapping
+func (v *Iface) relayKey() [2]string {
+	return [2]string{v.CircuitID, v.RemoteID}
+}
+
+// Validate checks VPP configuration values before using them
+func (c *VPPConfig) Validate() error {
+	if c.SrcVPPSocket == "" {
+		return errors.New("vpp.SrcVPPSocket is empty")
+	}
+
+	if c.UplinkIfaceIPv4 != "" {
+		if p, err := netip.ParsePrefix(c.UplinkIfaceIPv4); err != nil || !p.Addr().Is4() {
+			return fmt.Errorf("vpp.UplinkIfaceIPv4 %q is not an IPv4 prefix", c.UplinkIfaceIPv4)
+		}
+	}
+
+	uplinks := make(map[string]bool)
+	for _, u := range c.AllUplinks() {
+		if u.IfaceName == "" {
+			return errors.New("vpp.Uplinks has an uplink without IfaceName")
+		}
+		if uplinks[u.IfaceName] {
+			return fmt.Errorf("vpp uplink %s is duplicated", u.IfaceName)
+		}
+		uplinks[u.IfaceName] = true
+
+		if u.IPv4 != "" {
+			if p, err := netip.ParsePrefix(u.IPv4); err != nil || !p.Addr().Is4() {
+				return fmt.Errorf("vpp uplink %s, IPv4 %q is not an IPv4 prefix", u.IfaceName, u.IPv4)
+			}
+		}
+		if u.Gateway != "" {
+			if a, err := netip.ParseAddr(u.Gateway); err != nil || !a.Is4() {
+				return fmt.Errorf("vpp uplink %s, Gateway %q is not an IPv4 address", u.IfaceName, u.Gateway)
+			}
+		}
+	}
+
+	if c.DefaultRoute != "" {
+		if p, err := netip.ParsePrefix(c.DefaultRoute); err != nil || !p.Addr().Is4() {
+			return fmt.Errorf("vpp.DefaultRoute %q is not an IPv4 prefix", c.DefaultRoute)
+		}
+	}
+
+	if len(c.GatewayIfaceAddrs) == 0 {
+		return errors.New("vpp.GatewayIfaceAddrs is empty")
+	}
+	for _, v := range c.GatewayIfaceAddrs {
+		if a, err := netip.ParseAddr(v); err != nil || !a.Is4() {
+			return fmt.Errorf("vpp.GatewayIfaceAddrs %q is not an IPv4 address", v)
+		}
+	}
+
+	for _, v := range c.IPv4Pool {
+		if p, err := netip.ParsePrefix(v); err != nil || !p.Addr().Is4() {
+			return fmt.Errorf("vpp.IPv4Pool %q is not an IPv4 prefix", v)
+		}
+	}
+
+	// Tap network needs room for both ends of the tap
+	p, err := netip.ParsePrefix(c.TapNetworkPrefix)
+	if err != nil || !p.Addr().Is4() || p.Bits() > 30 {
+		return fmt.Errorf("vpp.TapNetworkPrefix %q is not an IPv4 prefix of /30 or bigger", c.TapNetworkPrefix)
+	}
+
+	if c.TapNetworkPrefixIPv6 != "" {
+		p, err := netip.ParsePrefix(c.TapNetworkPrefixIPv6)
+		if err != nil || !p.Addr().Is6() || p.Bits() > 126 {
+			return fmt.Errorf("vpp.TapNetworkPrefixIPv6 %q is not an IPv6 prefix of /126 or bigger", c.TapNetworkPrefixIPv6)
+		}
+	}
+
+	for name, p := range c.Profiles {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("vpp.Profiles %s, %w", name, err)
+		}
+	}
+
+	for name, table := range c.VRFs {
+		if table == 0 {
+			return fmt.Errorf("vpp.VRFs %s, table 0 is the default one", name)
+		}
+	}
+
+	if err := c.Stats.Validate(); err != nil {
+		return err
+	}
+
+	return c.IPv6.Validate()
+}
+
+// Validate checks a service profile
+func (p *ServiceProfile) Validate() error {
+	if p.Rate == 0 {
+		return errors.New("Rate is not set")
+	}
+	switch p.Direction {
+	case "", ProfileDirectionInput:
+	case ProfileDirectionOutput:
+		return fmt.Errorf("Direction %s is not supported, VPP policers only police traffic entering CPE interfaces, use %s", p.Direction, ProfileDirectionInput)
+	default:
+		return fmt.Errorf("Direction %q is not supported, only %s", p.Direction, ProfileDirectionInput)
+	}
+	if p.DHCP.FixedAddress != "" {
+		return errProfileFixedAddress
+	}
+
+	return p.DHCP.Validate()
+}
+
+// Validate checks IPv6 configuration when it's enabled, RDNSS is always
+// rejected
+func (c *IPv6Config) Validate() error {
+	if len(c.RDNSS) != 0 {
+		return errors.New("vpp.IPv6.RDNSS is not supported, VPP router advertisements have no RDNSS option, set RAOther and DNSServers of DHCP settings instead")
+	}
+	if !c.Enable {
+		return nil
+	}
+
+	for _, v := range c.GatewayIfaceAddrs {
+		if a, err := netip.ParseAddr(v); err != nil || !a.Is6() {
+			return fmt.Errorf("vpp.IPv6.GatewayIfaceAddrs %q is not an IPv6 address", v)
+		}
+	}
+
+	// Limits of RFC 4861
+	if c.RAMaxInterval != 0 && (c.RAMaxInterval < 4 || c.RAMaxInterval > 1800) {
+		return fmt.Errorf("vpp.IPv6.RAMaxInterval %d is not between 4 and 1800", c.RAMaxInterval)
+	}
+	if c.RAMinInterval != 0 && (c.RAMinInterval < 3 || c.RAMaxInterval != 0 && c.RAMinInterval*4 > c.RAMaxInterval*3) {
+		return fmt.Errorf("vpp.IPv6.RAMinInterval %d is not between 3 and 0.75 * RAMaxInterval", c.RAMinInterval)
+	}
+	if c.RALifetime > 9000 {
+		return fmt.Errorf("vpp.IPv6.RALifetime %d is bigger than 9000", c.RALifetime)
+	}
+
+	for _, v := range c.RAPrefixes {
+		if p, err := netip.ParsePrefix(v.Prefix); err != nil || !p.Addr().Is6() {
+			return fmt.Errorf("vpp.IPv6.RAPrefixes %q is not an IPv6 prefix", v.Prefix)
+		}
+		if v.PreferredLifetime > v.ValidLifetime {
+			return fmt.Errorf("vpp.IPv6.RAPrefixes %s, PreferredLifetime is bigger than ValidLifetime", v.Prefix)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks a CPE interface definition
+func (i *Iface) Validate() error {
+	if i.VPPSrcIface <= 0 {
+		return errors.New("VPPSrcIface must be a valid SwIf")
+	}
+	if i.MTU == 0 {
+		return errors.New("MTU is not set")
+	}
+	if i.FlexId == "" {
+		return errors.New("FlexId is empty")
+	}
+	if i.HasQinQ && !i.IsSubIf {
+		return errors.New("HasQinQ requires IsSubIf")
+	}
+	if i.IsSubIf && (i.OuterVLAN < 1 || i.OuterVLAN > 4094) {
+		return fmt.Errorf("OuterVLAN %d out of range", i.OuterVLAN)
+	}
+	if i.HasQinQ && (i.InnerVLAN < 1 || i.InnerVLAN > 4094) {
+		return fmt.Errorf("InnerVLAN %d out of range", i.InnerVLAN)
+	}
+
+	return i.DHCP.Validate()
+}
+
+// ValidateIfaces checks every interface and looks for duplicates
+func ValidateIfaces(ifaces map[string]Iface) error {
+	flexIds := make(map[string]string)
+	vlans := make(map[[3]int]string)
+	relayIDs := make(map[[2]string]string)
+
+	for name, v := range ifaces {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("interface %s, %w", name, err)
+		}
+
+		if other, ok := flexIds[v.FlexId]; ok {
+			return fmt.Errorf("interface %s, FlexId %s already used by %s", name, v.FlexId, other)
+		}
+		flexIds[v.FlexId] = name
+
+		key := [3]int{v.VPPSrcIface, 0, 0}
+		if v.IsSubIf {
+			key[1] = v.OuterVLAN
+		}
+		if v.HasQinQ {
+			key[2] = v.InnerVLAN
+		}
+		if other, ok := vlans[key]; ok {
+			return fmt.Errorf("interface %s, same iface and VLANs as %s", name, other)
+		}
+		vlans[key] = name
+
+		if v.CircuitID == "" && v.RemoteID == "" {
+			continue
+		}
+		if other, ok := relayIDs[v.relayKey()]; ok {
+			return fmt.Errorf("interface %s, CircuitID and RemoteID already used by %s", name, other)
+		}
+		relayIDs[v.relayKey()] = name
+	}
+
+	return nil
+}
+
+// ReadIfacesConfig decodes an interfaces.toml file
+func ReadIfacesConfig(filename string) (map[string]Iface, error) {
+	ifaces := make(map[string]Iface)
+
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = toml.Decode(string(body), &ifaces)
+	if err != nil {
+		return nil, err
+	}
+
+	return ifaces, nil
+}
 
-	body, err := os.ReadFile(c.ifacesFile)
+// WriteIfacesFile encodes interfaces in an interfaces.toml file. File is
+// replaced atomically, only the leading comment block of the previous file is
+// kept, comments between interfaces are lost.
+func WriteIfacesFile(filename string, ifaces map[string]Iface) error {
+	buf := new(bytes.Buffer)
 
+	if body, err := os.ReadFile(filename); err == nil {
+		buf.Write(commentHeader(body))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err := toml.NewEncoder(buf).Encode(ifaces)
 	if err != nil {
-		log.Fatalf("Error loading configuration file")
+		return err
 	}
 
-	_, err = toml.Decode(string(body), &c.ifaces)
+	return writeFileAtomic(filename, buf.Bytes(), 0644)
+}
 
+// AddIfaceFile appends an interface to an interfaces.toml file, the rest of
+// the file is kept as it is
+func AddIfaceFile(filename string, name string, v Iface) error {
+	body, ifaces, err := readIfacesBody(filename)
 	if err != nil {
-		log.Fatalf("Error decoding configuration file")
+		return err
+	}
+	if _, ok := ifaces[name]; ok {
+		return fmt.Errorf("interface %s already exists", name)
+	}
+
+	buf := bytes.NewBuffer(body)
+	if len(body) != 0 && !bytes.HasSuffix(body, []byte("\n")) {
+		buf.WriteByte('\n')
 	}
+	if len(bytes.TrimSpace(body)) != 0 {
+		buf.WriteByte('\n')
+	}
+	if err = toml.NewEncoder(buf).Encode(map[string]Iface{name: v}); err != nil {
+		return err
+	}
+
+	return writeIfacesBody(filename, buf.Bytes(), ifaces, name, true)
 }
 
-func (c *Client) WriteIfacesConfig() {
+// RemoveIfaceFile removes the tables of an interface from an interfaces.toml
+// file with the comments right above them. The rest of the file is kept as
+// it is.
+func RemoveIfaceFile(filename string, name string) error {
+	body, ifaces, err := readIfacesBody(filename)
+	if err != nil {
+		return err
+	}
+	if _, ok := ifaces[name]; !ok {
+		return fmt.Errorf("interface %s not exists", name)
+	}
+
+	out := new(bytes.Buffer)
+	// Comment and blank lines are kept or removed with the line after them
+	var pending [][]byte
+	inIface, content := false, false
+	for _, line := range bytes.SplitAfter(body, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 || trimmed[0] == '#' {
+			pending = append(pending, line)
+			continue
+		}
 
-	buf := new(bytes.Buffer)
-	err := toml.NewEncoder(buf).Encode(c.ifaces)
+		key, isTable := tableKey(trimmed)
+		switch {
+		case isTable && key == name:
+			if !inIface {
+				keep := dropAttached(pending)
+				if !content && len(keep) == 0 {
+					// Leading comments of the file
+					keep = pending
+				}
+				for _, p := range keep {
+					out.Write(p)
+				}
+			}
+			inIface = true
+		case inIface && isTable:
+			// Comments before the next table are kept, without doubling
+			// blank lines around the removed one
+			for len(pending) != 0 && len(bytes.TrimSpace(pending[0])) == 0 &&
+				(out.Len() == 0 || bytes.HasSuffix(out.Bytes(), []byte("\n\n"))) {
+				pending = pending[1:]
+			}
+			inIface = false
+		}
 
+		if inIface {
+			pending = nil
+			continue
+		}
+		for _, p := range pending {
+			out.Write(p)
+		}
+		pending = nil
+		out.Write(line)
+		content = true
+	}
+	if !inIface {
+		for _, p := range pending {
+			out.Write(p)
+		}
+	}
+	for inIface && bytes.HasSuffix(out.Bytes(), []byte("\n\n")) {
+		out.Truncate(out.Len() - 1)
+	}
+
+	return writeIfacesBody(filename, out.Bytes(), ifaces, name, false)
+}
+
+// tableKey returns the first key of a TOML table header, i.e. the name of
+// the interface of [cpe1] and [cpe1.DHCP]
+func tableKey(line []byte) (string, bool) {
+	s := string(line)
+	if !strings.HasPrefix(s, "[") {
+		return "", false
+	}
+	s = strings.TrimSpace(strings.TrimLeft(s, "["))
+
+	var key string
+	switch {
+	case strings.HasPrefix(s, "\""):
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return "", false
+		}
+		var err error
+		if key, err = strconv.Unquote(s[:end+1]); err != nil {
+			return "", false
+		}
+		s = s[end+1:]
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", false
+		}
+		key, s = s[1:end+1], s[end+2:]
+	default:
+		end := strings.IndexAny(s, ".]")
+		if end < 0 {
+			return "", false
+		}
+		key, s = strings.TrimSpace(s[:end]), s[end:]
+	}
+
+	// Rest of the key and closing brackets, lines of multi-line arrays are
+	// not headers
+	end := strings.LastIndexByte(s, ']')
+	if end < 0 {
+		return "", false
+	}
+	rest := strings.TrimSpace(s[end+1:])
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", false
+	}
+
+	return key, true
+}
+
+// dropAttached removes comment lines right above a line, a blank line ends
+// them
+func dropAttached(lines [][]byte) [][]byte {
+	end := len(lines)
+	for end > 0 && len(bytes.TrimSpace(lines[end-1])) != 0 {
+		end--
+	}
+	return lines[:end]
+}
+
+func readIfacesBody(filename string) ([]byte, map[string]Iface, error) {
+	body, err := os.ReadFile(filename)
 	if err != nil {
-		log.Fatalf("Error encoding configuration in TOML")
+		return nil, nil, err
 	}
 
-	err = os.WriteFile(c.ifacesFile, buf.Bytes(), 0666)
+	ifaces := make(map[string]Iface)
+	if _, err = toml.Decode(string(body), &ifaces); err != nil {
+		return nil, nil, err
+	}
 
+	return body, ifaces, nil
+}
+
+// writeIfacesBody replaces an interfaces.toml file where interface name was
+// added or removed, once it's checked other interfaces are not changed
+func writeIfacesBody(filename string, body []byte, old map[string]Iface, name string, added bool) error {
+	ifaces := make(map[string]Iface)
+	if _, err := toml.Decode(string(body), &ifaces); err != nil {
+		return fmt.Errorf("editing %s, %w", filename, err)
+	}
+
+	_, has := ifaces[name]
+	changed := has != added
+	for k, v := range old {
+		if k != name && !reflect.DeepEqual(ifaces[k], v) {
+			changed = true
+		}
+	}
+	want := len(old) + 1
+	if !added {
+		want = len(old) - 1
+	}
+	if changed || len(ifaces) != want {
+		return fmt.Errorf("editing %s changes other interfaces, edit it by hand", filename)
+	}
+	if err := ValidateIfaces(ifaces); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, body, 0644)
+}
+
+// commentHeader returns comment and blank lines at the start of a file
+func commentHeader(body []byte) []byte {
+	end := 0
+	for end < len(body) {
+		line := body[end:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i+1]
+		}
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) != 0 && trimmed[0] != '#' {
+			break
+		}
+		end += len(line)
+	}
+
+	header := body[:end]
+	if len(header) != 0 && header[len(header)-1] != '\n' {
+		header = append(header, '\n')
+	}
+	return header
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and
+// renames it, readers never see a partially written file
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
 	if err != nil {
-		log.Fatalf("Error writing configuration file")
+		os.Remove(tmp)
+		return err
 	}
+
+	return nil
+}
+
+func (c *Client) LoadIfacesConfig() error {
+	// Init map pointer to Iface using SwIf as Index
+	c.ifacesSwIf = make(map[int]Iface)
+
+	var err error
+	c.ifaces, err = c.readIfacesConfig()
+
+	return err
+}
+
+// readIfacesConfig reads and validates interfaces file of client
+func (c *Client) readIfacesConfig() (map[string]Iface, error) {
+	ifaces, err := ReadIfacesConfig(c.ifacesFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading interfaces file %s, %w", c.ifacesFile, err)
+	}
+
+	if err = ValidateIfaces(ifaces); err != nil {
+		return nil, fmt.Errorf("validating interfaces file %s, %w", c.ifacesFile, err)
+	}
+
+	return ifaces, nil
+}
+
+func (c *Client) WriteIfacesConfig() error {
+	err := WriteIfacesFile(c.ifacesFile, c.GetIfaces())
+
+	if err != nil {
+		return fmt.Errorf("writing interfaces file %s, %w", c.ifacesFile, err)
+	}
+
+	return nil
 }
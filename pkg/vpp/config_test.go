@@ -0,0 +1,332 @@
+package vpp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteIfacesFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "interfaces.toml")
+	header := "# CPE interfaces\n\n# managed by glubng-cli\n"
+	previous := header + "[old]\nVPPSrcIface = 1\n# dropped comment\n"
+	if err := os.WriteFile(filename, []byte(previous), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ifaces := map[string]Iface{
+		"cpe1": {VPPSrcIface: 1, IsSubIf: true, OuterVLAN: 101, MTU: 1500, FlexId: "cpe1"},
+	}
+	if err := WriteIfacesFile(filename, ifaces); err != nil {
+		t.Fatalf("WriteIfacesFile() error = %v", err)
+	}
+
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(body), header) {
+		t.Errorf("file starts with %q, want header %q", body, header)
+	}
+	if strings.Contains(string(body), "dropped comment") || strings.Contains(string(body), "[old]") {
+		t.Errorf("file keeps previous content, %q", body)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("file mode = %o, want 644", info.Mode().Perm())
+	}
+
+	got, err := ReadIfacesConfig(filename)
+	if err != nil {
+		t.Fatalf("ReadIfacesConfig() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, ifaces) {
+		t.Errorf("read %+v, want %+v", got, ifaces)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(filename))
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want no temporary files left", len(entries))
+	}
+}
+
+func TestWriteIfacesFileNew(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "interfaces.toml")
+
+	if err := WriteIfacesFile(filename, map[string]Iface{"cpe1": {VPPSrcIface: 1, MTU: 1500}}); err != nil {
+		t.Fatalf("WriteIfacesFile() error = %v", err)
+	}
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(body), "[cpe1]") {
+		t.Errorf("file = %q, want it to start with [cpe1]", body)
+	}
+}
+
+func TestWriteIfacesFileMissingDir(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "missing", "interfaces.toml")
+
+	if err := WriteIfacesFile(filename, map[string]Iface{}); err == nil {
+		t.Error("WriteIfacesFile() succeeded, want error")
+	}
+}
+
+func TestIPv6ConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  IPv6Config
+		wantErr string
+	}{
+		{name: "disabled", config: IPv6Config{}},
+		{name: "enabled", config: IPv6Config{Enable: true, GatewayIfaceAddrs: []string{"2001:db8:ffff::1"}, RAOther: true}},
+		{name: "IPv4 gateway", config: IPv6Config{Enable: true, GatewayIfaceAddrs: []string{"100.64.0.1"}}, wantErr: "GatewayIfaceAddrs"},
+		{name: "RDNSS", config: IPv6Config{Enable: true, RDNSS: []string{"2606:4700:4700::1111"}}, wantErr: "RDNSS is not supported"},
+		{name: "RDNSS disabled", config: IPv6Config{RDNSS: []string{"2606:4700:4700::1111"}}, wantErr: "RDNSS is not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+const editedIfaces = `# CPE interfaces
+# managed by glubng
+
+# Building A
+[cpe1]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 101
+MTU = 1500
+FlexId = "cpe1"
+
+  [cpe1.DHCP]
+  DNSServers = [
+    "192.0.2.53",
+  ]
+
+# Building B
+# second floor
+["cpe 2"]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 102 # moved from 202
+MTU = 1500
+FlexId = "cpe2"
+
+# Building C
+[cpe3]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 103
+MTU = 1500
+FlexId = "cpe3"
+`
+
+func writeIfacesFixture(t *testing.T, body string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "interfaces.toml")
+	if err := os.WriteFile(filename, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func readFile(t *testing.T, filename string) string {
+	t.Helper()
+
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestAddIfaceFile(t *testing.T) {
+	filename := writeIfacesFixture(t, editedIfaces)
+
+	v := Iface{VPPSrcIface: 1, IsSubIf: true, OuterVLAN: 104, MTU: 1500, FlexId: "cpe4"}
+	if err := AddIfaceFile(filename, "cpe4", v); err != nil {
+		t.Fatalf("AddIfaceFile() error = %v", err)
+	}
+
+	body := readFile(t, filename)
+	if !strings.HasPrefix(body, editedIfaces+"\n[cpe4]\n") {
+		t.Errorf("file = %q, want previous file followed by [cpe4]", body)
+	}
+	ifaces, err := ReadIfacesConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ifaces["cpe4"]; got.OuterVLAN != 104 || got.FlexId != "cpe4" {
+		t.Errorf("added interface = %+v, want %+v", got, v)
+	}
+
+	if err := AddIfaceFile(filename, "cpe1", v); err == nil {
+		t.Error("AddIfaceFile() of an existing interface succeeded, want error")
+	}
+	// Nothing is written when interfaces are not valid
+	if err := AddIfaceFile(filename, "cpe5", Iface{VPPSrcIface: 1, IsSubIf: true, OuterVLAN: 101, MTU: 1500, FlexId: "cpe5"}); err == nil {
+		t.Error("AddIfaceFile() of a duplicated VLAN succeeded, want error")
+	}
+	if _, ok := mustReadIfaces(t, filename)["cpe5"]; ok {
+		t.Error("interface with duplicated VLAN written")
+	}
+}
+
+func mustReadIfaces(t *testing.T, filename string) map[string]Iface {
+	t.Helper()
+
+	ifaces, err := ReadIfacesConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ifaces
+}
+
+func TestRemoveIfaceFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		iface string
+		want  string
+	}{
+		{
+			name:  "first with subtable",
+			iface: "cpe1",
+			want: `# CPE interfaces
+# managed by glubng
+
+# Building B
+# second floor
+["cpe 2"]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 102 # moved from 202
+MTU = 1500
+FlexId = "cpe2"
+
+# Building C
+[cpe3]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 103
+MTU = 1500
+FlexId = "cpe3"
+`,
+		},
+		{
+			name:  "quoted",
+			iface: "cpe 2",
+			want: `# CPE interfaces
+# managed by glubng
+
+# Building A
+[cpe1]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 101
+MTU = 1500
+FlexId = "cpe1"
+
+  [cpe1.DHCP]
+  DNSServers = [
+    "192.0.2.53",
+  ]
+
+# Building C
+[cpe3]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 103
+MTU = 1500
+FlexId = "cpe3"
+`,
+		},
+		{
+			name:  "last",
+			iface: "cpe3",
+			want: `# CPE interfaces
+# managed by glubng
+
+# Building A
+[cpe1]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 101
+MTU = 1500
+FlexId = "cpe1"
+
+  [cpe1.DHCP]
+  DNSServers = [
+    "192.0.2.53",
+  ]
+
+# Building B
+# second floor
+["cpe 2"]
+VPPSrcIface = 1
+IsSubIf = true
+OuterVLAN = 102 # moved from 202
+MTU = 1500
+FlexId = "cpe2"
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := writeIfacesFixture(t, editedIfaces)
+			if err := RemoveIfaceFile(filename, tt.iface); err != nil {
+				t.Fatalf("RemoveIfaceFile() error = %v", err)
+			}
+			if body := readFile(t, filename); body != tt.want {
+				t.Errorf("file = %q, want %q", body, tt.want)
+			}
+		})
+	}
+
+	filename := writeIfacesFixture(t, editedIfaces)
+	if err := RemoveIfaceFile(filename, "cpe9"); err == nil {
+		t.Error("RemoveIfaceFile() of an unknown interface succeeded, want error")
+	}
+}
+
+func TestTableKey(t *testing.T) {
+	tests := []struct {
+		line string
+		key  string
+		ok   bool
+	}{
+		{line: "[cpe1]", key: "cpe1", ok: true},
+		{line: "[ cpe1 . DHCP ]", key: "cpe1", ok: true},
+		{line: `["cpe \"1\"".DHCP]`, key: `cpe "1"`, ok: true},
+		{line: "['cpe.1']", key: "cpe.1", ok: true},
+		{line: "[[cpe1.Options]] # options", key: "cpe1", ok: true},
+		{line: `["a", "b"],`, ok: false},
+		{line: "MTU = 1500", ok: false},
+	}
+
+	for _, tt := range tests {
+		key, ok := tableKey([]byte(tt.line))
+		if key != tt.key || ok != tt.ok {
+			t.Errorf("tableKey(%q) = %q, %t, want %q, %t", tt.line, key, ok, tt.key, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package vpp
+
+import "net"
+
+// Dataplane groups VPP operations used by the control plane. Client
+// implements it against a real VPP, and against the in-memory VPP of
+// internal/vpptest.
+type Dataplane interface {
+	AddSession(ipv4 net.IP, iface uint32) error
+	RemoveSession(ipv4 net.IP, iface uint32) error
+	AddSessionPrefix(prefix *net.IPNet, iface uint32) error
+	RemoveSessionPrefix(prefix *net.IPNet, iface uint32) error
+	AdoptSessionPrefix(prefix *net.IPNet, iface uint32)
+	DumpSessionRoutes() (map[string]uint32, error)
+	GetIfacesSwMap() map[int]Iface
+	LookupIface(swIf int) (Iface, bool)
+	LookupIfaceName(name string) (Iface, bool)
+	LookupRelayIDs(circuitID string, remoteID string) (Iface, bool)
+	IfaceDHCP(swIf int) (DHCPSettings, bool)
+	GetIfaces() map[string]Iface
+	GetIfacesStatus() map[string]IfaceStatus
+	SetIfaceProfile(swIf int, session string, profile string) error
+	SetIfaceVRF(swIf int, session string, vrf string) error
+	Profiles() map[string]ServiceProfile
+	UpdateProfiles(profiles map[string]ServiceProfile) error
+	ReadCounters() (*Counters, error)
+	DiffIfacesConfig() (*IfacesDiff, error)
+	ApplyIfacesDiff(d *IfacesDiff) error
+	IsConnected() bool
+	Owned() []OwnedObject
+	Teardown() error
+	OnReconnect(fn func(ev ReconnectEvent))
+	Close()
+}
+
+// ReconnectEvent describes what was done once connection to VPP was
+// established again
+type ReconnectEvent struct {
+	// VPP lost its state, i.e. it was restarted, and it was provisioned again
+	Reprovisioned bool
+	// New SwIf of CPE interfaces recreated with a different index, old SwIf
+	// of interfaces which could not be provisioned again maps to -1
+	SwIfRemap map[int]int
+}
+
+var _ Dataplane = (*Client)(nil)
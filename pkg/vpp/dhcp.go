@@ -0,0 +1,126 @@
+package vpp
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// DHCPSettings are returned to Kea hook for queries of subscribers, so their
+// leases are driven by CPE interfaces and service profiles instead of
+// reservations in Kea configuration
+type DHCPSettings struct {
+	// Client classes assigned to queries
+	ClientClasses []string
+	// Subnet selected by Kea, its own selection is kept when zero
+	SubnetID uint32
+	// Pool of the subnet, as an IPv4 prefix or a range first-last
+	Pool string
+	// Reserved IPv4 address, only allowed in CPE interfaces
+	FixedAddress string
+	DNSServers   []string
+	DomainName   string
+	// Valid lifetime of leases in seconds, Kea default is used when zero
+	LeaseTime uint32
+}
+
+// IsEmpty reports if no setting is set
+func (d *DHCPSettings) IsEmpty() bool {
+	return len(d.ClientClasses) == 0 && d.SubnetID == 0 && d.Pool == "" && d.FixedAddress == "" &&
+		len(d.DNSServers) == 0 && d.DomainName == "" && d.LeaseTime == 0
+}
+
+// Validate checks addresses of settings
+func (d *DHCPSettings) Validate() error {
+	if d.Pool != "" && !isIPv4Pool(d.Pool) {
+		return fmt.Errorf("DHCP.Pool %q is not an IPv4 prefix or range", d.Pool)
+	}
+	if d.FixedAddress != "" {
+		if a, err := netip.ParseAddr(d.FixedAddress); err != nil || !a.Is4() {
+			return fmt.Errorf("DHCP.FixedAddress %q is not an IPv4 address", d.FixedAddress)
+		}
+	}
+	for _, v := range d.DNSServers {
+		if _, err := netip.ParseAddr(v); err != nil {
+			return fmt.Errorf("DHCP.DNSServers %q is not an IP address", v)
+		}
+	}
+
+	return nil
+}
+
+func isIPv4Pool(pool string) bool {
+	if p, err := netip.ParsePrefix(pool); err == nil {
+		return p.Addr().Is4()
+	}
+
+	for i := 0; i < len(pool); i++ {
+		if pool[i] != '-' {
+			continue
+		}
+		first, err1 := netip.ParseAddr(pool[:i])
+		last, err2 := netip.ParseAddr(pool[i+1:])
+		return err1 == nil && err2 == nil && first.Is4() && last.Is4() && first.Compare(last) <= 0
+	}
+
+	return false
+}
+
+// Merge returns settings of a CPE interface completed with the ones of its
+// service profile, client classes of both are kept
+func (d DHCPSettings) Merge(profile *DHCPSettings) DHCPSettings {
+	classes := append([]string(nil), d.ClientClasses...)
+	for _, c := range profile.ClientClasses {
+		if !contains(classes, c) {
+			classes = append(classes, c)
+		}
+	}
+	d.ClientClasses = classes
+
+	if d.SubnetID == 0 {
+		d.SubnetID = profile.SubnetID
+	}
+	if d.Pool == "" {
+		d.Pool = profile.Pool
+	}
+	if len(d.DNSServers) == 0 {
+		d.DNSServers = profile.DNSServers
+	}
+	if d.DomainName == "" {
+		d.DomainName = profile.DomainName
+	}
+	if d.LeaseTime == 0 {
+		d.LeaseTime = profile.LeaseTime
+	}
+
+	return d
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+var errProfileFixedAddress = errors.New("DHCP.FixedAddress is only allowed in CPE interfaces")
+
+// IfaceDHCP returns DHCP settings of a provisioned CPE interface, completed
+// with the ones of its service profile
+func (c *Client) IfaceDHCP(swIf int) (DHCPSettings, bool) {
+	v, ok := c.LookupIface(swIf)
+	if !ok {
+		return DHCPSettings{}, false
+	}
+
+	c.policerMu.Lock()
+	defer c.policerMu.Unlock()
+
+	if p, ok := c.config.Profiles[c.profileName(swIf, v.Profile)]; ok {
+		return v.DHCP.Merge(&p.DHCP), true
+	}
+
+	return v.DHCP, true
+}
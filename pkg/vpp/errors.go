@@ -0,0 +1,82 @@
+package vpp
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNoGwLoopback   = errors.New("gateway loopback is not available")
+	ErrInvalidAddress = errors.New("invalid address")
+	ErrIfaceNotFound  = errors.New("interface not found")
+	ErrNoTap          = errors.New("DHCP tap interface is not available")
+	ErrUnknownProfile = errors.New("unknown service profile")
+	ErrUnknownVRF     = errors.New("unknown VRF")
+	ErrVRFInUse       = errors.New("interface is in another VRF")
+	ErrClosed         = errors.New("VPP client is closed")
+)
+
+// ProvisionError is returned when a step configuring an object in VPP fails
+type ProvisionError struct {
+	Object string
+	Step   string
+	Err    error
+}
+
+func (e *ProvisionError) Error() string {
+	return e.Object + ", " + e.Step + ": " + e.Err.Error()
+}
+
+func (e *ProvisionError) Unwrap() error {
+	return e.Err
+}
+
+// ProvisionErrors aggregates failures of independent objects, so a failing
+// object does not prevent configuring the others
+type ProvisionErrors []error
+
+func (e ProvisionErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Append adds err, flattening aggregated errors
+func (e ProvisionErrors) Append(err error) ProvisionErrors {
+	var errs ProvisionErrors
+	if errors.As(err, &errs) {
+		return append(e, errs...)
+	}
+	if err != nil {
+		return append(e, err)
+	}
+
+	return e
+}
+
+// OrNil returns nil when there are no errors, avoiding non-nil interfaces
+// holding an empty slice
+func (e ProvisionErrors) OrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+type ProvisionState string
+
+const (
+	IfaceOK     ProvisionState = "ok"
+	IfaceFailed ProvisionState = "failed"
+)
+
+// IfaceStatus is the provisioning result of a CPE interface
+type IfaceStatus struct {
+	SwIf   int
+	State  ProvisionState
+	Reason string
+}
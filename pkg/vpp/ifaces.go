@@ -1,9 +1,12 @@
 package vpp
 
 import (
+	"time"
+
 	"go.fd.io/govpp/binapi/arp"
 	interfaces "go.fd.io/govpp/binapi/interface"
 	"go.fd.io/govpp/binapi/interface_types"
+	"go.fd.io/govpp/binapi/ip"
 	"go.fd.io/govpp/binapi/ip_types"
 	"go.fd.io/govpp/binapi/tapv2"
 )
@@ -16,7 +19,7 @@ func (c *Client) setInterfaceUp(swIf int) error {
 
 	reply := &interfaces.SwInterfaceSetFlagsReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
 		return err
 	}
 
@@ -33,7 +36,7 @@ func (c *Client) setInterfaceMTU(swIf int, mtu uint32) error {
 
 	reply := &interfaces.SwInterfaceSetMtuReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
 		return err
 	}
 
@@ -49,14 +52,14 @@ func (c *Client) setInterfaceProxyARP(swIf int, enable bool) error {
 
 	reply := &arp.ProxyArpIntfcEnableDisableReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (c *Client) setInterfaceAddrIPv4(swIf int, ipv4 *ip_types.Address, len uint8) error {
+func (c *Client) setInterfaceAddr(swIf int, ipv4 *ip_types.Address, len uint8) error {
 	req := &interfaces.SwInterfaceAddDelAddress{
 		SwIfIndex: interface_types.InterfaceIndex(swIf),
 		IsAdd:     true,
@@ -67,23 +70,57 @@ func (c *Client) setInterfaceAddrIPv4(swIf int, ipv4 *ip_types.Address, len uint
 	}
 	reply := &interfaces.SwInterfaceAddDelAddressReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (c *Client) setInterfaceUnnumbered(swIf int, toSwIf int) error {
+func (c *Client) delInterfaceAddr(swIf int, ipv4 *ip_types.Address, len uint8) error {
+	req := &interfaces.SwInterfaceAddDelAddress{
+		SwIfIndex: interface_types.InterfaceIndex(swIf),
+		IsAdd:     false,
+		Prefix: ip_types.AddressWithPrefix{
+			Address: *ipv4,
+			Len:     len,
+		},
+	}
+	reply := &interfaces.SwInterfaceAddDelAddressReply{}
+
+	if err := c.request(req, reply); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) setInterfaceUnnumbered(swIf int, toSwIf int, isAdd bool) error {
 	req := &interfaces.SwInterfaceSetUnnumbered{
 		SwIfIndex:           interface_types.InterfaceIndex(toSwIf),
 		UnnumberedSwIfIndex: interface_types.InterfaceIndex(swIf),
-		IsAdd:               true,
+		IsAdd:               isAdd,
 	}
 
 	reply := &interfaces.SwInterfaceSetUnnumberedReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// setInterfaceIP6 enables IPv6 in an interface, it gets a link-local address
+func (c *Client) setInterfaceIP6(swIf int, enable bool) error {
+	req := &ip.SwInterfaceIP6EnableDisable{
+		SwIfIndex: interface_types.InterfaceIndex(swIf),
+		Enable:    enable,
+	}
+
+	reply := &ip.SwInterfaceIP6EnableDisableReply{}
+
+	if err := c.request(req, reply); err != nil {
 		return err
 	}
 
@@ -94,7 +131,7 @@ func (c *Client) createLoopackIface() (int, error) {
 	req := &interfaces.CreateLoopback{}
 	reply := &interfaces.CreateLoopbackReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
 		return 0, err
 	}
 
@@ -110,9 +147,15 @@ func (c *Client) createTapInterface(ipv4 *ip_types.Address, len uint8) (int, err
 		HostIP4PrefixSet: true,
 		HostIP4Prefix:    ip_types.IP4AddressWithPrefix{Address: ipv4.Un.GetIP4(), Len: len},
 	}
+
+	// Host end of DHCPv6 relay
+	if _, host, bits, err := tapIPv6Addrs(c.config.TapNetworkPrefixIPv6); err == nil {
+		req.HostIP6PrefixSet = true
+		req.HostIP6Prefix = ip_types.IP6AddressWithPrefix{Address: host.Un.GetIP6(), Len: bits}
+	}
 	reply := &tapv2.TapCreateV2Reply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
 		return 0, err
 	}
 
@@ -129,7 +172,7 @@ func (c *Client) createVlanInterface(swIf int, id int, vlan int) (int, error) {
 
 	reply := &interfaces.CreateSubifReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
 		return 0, err
 	}
 
@@ -147,9 +190,75 @@ func (c *Client) createQinQInterface(swIf int, id int, outerVlan int, innerVlan
 
 	reply := &interfaces.CreateSubifReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
 		return 0, err
 	}
 
 	return int(reply.SwIfIndex), nil
 }
+
+func (c *Client) deleteSubInterface(swIf int) error {
+	req := &interfaces.DeleteSubif{SwIfIndex: interface_types.InterfaceIndex(swIf)}
+	reply := &interfaces.DeleteSubifReply{}
+
+	if err := c.request(req, reply); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) deleteLoopbackIface(swIf int) error {
+	req := &interfaces.DeleteLoopback{SwIfIndex: interface_types.InterfaceIndex(swIf)}
+	reply := &interfaces.DeleteLoopbackReply{}
+
+	if err := c.request(req, reply); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) deleteTapInterface(swIf int) error {
+	req := &tapv2.TapDeleteV2{SwIfIndex: interface_types.InterfaceIndex(swIf)}
+	reply := &tapv2.TapDeleteV2Reply{}
+
+	if err := c.request(req, reply); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// dumpInterfaces returns details of interfaces indexed by SwIf, only swIf is
+// dumped unless it is negative
+func (c *Client) dumpInterfaces(swIf int) (_ map[int]*interfaces.SwInterfaceDetails, err error) {
+	req := &interfaces.SwInterfaceDump{SwIfIndex: interface_types.InterfaceIndex(swIf)}
+	if swIf < 0 {
+		req.SwIfIndex = ^interface_types.InterfaceIndex(0)
+	}
+
+	ch, err := c.channel()
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(ch)
+	defer observeRequest(req, time.Now(), &err)
+	reqCtx := ch.SendMultiRequest(req)
+
+	ifaces := make(map[int]*interfaces.SwInterfaceDetails)
+	for {
+		reply := &interfaces.SwInterfaceDetails{}
+		var stop bool
+		stop, err = reqCtx.ReceiveReply(reply)
+		if err != nil {
+			return nil, err
+		}
+		if stop {
+			break
+		}
+		ifaces[int(reply.SwIfIndex)] = reply
+	}
+
+	return ifaces, nil
+}
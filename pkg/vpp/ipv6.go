@@ -0,0 +1,165 @@
+package vpp
+
+import (
+	"net/netip"
+
+	"go.fd.io/govpp/api"
+	"go.fd.io/govpp/binapi/interface_types"
+	"go.fd.io/govpp/binapi/ip6_nd"
+	"go.fd.io/govpp/binapi/ip_types"
+)
+
+// tapIPv6Addrs returns VPP and host ends of the tap in an IPv6 network, as in
+// IPv4 they are first and second addresses of the network
+func tapIPv6Addrs(prefix string) (ip_types.Address, ip_types.Address, uint8, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return ip_types.Address{}, ip_types.Address{}, 0, err
+	}
+	if !p.Addr().Is6() {
+		return ip_types.Address{}, ip_types.Address{}, 0, ErrInvalidAddress
+	}
+
+	first := p.Masked().Addr().Next()
+	second := first.Next()
+
+	vpp := ip_types.Address{Af: ip_types.ADDRESS_IP6, Un: ip_types.AddressUnionIP6(first.As16())}
+	host := ip_types.Address{Af: ip_types.ADDRESS_IP6, Un: ip_types.AddressUnionIP6(second.As16())}
+
+	return vpp, host, uint8(p.Bits()), nil
+}
+
+// configDHCPv6Relay relays DHCPv6 from CPEs to the server at host end of the
+// tap created by configDHCPRelay
+func (c *Client) configDHCPv6Relay() error {
+	if c.config.TapNetworkPrefixIPv6 == "" {
+		return nil
+	}
+
+	vppIP, hostIP, bits, err := tapIPv6Addrs(c.config.TapNetworkPrefixIPv6)
+	if err != nil {
+		return &ProvisionError{Object: "dhcpv6-relay", Step: "parse TapNetworkPrefixIPv6", Err: err}
+	}
+
+	if c.tapSwIf < 0 {
+		return &ProvisionError{Object: "dhcpv6-relay", Step: "add IPv6 to tap interface", Err: ErrNoTap}
+	}
+
+	// Add first IPv6 from net to tap, it also enables IPv6 on it
+	err = c.setInterfaceAddr(c.tapSwIf, &vppIP, bits)
+	if err != nil && !isAddressConfigured(err) {
+		return &ProvisionError{Object: "dhcpv6-relay", Step: "add IPv6 to tap interface", Err: err}
+	}
+
+	// Enable DHCPv6 Proxy to External Server
+	err = c.setProxyDHCP(&vppIP, &hostIP, true)
+	if err != nil {
+		return &ProvisionError{Object: "dhcpv6-relay", Step: "set DHCPv6 proxy", Err: err}
+	}
+	c.owned.add(OwnedDHCPProxy, hostIP.String(), -1, func() error {
+		return c.setProxyDHCP(&vppIP, &hostIP, false)
+	})
+
+	return nil
+}
+
+// configIPv6GwLoopback enables IPv6 in the gateway loopback and adds IPv6
+// gateway addresses to it. CPE interfaces are unnumbered to a single
+// interface, so the IPv4 gateway loopback is shared.
+func (c *Client) configIPv6GwLoopback() error {
+	if !c.config.IPv6.Enable {
+		return nil
+	}
+
+	if c.gwLoopSwIf < 0 {
+		return &ProvisionError{Object: "gateway loopback", Step: "enable IPv6", Err: ErrNoGwLoopback}
+	}
+
+	err := c.setInterfaceIP6(c.gwLoopSwIf, true)
+	if err != nil && !isVPPError(err, api.VALUE_EXIST) {
+		return &ProvisionError{Object: "gateway loopback", Step: "enable IPv6", Err: err}
+	}
+
+	var errs ProvisionErrors
+
+	for _, v := range c.config.IPv6.GatewayIfaceAddrs {
+		vppip, err := ip_types.ParseAddress(v)
+		if err != nil || vppip.Af != ip_types.ADDRESS_IP6 {
+			errs = append(errs, &ProvisionError{Object: "gateway loopback", Step: "parse IPv6 " + v, Err: ErrInvalidAddress})
+			continue
+		}
+
+		err = c.setInterfaceAddr(c.gwLoopSwIf, &vppip, 128)
+		if err != nil && !isAddressConfigured(err) {
+			errs = append(errs, &ProvisionError{Object: "gateway loopback", Step: "add IPv6 " + v, Err: err})
+		}
+	}
+
+	return errs.OrNil()
+}
+
+// enableCPEIPv6 enables IPv6 in a CPE interface, its link-local address is
+// advertised as default router to the CPE
+func (c *Client) enableCPEIPv6(swIf int) error {
+	err := c.setInterfaceIP6(swIf, true)
+	if err != nil && !isVPPError(err, api.VALUE_EXIST) {
+		return err
+	}
+
+	return nil
+}
+
+// configRouterAdvertisements configures router advertisements sent to a CPE
+// interface. VPP advertisements have no RDNSS option, DNS servers are handed
+// out by DHCPv6 when RAOther is set.
+func (c *Client) configRouterAdvertisements(swIf int) error {
+	cfg := &c.config.IPv6
+
+	req := &ip6_nd.SwInterfaceIP6ndRaConfig{
+		SwIfIndex:   interface_types.InterfaceIndex(swIf),
+		Managed:     boolToU8(cfg.RAManaged),
+		Other:       boolToU8(cfg.RAOther),
+		MaxInterval: cfg.RAMaxInterval,
+		MinInterval: cfg.RAMinInterval,
+		Lifetime:    cfg.RALifetime,
+	}
+	reply := &ip6_nd.SwInterfaceIP6ndRaConfigReply{}
+
+	if err := c.request(req, reply); err != nil {
+		return err
+	}
+
+	for _, v := range cfg.RAPrefixes {
+		p, err := netip.ParsePrefix(v.Prefix)
+		if err != nil {
+			return err
+		}
+		prefix, err := ip_types.ParsePrefix(p.Masked().String())
+		if err != nil {
+			return err
+		}
+
+		req := &ip6_nd.SwInterfaceIP6ndRaPrefix{
+			SwIfIndex:    interface_types.InterfaceIndex(swIf),
+			Prefix:       prefix,
+			UseDefault:   v.ValidLifetime == 0 && v.PreferredLifetime == 0,
+			NoAutoconfig: !v.Autoconfig,
+			ValLifetime:  v.ValidLifetime,
+			PrefLifetime: v.PreferredLifetime,
+		}
+		reply := &ip6_nd.SwInterfaceIP6ndRaPrefixReply{}
+
+		if err := c.request(req, reply); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func boolToU8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -5,16 +5,16 @@ import (
 	"go.fd.io/govpp/binapi/ip_types"
 )
 
-func (c *Client) setProxyDHCPv4(src *ip_types.Address, dst *ip_types.Address) error {
+func (c *Client) setProxyDHCP(src *ip_types.Address, dst *ip_types.Address, isAdd bool) error {
 	req := &dhcp.DHCPProxyConfig{
-		IsAdd:          true,
+		IsAdd:          isAdd,
 		DHCPServer:     *dst,
 		DHCPSrcAddress: *src,
 	}
 
 	reply := &dhcp.DHCPProxyConfigReply{}
 
-	if err := c.ch.SendRequest(req).ReceiveReply(reply); err != nil {
+	if err := c.request(req, reply); err != nil {
 		return err
 	}
 
@@ -0,0 +1,144 @@
+package vpp
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// OwnedKind is the type of an object created in VPP by glubngd
+type OwnedKind string
+
+const (
+	OwnedRoute      OwnedKind = "route"
+	OwnedPolicer    OwnedKind = "policer"
+	OwnedUplinkAddr OwnedKind = "uplink-address"
+	OwnedCPEIface   OwnedKind = "cpe-interface"
+	OwnedDHCPProxy  OwnedKind = "dhcp-proxy"
+	OwnedTap        OwnedKind = "tap"
+	OwnedProxyArp   OwnedKind = "proxy-arp"
+	OwnedLoopback   OwnedKind = "loopback"
+)
+
+// Objects are removed before the ones they depend on
+var teardownOrder = []OwnedKind{
+	OwnedRoute,
+	OwnedPolicer,
+	OwnedUplinkAddr,
+	OwnedCPEIface,
+	OwnedDHCPProxy,
+	OwnedTap,
+	OwnedProxyArp,
+	OwnedLoopback,
+}
+
+// OwnedObject is an object created in VPP by glubngd
+type OwnedObject struct {
+	Kind OwnedKind
+	Name string
+	// SwIf of the interface, -1 for objects which are not interfaces
+	SwIf int
+}
+
+type ownedEntry struct {
+	OwnedObject
+	// Removes the object from VPP
+	undo func() error
+}
+
+// ownership registry keeps every object created in VPP and how to remove it
+type ownership struct {
+	mu      sync.Mutex
+	objects map[OwnedKind]map[string]*ownedEntry
+}
+
+func (o *ownership) add(kind OwnedKind, name string, swIf int, undo func() error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.objects == nil {
+		o.objects = make(map[OwnedKind]map[string]*ownedEntry)
+	}
+	if o.objects[kind] == nil {
+		o.objects[kind] = make(map[string]*ownedEntry)
+	}
+
+	o.objects[kind][name] = &ownedEntry{OwnedObject{Kind: kind, Name: name, SwIf: swIf}, undo}
+}
+
+func (o *ownership) remove(kind OwnedKind, name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.objects[kind], name)
+}
+
+// reset forgets every object, used when VPP lost them
+func (o *ownership) reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.objects = nil
+}
+
+// entries returns objects of a kind sorted by name
+func (o *ownership) entries(kind OwnedKind) []*ownedEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	list := make([]*ownedEntry, 0, len(o.objects[kind]))
+	for _, e := range o.objects[kind] {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// Owned returns objects created in VPP by the client, in teardown order
+func (c *Client) Owned() []OwnedObject {
+	var list []OwnedObject
+	for _, kind := range teardownOrder {
+		for _, e := range c.owned.entries(kind) {
+			list = append(list, e.OwnedObject)
+		}
+	}
+
+	return list
+}
+
+// Teardown removes every object created in VPP by the client, dependent
+// objects first. Objects failing to be removed are kept in the registry.
+func (c *Client) Teardown() error {
+	c.provisionMu.Lock()
+	defer c.provisionMu.Unlock()
+
+	var errs ProvisionErrors
+	var removed int
+
+	for _, kind := range teardownOrder {
+		for _, e := range c.owned.entries(kind) {
+			if err := e.undo(); err != nil {
+				errs = append(errs, &ProvisionError{Object: string(kind) + " " + e.Name, Step: "remove", Err: err})
+				continue
+			}
+			c.owned.remove(kind, e.Name)
+			removed++
+		}
+	}
+
+	c.ifacesMu.Lock()
+	c.ifacesSwIf = make(map[int]Iface)
+	c.ifacesMu.Unlock()
+	c.gwLoopSwIf = -1
+	c.tapSwIf = -1
+
+	log.Printf("Removed %d objects from VPP, %d failed", removed, len(errs))
+
+	return errs.OrNil()
+}
+
+func swIfName(swIf int) string {
+	return "SwIf " + strconv.Itoa(swIf)
+}
@@ -0,0 +1,286 @@
+package vpp
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"go.fd.io/govpp/binapi/interface_types"
+	"go.fd.io/govpp/binapi/policer"
+	"go.fd.io/govpp/binapi/policer_types"
+)
+
+// Only direction supported by VPP policer API 2.0, traffic sent by the
+// subscriber is policed when it enters the CPE interface. Its govpp bindings
+// have no PolicerOutput, ProfileDirectionOutput is rejected by Validate.
+const (
+	ProfileDirectionInput  = "input"
+	ProfileDirectionOutput = "output"
+)
+
+// ServiceProfile limits bandwidth of a subscriber with a VPP policer bound to
+// its CPE interface
+type ServiceProfile struct {
+	// Committed rate in kbps
+	Rate uint32
+	// Committed burst in bytes, 200ms of Rate when zero
+	Burst uint64
+	// ProfileDirectionInput, it's the default when empty. Traffic sent to
+	// subscribers can not be policed.
+	Direction string
+	// Returned to Kea hook for queries of subscribers, settings of CPE
+	// interfaces take precedence
+	DHCP DHCPSettings
+}
+
+// samePolicer reports if policers of both profiles are the same
+func (p *ServiceProfile) samePolicer(o *ServiceProfile) bool {
+	return p.Rate == o.Rate && p.Burst == o.Burst && p.Direction == o.Direction
+}
+
+// leaseProfile is a service profile set by the lease of a session
+type leaseProfile struct {
+	session string
+	profile string
+}
+
+func policerName(swIf int) string {
+	return tagPrefix + "policer-" + strconv.Itoa(swIf)
+}
+
+// profileName returns service profile of a CPE interface, the one set last by
+// the lease of a session on it or else the one of the interface. policerMu
+// must be held.
+func (c *Client) profileName(swIf int, ifaceProfile string) string {
+	if leases := c.leaseProfiles[swIf]; len(leases) != 0 {
+		return leases[len(leases)-1].profile
+	}
+	return ifaceProfile
+}
+
+// applyProfile makes the policer of a CPE interface match its service profile
+func (c *Client) applyProfile(swIf int, ifaceProfile string) error {
+	c.policerMu.Lock()
+	defer c.policerMu.Unlock()
+
+	name := c.profileName(swIf, ifaceProfile)
+
+	var want *ServiceProfile
+	if name != "" {
+		p, ok := c.config.Profiles[name]
+		if !ok {
+			return fmt.Errorf("%w %s", ErrUnknownProfile, name)
+		}
+		want = &p
+	}
+
+	cur, ok := c.policers[swIf]
+	if ok && want != nil && cur.samePolicer(want) {
+		return nil
+	}
+	if ok {
+		// VPP policers can not be updated, they are replaced
+		if err := c.removePolicer(swIf); err != nil {
+			return err
+		}
+	}
+	if want == nil {
+		return nil
+	}
+
+	return c.addPolicer(swIf, *want)
+}
+
+func (c *Client) addPolicer(swIf int, p ServiceProfile) error {
+	name := policerName(swIf)
+
+	// Policer may be left by a previous run
+	c.bindPolicer(name, swIf, false)
+	c.addDelPolicer(name, p, false)
+
+	if err := c.addDelPolicer(name, p, true); err != nil {
+		return err
+	}
+	if err := c.bindPolicer(name, swIf, true); err != nil {
+		c.addDelPolicer(name, p, false)
+		return err
+	}
+
+	if c.policers == nil {
+		c.policers = make(map[int]ServiceProfile)
+	}
+	c.policers[swIf] = p
+	c.owned.add(OwnedPolicer, name, swIf, func() error {
+		c.policerMu.Lock()
+		defer c.policerMu.Unlock()
+		return c.removePolicer(swIf)
+	})
+
+	return nil
+}
+
+// removePolicer unbinds and deletes policer of an interface, policerMu must
+// be held
+func (c *Client) removePolicer(swIf int) error {
+	p, ok := c.policers[swIf]
+	if !ok {
+		return nil
+	}
+
+	name := policerName(swIf)
+	if err := c.bindPolicer(name, swIf, false); err != nil {
+		return err
+	}
+	if err := c.addDelPolicer(name, p, false); err != nil {
+		return err
+	}
+
+	delete(c.policers, swIf)
+	c.owned.remove(OwnedPolicer, name)
+
+	return nil
+}
+
+// unconfigPolicer removes policer of a CPE interface being deleted, its
+// lease profile is forgotten
+func (c *Client) unconfigPolicer(swIf int) error {
+	c.policerMu.Lock()
+	defer c.policerMu.Unlock()
+
+	delete(c.leaseProfiles, swIf)
+
+	return c.removePolicer(swIf)
+}
+
+// resetPolicers forgets policers, used when VPP lost them
+func (c *Client) resetPolicers() {
+	c.policerMu.Lock()
+	defer c.policerMu.Unlock()
+
+	c.policers = nil
+	c.leaseProfiles = nil
+}
+
+// SetIfaceProfile applies a service profile given by the lease of a session
+// to its CPE interface, an empty profile drops the one of the session. Policer
+// of the interface follows the session which set its profile last, profile of
+// the interface is restored when no session sets one.
+func (c *Client) SetIfaceProfile(swIf int, session string, profile string) error {
+	v, ok := c.LookupIface(swIf)
+	if !ok {
+		return fmt.Errorf("%w, SwIf %d", ErrIfaceNotFound, swIf)
+	}
+
+	c.policerMu.Lock()
+	if profile != "" {
+		if _, ok := c.config.Profiles[profile]; !ok {
+			c.policerMu.Unlock()
+			return fmt.Errorf("%w %s", ErrUnknownProfile, profile)
+		}
+	}
+	leases := c.leaseProfiles[swIf]
+	for i, l := range leases {
+		if l.session == session {
+			leases = append(leases[:i:i], leases[i+1:]...)
+			break
+		}
+	}
+	if profile != "" {
+		leases = append(leases, leaseProfile{session: session, profile: profile})
+	}
+	if c.leaseProfiles == nil {
+		c.leaseProfiles = make(map[int][]leaseProfile)
+	}
+	if len(leases) == 0 {
+		delete(c.leaseProfiles, swIf)
+	} else {
+		c.leaseProfiles[swIf] = leases
+	}
+	c.policerMu.Unlock()
+
+	return c.applyProfile(swIf, v.Profile)
+}
+
+// Profiles returns a copy of running service profiles
+func (c *Client) Profiles() map[string]ServiceProfile {
+	c.policerMu.Lock()
+	defer c.policerMu.Unlock()
+
+	profiles := make(map[string]ServiceProfile, len(c.config.Profiles))
+	for k, v := range c.config.Profiles {
+		profiles[k] = v
+	}
+
+	return profiles
+}
+
+// UpdateProfiles replaces service profiles, policers of changed profiles are
+// replaced live
+func (c *Client) UpdateProfiles(profiles map[string]ServiceProfile) error {
+	c.provisionMu.Lock()
+	defer c.provisionMu.Unlock()
+
+	c.policerMu.Lock()
+	c.config.Profiles = profiles
+	c.policerMu.Unlock()
+
+	var errs ProvisionErrors
+	for swIf, v := range c.GetIfacesSwMap() {
+		if err := c.applyProfile(swIf, v.Profile); err != nil {
+			errs = append(errs, &ProvisionError{Object: swIfName(swIf), Step: "apply service profile", Err: err})
+		}
+	}
+	log.Printf("Service profiles updated, %d policers failed", len(errs))
+
+	return errs.OrNil()
+}
+
+func (c *Client) addDelPolicer(name string, p ServiceProfile, isAdd bool) error {
+	burst := p.Burst
+	if burst == 0 {
+		burst = uint64(p.Rate) * 1000 / 8 / 5
+	}
+
+	req := &policer.PolicerAddDel{
+		IsAdd:     isAdd,
+		Name:      name,
+		Cir:       p.Rate,
+		Cb:        burst,
+		RateType:  policer_types.SSE2_QOS_RATE_API_KBPS,
+		RoundType: policer_types.SSE2_QOS_ROUND_API_TO_CLOSEST,
+		Type:      policer_types.SSE2_QOS_POLICER_TYPE_API_1R2C,
+		ConformAction: policer_types.Sse2QosAction{
+			Type: policer_types.SSE2_QOS_ACTION_API_TRANSMIT,
+		},
+		ExceedAction: policer_types.Sse2QosAction{
+			Type: policer_types.SSE2_QOS_ACTION_API_DROP,
+		},
+		ViolateAction: policer_types.Sse2QosAction{
+			Type: policer_types.SSE2_QOS_ACTION_API_DROP,
+		},
+	}
+
+	reply := &policer.PolicerAddDelReply{}
+
+	if err := c.request(req, reply); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) bindPolicer(name string, swIf int, apply bool) error {
+	req := &policer.PolicerInput{
+		Name:      name,
+		SwIfIndex: interface_types.InterfaceIndex(swIf),
+		Apply:     apply,
+	}
+
+	reply := &policer.PolicerInputReply{}
+
+	if err := c.request(req, reply); err != nil {
+		return err
+	}
+
+	return nil
+}
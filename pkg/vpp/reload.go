@@ -0,0 +1,199 @@
+package vpp
+
+import (
+	"log"
+	"reflect"
+	"sort"
+)
+
+// IfacesDiff holds changes of interfaces.toml compared with running CPE
+// interfaces
+type IfacesDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+	// SwIf of running interfaces deleted by the changes, sessions on them
+	// must be torn down
+	TornDown []int
+	ifaces   map[string]Iface
+}
+
+// IsEmpty reports if interfaces file has no changes
+func (d *IfacesDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// needsRecreate reports if changes of an interface can not be applied to the
+// existing one
+func needsRecreate(old Iface, v Iface) bool {
+	old.MTU, v.MTU = 0, 0
+	old.Profile, v.Profile = "", ""
+	old.FlexId, v.FlexId = "", ""
+	old.CircuitID, v.CircuitID = "", ""
+	old.RemoteID, v.RemoteID = "", ""
+	old.DHCP, v.DHCP = DHCPSettings{}, DHCPSettings{}
+	old.SwIf, v.SwIf = 0, 0
+
+	return !reflect.DeepEqual(old, v)
+}
+
+// DiffIfacesConfig reads interfaces file again and compares it with running
+// CPE interfaces. Invalid files are rejected with an error.
+func (c *Client) DiffIfacesConfig() (*IfacesDiff, error) {
+	next, err := c.readIfacesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cur := c.GetIfaces()
+	status := c.GetIfacesStatus()
+	d := &IfacesDiff{ifaces: next}
+
+	for name, old := range cur {
+		v, ok := next[name]
+		v.SwIf = old.SwIf
+
+		switch {
+		case !ok:
+			d.Removed = append(d.Removed, name)
+		case !reflect.DeepEqual(v, old):
+			d.Changed = append(d.Changed, name)
+			if !needsRecreate(old, v) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if st := status[name]; st.State == IfaceOK {
+			d.TornDown = append(d.TornDown, st.SwIf)
+		}
+	}
+
+	for name := range next {
+		if _, ok := cur[name]; !ok {
+			d.Added = append(d.Added, name)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	sort.Ints(d.TornDown)
+
+	return d, nil
+}
+
+// ApplyIfacesDiff configures VPP with the changes of interfaces file. Removed
+// interfaces are deleted, changed ones are updated or recreated and added
+// ones are provisioned.
+func (c *Client) ApplyIfacesDiff(d *IfacesDiff) error {
+	c.provisionMu.Lock()
+	defer c.provisionMu.Unlock()
+
+	var errs ProvisionErrors
+	ifaces := c.GetIfaces()
+	status := c.GetIfacesStatus()
+
+	for _, name := range d.Removed {
+		log.Printf("Removing CPE interface %s", name)
+		errs = errs.Append(c.unconfigCPEInterface(name, ifaces[name], status[name].SwIf))
+		delete(ifaces, name)
+		c.deleteIfaceStatus(name)
+	}
+
+	for _, name := range d.Changed {
+		old, v := ifaces[name], d.ifaces[name]
+		var err error
+
+		if status[name].State == IfaceOK && !needsRecreate(old, v) {
+			log.Printf("Updating CPE interface %s", name)
+			v.SwIf = old.SwIf
+			if v.MTU != old.MTU {
+				if err = c.setInterfaceMTU(v.SwIf, v.MTU); err != nil {
+					err = &ProvisionError{Object: "iface " + name, Step: "set MTU", Err: err}
+				}
+			}
+			if err == nil && v.Profile != old.Profile {
+				if err = c.applyProfile(v.SwIf, v.Profile); err != nil {
+					err = &ProvisionError{Object: "iface " + name, Step: "apply service profile", Err: err}
+				}
+			}
+			c.setIfaceStatus(name, v.SwIf, err)
+			ifaces[name] = v
+			errs = errs.Append(err)
+			continue
+		}
+
+		log.Printf("Recreating CPE interface %s", name)
+		if err = c.unconfigCPEInterface(name, old, status[name].SwIf); err != nil {
+			// Keep running interface, it can not be replaced
+			c.setIfaceStatus(name, status[name].SwIf, err)
+			errs = errs.Append(err)
+			continue
+		}
+		ifaces[name], err = c.provisionCPEInterface(name, v)
+		errs = errs.Append(err)
+	}
+
+	for _, name := range d.Added {
+		log.Printf("Adding CPE interface %s", name)
+		var err error
+		ifaces[name], err = c.provisionCPEInterface(name, d.ifaces[name])
+		errs = errs.Append(err)
+	}
+
+	c.setIfaces(ifaces)
+
+	return errs.OrNil()
+}
+
+// unconfigCPEInterface undoes configCPEInterface, sub-interfaces are deleted
+// and parent interfaces only lose unnumbered and proxy-arp configuration
+func (c *Client) unconfigCPEInterface(name string, v Iface, swIf int) error {
+	fail := func(step string, err error) error {
+		return &ProvisionError{Object: "iface " + name, Step: step, Err: err}
+	}
+
+	// Interface was never created
+	if swIf < 0 {
+		return nil
+	}
+
+	if err := c.unconfigPolicer(swIf); err != nil {
+		return fail("remove policer", err)
+	}
+	if err := c.unconfigVRF(swIf); err != nil {
+		return fail("set table 0", err)
+	}
+
+	if v.IsSubIf {
+		if err := c.deleteSubInterface(swIf); err != nil {
+			return fail("delete sub-interface", err)
+		}
+		c.owned.remove(OwnedCPEIface, name)
+		return nil
+	}
+
+	// Router advertisements go away with IPv6
+	if c.config.IPv6.Enable {
+		if err := c.setInterfaceIP6(swIf, false); err != nil {
+			return fail("disable IPv6", err)
+		}
+	}
+
+	if c.config.EnableProxyARP {
+		if err := c.setInterfaceProxyARP(swIf, false); err != nil {
+			return fail("disable proxy-arp", err)
+		}
+	}
+
+	if c.gwLoopSwIf >= 0 {
+		if err := c.setInterfaceUnnumbered(swIf, c.gwLoopSwIf, false); err != nil {
+			return fail("unset unnumbered", err)
+		}
+	}
+	c.owned.remove(OwnedCPEIface, name)
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package vpp
+
+import (
+	"errors"
+	"fmt"
+
+	"go.fd.io/govpp/adapter"
+	"go.fd.io/govpp/adapter/statsclient"
+)
+
+// Counters collected from the stats segment
+const (
+	// Combined rx and tx counters of CPE interfaces
+	StatsInterface = "interface"
+	// Combined counters of session routes, traffic routed to subscribers
+	StatsRoute = "route"
+)
+
+const defaultStatsInterval = 10
+
+// Stats segment entries of collected counters
+const (
+	statsIfaceRx = "/if/rx"
+	statsIfaceTx = "/if/tx"
+	statsRouteTo = "/net/route/to"
+)
+
+var ErrStatsDisabled = errors.New("stats segment is not connected")
+
+// Sampling of per subscriber counters, enabled by SrcStatsSocket
+type StatsConfig struct {
+	// Seconds between samples, 10 when zero
+	Interval int
+	// StatsInterface and StatsRoute, both are collected when empty
+	Counters []string
+}
+
+// IntervalSeconds returns seconds between samples
+func (c *StatsConfig) IntervalSeconds() int {
+	if c.Interval == 0 {
+		return defaultStatsInterval
+	}
+	return c.Interval
+}
+
+// Collects reports if a type of counters is collected
+func (c *StatsConfig) Collects(counters string) bool {
+	if len(c.Counters) == 0 {
+		return true
+	}
+	for _, v := range c.Counters {
+		if v == counters {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks sampling configuration
+func (c *StatsConfig) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("vpp.Stats.Interval is negative")
+	}
+	for _, v := range c.Counters {
+		if v != StatsInterface && v != StatsRoute {
+			return fmt.Errorf("vpp.Stats.Counters %q is not %s or %s", v, StatsInterface, StatsRoute)
+		}
+	}
+
+	return nil
+}
+
+// Counter is a combined counter of VPP
+type Counter struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// IfaceCounters are counters of a CPE interface, rx is traffic sent by the
+// subscriber
+type IfaceCounters struct {
+	Rx Counter
+	Tx Counter
+}
+
+// Counters holds a sample of the stats segment, values are totals kept by
+// VPP and restart from zero when objects are created again
+type Counters struct {
+	// By SwIf of CPE interfaces
+	Ifaces map[int]IfaceCounters
+	// By prefix of session routes
+	Routes map[string]Counter
+}
+
+// connectStats connects to stats segment, counters are not available if it
+// fails
+func (c *Client) connectStats() error {
+	if c.config.SrcStatsSocket == "" {
+		return nil
+	}
+
+	stats := statsclient.NewStatsClient(c.config.SrcStatsSocket)
+	if err := stats.Connect(); err != nil {
+		return fmt.Errorf("connecting to VPP stats segment %s, %w", c.config.SrcStatsSocket, err)
+	}
+	c.stats = stats
+
+	return nil
+}
+
+func (c *Client) closeStats() {
+	if c.stats != nil {
+		c.stats.Disconnect()
+	}
+}
+
+// setRouteStats keeps stats index of a session route, deleted with a
+// negative index
+func (c *Client) setRouteStats(prefix string, index int64) {
+	c.routeStatsMu.Lock()
+	defer c.routeStatsMu.Unlock()
+
+	if index < 0 {
+		delete(c.routeStats, prefix)
+		return
+	}
+	if c.routeStats == nil {
+		c.routeStats = make(map[string]uint32)
+	}
+	c.routeStats[prefix] = uint32(index)
+}
+
+// resetRouteStats forgets stats indexes, used when VPP lost routes
+func (c *Client) resetRouteStats() {
+	c.routeStatsMu.Lock()
+	defer c.routeStatsMu.Unlock()
+
+	c.routeStats = nil
+}
+
+// sumCombined adds counter at index of every thread
+func sumCombined(stat adapter.CombinedCounterStat, index uint32) Counter {
+	var total Counter
+	for _, thread := range stat {
+		if int(index) < len(thread) {
+			total.Packets += thread[index].Packets()
+			total.Bytes += thread[index].Bytes()
+		}
+	}
+
+	return total
+}
+
+// ReadCounters samples counters of CPE interfaces and session routes
+func (c *Client) ReadCounters() (*Counters, error) {
+	if c.stats == nil {
+		return nil, ErrStatsDisabled
+	}
+
+	var patterns []string
+	if c.config.Stats.Collects(StatsInterface) {
+		patterns = append(patterns, "^"+statsIfaceRx+"$", "^"+statsIfaceTx+"$")
+	}
+	if c.config.Stats.Collects(StatsRoute) {
+		patterns = append(patterns, "^"+statsRouteTo+"$")
+	}
+
+	entries, err := c.stats.DumpStats(patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := &Counters{Ifaces: make(map[int]IfaceCounters), Routes: make(map[string]Counter)}
+	ifaces := c.GetIfacesSwMap()
+
+	c.routeStatsMu.Lock()
+	defer c.routeStatsMu.Unlock()
+
+	for _, e := range entries {
+		stat, ok := e.Data.(adapter.CombinedCounterStat)
+		if !ok {
+			continue
+		}
+
+		switch string(e.Name) {
+		case statsIfaceRx, statsIfaceTx:
+			for swIf := range ifaces {
+				v := counters.Ifaces[swIf]
+				if string(e.Name) == statsIfaceRx {
+					v.Rx = sumCombined(stat, uint32(swIf))
+				} else {
+					v.Tx = sumCombined(stat, uint32(swIf))
+				}
+				counters.Ifaces[swIf] = v
+			}
+		case statsRouteTo:
+			for prefix, index := range c.routeStats {
+				counters.Routes[prefix] = sumCombined(stat, index)
+			}
+		}
+	}
+
+	return counters, nil
+}
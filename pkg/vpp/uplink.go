@@ -0,0 +1,147 @@
+package vpp
+
+import (
+	"go.fd.io/govpp/binapi/fib_types"
+	"go.fd.io/govpp/binapi/ip"
+	"go.fd.io/govpp/binapi/ip_types"
+)
+
+const defaultRoute = "0.0.0.0/0"
+
+// configUplinks brings uplinks up with their address and installs default
+// route through every uplink with a gateway, balanced by their weight
+func (c *Client) configUplinks() error {
+	uplinks := c.config.AllUplinks()
+	if len(uplinks) == 0 {
+		return nil
+	}
+
+	// Uplinks are resolved by name
+	existing := map[int]string{}
+	if c.existing != nil {
+		for swIf, v := range c.existing.all {
+			existing[swIf] = v.InterfaceName
+		}
+	} else {
+		dump, err := c.dumpInterfaces(-1)
+		if err != nil {
+			return &ProvisionError{Object: "uplinks", Step: "dump interfaces", Err: err}
+		}
+		for swIf, v := range dump {
+			existing[swIf] = v.InterfaceName
+		}
+	}
+	swIfs := make(map[string]int, len(existing))
+	for swIf, name := range existing {
+		swIfs[name] = swIf
+	}
+
+	var errs ProvisionErrors
+	var paths []fib_types.FibPath
+
+	for _, u := range uplinks {
+		swIf, ok := swIfs[u.IfaceName]
+		if !ok {
+			errs = append(errs, &ProvisionError{Object: "uplink " + u.IfaceName, Step: "resolve interface", Err: ErrIfaceNotFound})
+			continue
+		}
+
+		path, err := c.configUplink(&u, swIf)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if path != nil {
+			paths = append(paths, *path)
+		}
+	}
+
+	if len(paths) == 0 {
+		return errs.OrNil()
+	}
+
+	return errs.Append(c.configDefaultRoute(paths)).OrNil()
+}
+
+// configUplink configures an uplink and returns its path for default route,
+// nil if it has no gateway
+func (c *Client) configUplink(u *UplinkConfig, swIf int) (*fib_types.FibPath, error) {
+	fail := func(step string, err error) error {
+		return &ProvisionError{Object: "uplink " + u.IfaceName, Step: step, Err: err}
+	}
+
+	err := c.setInterfaceUp(swIf)
+	if err != nil {
+		return nil, fail("set interface up", err)
+	}
+
+	if u.IPv4 != "" {
+		prefix, err := ip_types.ParseAddressWithPrefix(u.IPv4)
+		if err != nil {
+			return nil, fail("parse IPv4 "+u.IPv4, err)
+		}
+
+		// Addresses configured by the operator are kept on teardown
+		err = c.setInterfaceAddr(swIf, &prefix.Address, prefix.Len)
+		switch {
+		case err == nil:
+			c.owned.add(OwnedUplinkAddr, u.IfaceName+" "+u.IPv4, swIf, func() error {
+				return c.delInterfaceAddr(swIf, &prefix.Address, prefix.Len)
+			})
+		case !isAddressConfigured(err):
+			return nil, fail("add IPv4 "+u.IPv4, err)
+		}
+	}
+
+	if u.Gateway == "" {
+		return nil, nil
+	}
+
+	gw, err := ip_types.ParseAddress(u.Gateway)
+	if err != nil {
+		return nil, fail("parse gateway "+u.Gateway, err)
+	}
+
+	weight := u.Weight
+	if weight == 0 {
+		weight = 1
+	}
+
+	return &fib_types.FibPath{
+		SwIfIndex: uint32(swIf),
+		Weight:    weight,
+		Proto:     fib_types.FIB_API_PATH_NH_PROTO_IP4,
+		Nh:        fib_types.FibPathNh{Address: gw.Un},
+	}, nil
+}
+
+// configDefaultRoute installs default route, replacing paths of an existing one
+func (c *Client) configDefaultRoute(paths []fib_types.FibPath) error {
+	route := c.config.DefaultRoute
+	if route == "" {
+		route = defaultRoute
+	}
+
+	prefix, err := ip_types.ParsePrefix(route)
+	if err != nil {
+		return &ProvisionError{Object: "default route", Step: "parse " + route, Err: err}
+	}
+
+	req := &ip.IPRouteAddDel{IsAdd: true,
+		Route: ip.IPRoute{TableID: 0,
+			Prefix: prefix,
+			NPaths: uint8(len(paths)),
+			Paths:  paths}}
+	reply := &ip.IPRouteAddDelReply{}
+
+	if err = c.request(req, reply); err != nil {
+		return &ProvisionError{Object: "default route", Step: "add route " + route, Err: err}
+	}
+
+	c.owned.add(OwnedRoute, route, -1, func() error {
+		del := &ip.IPRouteAddDel{IsAdd: false, Route: req.Route}
+		return c.request(del, &ip.IPRouteAddDelReply{})
+	})
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package vpp
+
+import (
+	"fmt"
+
+	interfaces "go.fd.io/govpp/binapi/interface"
+	"go.fd.io/govpp/binapi/interface_types"
+)
+
+// ifaceVRF is the VRF a CPE interface was placed in by sessions on it
+type ifaceVRF struct {
+	name  string
+	table uint32
+	// Sessions holding the VRF
+	sessions map[string]bool
+}
+
+// SetIfaceVRF places IPv4 of a CPE interface in the VRF given to a session,
+// an empty vrf drops the one of the session. Interface is back in table 0 when
+// no session holds a VRF, sessions on the same interface must share it.
+// Routes of sessions on the interface must be removed before its table
+// changes.
+func (c *Client) SetIfaceVRF(swIf int, session string, vrf string) error {
+	if _, ok := c.LookupIface(swIf); !ok {
+		return fmt.Errorf("%w, SwIf %d", ErrIfaceNotFound, swIf)
+	}
+
+	c.vrfMu.Lock()
+	defer c.vrfMu.Unlock()
+
+	cur := c.ifaceVRFs[swIf]
+	if vrf == "" {
+		if cur == nil || !cur.sessions[session] {
+			return nil
+		}
+		if len(cur.sessions) > 1 {
+			delete(cur.sessions, session)
+			return nil
+		}
+		if err := c.setInterfaceTable(swIf, 0); err != nil {
+			return err
+		}
+		delete(c.ifaceVRFs, swIf)
+		return nil
+	}
+
+	table, ok := c.config.VRFs[vrf]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownVRF, vrf)
+	}
+	if cur != nil && cur.name != vrf {
+		if len(cur.sessions) > 1 || !cur.sessions[session] {
+			return fmt.Errorf("%w, SwIf %d is in VRF %s", ErrVRFInUse, swIf, cur.name)
+		}
+	}
+	if cur != nil && cur.name == vrf {
+		cur.sessions[session] = true
+		return nil
+	}
+
+	if err := c.setInterfaceTable(swIf, table); err != nil {
+		return err
+	}
+	if c.ifaceVRFs == nil {
+		c.ifaceVRFs = make(map[int]*ifaceVRF)
+	}
+	c.ifaceVRFs[swIf] = &ifaceVRF{name: vrf, table: table, sessions: map[string]bool{session: true}}
+
+	return nil
+}
+
+// ifaceTable returns the IPv4 table of a CPE interface
+func (c *Client) ifaceTable(swIf int) uint32 {
+	c.vrfMu.Lock()
+	defer c.vrfMu.Unlock()
+
+	if v, ok := c.ifaceVRFs[swIf]; ok {
+		return v.table
+	}
+	return 0
+}
+
+// unconfigVRF moves a CPE interface being deleted back to table 0
+func (c *Client) unconfigVRF(swIf int) error {
+	c.vrfMu.Lock()
+	defer c.vrfMu.Unlock()
+
+	if _, ok := c.ifaceVRFs[swIf]; !ok {
+		return nil
+	}
+	if err := c.setInterfaceTable(swIf, 0); err != nil {
+		return err
+	}
+	delete(c.ifaceVRFs, swIf)
+
+	return nil
+}
+
+// resetVRFs forgets VRFs of interfaces, used when VPP lost them
+func (c *Client) resetVRFs() {
+	c.vrfMu.Lock()
+	defer c.vrfMu.Unlock()
+
+	c.ifaceVRFs = nil
+}
+
+// vrfTables returns tables of configured VRFs
+func (c *Client) vrfTables() []uint32 {
+	tables := make([]uint32, 0, len(c.config.VRFs))
+	for _, v := range c.config.VRFs {
+		tables = append(tables, v)
+	}
+	return tables
+}
+
+func (c *Client) setInterfaceTable(swIf int, table uint32) error {
+	req := &interfaces.SwInterfaceSetTable{
+		SwIfIndex: interface_types.InterfaceIndex(swIf),
+		VrfID:     table,
+	}
+	reply := &interfaces.SwInterfaceSetTableReply{}
+
+	return c.request(req, reply)
+}